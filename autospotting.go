@@ -31,6 +31,13 @@ var eventFile string
 func main() {
 	eventFile = conf.EventFile
 
+	if len(conf.Command) > 0 {
+		if err := as.RunCommand(conf.Command); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if autospotting.RunningFromLambda() {
 		lambda.Start(Handler)
 	} else if eventFile != "" {
@@ -80,5 +87,8 @@ func init() {
 
 // Handler implements the AWS Lambda handler interface
 func Handler(ctx context.Context, rawEvent json.RawMessage) {
+	if deadline, ok := ctx.Deadline(); ok {
+		as.SetDeadline(deadline)
+	}
 	eventHandler(&rawEvent)
 }
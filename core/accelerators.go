@@ -0,0 +1,244 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// accelerators.go contains the logic used for comparing the GPUs and other
+// accelerators of the instance types, so that for example a group running on
+// NVIDIA V100 GPUs isn't replaced with instances having T4 GPUs or
+// Inferentia chips.
+
+import (
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// AcceleratorCompatibilityTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the AcceleratorCompatibility
+	// parameter
+	AcceleratorCompatibilityTag = "autospotting_accelerator_compatibility"
+
+	// AcceleratorCompatibilityModel only allows accelerators of the same kind,
+	// manufacturer and model, with at least as many devices and memory.
+	AcceleratorCompatibilityModel = "model"
+
+	// AcceleratorCompatibilityManufacturer also allows newer models of the
+	// same kind and manufacturer, with at least as many devices and memory.
+	AcceleratorCompatibilityManufacturer = "manufacturer"
+
+	// AcceleratorCompatibilityCount only compares the number of GPUs, which
+	// was the behavior of older AutoSpotting versions.
+	AcceleratorCompatibilityCount = "count"
+
+	// DefaultAcceleratorCompatibility is the default accelerator compatibility
+	DefaultAcceleratorCompatibility = AcceleratorCompatibilityModel
+)
+
+// The kinds of accelerators
+const (
+	acceleratorGPU       = "gpu"
+	acceleratorInference = "inference"
+	acceleratorTraining  = "training"
+	acceleratorFPGA      = "fpga"
+)
+
+// accelerator describes the accelerators of an instance type.
+type accelerator struct {
+	kind         string
+	manufacturer string
+	model        string
+	// number of devices, 0 if unknown
+	count int
+	// memory per device in MiB, 0 if unknown
+	memory int64
+}
+
+// knownAccelerators describes the accelerators of the instance families, for
+// when the hardware specs from the DescribeInstanceTypes API aren't available
+// or don't cover them.
+var knownAccelerators = map[string]accelerator{
+	"p2":    {kind: acceleratorGPU, manufacturer: "NVIDIA", model: "K80", memory: 12288},
+	"p3":    {kind: acceleratorGPU, manufacturer: "NVIDIA", model: "V100", memory: 16384},
+	"p3dn":  {kind: acceleratorGPU, manufacturer: "NVIDIA", model: "V100", memory: 32768},
+	"p4d":   {kind: acceleratorGPU, manufacturer: "NVIDIA", model: "A100", memory: 40960},
+	"p4de":  {kind: acceleratorGPU, manufacturer: "NVIDIA", model: "A100", memory: 81920},
+	"p5":    {kind: acceleratorGPU, manufacturer: "NVIDIA", model: "H100", memory: 81920},
+	"g3":    {kind: acceleratorGPU, manufacturer: "NVIDIA", model: "M60", memory: 8192},
+	"g3s":   {kind: acceleratorGPU, manufacturer: "NVIDIA", model: "M60", memory: 8192},
+	"g4dn":  {kind: acceleratorGPU, manufacturer: "NVIDIA", model: "T4", memory: 16384},
+	"g4ad":  {kind: acceleratorGPU, manufacturer: "AMD", model: "Radeon Pro V520", memory: 8192},
+	"g5":    {kind: acceleratorGPU, manufacturer: "NVIDIA", model: "A10G", memory: 24576},
+	"g5g":   {kind: acceleratorGPU, manufacturer: "NVIDIA", model: "T4g", memory: 16384},
+	"g6":    {kind: acceleratorGPU, manufacturer: "NVIDIA", model: "L4", memory: 23040},
+	"dl1":   {kind: acceleratorGPU, manufacturer: "Habana", model: "Gaudi HL-205", memory: 32768},
+	"inf1":  {kind: acceleratorInference, manufacturer: "AWS", model: "Inferentia"},
+	"inf2":  {kind: acceleratorInference, manufacturer: "AWS", model: "Inferentia2"},
+	"trn1":  {kind: acceleratorTraining, manufacturer: "AWS", model: "Trainium"},
+	"trn1n": {kind: acceleratorTraining, manufacturer: "AWS", model: "Trainium"},
+	"f1":    {kind: acceleratorFPGA, manufacturer: "Xilinx", model: "Virtex UltraScale (VU9P)"},
+}
+
+// acceleratorGenerations ranks the known accelerator models by their
+// architecture generation, so only models at least as recent as the current one
+// are allowed when the compatibility is relaxed to the manufacturer.
+var acceleratorGenerations = map[string]int{
+	"k80":  1, // Kepler
+	"m60":  2, // Maxwell
+	"v100": 3, // Volta
+	"t4":   4, // Turing
+	"t4g":  4,
+	"a10g": 5, // Ampere
+	"a100": 5,
+	"l4":   6, // Ada Lovelace
+	"h100": 7, // Hopper
+}
+
+// isNotOlderThan returns whether the accelerator model is at least as recent as
+// the other one, which is unknown unless both are ranked.
+func (a *accelerator) isNotOlderThan(other *accelerator) bool {
+	generation, found := acceleratorGenerations[strings.ToLower(a.model)]
+	otherGeneration, otherFound := acceleratorGenerations[strings.ToLower(other.model)]
+	return found && otherFound && generation >= otherGeneration
+}
+
+// acceleratorFromSpecs returns the accelerators reported by the
+// DescribeInstanceTypes API, or nil if there are none.
+func acceleratorFromSpecs(it *ec2.InstanceTypeInfo) *accelerator {
+	if it.GpuInfo != nil && len(it.GpuInfo.Gpus) > 0 {
+		gpu := it.GpuInfo.Gpus[0]
+		a := &accelerator{
+			kind:         acceleratorGPU,
+			manufacturer: aws.StringValue(gpu.Manufacturer),
+			model:        aws.StringValue(gpu.Name),
+		}
+		if gpu.MemoryInfo != nil {
+			a.memory = aws.Int64Value(gpu.MemoryInfo.SizeInMiB)
+		}
+		for _, g := range it.GpuInfo.Gpus {
+			a.count += int(aws.Int64Value(g.Count))
+		}
+		return a
+	}
+
+	if it.InferenceAcceleratorInfo != nil && len(it.InferenceAcceleratorInfo.Accelerators) > 0 {
+		inf := it.InferenceAcceleratorInfo.Accelerators[0]
+		a := &accelerator{
+			kind:         acceleratorInference,
+			manufacturer: aws.StringValue(inf.Manufacturer),
+			model:        aws.StringValue(inf.Name),
+		}
+		for _, i := range it.InferenceAcceleratorInfo.Accelerators {
+			a.count += int(aws.Int64Value(i.Count))
+		}
+		return a
+	}
+
+	if it.FpgaInfo != nil && len(it.FpgaInfo.Fpgas) > 0 {
+		fpga := it.FpgaInfo.Fpgas[0]
+		a := &accelerator{
+			kind:         acceleratorFPGA,
+			manufacturer: aws.StringValue(fpga.Manufacturer),
+			model:        aws.StringValue(fpga.Name),
+		}
+		if fpga.MemoryInfo != nil {
+			a.memory = aws.Int64Value(fpga.MemoryInfo.SizeInMiB)
+		}
+		for _, f := range it.FpgaInfo.Fpgas {
+			a.count += int(aws.Int64Value(f.Count))
+		}
+		return a
+	}
+	return nil
+}
+
+// acceleratorOf returns the accelerators of the instance type, from the
+// hardware specs if available or otherwise from the known instance families,
+// or nil if it has none.
+func acceleratorOf(instanceType string, gpuCount int, specs *instanceTypeSpecs) *accelerator {
+	if specs != nil && specs.accelerator != nil {
+		return specs.accelerator
+	}
+
+	family := instanceFamily(instanceType)
+	if known, found := knownAccelerators[family]; found {
+		a := known
+		if a.kind == acceleratorGPU {
+			a.count = gpuCount
+		}
+		return &a
+	}
+
+	if gpuCount > 0 {
+		return &accelerator{kind: acceleratorGPU, count: gpuCount}
+	}
+	return nil
+}
+
+// isAcceleratorCompatible only allows accelerators of the same kind as the
+// current instance type, which are equal or better according to the
+// configured compatibility level.
+func (i *instance) isAcceleratorCompatible(spotCandidate *instanceTypeInformation) bool {
+	current := i.typeInfo.accelerator
+	if current == nil {
+		return true
+	}
+
+	mode := DefaultAcceleratorCompatibility
+	if i.asg != nil && i.asg.config.AcceleratorCompatibility != "" {
+		mode = i.asg.config.AcceleratorCompatibility
+	}
+
+	if mode == AcceleratorCompatibilityCount {
+		return true
+	}
+
+	candidate := spotCandidate.accelerator
+
+	var reason string
+	switch {
+	case candidate == nil:
+		reason = "no accelerators"
+	case candidate.kind != current.kind:
+		reason = "different kind of accelerators " + candidate.kind
+	case !strings.EqualFold(candidate.manufacturer, current.manufacturer):
+		reason = "different accelerator manufacturer " + candidate.manufacturer
+	case mode == AcceleratorCompatibilityModel && !strings.EqualFold(candidate.model, current.model):
+		reason = "different accelerator model " + candidate.model
+	case !strings.EqualFold(candidate.model, current.model) && !candidate.isNotOlderThan(current):
+		reason = "older or unknown accelerator model " + candidate.model
+	case candidate.count > 0 && current.count > 0 && candidate.count < current.count:
+		reason = "fewer accelerators"
+	case candidate.memory > 0 && current.memory > 0 && candidate.memory < current.memory:
+		reason = "less accelerator memory"
+	}
+
+	if reason != "" {
+		debug.Println("\tNot accelerator compatible:", reason)
+		return false
+	}
+	return true
+}
+
+func (a *autoScalingGroup) loadAcceleratorCompatibility() bool {
+	a.config.AcceleratorCompatibility = a.region.conf.AcceleratorCompatibility
+
+	tagValue := a.getTagValue(AcceleratorCompatibilityTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", AcceleratorCompatibilityTag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	switch *tagValue {
+	case AcceleratorCompatibilityModel, AcceleratorCompatibilityManufacturer, AcceleratorCompatibilityCount:
+		log.Printf("Loaded AcceleratorCompatibility value %v from tag %v\n", *tagValue, AcceleratorCompatibilityTag)
+		a.config.AcceleratorCompatibility = *tagValue
+		return true
+	}
+
+	log.Printf("Ignoring invalid AcceleratorCompatibility value %v from tag %v\n", *tagValue, AcceleratorCompatibilityTag)
+	return false
+}
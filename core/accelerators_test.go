@@ -0,0 +1,189 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_acceleratorFromSpecs(t *testing.T) {
+	tests := []struct {
+		name string
+		info *ec2.InstanceTypeInfo
+		want *accelerator
+	}{
+		{
+			name: "no accelerators",
+			info: &ec2.InstanceTypeInfo{},
+		},
+		{
+			name: "GPU",
+			info: &ec2.InstanceTypeInfo{GpuInfo: &ec2.GpuInfo{Gpus: []*ec2.GpuDeviceInfo{{
+				Count:        aws.Int64(4),
+				Manufacturer: aws.String("NVIDIA"),
+				Name:         aws.String("V100"),
+				MemoryInfo:   &ec2.GpuDeviceMemoryInfo{SizeInMiB: aws.Int64(16384)},
+			}}}},
+			want: &accelerator{kind: "gpu", manufacturer: "NVIDIA", model: "V100", count: 4, memory: 16384},
+		},
+		{
+			name: "Inferentia",
+			info: &ec2.InstanceTypeInfo{InferenceAcceleratorInfo: &ec2.InferenceAcceleratorInfo{
+				Accelerators: []*ec2.InferenceDeviceInfo{{
+					Count:        aws.Int64(1),
+					Manufacturer: aws.String("AWS"),
+					Name:         aws.String("Inferentia"),
+				}},
+			}},
+			want: &accelerator{kind: "inference", manufacturer: "AWS", model: "Inferentia", count: 1},
+		},
+		{
+			name: "FPGA",
+			info: &ec2.InstanceTypeInfo{FpgaInfo: &ec2.FpgaInfo{Fpgas: []*ec2.FpgaDeviceInfo{{
+				Count:        aws.Int64(1),
+				Manufacturer: aws.String("Xilinx"),
+				Name:         aws.String("Virtex UltraScale (VU9P)"),
+				MemoryInfo:   &ec2.FpgaDeviceMemoryInfo{SizeInMiB: aws.Int64(65536)},
+			}}}},
+			want: &accelerator{kind: "fpga", manufacturer: "Xilinx", model: "Virtex UltraScale (VU9P)", count: 1, memory: 65536},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := acceleratorFromSpecs(tt.info); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("acceleratorFromSpecs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_acceleratorOf(t *testing.T) {
+	tests := []struct {
+		name         string
+		instanceType string
+		gpuCount     int
+		specs        *instanceTypeSpecs
+		want         *accelerator
+	}{
+		{
+			name:         "no accelerators",
+			instanceType: "m5.large",
+		},
+		{
+			name:         "known GPU family",
+			instanceType: "g4dn.12xlarge",
+			gpuCount:     4,
+			want:         &accelerator{kind: "gpu", manufacturer: "NVIDIA", model: "T4", count: 4, memory: 16384},
+		},
+		{
+			name:         "known Trainium family not reported by the API",
+			instanceType: "trn1.32xlarge",
+			specs:        &instanceTypeSpecs{},
+			want:         &accelerator{kind: "training", manufacturer: "AWS", model: "Trainium"},
+		},
+		{
+			name:         "specs take precedence",
+			instanceType: "p3.2xlarge",
+			gpuCount:     1,
+			specs:        &instanceTypeSpecs{accelerator: &accelerator{kind: "gpu", manufacturer: "NVIDIA", model: "V100", count: 1, memory: 16160}},
+			want:         &accelerator{kind: "gpu", manufacturer: "NVIDIA", model: "V100", count: 1, memory: 16160},
+		},
+		{
+			name:         "unknown GPU family",
+			instanceType: "g9.xlarge",
+			gpuCount:     1,
+			want:         &accelerator{kind: "gpu", count: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := acceleratorOf(tt.instanceType, tt.gpuCount, tt.specs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("acceleratorOf() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_isAcceleratorCompatible(t *testing.T) {
+	v100 := &accelerator{kind: "gpu", manufacturer: "NVIDIA", model: "V100", count: 1, memory: 16384}
+	v100x4 := &accelerator{kind: "gpu", manufacturer: "NVIDIA", model: "V100", count: 4, memory: 16384}
+	a100 := &accelerator{kind: "gpu", manufacturer: "NVIDIA", model: "A100", count: 8, memory: 40960}
+	t4 := &accelerator{kind: "gpu", manufacturer: "NVIDIA", model: "T4", count: 1, memory: 16384}
+	k80 := &accelerator{kind: "gpu", manufacturer: "NVIDIA", model: "K80", count: 1, memory: 12288}
+	inferentia := &accelerator{kind: "inference", manufacturer: "AWS", model: "Inferentia", count: 1}
+
+	tests := []struct {
+		name      string
+		mode      string
+		current   *accelerator
+		candidate *accelerator
+		want      bool
+	}{
+		{name: "no accelerators", current: nil, candidate: nil, want: true},
+		{name: "accelerators not needed", current: nil, candidate: t4, want: true},
+		{name: "accelerators missing", current: v100, candidate: nil, want: false},
+		{name: "same model, more GPUs", current: v100, candidate: v100x4, want: true},
+		{name: "same model, fewer GPUs", current: v100x4, candidate: v100, want: false},
+		{name: "other model", current: v100, candidate: t4, want: false},
+		{name: "other kind", current: t4, candidate: inferentia, want: false},
+		{name: "other model of the same manufacturer", mode: "manufacturer", current: v100, candidate: a100, want: true},
+		{name: "other model with less memory", mode: "manufacturer", current: t4, candidate: k80, want: false},
+		{name: "older model of the same manufacturer", mode: "manufacturer", current: a100, candidate: v100x4, want: false},
+		{name: "older model with unknown memory", mode: "manufacturer",
+			current:   &accelerator{kind: "gpu", manufacturer: "NVIDIA", model: "V100"},
+			candidate: &accelerator{kind: "gpu", manufacturer: "NVIDIA", model: "K80"}, want: false},
+		{name: "unknown model of the same manufacturer", mode: "manufacturer", current: v100,
+			candidate: &accelerator{kind: "gpu", manufacturer: "NVIDIA", model: "B200", count: 8}, want: false},
+		{name: "other kind of the same manufacturer", mode: "manufacturer", current: t4, candidate: inferentia, want: false},
+		{name: "only the count is compared", mode: "count", current: t4, candidate: inferentia, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				typeInfo: instanceTypeInformation{accelerator: tt.current},
+				asg:      &autoScalingGroup{config: AutoScalingConfig{AcceleratorCompatibility: tt.mode}},
+			}
+			if got := i.isAcceleratorCompatible(&instanceTypeInformation{accelerator: tt.candidate}); got != tt.want {
+				t.Errorf("isAcceleratorCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadAcceleratorCompatibility(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     string
+		wantOK   bool
+	}{
+		{name: "default", want: "model"},
+		{name: "relaxed", tagValue: aws.String("manufacturer"), want: "manufacturer", wantOK: true},
+		{name: "invalid", tagValue: aws.String("any"), want: "model"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				region: &region{conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{AcceleratorCompatibility: "model"},
+				}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(AcceleratorCompatibilityTag), Value: tt.tagValue}}
+			}
+
+			ok := a.loadAcceleratorCompatibility()
+			if ok != tt.wantOK || a.config.AcceleratorCompatibility != tt.want {
+				t.Errorf("loadAcceleratorCompatibility() = %v, %v, want %v, %v",
+					ok, a.config.AcceleratorCompatibility, tt.wantOK, tt.want)
+			}
+		})
+	}
+}
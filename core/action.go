@@ -83,3 +83,25 @@ func (ssmoil sqsSendMessageOnInstanceLaunch) run() {
 	state := ssmoil.target.onDemandInstance.State.Name
 	region.sqsSendMessageOnInstanceLaunch(&asg.name, onDemandInstanceID, state, "cron-spot-instance-launch")
 }
+
+// raises the capacity of a group being evacuated, so it launches a batch of
+// on-demand instances
+type launchEvacuationBatch struct {
+	target target
+	batch  int64
+}
+
+func (leb launchEvacuationBatch) run() {
+	leb.target.asg.startEvacuationBatch(leb.batch)
+}
+
+// terminates the Spot instances replaced by the on-demand instances of the
+// evacuation batch
+type terminateEvacuatedSpotInstances struct {
+	target target
+	state  evacuationState
+}
+
+func (tesi terminateEvacuatedSpotInstances) run() {
+	tesi.target.asg.finishEvacuationBatch(tesi.state)
+}
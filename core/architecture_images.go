@@ -0,0 +1,241 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// architecture_images.go contains the logic used for replacing on-demand
+// instances with Spot instances of another CPU architecture, such as x86_64
+// instances with Graviton ones, when the group is configured with an AMI for
+// each architecture.
+
+import (
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// ImageX86Tag and ImageARM64Tag configure the AMIs used for launching Spot
+	// instances of each CPU architecture. The value is either an AMI ID or the
+	// path of an SSM parameter containing the AMI ID, such as the ones
+	// published for Amazon Linux.
+	ImageX86Tag   = "autospotting_image_x86_64"
+	ImageARM64Tag = "autospotting_image_arm64"
+
+	// the prefix used by the launch templates for resolving the AMI ID from an
+	// SSM parameter at launch time
+	ssmImagePrefix = "resolve:ssm:"
+)
+
+// loadArchitectureImages reads the per-architecture AMIs from the group tags.
+func (a *autoScalingGroup) loadArchitectureImages() bool {
+	a.config.ArchitectureImages = nil
+
+	for arch, tag := range map[string]string{
+		ec2.ArchitectureTypeX8664: ImageX86Tag,
+		ec2.ArchitectureTypeArm64: ImageARM64Tag,
+	} {
+		tagValue := a.getTagValue(tag)
+		if tagValue == nil {
+			continue
+		}
+
+		image := parseArchitectureImage(*tagValue)
+		if image == "" {
+			log.Printf("Ignoring the invalid AMI %v from tag %v, expected an AMI ID or an SSM parameter path\n",
+				*tagValue, tag)
+			continue
+		}
+
+		log.Printf("Loaded the %v AMI %v from tag %v\n", arch, image, tag)
+		if a.config.ArchitectureImages == nil {
+			a.config.ArchitectureImages = make(map[string]string)
+		}
+		a.config.ArchitectureImages[arch] = image
+	}
+	return a.config.ArchitectureImages != nil
+}
+
+// parseArchitectureImage returns the image ID to be set in the launch template,
+// or an empty string if the value is invalid.
+func parseArchitectureImage(value string) string {
+	value = strings.TrimSpace(value)
+	switch {
+	case strings.HasPrefix(value, "ami-"), strings.HasPrefix(value, ssmImagePrefix+"/"):
+		return value
+	case strings.HasPrefix(value, "/"):
+		return ssmImagePrefix + value
+	}
+	return ""
+}
+
+// architecture returns the CPU architecture of the instance type, based on the
+// hardware specs if available or otherwise on the processor name.
+func (it *instanceTypeInformation) architecture() string {
+	if it.specs != nil {
+		for _, arch := range []string{ec2.ArchitectureTypeArm64, ec2.ArchitectureTypeX8664} {
+			if itemInSlice(arch, it.specs.architectures) {
+				return arch
+			}
+		}
+	}
+
+	switch {
+	case isARM(it.PhysicalProcessor):
+		return ec2.ArchitectureTypeArm64
+	case isIntelCompatible(it.PhysicalProcessor):
+		return ec2.ArchitectureTypeX8664
+	}
+	return ""
+}
+
+// architecture returns the CPU architecture of the running instance.
+func (i *instance) architecture() string {
+	if i.Instance != nil && i.Architecture != nil {
+		return *i.Architecture
+	}
+	return i.typeInfo.architecture()
+}
+
+// architectureImage returns the AMI configured on the group for launching Spot
+// instances of another architecture than the current instance, or an empty
+// string if not configured.
+func (i *instance) architectureImage(arch string) string {
+	if i.asg == nil || arch == "" || arch == i.architecture() {
+		return ""
+	}
+	return i.asg.config.ArchitectureImages[arch]
+}
+
+// isArchCompatible allows Spot candidates of the same architecture, and of
+// other architectures for which the group has an AMI configured.
+func (i *instance) isArchCompatible(spotCandidate *instanceTypeInformation) bool {
+	if i.isSameArch(spotCandidate) {
+		return true
+	}
+
+	if i.architectureImage(spotCandidate.architecture()) != "" {
+		debug.Println("\tAllowing", spotCandidate.architecture(), "candidate using the AMI configured on the group")
+		return true
+	}
+	return false
+}
+
+// pickLaunchArchitecture keeps the instance types having the same architecture
+// as the first one, since a launch template only has a single AMI, and
+// remembers it so the matching AMI is used in the launch template.
+func (i *instance) pickLaunchArchitecture(instanceTypes []*string) []*string {
+	i.launchArchitecture = ""
+
+	if i.asg == nil || len(i.asg.config.ArchitectureImages) == 0 || len(instanceTypes) == 0 {
+		return instanceTypes
+	}
+
+	typeInformation := i.region.instanceTypeInformation
+	if i.asg.region != nil {
+		typeInformation = i.region.instanceTypeInformationForOS(i.asg.operatingSystem())
+	}
+	archOf := func(instanceType *string) string {
+		info := typeInformation[aws.StringValue(instanceType)]
+		return info.architecture()
+	}
+
+	arch := archOf(instanceTypes[0])
+
+	var result []*string
+	for _, it := range instanceTypes {
+		if archOf(it) == arch {
+			result = append(result, it)
+		}
+	}
+
+	if i.architectureImage(arch) != "" {
+		log.Println(i.region.name, i.asg.name, "Launching", arch, "Spot instances for replacing",
+			*i.InstanceId, "using the AMI configured on the group")
+		i.launchArchitecture = arch
+	}
+	return result
+}
+
+// swapArchitectureImage replaces the AMI of the launch template data when
+// launching Spot instances of another architecture, together with the block
+// device mappings copied from the snapshots of the current AMI.
+func (i *instance) swapArchitectureImage(ltData *ec2.RequestLaunchTemplateData) {
+	image := i.architectureImage(i.launchArchitecture)
+	if image == "" {
+		return
+	}
+	ltData.ImageId = aws.String(image)
+	ltData.BlockDeviceMappings = i.architectureImageBlockDeviceMappings(image, ltData.BlockDeviceMappings)
+}
+
+// architectureImageBlockDeviceMappings drops the block device mappings using
+// the snapshots of the current AMI, which can't be attached to an instance of
+// another architecture, and adds the ones of the new AMI in their place. The
+// AMIs resolved from SSM at launch time aren't known yet, so their own block
+// device mappings are used as they are.
+func (i *instance) architectureImageBlockDeviceMappings(image string,
+	bdms []*ec2.LaunchTemplateBlockDeviceMappingRequest) []*ec2.LaunchTemplateBlockDeviceMappingRequest {
+
+	var sourceSnapshots map[string]bool
+	var err error
+	if len(bdms) > 0 {
+		if sourceSnapshots, err = i.imageSnapshots(i.ImageId); err != nil {
+			log.Println(i.region.name, "Couldn't describe the AMI of", aws.StringValue(i.InstanceId),
+				"dropping all the block device mappings using snapshots:", err.Error())
+		}
+	}
+
+	var result []*ec2.LaunchTemplateBlockDeviceMappingRequest
+	devices := make(map[string]bool)
+	for _, bdm := range bdms {
+		if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil && (err != nil || sourceSnapshots[*bdm.Ebs.SnapshotId]) {
+			continue
+		}
+		result = append(result, bdm)
+		devices[aws.StringValue(bdm.DeviceName)] = true
+	}
+
+	if strings.HasPrefix(image, ssmImagePrefix) {
+		return result
+	}
+
+	resp, err := i.region.services.ec2.DescribeImages(&ec2.DescribeImagesInput{
+		ImageIds: []*string{aws.String(image)},
+	})
+	if err != nil || len(resp.Images) == 0 {
+		log.Println(i.region.name, "Couldn't describe the AMI", image,
+			"using its block device mappings as they are")
+		return result
+	}
+
+	for _, bdm := range i.convertImageBlockDeviceMappings(resp.Images[0].BlockDeviceMappings) {
+		if !devices[aws.StringValue(bdm.DeviceName)] {
+			result = append(result, bdm)
+		}
+	}
+	return result
+}
+
+// imageSnapshots returns the IDs of the snapshots used by the block device
+// mappings of an AMI.
+func (i *instance) imageSnapshots(imageID *string) (map[string]bool, error) {
+	resp, err := i.region.services.ec2.DescribeImages(&ec2.DescribeImagesInput{
+		ImageIds: []*string{imageID},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool)
+	for _, image := range resp.Images {
+		for _, bdm := range image.BlockDeviceMappings {
+			if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+				result[*bdm.Ebs.SnapshotId] = true
+			}
+		}
+	}
+	return result, nil
+}
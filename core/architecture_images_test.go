@@ -0,0 +1,254 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_autoScalingGroup_loadArchitectureImages(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []*autoscaling.TagDescription
+		want map[string]string
+	}{
+		{
+			name: "no tags",
+		},
+		{
+			name: "AMI ID and SSM parameter",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(ImageX86Tag), Value: aws.String("ami-123")},
+				{Key: aws.String(ImageARM64Tag), Value: aws.String("/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-arm64")},
+			},
+			want: map[string]string{
+				"x86_64": "ami-123",
+				"arm64":  "resolve:ssm:/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-arm64",
+			},
+		},
+		{
+			name: "invalid value",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(ImageARM64Tag), Value: aws.String("amazon-linux")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{Group: &autoscaling.Group{Tags: tt.tags}}
+			if got := a.loadArchitectureImages(); got != (tt.want != nil) {
+				t.Errorf("loadArchitectureImages() = %v, want %v", got, tt.want != nil)
+			}
+			if !reflect.DeepEqual(a.config.ArchitectureImages, tt.want) {
+				t.Errorf("loadArchitectureImages() loaded %v, want %v", a.config.ArchitectureImages, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_isArchCompatible(t *testing.T) {
+	intel := instanceTypeInformation{PhysicalProcessor: "Intel Xeon"}
+	graviton := instanceTypeInformation{PhysicalProcessor: "AWS Graviton2 Processor"}
+
+	tests := []struct {
+		name      string
+		images    map[string]string
+		current   instanceTypeInformation
+		candidate instanceTypeInformation
+		want      bool
+	}{
+		{
+			name:      "same architecture",
+			current:   intel,
+			candidate: intel,
+			want:      true,
+		},
+		{
+			name:      "other architecture without AMI",
+			current:   intel,
+			candidate: graviton,
+			want:      false,
+		},
+		{
+			name:      "other architecture with AMI",
+			images:    map[string]string{"arm64": "ami-arm"},
+			current:   intel,
+			candidate: graviton,
+			want:      true,
+		},
+		{
+			name:      "AMI configured only for the current architecture",
+			images:    map[string]string{"arm64": "ami-arm"},
+			current:   graviton,
+			candidate: intel,
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{},
+				typeInfo: tt.current,
+				asg:      &autoScalingGroup{config: AutoScalingConfig{ArchitectureImages: tt.images}},
+			}
+			if got := i.isArchCompatible(&tt.candidate); got != tt.want {
+				t.Errorf("isArchCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_pickLaunchArchitecture(t *testing.T) {
+	r := &region{
+		instanceTypeInformation: map[string]instanceTypeInformation{
+			"m5.large":  {PhysicalProcessor: "Intel Xeon"},
+			"m5a.large": {PhysicalProcessor: "AMD EPYC"},
+			"m6g.large": {PhysicalProcessor: "AWS Graviton2 Processor"},
+		},
+		services: connections{ec2: mockEC2{damio: &ec2.DescribeImagesOutput{}}},
+	}
+
+	tests := []struct {
+		name          string
+		images        map[string]string
+		instanceTypes []string
+		want          []string
+		wantImage     *string
+	}{
+		{
+			name:          "no AMIs configured",
+			instanceTypes: []string{"m6g.large", "m5.large"},
+			want:          []string{"m6g.large", "m5.large"},
+			wantImage:     aws.String("ami-x86"),
+		},
+		{
+			name:          "cheapest of the other architecture",
+			images:        map[string]string{"arm64": "ami-arm"},
+			instanceTypes: []string{"m6g.large", "m5.large", "m5a.large"},
+			want:          []string{"m6g.large"},
+			wantImage:     aws.String("ami-arm"),
+		},
+		{
+			name:          "cheapest of the same architecture",
+			images:        map[string]string{"arm64": "ami-arm"},
+			instanceTypes: []string{"m5a.large", "m6g.large", "m5.large"},
+			want:          []string{"m5a.large", "m5.large"},
+			wantImage:     aws.String("ami-x86"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					InstanceId:   aws.String("i-1"),
+					Architecture: aws.String("x86_64"),
+				},
+				region: r,
+				asg: &autoScalingGroup{
+					name:   "asg",
+					config: AutoScalingConfig{ArchitectureImages: tt.images},
+				},
+			}
+
+			got := aws.StringValueSlice(i.pickLaunchArchitecture(aws.StringSlice(tt.instanceTypes)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pickLaunchArchitecture() = %v, want %v", got, tt.want)
+			}
+
+			ltData := &ec2.RequestLaunchTemplateData{ImageId: aws.String("ami-x86")}
+			i.swapArchitectureImage(ltData)
+			if !reflect.DeepEqual(ltData.ImageId, tt.wantImage) {
+				t.Errorf("swapArchitectureImage() set the image to %v, want %v",
+					aws.StringValue(ltData.ImageId), aws.StringValue(tt.wantImage))
+			}
+		})
+	}
+}
+
+func Test_instance_swapArchitectureImage(t *testing.T) {
+	imageBDM := func(device, snapshot string) *ec2.BlockDeviceMapping {
+		return &ec2.BlockDeviceMapping{
+			DeviceName: aws.String(device),
+			Ebs: &ec2.EbsBlockDevice{
+				SnapshotId: aws.String(snapshot),
+				VolumeSize: aws.Int64(8),
+				VolumeType: aws.String("gp3"),
+			},
+		}
+	}
+	images := map[string]*ec2.DescribeImagesOutput{
+		"ami-x86": {Images: []*ec2.Image{{BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			imageBDM("/dev/xvda", "snap-x86"),
+		}}}},
+		"ami-arm": {Images: []*ec2.Image{{BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			imageBDM("/dev/xvda", "snap-arm"),
+		}}}},
+	}
+
+	dataVolume := &ec2.LaunchTemplateBlockDeviceMappingRequest{
+		DeviceName: aws.String("/dev/xvdb"),
+		Ebs:        &ec2.LaunchTemplateEbsBlockDeviceRequest{VolumeSize: aws.Int64(100)},
+	}
+
+	tests := []struct {
+		name          string
+		image         string
+		wantImage     string
+		wantSnapshots map[string]string
+	}{
+		{
+			name:          "AMI ID",
+			image:         "ami-arm",
+			wantImage:     "ami-arm",
+			wantSnapshots: map[string]string{"/dev/xvda": "snap-arm", "/dev/xvdb": ""},
+		},
+		{
+			name:          "SSM parameter",
+			image:         "resolve:ssm:/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-arm64",
+			wantImage:     "resolve:ssm:/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-arm64",
+			wantSnapshots: map[string]string{"/dev/xvdb": ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{name: "us-east-1", services: connections{ec2: mockEC2{damiid: images}}}
+			i := &instance{
+				Instance: &ec2.Instance{
+					InstanceId:   aws.String("i-1"),
+					ImageId:      aws.String("ami-x86"),
+					Architecture: aws.String(ec2.ArchitectureTypeX8664),
+				},
+				region: r,
+				asg: &autoScalingGroup{
+					name:   "asg",
+					region: r,
+					config: AutoScalingConfig{ArchitectureImages: map[string]string{"arm64": tt.image}},
+				},
+				launchArchitecture: ec2.ArchitectureTypeArm64,
+			}
+
+			ltData := &ec2.RequestLaunchTemplateData{ImageId: i.ImageId}
+			i.processImageBlockDevices(ltData)
+			ltData.BlockDeviceMappings = append(ltData.BlockDeviceMappings, dataVolume)
+
+			i.swapArchitectureImage(ltData)
+
+			if got := aws.StringValue(ltData.ImageId); got != tt.wantImage {
+				t.Errorf("swapArchitectureImage() image = %v, want %v", got, tt.wantImage)
+			}
+			gotSnapshots := make(map[string]string)
+			for _, bdm := range ltData.BlockDeviceMappings {
+				gotSnapshots[aws.StringValue(bdm.DeviceName)] = aws.StringValue(bdm.Ebs.SnapshotId)
+			}
+			if !reflect.DeepEqual(gotSnapshots, tt.wantSnapshots) {
+				t.Errorf("swapArchitectureImage() block devices = %v, want %v", gotSnapshots, tt.wantSnapshots)
+			}
+		})
+	}
+}
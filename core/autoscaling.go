@@ -24,6 +24,13 @@ type autoScalingGroup struct {
 	launchTemplate      *launchTemplate
 	instances           instances
 	config              AutoScalingConfig
+
+	// the settings applied to the group from the configuration file, which
+	// are overridden by its tags
+	fileSettings map[string]configFileSetting
+
+	// the operating system detected from the AMI, see operatingSystem()
+	os *operatingSystem
 }
 
 func (a *autoScalingGroup) loadLaunchConfiguration() (*launchConfiguration, error) {
@@ -62,7 +69,7 @@ func (a *autoScalingGroup) loadLaunchTemplate() (*launchTemplate, error) {
 		return a.launchTemplate, nil
 	}
 
-	lt := a.LaunchTemplate
+	lt := a.launchTemplateSpecification()
 
 	if lt == nil {
 		return nil, errors.New("missing launch template")
@@ -122,18 +129,25 @@ func (a *autoScalingGroup) needReplaceOnDemandInstances() (bool, int64) {
 	debug.Printf("onDemandRunning=%v totalRunning=%v a.minOnDemand=%v",
 		onDemandRunning, totalRunning, a.config.MinOnDemand)
 
+	// the on-demand instances covered by reservations are kept running
+	minOnDemand := a.config.MinOnDemand
+	if covered := a.coveredOnDemandInstanceCount(); covered > minOnDemand {
+		log.Println(a.name, "Keeping", covered, "on-demand instances covered by Reserved Instances or Savings Plans")
+		minOnDemand = covered
+	}
+
 	if totalRunning == 0 {
 		log.Printf("The group %s is currently empty or in the process of launching new instances",
 			a.name)
 		return true, totalRunning
 	}
 
-	if onDemandRunning > a.config.MinOnDemand {
+	if onDemandRunning > minOnDemand {
 		log.Println("Currently more than enough OnDemand instances running")
 		return true, totalRunning
 	}
 
-	if onDemandRunning == a.config.MinOnDemand {
+	if onDemandRunning == minOnDemand {
 		log.Println("Currently OnDemand running equals to the required number, skipping run")
 		return false, totalRunning
 	}
@@ -191,6 +205,27 @@ func (a *autoScalingGroup) allInstancesRunning() (bool, int64) {
 	return totalRunning == a.instances.count64(), onDemandRunning
 }
 
+// replacementsPaused returns the reason for which the group shouldn't take
+// any replacement actions at the given time, because of its blackout dates or
+// its cron schedule, or an empty string if it can.
+func (a *autoScalingGroup) replacementsPaused(now time.Time) string {
+	if !blackoutRunAction(now, a.config.CronBlackoutDates, a.config.CronTimezone) {
+		log.Println(a.region.name, a.name,
+			"Skipping run, inside a blackout period or having invalid blackout dates")
+		return "inside-blackout-period"
+	}
+
+	shouldRun := cronRunAction(now, a.config.CronSchedule, a.config.CronTimezone, a.config.CronScheduleState)
+	debug.Println(a.region.name, a.name, "Should take replacement actions:", shouldRun)
+
+	if !shouldRun {
+		log.Println(a.region.name, a.name,
+			"Skipping run, outside the enabled cron run schedule")
+		return "outside-cron-schedule"
+	}
+	return ""
+}
+
 func (a *autoScalingGroup) cronEventAction() runer {
 
 	a.scanInstances()
@@ -201,13 +236,22 @@ func (a *autoScalingGroup) cronEventAction() runer {
 
 	spotInstance := a.findUnattachedInstanceLaunchedForThisASG()
 
-	shouldRun := cronRunAction(time.Now(), a.config.CronSchedule, a.config.CronTimezone, a.config.CronScheduleState)
-	debug.Println(a.region.name, a.name, "Should take replacement actions:", shouldRun)
+	if a.config.Evacuate || a.evacuationInProgress() {
+		if spotInstance != nil {
+			log.Println(a.region.name, a.name, "Terminating unattached spot instance while evacuating")
+			return terminateUnneededSpotInstance{target{asg: a, spotInstance: spotInstance}}
+		}
+		return a.evacuationAction()
+	}
 
-	if !shouldRun {
-		log.Println(a.region.name, a.name,
-			"Skipping run, outside the enabled cron run schedule")
-		return skipRun{reason: "outside-cron-schedule"}
+	a.checkSwappedInstances(time.Now())
+
+	if a.circuitOpen(time.Now()) {
+		return skipRun{reason: "circuit-open"}
+	}
+
+	if reason := a.replacementsPaused(time.Now()); reason != "" {
+		return skipRun{reason: reason}
 	}
 
 	if spotInstance == nil {
@@ -217,6 +261,9 @@ func (a *autoScalingGroup) cronEventAction() runer {
 
 		if need, total := a.needReplaceOnDemandInstances(); !need {
 			log.Printf("Not allowed to replace any more of the running OD instances in %s", a.name)
+			if action := a.scheduledOnDemandAction(); action != nil {
+				return action
+			}
 			return terminateSpotInstance{target{asg: a, totalInstances: total}}
 		}
 
@@ -227,6 +274,22 @@ func (a *autoScalingGroup) cronEventAction() runer {
 			return skipRun{reason: "no-instances-to-replace"}
 		}
 
+		if reason := a.replacementThrottled(time.Now()); reason != "" {
+			log.Println(a.region.name, a.name, "Delaying the replacement of on-demand instances:", reason)
+			return skipRun{reason: reason}
+		}
+
+		if reason := a.region.reserveGlobalReplacement(time.Now()); reason != "" {
+			log.Println(a.region.name, a.name, "Delaying the replacement of on-demand instances:", reason)
+			return skipRun{reason: reason}
+		}
+
+		if !a.region.reserveRunReplacement() {
+			log.Println(a.region.name, a.name,
+				"Reached the maximum number of replacements for this run, delaying the replacement")
+			return skipRun{reason: "max-replacements-per-run"}
+		}
+
 		a.loadLaunchConfiguration()
 		a.loadLaunchTemplate()
 
@@ -245,7 +308,7 @@ func (a *autoScalingGroup) cronEventAction() runer {
 	spotInstanceID := *spotInstance.InstanceId
 	log.Println("Found unattached spot instance", spotInstanceID)
 
-	if need, total := a.needReplaceOnDemandInstances(); !need || !shouldRun {
+	if need, total := a.needReplaceOnDemandInstances(); !need {
 		// add to FinalRecap
 		recapText := fmt.Sprintf("%s Terminated spot instance %s [not needed]", a.name, spotInstanceID)
 		a.region.conf.FinalRecap[a.region.name] = append(a.region.conf.FinalRecap[a.region.name], recapText)
@@ -295,6 +358,10 @@ func (a *autoScalingGroup) scanInstances() instances {
 			i.protected = i.protected || *inst.ProtectedFromScaleIn
 		}
 
+		if ti, found := a.instanceTypeInformation()[i.typeInfo.instanceType]; found {
+			i.typeInfo = ti
+		}
+
 		if i.isSpot() {
 			i.price = i.typeInfo.pricing.spot[*i.Placement.AvailabilityZone]
 		} else {
@@ -378,6 +445,12 @@ func (a *autoScalingGroup) getInstance(
 				continue
 			}
 
+			if considerInstanceProtection && onDemand && i.isCoveredByReservation() {
+				debug.Println(a.name, "skipping instance", *i.InstanceId,
+					"covered by Reserved Instances or Savings Plans")
+				continue
+			}
+
 			if (availabilityZone != nil) && (*availabilityZone != *i.Placement.AvailabilityZone) {
 				debug.Println(a.name, "skipping instance", *i.InstanceId,
 					"placed in a different AZ than what we're looking for")
@@ -485,9 +558,11 @@ func (a *autoScalingGroup) getAllowedInstanceTypes(baseInstance *instance) []str
 	}
 
 	// Simple trick to avoid returning list with empty elements
-	return strings.FieldsFunc(allowed, func(c rune) bool {
+	allowedList := strings.FieldsFunc(allowed, func(c rune) bool {
 		return c == ','
 	})
+
+	return a.filterMixedInstancesPolicyInstanceTypes(allowedList, baseInstance)
 }
 
 func (a *autoScalingGroup) getDisallowedInstanceTypes(baseInstance *instance) []string {
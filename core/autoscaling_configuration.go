@@ -7,6 +7,7 @@ import (
 	"log"
 	"math"
 	"strconv"
+	"time"
 )
 
 const (
@@ -93,6 +94,10 @@ const (
 	// can override the global value of the CronScheduleState parameter
 	CronScheduleStateTag = "autospotting_cron_schedule_state"
 
+	// CronBlackoutDatesTag is the name of the tag set on the AutoScaling Group
+	// that can override the global value of the CronBlackoutDates parameter
+	CronBlackoutDatesTag = "autospotting_cron_blackout_dates"
+
 	// EnableInstanceLaunchEventHandlingTag is the name of the tag set on the
 	// AutoScaling Group that enables the event-based instance replacement logic
 	// for this group. It is set automatically once the legacy cron-based
@@ -114,6 +119,18 @@ const (
 	// TerminationNotificationActionTag is the name of the tag set on the AutoScaling Group that
 	// can override the global value of the TerminationNotificationAction parameter
 	TerminationNotificationActionTag = "autospotting_termination_notification_action"
+
+	// MaxInterruptionRateTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the MaxInterruptionRate parameter
+	MaxInterruptionRateTag = "autospotting_max_interruption_rate"
+
+	// LaunchInAllSubnetsTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the LaunchInAllSubnets parameter
+	LaunchInAllSubnetsTag = "autospotting_launch_in_all_subnets"
+
+	// DefaultMaxInterruptionRate is the default value for the maximum interruption
+	// frequency of the Spot pools, the value 0 means all pools are allowed.
+	DefaultMaxInterruptionRate = 0.0
 )
 
 // AutoScalingConfig stores some group-specific configurations that can override
@@ -122,6 +139,7 @@ type AutoScalingConfig struct {
 	MinOnDemand             int64
 	MinOnDemandNumber       int64
 	MinOnDemandPercentage   float64
+	MinOnDemandSchedule     string
 	AllowedInstanceTypes    string
 	DisallowedInstanceTypes string
 
@@ -144,6 +162,7 @@ type AutoScalingConfig struct {
 	CronSchedule      string
 	CronTimezone      string
 	CronScheduleState string // "on" or "off", dictate whether to run inside the CronSchedule or not
+	CronBlackoutDates string // date ranges in which no actions are taken, regardless of the CronSchedule
 
 	PatchBeanstalkUserdata bool
 
@@ -155,6 +174,66 @@ type AutoScalingConfig struct {
 	// Further information about this is available at
 	// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/ec2-fleet-allocation-strategy.html
 	SpotAllocationStrategy string
+
+	// Maximum interruption frequency percentage of the Spot pools, based on the
+	// Spot Instance Advisor buckets. Pools from buckets exceeding it are excluded.
+	MaxInterruptionRate float64
+
+	// Controls whether the Spot instances can be launched in any of the subnets
+	// of the group instead of only in the subnet of the replaced instance.
+	LaunchInAllSubnets bool
+
+	// Attribute-based constraints of the Spot instance types.
+	InstanceRequirements InstanceRequirements
+
+	// Strategy used for ordering the compatible Spot instance types, and the
+	// weights of the factors used by the weighted strategy.
+	ScoringStrategy string
+	ScoringWeights  string
+
+	// Controls how strictly the GPUs and other accelerators of the Spot
+	// instance types need to match the ones of the replaced instance.
+	AcceleratorCompatibility string
+
+	// AMIs used for launching Spot instances of another CPU architecture than
+	// the replaced instance, keyed by the architecture.
+	ArchitectureImages map[string]string
+
+	// Maximum percentage of the group allowed to run on a single instance
+	// type, and on a single Spot pool. The value 0 disables the limits.
+	MaxInstanceTypeShare float64
+	MaxPoolShare         float64
+
+	// Maximum number of Spot replacements launched for the group per hour and
+	// not yet attached to it. The value 0 disables the limits.
+	MaxReplacementsPerHour  int64
+	MaxReplacementsInFlight int64
+
+	// Number of instances converted when the group is first enabled, before
+	// waiting for the soak period without health replacements.
+	CanaryInstances  int64
+	CanarySoakPeriod string
+
+	// Replace the Spot instances of the group with on-demand instances, the
+	// given number of instances at a time.
+	Evacuate            bool
+	EvacuationBatchSize int64
+
+	// Number of failed replacements and Spot instances failing health checks
+	// after which the replacements stop in the group. The value 0 disables
+	// the circuit breaker.
+	CircuitBreakerThreshold int64
+
+	// Checks the Spot instances need to pass, each within its timeout, before
+	// the on-demand instances they replace are terminated, and the port and
+	// path requested by the HTTP check.
+	ReadinessChecks    string
+	ReadinessTimeout   string
+	ReadinessHTTPProbe string
+
+	// Time during which the swapped Spot instances are watched and rolled back
+	// if they turn unhealthy. The value 0 disables the rollback.
+	SwapWatchPeriod string
 }
 
 func (a *autoScalingGroup) loadPercentageOnDemand(tagValue *string) (int64, bool) {
@@ -219,7 +298,22 @@ func (a *autoScalingGroup) loadOnDemandPriceMultiplier(tagValue *string) (float6
 	return onDemandPriceMultiplier, true
 }
 
+// getTagValue returns the value of a tag set on the group, or else the value
+// of the same setting from the configuration file.
 func (a *autoScalingGroup) getTagValue(keyMatch string) *string {
+	if value := a.groupTagValue(keyMatch); value != nil {
+		return value
+	}
+	if setting, ok := a.fileSettings[keyMatch]; ok {
+		value := setting.value
+		return &value
+	}
+	return nil
+}
+
+// groupTagValue returns the value of a tag set on the group itself, ignoring
+// the configuration file.
+func (a *autoScalingGroup) groupTagValue(keyMatch string) *string {
 	for _, asgTag := range a.Tags {
 		if *asgTag.Key == keyMatch {
 			return asgTag.Value
@@ -236,6 +330,10 @@ func (a *autoScalingGroup) setMinOnDemandIfLarger(newValue int64, hasMinOnDemand
 }
 
 func (a *autoScalingGroup) loadConfOnDemand() bool {
+	if a.loadMinOnDemandSchedule(time.Now()) {
+		return true
+	}
+
 	tagList := [2]string{OnDemandNumberLong, OnDemandPercentageTag}
 	loadDyn := map[string]func(*string) (int64, bool){
 		OnDemandPercentageTag: a.loadPercentageOnDemand,
@@ -275,6 +373,27 @@ func (a *autoScalingGroup) loadPatchBeanstalkUserdata() bool {
 	return false
 }
 
+func (a *autoScalingGroup) loadLaunchInAllSubnets() bool {
+	a.config.LaunchInAllSubnets = a.region.conf.LaunchInAllSubnets
+
+	tagValue := a.getTagValue(LaunchInAllSubnetsTag)
+
+	if tagValue != nil {
+		log.Printf("Loaded LaunchInAllSubnets value %v from tag %v\n", *tagValue, LaunchInAllSubnetsTag)
+		val, err := strconv.ParseBool(*tagValue)
+
+		if err != nil {
+			log.Printf("Failed to parse LaunchInAllSubnets value %v as a boolean", *tagValue)
+			return false
+		}
+		a.config.LaunchInAllSubnets = val
+		return true
+	}
+
+	debug.Println("Couldn't find tag", LaunchInAllSubnetsTag, "on the group", a.name, "using the default configuration")
+	return false
+}
+
 func (a *autoScalingGroup) loadSpotAllocationStrategy() bool {
 	a.config.SpotAllocationStrategy = a.region.conf.SpotAllocationStrategy
 
@@ -351,6 +470,19 @@ func (a *autoScalingGroup) LoadCronTimezone() bool {
 	return false
 }
 
+func (a *autoScalingGroup) LoadCronBlackoutDates() bool {
+	tagValue := a.getTagValue(CronBlackoutDatesTag)
+	if tagValue != nil {
+		log.Printf("Loaded CronBlackoutDates value %v from tag %v\n", *tagValue, CronBlackoutDatesTag)
+		a.config.CronBlackoutDates = *tagValue
+		return true
+	}
+
+	debug.Println("Couldn't find tag", CronBlackoutDatesTag, "on the group", a.name, "using the default configuration")
+	a.config.CronBlackoutDates = a.region.conf.CronBlackoutDates
+	return false
+}
+
 func (a *autoScalingGroup) LoadCronScheduleState() bool {
 	tagValue := a.getTagValue(CronScheduleStateTag)
 	if tagValue != nil {
@@ -377,6 +509,29 @@ func (a *autoScalingGroup) loadTerminationNotificationAction() bool {
 	return false
 }
 
+func (a *autoScalingGroup) loadMaxInterruptionRate() bool {
+	a.config.MaxInterruptionRate = a.region.conf.MaxInterruptionRate
+
+	tagValue := a.getTagValue(MaxInterruptionRateTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", MaxInterruptionRateTag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	rate, err := strconv.ParseFloat(*tagValue, 64)
+	if err != nil {
+		log.Printf("Error with ParseFloat: %s\n", err.Error())
+		return false
+	} else if rate < 0 || rate > 100 {
+		log.Printf("Ignoring out of range value : %f\n", rate)
+		return false
+	}
+
+	log.Printf("Loaded MaxInterruptionRate value %v from tag %v\n", rate, MaxInterruptionRateTag)
+	a.config.MaxInterruptionRate = rate
+	return true
+}
+
 func (a *autoScalingGroup) loadConfSpot() bool {
 	tagValue := a.getTagValue(BiddingPolicyTag)
 	if tagValue == nil {
@@ -429,11 +584,19 @@ func (a *autoScalingGroup) loadConfOnDemandPriceMultiplier() bool {
 func (a *autoScalingGroup) loadConfigFromTags() bool {
 	ret := false
 
+	a.loadConfigFileSettings()
+	a.logInvalidTags()
+
 	if a.loadConfOnDemand() {
 		log.Println("Found and applied configuration for OnDemand value")
 		ret = true
 	}
 
+	if a.loadMixedInstancesPolicyOnDemand() {
+		log.Println("Found and applied configuration for OnDemand value from the MixedInstancesPolicy")
+		ret = true
+	}
+
 	if a.loadConfOnDemandPriceMultiplier() {
 		log.Println("Found and applied configuration for OnDemand Price Multiplier")
 		ret = true
@@ -464,6 +627,11 @@ func (a *autoScalingGroup) loadConfigFromTags() bool {
 		ret = true
 	}
 
+	if a.LoadCronBlackoutDates() {
+		log.Println("Found and applied configuration for CronBlackoutDates")
+		ret = true
+	}
+
 	if a.loadPatchBeanstalkUserdata() {
 		log.Println("Found and applied configuration for PatchBeanstalkUserdata")
 		ret = true
@@ -484,6 +652,76 @@ func (a *autoScalingGroup) loadConfigFromTags() bool {
 		ret = true
 	}
 
+	if a.loadMaxInterruptionRate() {
+		log.Println("Found and applied configuration for MaxInterruptionRate")
+		ret = true
+	}
+
+	if a.loadLaunchInAllSubnets() {
+		log.Println("Found and applied configuration for LaunchInAllSubnets")
+		ret = true
+	}
+
+	if a.loadInstanceRequirements() {
+		log.Println("Found and applied configuration for InstanceRequirements")
+		ret = true
+	}
+
+	if a.loadScoringStrategy() {
+		log.Println("Found and applied configuration for ScoringStrategy")
+		ret = true
+	}
+
+	if a.loadAcceleratorCompatibility() {
+		log.Println("Found and applied configuration for AcceleratorCompatibility")
+		ret = true
+	}
+
+	if a.loadArchitectureImages() {
+		log.Println("Found and applied configuration for ArchitectureImages")
+		ret = true
+	}
+
+	if a.loadMaxInstanceTypeShare() {
+		log.Println("Found and applied configuration for MaxInstanceTypeShare")
+		ret = true
+	}
+
+	if a.loadMaxPoolShare() {
+		log.Println("Found and applied configuration for MaxPoolShare")
+		ret = true
+	}
+
+	if a.loadRolloutConfiguration() {
+		log.Println("Found and applied configuration for the replacement rollout")
+		ret = true
+	}
+
+	if a.loadEvacuate() {
+		log.Println("Found and applied configuration for Evacuate")
+		ret = true
+	}
+
+	if a.loadEvacuationBatchSize() {
+		log.Println("Found and applied configuration for EvacuationBatchSize")
+		ret = true
+	}
+
+	if a.loadCircuitBreakerThreshold() {
+		log.Println("Found and applied configuration for CircuitBreakerThreshold")
+		ret = true
+	}
+
+	if a.loadReadinessChecks() {
+		log.Println("Found and applied configuration for ReadinessChecks")
+		ret = true
+	}
+
+	if a.loadSwapWatchPeriod() {
+		log.Println("Found and applied configuration for SwapWatchPeriod")
+		ret = true
+	}
+
 	return ret
 }
 
@@ -525,5 +763,11 @@ func (a *autoScalingGroup) loadDefaultConfig() bool {
 	} else {
 		log.Println("No default value for on-demand instances specified, skipping.")
 	}
+
+	if a.region.conf.MinOnDemandSchedule != "" {
+		if onDemand, found := a.scheduledMinOnDemand(a.region.conf.MinOnDemandSchedule, time.Now()); found {
+			a.config.MinOnDemand, done = onDemand, true
+		}
+	}
 	return done
 }
@@ -1392,3 +1392,177 @@ func Test_autoScalingGroup_loadGP2ConversionThreshold(t *testing.T) {
 		})
 	}
 }
+
+func Test_autoScalingGroup_loadMaxInterruptionRate(t *testing.T) {
+	tests := []struct {
+		name       string
+		group      *autoscaling.Group
+		region     *region
+		want       bool
+		wantConfig AutoScalingConfig
+	}{
+		{
+			name:  "No tag set on the group, use region config",
+			group: &autoscaling.Group{},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						MaxInterruptionRate: 15,
+					},
+				},
+			},
+			want: false,
+			wantConfig: AutoScalingConfig{
+				MaxInterruptionRate: 15,
+			},
+		},
+		{
+			name: "Tag set on the group",
+			group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(MaxInterruptionRateTag),
+						Value: aws.String("10"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						MaxInterruptionRate: 15,
+					},
+				},
+			},
+			want: true,
+			wantConfig: AutoScalingConfig{
+				MaxInterruptionRate: 10,
+			},
+		},
+		{
+			name: "Invalid tag value set on the group",
+			group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(MaxInterruptionRateTag),
+						Value: aws.String("ten"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{},
+			},
+			want:       false,
+			wantConfig: AutoScalingConfig{},
+		},
+		{
+			name: "Out of range tag value set on the group",
+			group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(MaxInterruptionRateTag),
+						Value: aws.String("120"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{},
+			},
+			want:       false,
+			wantConfig: AutoScalingConfig{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				region: tt.region,
+				Group:  tt.group,
+			}
+			if got := a.loadMaxInterruptionRate(); got != tt.want {
+				t.Errorf("autoScalingGroup.loadMaxInterruptionRate() = %v, want %v", got, tt.want)
+			}
+
+			if !reflect.DeepEqual(a.config, tt.wantConfig) {
+				t.Errorf("autoScalingGroup.loadMaxInterruptionRate() created incorrect ASG configuration: %v expected %v",
+					spew.Sdump(a.config), spew.Sdump(tt.wantConfig))
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadLaunchInAllSubnets(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		group      *autoscaling.Group
+		region     *region
+		want       bool
+		wantConfig AutoScalingConfig
+	}{
+		{
+			name:  "No tag set on the group, use region config",
+			group: &autoscaling.Group{},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						LaunchInAllSubnets: true,
+					},
+				},
+			},
+			want: false,
+			wantConfig: AutoScalingConfig{
+				LaunchInAllSubnets: true,
+			},
+		},
+		{
+			name: "Tag set on the group",
+			group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(LaunchInAllSubnetsTag),
+						Value: aws.String("true"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{},
+			},
+			want: true,
+			wantConfig: AutoScalingConfig{
+				LaunchInAllSubnets: true,
+			},
+		},
+		{
+			name: "Invalid tag value, use region config",
+			group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(LaunchInAllSubnetsTag),
+						Value: aws.String("maybe"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{},
+			},
+			want:       false,
+			wantConfig: AutoScalingConfig{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				region: tt.region,
+				Group:  tt.group,
+			}
+			if got := a.loadLaunchInAllSubnets(); got != tt.want {
+				t.Errorf("autoScalingGroup.loadLaunchInAllSubnets() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(a.config, tt.wantConfig) {
+				t.Errorf("loadLaunchInAllSubnets() created incorrect ASG configuration: %v expected %v",
+					a.config, tt.wantConfig)
+			}
+		})
+	}
+}
@@ -3407,3 +3407,54 @@ func Test_autoScalingGroup_cronEventAction(t *testing.T) {
 		})
 	}
 }
+
+func Test_autoScalingGroup_replacementsPaused(t *testing.T) {
+	// a Saturday
+	now := time.Date(2023, 11, 25, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		blackout  string
+		schedule  string
+		wantPause string
+	}{
+		{
+			name:     "inside the schedule",
+			schedule: DefaultCronSchedule,
+		},
+		{
+			name:      "inside a blackout period",
+			blackout:  "2023-11-24/2023-11-27",
+			schedule:  DefaultCronSchedule,
+			wantPause: "inside-blackout-period",
+		},
+		{
+			name:      "invalid blackout dates",
+			blackout:  "24/11/2023",
+			schedule:  DefaultCronSchedule,
+			wantPause: "inside-blackout-period",
+		},
+		{
+			name:      "outside the schedule",
+			schedule:  "9-18 1-5",
+			wantPause: "outside-cron-schedule",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name:   "asg",
+				region: &region{name: "us-east-1"},
+				config: AutoScalingConfig{
+					CronBlackoutDates: tt.blackout,
+					CronSchedule:      tt.schedule,
+					CronScheduleState: CronScheduleStateOn,
+					CronTimezone:      "UTC",
+				},
+			}
+			if got := a.replacementsPaused(now); got != tt.wantPause {
+				t.Errorf("replacementsPaused() = %q, want %q", got, tt.wantPause)
+			}
+		})
+	}
+}
@@ -0,0 +1,338 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// circuit_breaker.go contains the logic used for stopping the replacements in
+// a group after repeated failures, such as Spot instances failing the health
+// checks or failing to be swapped into the group, and for resuming them
+// gradually after a cooldown period.
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// CircuitBreakerThresholdTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the CircuitBreakerThreshold
+	// parameter
+	CircuitBreakerThresholdTag = "autospotting_circuit_breaker_threshold"
+
+	// CircuitOpenTag is the name of the tag set by AutoSpotting on the
+	// AutoScaling groups in which it stopped acting after repeated failures,
+	// as the unix timestamp of the opening followed by the reason. It is set
+	// to "closed" followed by a unix timestamp once the replacements resume,
+	// and can be removed or set to "false" for resetting the circuit breaker.
+	CircuitOpenTag = "autospotting_circuit_open"
+
+	// ReplacementFailuresTag is the name of the tag set by AutoSpotting on the
+	// AutoScaling groups to keep track of the recent failed replacements, as a
+	// space separated list of unix timestamps.
+	ReplacementFailuresTag = "autospotting_replacement_failures"
+
+	// DefaultCircuitBreakerWindow is the default time interval in which the
+	// failures are counted.
+	DefaultCircuitBreakerWindow = time.Hour
+
+	// DefaultCircuitBreakerCooldown is the default amount of time after which
+	// an open circuit breaker allows a trial replacement.
+	DefaultCircuitBreakerCooldown = 2 * time.Hour
+
+	circuitClosed = "closed"
+)
+
+var instanceIDPattern = regexp.MustCompile(`i-[0-9a-f]+`)
+
+// circuitState is the state of the circuit breaker of a group, decoded from
+// the CircuitOpenTag.
+type circuitState struct {
+	open bool
+	// the time at which the circuit breaker was opened or closed
+	since  time.Time
+	reason string
+}
+
+func parseCircuitState(value *string) circuitState {
+	if value == nil {
+		return circuitState{}
+	}
+
+	fields := strings.SplitN(strings.TrimSpace(*value), " ", 2)
+	if fields[0] == "" || fields[0] == "false" {
+		return circuitState{}
+	}
+
+	if fields[0] == circuitClosed {
+		state := circuitState{}
+		if len(fields) == 2 {
+			if ts, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+				state.since = time.Unix(ts, 0)
+			}
+		}
+		return state
+	}
+
+	ts, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		debug.Println("Ignoring invalid circuit breaker state", *value)
+		return circuitState{}
+	}
+
+	state := circuitState{open: true, since: time.Unix(ts, 0)}
+	if len(fields) == 2 {
+		state.reason = fields[1]
+	}
+	return state
+}
+
+func (s circuitState) String() string {
+	if !s.open {
+		return fmt.Sprintf("%s %d", circuitClosed, s.since.Unix())
+	}
+
+	value := fmt.Sprintf("%d %s", s.since.Unix(), s.reason)
+	if len(value) > maxTagValueLength {
+		value = value[:maxTagValueLength]
+	}
+	return value
+}
+
+func (r *region) circuitBreakerWindow() time.Duration {
+	if r.conf == nil || r.conf.CircuitBreakerWindow <= 0 {
+		return DefaultCircuitBreakerWindow
+	}
+	return r.conf.CircuitBreakerWindow
+}
+
+func (r *region) circuitBreakerCooldown() time.Duration {
+	if r.conf == nil || r.conf.CircuitBreakerCooldown <= 0 {
+		return DefaultCircuitBreakerCooldown
+	}
+	return r.conf.CircuitBreakerCooldown
+}
+
+// healthReplacement is an instance replaced by the group because of a failed
+// health check.
+type healthReplacement struct {
+	// empty if the scaling activity doesn't mention the instance
+	id   string
+	time time.Time
+	spot bool
+}
+
+// healthReplacements returns the instances replaced by the group because of
+// failed health checks since the given time. The interrupted Spot instances
+// are left out, since the group replaces them in response to an EC2 health
+// check as well once they're stopped or terminated.
+func (a *autoScalingGroup) healthReplacements(since time.Time) ([]healthReplacement, error) {
+	resp, err := a.region.services.autoScaling.DescribeScalingActivities(
+		&autoscaling.DescribeScalingActivitiesInput{
+			AutoScalingGroupName: a.AutoScalingGroupName,
+		})
+
+	if err != nil {
+		log.Println(a.region.name, a.name, "Failed to describe scaling activities", err.Error())
+		return nil, err
+	}
+
+	var result []healthReplacement
+	var ids []*string
+	for _, activity := range resp.Activities {
+		if activity.StartTime == nil || activity.StartTime.Before(since) ||
+			!strings.Contains(strings.ToLower(aws.StringValue(activity.Cause)), "health check") {
+			continue
+		}
+		r := healthReplacement{
+			id:   instanceIDPattern.FindString(aws.StringValue(activity.Description)),
+			time: *activity.StartTime,
+		}
+		if r.id != "" {
+			ids = append(ids, aws.String(r.id))
+		}
+		result = append(result, r)
+	}
+
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	// filtering by ID doesn't fail for the instances no longer visible
+	resp2, err := a.region.services.ec2.DescribeInstances(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: ids}},
+	})
+
+	if err != nil {
+		log.Println(a.region.name, a.name, "Failed to describe the instances replaced by health checks", err.Error())
+		return nil, err
+	}
+
+	spot := make(map[string]bool)
+	interrupted := make(map[string]bool)
+	for _, reservation := range resp2.Reservations {
+		for _, inst := range reservation.Instances {
+			id := aws.StringValue(inst.InstanceId)
+			spot[id] = aws.StringValue(inst.InstanceLifecycle) == Spot
+			interrupted[id] = isSpotInterrupted(inst)
+		}
+	}
+
+	filtered := result[:0]
+	for _, r := range result {
+		if interrupted[r.id] {
+			debug.Println(a.region.name, a.name, "Ignoring the replacement of the interrupted Spot instance", r.id)
+			continue
+		}
+		r.spot = spot[r.id]
+		filtered = append(filtered, r)
+	}
+	return filtered, nil
+}
+
+// spotHealthReplacements counts the Spot instances replaced by the group
+// because of failed health checks since the given time.
+func (a *autoScalingGroup) spotHealthReplacements(since time.Time) (int, error) {
+	replacements, err := a.healthReplacements(since)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, r := range replacements {
+		if r.spot {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// replacementFailures returns the number of failed replacements and of Spot
+// instances failing health checks since the given time.
+func (a *autoScalingGroup) replacementFailures(now, since time.Time) (int, int) {
+	swaps := 0
+	if tagValue := a.getTagValue(ReplacementFailuresTag); tagValue != nil {
+		swaps = len(parseTimestamps(*tagValue, now, now.Sub(since)))
+	}
+
+	health, _ := a.spotHealthReplacements(since)
+	return swaps, health
+}
+
+// circuitOpen returns whether AutoSpotting should stop acting on the group,
+// opening the circuit breaker when the failures reached the threshold. Once
+// the cooldown period passed, the circuit breaker is half-open and allows
+// replacements again, but it opens again on the first failure.
+func (a *autoScalingGroup) circuitOpen(now time.Time) bool {
+	state := parseCircuitState(a.getTagValue(CircuitOpenTag))
+
+	if state.open {
+		halfOpen := state.since.Add(a.region.circuitBreakerCooldown())
+		if now.Before(halfOpen) {
+			log.Println(a.region.name, a.name, "Circuit breaker open since",
+				state.since.UTC().Format(time.RFC3339), "because of", state.reason)
+			return true
+		}
+
+		if swaps, health := a.replacementFailures(now, halfOpen); swaps+health > 0 {
+			a.openCircuit(now, fmt.Sprintf("%d failed replacements and %d Spot health check failures while half-open",
+				swaps, health))
+			return true
+		}
+		debug.Println(a.region.name, a.name, "Circuit breaker half-open, allowing a trial replacement")
+		return false
+	}
+
+	threshold := a.config.CircuitBreakerThreshold
+	if threshold <= 0 {
+		return false
+	}
+
+	since := now.Add(-a.region.circuitBreakerWindow())
+	if state.since.After(since) {
+		since = state.since
+	}
+
+	if swaps, health := a.replacementFailures(now, since); int64(swaps+health) >= threshold {
+		a.openCircuit(now, fmt.Sprintf("%d failed replacements and %d Spot health check failures in %s",
+			swaps, health, now.Sub(since).Round(time.Minute)))
+		return true
+	}
+	return false
+}
+
+// circuitHalfOpen returns whether the circuit breaker of the group is open
+// but past its cooldown period.
+func (a *autoScalingGroup) circuitHalfOpen(now time.Time) bool {
+	state := parseCircuitState(a.getTagValue(CircuitOpenTag))
+	return state.open && !now.Before(state.since.Add(a.region.circuitBreakerCooldown()))
+}
+
+func (a *autoScalingGroup) openCircuit(now time.Time, reason string) {
+	state := circuitState{open: true, since: now, reason: reason}
+	log.Println(a.region.name, a.name, "Opening the circuit breaker:", reason)
+
+	a.setStateTags(map[string]string{CircuitOpenTag: state.String()})
+
+	recapText := fmt.Sprintf("%s Circuit breaker opened [%s]", a.name, reason)
+	a.region.conf.FinalRecap[a.region.name] = append(a.region.conf.FinalRecap[a.region.name], recapText)
+
+	a.region.notify(fmt.Sprintf("AutoSpotting circuit breaker opened for %s", a.name),
+		fmt.Sprintf("AutoSpotting stopped replacing instances in the group %s from %s: %s.\n"+
+			"It will allow a trial replacement after %s, or it can be reset by removing the %s tag.",
+			a.name, a.region.name, reason, now.Add(a.region.circuitBreakerCooldown()).UTC().Format(time.RFC3339),
+			CircuitOpenTag))
+}
+
+func (a *autoScalingGroup) closeCircuit(now time.Time) {
+	log.Println(a.region.name, a.name, "Closing the circuit breaker after a successful trial replacement")
+
+	a.setStateTags(map[string]string{
+		CircuitOpenTag:         circuitState{since: now}.String(),
+		ReplacementFailuresTag: "",
+	})
+
+	a.region.notify(fmt.Sprintf("AutoSpotting circuit breaker closed for %s", a.name),
+		fmt.Sprintf("AutoSpotting resumed replacing instances in the group %s from %s.", a.name, a.region.name))
+}
+
+// recordReplacementFailure counts a failed replacement, opening the circuit
+// breaker again if it was half-open.
+func (a *autoScalingGroup) recordReplacementFailure(now time.Time, reason string) {
+	log.Println(a.region.name, a.name, "Replacement failed:", reason)
+
+	if a.circuitHalfOpen(now) {
+		a.openCircuit(now, "trial replacement failed: "+reason)
+		return
+	}
+
+	if a.config.CircuitBreakerThreshold <= 0 {
+		return
+	}
+
+	var failures []time.Time
+	if tagValue := a.getTagValue(ReplacementFailuresTag); tagValue != nil {
+		failures = parseTimestamps(*tagValue, now, a.region.circuitBreakerWindow())
+	}
+	a.setStateTags(map[string]string{ReplacementFailuresTag: formatRecentReplacements(append(failures, now))})
+}
+
+// recordReplacementSuccess closes the circuit breaker after a successful
+// trial replacement.
+func (a *autoScalingGroup) recordReplacementSuccess(now time.Time) {
+	if a.circuitHalfOpen(now) {
+		a.closeCircuit(now)
+	}
+}
+
+func (a *autoScalingGroup) loadCircuitBreakerThreshold() bool {
+	a.config.CircuitBreakerThreshold = a.region.conf.CircuitBreakerThreshold
+	return a.loadRolloutLimit(CircuitBreakerThresholdTag, &a.config.CircuitBreakerThreshold)
+}
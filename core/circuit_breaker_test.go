@@ -0,0 +1,245 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+func Test_parseCircuitState(t *testing.T) {
+	tests := []struct {
+		value *string
+		want  circuitState
+	}{
+		{value: nil, want: circuitState{}},
+		{value: aws.String("false"), want: circuitState{}},
+		{value: aws.String(""), want: circuitState{}},
+		{value: aws.String("closed 1700000000"), want: circuitState{since: time.Unix(1700000000, 0)}},
+		{value: aws.String("open"), want: circuitState{}},
+		{
+			value: aws.String("1700000000 3 failed replacements"),
+			want:  circuitState{open: true, since: time.Unix(1700000000, 0), reason: "3 failed replacements"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(aws.StringValue(tt.value), func(t *testing.T) {
+			got := parseCircuitState(tt.value)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCircuitState() = %+v, want %+v", got, tt.want)
+			}
+			if !got.since.IsZero() && got.String() != *tt.value {
+				t.Errorf("circuitState.String() = %v, want %v", got.String(), *tt.value)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_circuitOpen(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ago := func(d time.Duration) string { return fmt.Sprint(now.Add(-d).Unix()) }
+
+	healthActivity := func(d time.Duration) *autoscaling.DescribeScalingActivitiesOutput {
+		return &autoscaling.DescribeScalingActivitiesOutput{Activities: []*autoscaling.Activity{{
+			StartTime:   aws.Time(now.Add(-d)),
+			Description: aws.String("Terminating EC2 instance: i-0abc123"),
+			Cause: aws.String("At 2023-11-14T21:00:00Z an instance was taken out of service " +
+				"in response to an EC2 health check indicating it has been terminated or stopped."),
+		}}}
+	}
+	replaced := func(lifecycle *string) *ec2.DescribeInstancesOutput {
+		return &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{
+			Instances: []*ec2.Instance{{InstanceId: aws.String("i-0abc123"), InstanceLifecycle: lifecycle}},
+		}}}
+	}
+	interrupted := replaced(aws.String(Spot))
+	interrupted.Reservations[0].Instances[0].StateReason = &ec2.StateReason{
+		Code:    aws.String("Server.SpotInstanceTermination"),
+		Message: aws.String("Server.SpotInstanceTermination: Spot instance termination"),
+	}
+
+	tests := []struct {
+		name       string
+		threshold  int64
+		tags       map[string]string
+		activities *autoscaling.DescribeScalingActivitiesOutput
+		instances  *ec2.DescribeInstancesOutput
+		want       bool
+		wantOpened bool
+	}{
+		{
+			name: "disabled",
+			tags: map[string]string{ReplacementFailuresTag: ago(time.Minute) + " " + ago(2*time.Minute)},
+		},
+		{
+			name:       "failed replacements reaching the threshold",
+			threshold:  2,
+			tags:       map[string]string{ReplacementFailuresTag: ago(time.Minute) + " " + ago(2*time.Minute)},
+			activities: &autoscaling.DescribeScalingActivitiesOutput{},
+			want:       true,
+			wantOpened: true,
+		},
+		{
+			name:       "failed replacements outside the window",
+			threshold:  2,
+			tags:       map[string]string{ReplacementFailuresTag: ago(time.Minute) + " " + ago(2*time.Hour)},
+			activities: &autoscaling.DescribeScalingActivitiesOutput{},
+		},
+		{
+			name:      "failed replacements before the reset",
+			threshold: 2,
+			tags: map[string]string{
+				ReplacementFailuresTag: ago(time.Minute) + " " + ago(20*time.Minute),
+				CircuitOpenTag:         "closed " + ago(10*time.Minute),
+			},
+			activities: &autoscaling.DescribeScalingActivitiesOutput{},
+		},
+		{
+			name:       "spot instance failing health checks",
+			threshold:  2,
+			tags:       map[string]string{ReplacementFailuresTag: ago(time.Minute)},
+			activities: healthActivity(5 * time.Minute),
+			instances:  replaced(aws.String(Spot)),
+			want:       true,
+			wantOpened: true,
+		},
+		{
+			name:       "interrupted spot instance",
+			threshold:  2,
+			tags:       map[string]string{ReplacementFailuresTag: ago(time.Minute)},
+			activities: healthActivity(5 * time.Minute),
+			instances:  interrupted,
+		},
+		{
+			name:       "on-demand instance failing health checks",
+			threshold:  2,
+			tags:       map[string]string{ReplacementFailuresTag: ago(time.Minute)},
+			activities: healthActivity(5 * time.Minute),
+			instances:  replaced(nil),
+		},
+		{
+			name: "open",
+			tags: map[string]string{CircuitOpenTag: ago(time.Hour) + " 3 failed replacements"},
+			want: true,
+		},
+		{
+			name:       "half-open",
+			tags:       map[string]string{CircuitOpenTag: ago(3*time.Hour) + " 3 failed replacements"},
+			activities: &autoscaling.DescribeScalingActivitiesOutput{},
+		},
+		{
+			name:       "half-open with a new failure",
+			tags:       map[string]string{CircuitOpenTag: ago(3*time.Hour) + " 3 failed replacements"},
+			activities: healthActivity(30 * time.Minute),
+			instances:  replaced(aws.String(Spot)),
+			want:       true,
+			wantOpened: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var published []string
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{AutoScalingGroupName: aws.String("asg")},
+				name:   "asg",
+				config: AutoScalingConfig{CircuitBreakerThreshold: tt.threshold},
+				region: &region{
+					name: "us-east-1",
+					conf: &Config{
+						FinalRecap:        map[string][]string{},
+						NotificationTopic: "arn:aws:sns:us-east-1:123456789012:autospotting",
+					},
+					services: connections{
+						autoScaling: mockASG{dsao: tt.activities, couto: &autoscaling.CreateOrUpdateTagsOutput{}},
+						ec2:         mockEC2{dio: tt.instances},
+						sns:         mockSNS{po: &sns.PublishOutput{}, published: &published},
+					},
+				},
+			}
+			for key, value := range tt.tags {
+				a.setTagValue(key, value)
+			}
+
+			if got := a.circuitOpen(now); got != tt.want {
+				t.Errorf("circuitOpen() = %v, want %v", got, tt.want)
+			}
+
+			state := parseCircuitState(a.getTagValue(CircuitOpenTag))
+			if opened := state.open && state.since.Equal(now); opened != tt.wantOpened {
+				t.Errorf("circuit breaker opened = %v, want %v", opened, tt.wantOpened)
+			}
+			if tt.wantOpened && (len(published) != 1 || !strings.Contains(published[0], "opened")) {
+				t.Errorf("notifications = %v, want one about the opening", published)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_recordReplacementOutcome(t *testing.T) {
+	now := time.Now()
+
+	newGroup := func(circuit string) *autoScalingGroup {
+		a := &autoScalingGroup{
+			Group:  &autoscaling.Group{AutoScalingGroupName: aws.String("asg")},
+			name:   "asg",
+			config: AutoScalingConfig{CircuitBreakerThreshold: 3},
+			region: &region{
+				name:     "us-east-1",
+				conf:     &Config{FinalRecap: map[string][]string{}},
+				services: connections{autoScaling: mockASG{couto: &autoscaling.CreateOrUpdateTagsOutput{}}},
+			},
+		}
+		a.setTagValue(ReplacementFailuresTag, fmt.Sprint(now.Add(-time.Minute).Unix()))
+		if circuit != "" {
+			a.setTagValue(CircuitOpenTag, circuit)
+		}
+		return a
+	}
+	halfOpen := fmt.Sprintf("%d 3 failed replacements", now.Add(-3*time.Hour).Unix())
+
+	a := newGroup("")
+	a.recordReplacementFailure(now, "couldn't attach spot instance i-1")
+	if got := len(strings.Fields(aws.StringValue(a.getTagValue(ReplacementFailuresTag)))); got != 2 {
+		t.Errorf("recorded %d replacement failures, want 2", got)
+	}
+	a.recordReplacementSuccess(now)
+	if a.getTagValue(CircuitOpenTag) != nil {
+		t.Errorf("recordReplacementSuccess() changed a closed circuit breaker")
+	}
+
+	a = newGroup(halfOpen)
+	a.recordReplacementFailure(now, "couldn't attach spot instance i-1")
+	if state := parseCircuitState(a.getTagValue(CircuitOpenTag)); !state.open || !state.since.Equal(time.Unix(now.Unix(), 0)) {
+		t.Errorf("failed trial replacement left the circuit breaker as %+v, want it opened again", state)
+	}
+
+	a = newGroup(halfOpen)
+	a.recordReplacementSuccess(now)
+	if state := parseCircuitState(a.getTagValue(CircuitOpenTag)); state.open {
+		t.Errorf("successful trial replacement left the circuit breaker open")
+	}
+	if got := aws.StringValue(a.getTagValue(ReplacementFailuresTag)); got != "" {
+		t.Errorf("replacement failures = %q after closing the circuit breaker", got)
+	}
+}
+
+func Test_region_runGroupCommand(t *testing.T) {
+	r := &region{name: "us-east-1", services: connections{autoScaling: mockASG{
+		couto: &autoscaling.CreateOrUpdateTagsOutput{},
+	}}}
+
+	for _, command := range []string{"evacuate", "restore", "reset-circuit"} {
+		if err := r.runGroupCommand(command, "asg"); err != nil {
+			t.Errorf("runGroupCommand(%s) error = %v", command, err)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// commandUsage documents the commands which can be given as positional
+// arguments after the flags.
+const commandUsage = `Commands:
+	evacuate <region> <group>	Start replacing the Spot instances of the group with on-demand instances
+	restore <region> <group>	Stop the evacuation and allow Spot instances in the group again
+	reset-circuit <region> <group>	Close the circuit breaker of the group and forget its recent failures
+	show-config <region> <group>	Print the effective configuration of the group and the source of its settings
+	validate [<region>|<file>]...	Check the flags, the configuration file and the group tags, failing on any problem`
+
+// RunCommand executes the command given on the command line, such as
+// "evacuate us-east-1 my-group".
+func (a *AutoSpotting) RunCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n%s", commandUsage)
+	}
+
+	switch args[0] {
+	case "evacuate", "restore", "reset-circuit", "show-config":
+	case "validate":
+		return a.validate(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], commandUsage)
+	}
+
+	if len(args) != 3 {
+		return fmt.Errorf("usage: %s <region> <group>\n%s", args[0], commandUsage)
+	}
+	r := region{name: args[1], conf: a.config, services: connections{}}
+	r.services.connect(r.name, a.config.MainRegion)
+
+	if args[0] == "show-config" {
+		return r.showGroupConfig(args[2])
+	}
+	return r.runGroupCommand(args[0], args[2])
+}
+
+// runGroupCommand executes a command acting on a single group.
+func (r *region) runGroupCommand(command, group string) error {
+	var err error
+
+	switch command {
+	case "evacuate", "restore":
+		err = r.setGroupEvacuation(group, command == "evacuate")
+	case "reset-circuit":
+		err = r.setGroupTags(group, map[string]string{
+			CircuitOpenTag:         circuitState{since: time.Now()}.String(),
+			ReplacementFailuresTag: "",
+		})
+	}
+
+	if err != nil {
+		return err
+	}
+	log.Printf("Executed %s on the group %s in %s, applied by the next run\n", command, group, r.name)
+	return nil
+}
+
+// setGroupTags sets the given tags on a group, without propagating them to
+// its instances.
+func (r *region) setGroupTags(group string, values map[string]string) error {
+	var tags []*autoscaling.Tag
+	for key, value := range values {
+		tags = append(tags, &autoscaling.Tag{
+			ResourceId:        aws.String(group),
+			ResourceType:      aws.String("auto-scaling-group"),
+			Key:               aws.String(key),
+			Value:             aws.String(value),
+			PropagateAtLaunch: aws.Bool(false),
+		})
+	}
+
+	_, err := r.services.autoScaling.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{Tags: tags})
+	if err != nil {
+		return fmt.Errorf("couldn't tag the group %s in %s: %s", group, r.name, err.Error())
+	}
+	return nil
+}
+
+// showGroupConfig prints the configuration of a group as it would be loaded by
+// the next run, along with the source of the settings it doesn't take from the
+// command line flags.
+func (r *region) showGroupConfig(group string) error {
+	resp, err := r.services.autoScaling.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(group)},
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't describe the group %s in %s: %s", group, r.name, err.Error())
+	}
+	if len(resp.AutoScalingGroups) == 0 {
+		return fmt.Errorf("couldn't find the group %s in %s", group, r.name)
+	}
+
+	a := autoScalingGroup{
+		Group:     resp.AutoScalingGroups[0],
+		name:      group,
+		region:    r,
+		instances: makeInstances(),
+		config:    r.conf.AutoScalingConfig,
+	}
+	a.loadDefaultConfig()
+	a.loadConfigFromTags()
+
+	fmt.Println(a.effectiveConfig())
+	return nil
+}
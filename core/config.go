@@ -106,6 +106,10 @@ type Config struct {
 	// JSON file containing event data used for locally simulating execution from Lambda.
 	EventFile string
 
+	// Command given as positional arguments on the command line, such as
+	// "evacuate us-east-1 my-group", executed instead of processing events.
+	Command []string
+
 	// Final Recap String Array to show actions taken by ScheduleRun on ASGs
 	FinalRecap map[string][]string
 
@@ -123,6 +127,96 @@ type Config struct {
 
 	// BillingOnly - only billing related actions will be taken, no instance replacement will be performed.
 	BillingOnly bool
+
+	// MaxReplacementsPerRun is the maximum number of Spot replacements started
+	// by a cron run across all the groups, the value 0 disables the limit.
+	MaxReplacementsPerRun int64
+
+	// MaxGlobalReplacementsPerHour is the maximum number of Spot replacements
+	// launched in the last hour across all the groups, the value 0 disables
+	// the limit.
+	MaxGlobalReplacementsPerHour int64
+
+	// MaxGlobalReplacementsInFlight is the maximum number of Spot replacements
+	// not yet attached across all the groups, the value 0 disables the limit.
+	MaxGlobalReplacementsInFlight int64
+
+	// SpotAdvisorData is the local file or http(s) URL of the Spot Instance
+	// Advisor dataset used for estimating the interruption frequency of the
+	// Spot pools.
+	SpotAdvisorData string
+
+	// Spot Instance Advisor data loaded from SpotAdvisorData
+	spotAdvisor *spotAdvisorData
+
+	// EnableSpotPlacementScores controls whether the Spot placement scores are
+	// used for prioritizing the instance types and picking the subnet of the
+	// Spot instances.
+	EnableSpotPlacementScores bool
+
+	// SpotPlacementScoreTTL is the amount of time for which the Spot placement
+	// scores are cached before being fetched again.
+	SpotPlacementScoreTTL time.Duration
+
+	// FailedPoolCooldown is the amount of time for which the Spot pools that
+	// failed to launch instances are avoided.
+	FailedPoolCooldown time.Duration
+
+	// MaxLaunchBackoff is the maximum delay between the Spot launch attempts
+	// of a group failing repeatedly.
+	MaxLaunchBackoff time.Duration
+
+	// CircuitBreakerWindow is the time interval in which the failures are
+	// counted by the circuit breaker, and CircuitBreakerCooldown is the time
+	// after which an open circuit breaker allows a trial replacement.
+	CircuitBreakerWindow   time.Duration
+	CircuitBreakerCooldown time.Duration
+
+	// NotificationTopic is the ARN of the SNS topic from the main region to
+	// which notifications are published, such as for the circuit breaker.
+	NotificationTopic string
+
+	// EnableReservationCoverage controls whether the on-demand instances
+	// covered by active Reserved Instances are left in place.
+	EnableReservationCoverage bool
+
+	// SavingsPlanHourlyCommitment is the hourly Savings Plan commitment used
+	// for covering the on-demand instances of each region, which are then left
+	// in place.
+	SavingsPlanHourlyCommitment float64
+
+	// EnablePricingAPI controls whether the on-demand prices are fetched from
+	// the AWS Pricing API instead of using the data bundled in the binary.
+	EnablePricingAPI bool
+
+	// PricingCache is the local file or S3 URL where the prices fetched from
+	// the Pricing API are cached.
+	PricingCache string
+
+	// PricingCacheTTL is the amount of time for which the cached prices are
+	// used before being fetched again.
+	PricingCacheTTL time.Duration
+
+	// pricing is the provider of the on-demand prices
+	pricing pricingProvider
+
+	// InstanceCatalogOverlay is the local JSON or YAML file used for adding or
+	// patching instance types in the bundled instance data.
+	InstanceCatalogOverlay string
+
+	// ConfigFile is the local path or S3 URL of the configuration file with
+	// per-group rules, and configFile is its parsed content.
+	ConfigFile string
+	configFile *configFile
+
+	// EnableDescribeInstanceTypes controls whether the hardware specs of the
+	// instance types are fetched from the DescribeInstanceTypes API and merged
+	// into the bundled instance data.
+	EnableDescribeInstanceTypes bool
+
+	// deadline is the time at which the current Lambda invocation times out,
+	// zero when not running from Lambda.
+	deadline time.Time
 }
 
 // ParseConfig loads configuration from command line flags, environments variables, and config files.
@@ -184,6 +278,14 @@ func ParseConfig(conf *Config) {
 			"Can be overridden on a per-group basis using the tag "+OnDemandPercentageTag+
 			"\n\tIt is ignored if min_on_demand_number is also set.\n")
 
+	flagSet.StringVar(&conf.MinOnDemandSchedule, "min_on_demand_schedule", "",
+		"\n\tSchedule of the minimum on-demand capacity of each group, as semicolon separated window=value\n"+
+			"\tentries. The windows use the cron_schedule format evaluated in the cron_timezone, '*' matches\n"+
+			"\tall the time, and the values are numbers or percentages. The first matching window is used,\n"+
+			"\ttaking precedence over min_on_demand_number and min_on_demand_percentage.\n"+
+			"\tThe tag "+MinOnDemandScheduleTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --min_on_demand_schedule '9-18 1-5=50%;*=1'\n")
+
 	flagSet.Float64Var(&conf.OnDemandPriceMultiplier, "on_demand_price_multiplier", DefaultOnDemandPriceMultiplier,
 		"\n\tMultiplier for the on-demand price. Numbers less than 1.0 are useful for volume discounts.\n"+
 			"The tag "+OnDemandPriceMultiplierTag+" can be used to override this on a group level.\n"+
@@ -208,7 +310,8 @@ func ParseConfig(conf *Config) {
 
 	flagSet.Float64Var(&conf.SpotProductPremium, "spot_product_premium", DefaultSpotProductPremium,
 		"\n\tThe Product Premium to apply to the on demand price to improve spot selection and savings calculations\n"+
-			"\twhen using a premium instance type such as RHEL.")
+			"\twhen using a premium instance type such as RHEL.\n"+
+			"\tNot applied to the groups running a different operating system, which is detected automatically.\n")
 
 	flagSet.StringVar(&conf.TagFilteringMode, "tag_filtering_mode", "opt-in", "\n\tControls the behavior of the tag_filters option.\n"+
 		"\tValid choices: opt-in | opt-out\n\tDefault value: 'opt-in'\n\tExample: ./AutoSpotting --tag_filtering_mode opt-out\n")
@@ -219,8 +322,10 @@ func ParseConfig(conf *Config) {
 		"\tExample: ./AutoSpotting --tag_filters 'spot-enabled=true,Environment=dev,Team=vision'\n")
 
 	flagSet.StringVar(&conf.CronSchedule, "cron_schedule", DefaultCronSchedule, "\n\tCron-like schedule in which to"+
-		"\tperform(or not) spot replacement actions. Format: hour day-of-week\n"+
-		"\tExample: ./AutoSpotting --cron_schedule '9-18 1-5' # workdays during the office hours \n")
+		"\tperform(or not) spot replacement actions. Format: hour day-of-week, or the full crontab format\n"+
+		"\tminute hour day-of-month month day-of-week. Multiple windows can be separated by semicolons.\n"+
+		"\tExample: ./AutoSpotting --cron_schedule '9-18 1-5' # workdays during the office hours \n"+
+		"\tExample: ./AutoSpotting --cron_schedule '30-59 8 * * 1-5; * 9-17 * * 1-5; 10-14 6'\n")
 
 	flagSet.StringVar(&conf.CronTimezone, "cron_timezone", "UTC", "\n\tTimezone to"+
 		"\tperform(or not) spot replacement actions. Format: timezone\n"+
@@ -230,6 +335,12 @@ func ParseConfig(conf *Config) {
 		"inside or outside the schedule defined by cron_schedule. Allowed values: on|off\n"+
 		"\tExample: ./AutoSpotting --cron_schedule_state='off' --cron_schedule '9-18 1-5'  # would only take action outside the defined schedule\n")
 
+	flagSet.StringVar(&conf.CronBlackoutDates, "cron_blackout_dates", "", "\n\tComma separated dates or date ranges in"+
+		"\twhich no spot replacement actions are taken, regardless of the cron_schedule. Evaluated in the\n"+
+		"\tcron_timezone. Format: YYYY-MM-DD or YYYY-MM-DDTHH:MM, ranges are separated by a slash.\n"+
+		"\tThe tag "+CronBlackoutDatesTag+" can be used to override this on a group level.\n"+
+		"\tExample: ./AutoSpotting --cron_blackout_dates '2023-11-24/2023-11-27,2023-12-24T18:00/2023-12-26T06:00'\n")
+
 	flagSet.StringVar(&conf.LicenseType, "license", "evaluation", "\n\t - obsoleted, kept for compatibility only\n"+
 		"\tExample: ./AutoSpotting --license evaluation\n")
 
@@ -275,11 +386,248 @@ func ParseConfig(conf *Config) {
 			"replacement actions when executed in cron mode\n"+
 			"\tExample: ./AutoSpotting --billing_only true\n")
 
+	flagSet.StringVar(&conf.SpotAdvisorData, "spot_advisor_data", DefaultSpotAdvisorData,
+		"\n\tLocal file or http(s) URL of the Spot Instance Advisor dataset, used for ranking the Spot\n"+
+			"\tinstance types by their expected cost including the churn caused by interruptions.\n"+
+			"\tBy default it's not loaded, and the instance types are ranked only by price.\n"+
+			"\tThe public dataset is available at "+PublicSpotAdvisorData+"\n"+
+			"\tExample: ./AutoSpotting --spot_advisor_data /opt/spot-advisor-data.json\n")
+
+	flagSet.Float64Var(&conf.MaxInterruptionRate, "max_interruption_rate", DefaultMaxInterruptionRate,
+		"\n\tMaximum interruption frequency percentage reported by the Spot Instance Advisor for the\n"+
+			"\tSpot pools we launch instances in. Pools in the buckets exceeding it are excluded, for example\n"+
+			"\t10 allows only the '<5%' and '5-10%' buckets. The default value of 0 disables this filter.\n"+
+			"\tThe tag "+MaxInterruptionRateTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --max_interruption_rate 10\n")
+
+	flagSet.StringVar(&conf.ScoringStrategy, "scoring_strategy", DefaultScoringStrategy,
+		"\n\tStrategy used for ordering the compatible Spot instance types, which are then launched in\n"+
+			"\tthis order of priority. Available strategies: cheapest (by expected cost, including the\n"+
+			"\testimated interruptions), cheapest-per-vcpu, cheapest-per-gib, prefer-same-family,\n"+
+			"\tprefer-newest-generation and weighted, which combines the price, interruption rate and\n"+
+			"\tSpot placement score using the weights configured by scoring_weights.\n"+
+			"\tThe tag "+ScoringStrategyTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --scoring_strategy cheapest-per-vcpu\n")
+
+	flagSet.StringVar(&conf.ScoringWeights, "scoring_weights", DefaultScoringWeights,
+		"\n\tWeights of the price, stability and capacity factors used by the weighted scoring strategy.\n"+
+			"\tThe capacity factor is only used when Spot placement scores are enabled.\n"+
+			"\tThe tag "+ScoringWeightsTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --scoring_weights price=0.5,stability=0.5\n")
+
+	flagSet.StringVar(&conf.AcceleratorCompatibility, "accelerator_compatibility", DefaultAcceleratorCompatibility,
+		"\n\tControls how the GPUs and other accelerators of the Spot instance types are compared with the\n"+
+			"\tones of the replaced instance. Accelerators of the same kind, manufacturer and model are\n"+
+			"\trequired by default. Set it to 'manufacturer' to also allow newer models of the same\n"+
+			"\tmanufacturer, or to 'count' for only comparing the number of GPUs. In all cases the Spot\n"+
+			"\tinstance types need at least as many GPUs as the replaced instance.\n"+
+			"\tThe tag "+AcceleratorCompatibilityTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --accelerator_compatibility manufacturer\n")
+
+	flagSet.Float64Var(&conf.MaxInstanceTypeShare, "max_instance_type_share", 0,
+		"\n\tMaximum percentage of the Spot capacity of the group allowed to run on a single instance\n"+
+			"\ttype, counting the Spot instances the group already runs. Instance types that would exceed it are excluded from the\n"+
+			"\tSpot replacements, unless no other instance types are available. The value 0 disables it.\n"+
+			"\tThe tag "+MaxInstanceTypeShareTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --max_instance_type_share 50\n")
+
+	flagSet.Float64Var(&conf.MaxPoolShare, "max_pool_share", 0,
+		"\n\tMaximum percentage of the Spot capacity of the group allowed to run in a single Spot pool,\n"+
+			"\twhich is an instance type in an availability zone. Pools that would exceed it are excluded from the Spot\n"+
+			"\treplacements, unless all of them would. The value 0 disables it.\n"+
+			"\tThe tag "+MaxPoolShareTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --max_pool_share 30\n")
+
+	flagSet.Int64Var(&conf.MaxReplacementsPerRun, "max_replacements_per_run", 0,
+		"\n\tMaximum number of Spot replacements started by a cron run across all the groups and regions,\n"+
+			"\tincluding the replacements triggered by instance launch events until the next cron run.\n"+
+			"\tThe value 0 disables the limit.\n"+
+			"\tExample: ./AutoSpotting --max_replacements_per_run 10\n")
+
+	flagSet.Int64Var(&conf.MaxGlobalReplacementsPerHour, "max_global_replacements_per_hour", 0,
+		"\n\tMaximum number of Spot replacements launched in the last hour across all the groups and\n"+
+			"\tregions. The value 0 disables the limit.\n"+
+			"\tExample: ./AutoSpotting --max_global_replacements_per_hour 20\n")
+
+	flagSet.Int64Var(&conf.MaxGlobalReplacementsInFlight, "max_global_replacements_in_flight", 0,
+		"\n\tMaximum number of Spot replacements launched and not yet attached to their groups, across\n"+
+			"\tall the groups and regions. The value 0 disables the limit.\n"+
+			"\tExample: ./AutoSpotting --max_global_replacements_in_flight 5\n")
+
+	flagSet.Int64Var(&conf.MaxReplacementsPerHour, "max_replacements_per_hour", 0,
+		"\n\tMaximum number of Spot replacements launched for a group in the last hour.\n"+
+			"\tThe value 0 disables the limit.\n"+
+			"\tThe tag "+MaxReplacementsPerHourTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --max_replacements_per_hour 5\n")
+
+	flagSet.Int64Var(&conf.MaxReplacementsInFlight, "max_replacements_in_flight", 0,
+		"\n\tMaximum number of Spot replacements launched for a group and not yet attached to it.\n"+
+			"\tThe value 0 disables the limit.\n"+
+			"\tThe tag "+MaxReplacementsInFlightTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --max_replacements_in_flight 2\n")
+
+	flagSet.Int64Var(&conf.CanaryInstances, "canary_instances", 0,
+		"\n\tNumber of on-demand instances converted to Spot when a group is first enabled, before\n"+
+			"\twaiting for the canary soak period. The rest of the group is only converted if the group\n"+
+			"\tdidn't replace any instances because of failed health checks during the soak period.\n"+
+			"\tThe value 0 disables the canary step.\n"+
+			"\tThe tag "+CanaryInstancesTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --canary_instances 2\n")
+
+	flagSet.StringVar(&conf.CanarySoakPeriod, "canary_soak_period", DefaultCanarySoakPeriod,
+		"\n\tTime to wait after the canary replacements before converting the rest of the group.\n"+
+			"\tThe tag "+CanarySoakPeriodTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --canary_soak_period 30m\n")
+
+	flagSet.BoolVar(&conf.Evacuate, "evacuate", false,
+		"\n\tReplaces the Spot instances of the groups with on-demand instances, by raising the desired\n"+
+			"\tcapacity, waiting for the new instances to be InService and then terminating the Spot\n"+
+			"\tinstances, in batches of evacuation_batch_size instances. No Spot instances are launched\n"+
+			"\tuntil this is disabled again.\n"+
+			"\tThe tag "+EvacuateTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --evacuate true\n")
+
+	flagSet.Int64Var(&conf.EvacuationBatchSize, "evacuation_batch_size", DefaultEvacuationBatchSize,
+		"\n\tNumber of Spot instances replaced with on-demand instances at once while evacuating a group.\n"+
+			"\tThe tag "+EvacuationBatchSizeTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --evacuation_batch_size 2\n")
+
+	flagSet.BoolVar(&conf.LaunchInAllSubnets, "launch_in_all_subnets", false,
+		"\n\tControls whether the Spot instances can be launched in any of the subnets of the group, in\n"+
+			"\tcase the availability zone of the replaced on-demand instance has no Spot capacity. The\n"+
+			"\tsubnets from the availability zones having more on-demand instances are preferred, and the\n"+
+			"\ton-demand instance terminated afterwards is picked from the availability zone in which the\n"+
+			"\tSpot instance was launched.\n"+
+			"\tThe tag "+LaunchInAllSubnetsTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --launch_in_all_subnets true\n")
+
+	flagSet.BoolVar(&conf.EnableSpotPlacementScores, "enable_spot_placement_scores", false,
+		"\n\tControls whether AutoSpotting queries the Spot placement scores of the compatible instance\n"+
+			"\ttypes before launching Spot instances, with a single call scoring them together. The scores\n"+
+			"\tare used for picking the subnet with the best score when the group spans several availability\n"+
+			"\tzones, and by the capacity factor of the weighted scoring strategy.\n"+
+			"\tExample: ./AutoSpotting --enable_spot_placement_scores true\n")
+
+	flagSet.DurationVar(&conf.SpotPlacementScoreTTL, "spot_placement_score_ttl", DefaultSpotPlacementScoreTTL,
+		"\n\tAmount of time for which the Spot placement scores are cached in each region, in order to\n"+
+			"\tstay within the API limits.\n"+
+			"\tExample: ./AutoSpotting --spot_placement_score_ttl 30m\n")
+
+	flagSet.DurationVar(&conf.FailedPoolCooldown, "failed_pool_cooldown", DefaultFailedPoolCooldown,
+		"\n\tAmount of time for which the Spot pools in which CreateFleet failed for lack of capacity\n"+
+			"\tare excluded from the launches of the same group, unless all the pools failed.\n"+
+			"\tExample: ./AutoSpotting --failed_pool_cooldown 1h\n")
+
+	flagSet.DurationVar(&conf.MaxLaunchBackoff, "max_launch_backoff", DefaultMaxLaunchBackoff,
+		"\n\tMaximum delay between the Spot launch attempts of a group after repeated failures. The\n"+
+			"\tdelay starts at 5 minutes after 3 consecutive failures and doubles on each further failure.\n"+
+			"\tExample: ./AutoSpotting --max_launch_backoff 2h\n")
+
+	flagSet.Int64Var(&conf.CircuitBreakerThreshold, "circuit_breaker_threshold", 0,
+		"\n\tNumber of failed replacements and Spot instances replaced because of failed health checks,\n"+
+			"\twithin the circuit_breaker_window, after which AutoSpotting stops acting on a group and tags\n"+
+			"\tit with "+CircuitOpenTag+". The value 0 disables the circuit breaker.\n"+
+			"\tThe tag "+CircuitBreakerThresholdTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --circuit_breaker_threshold 3\n")
+
+	flagSet.StringVar(&conf.ReadinessChecks, "readiness_checks", "",
+		"\n\tComma separated list of checks a Spot instance needs to pass before the on-demand instance\n"+
+			"\tit replaces is terminated. Available checks: ec2-status (the EC2 instance and system status\n"+
+			"\tchecks), http (a request sent to the private IP address of the instance, see\n"+
+			"\treadiness_http_probe) and target-group (the health of the instance in the target groups of\n"+
+			"\tthe group, checked after attaching it). Each check can be followed by its own timeout.\n"+
+			"\tInstances failing a check are terminated and count as failed replacements.\n"+
+			"\tOn Lambda, the sum of the timeouts needs to fit the time left before the function\n"+
+			"\ttimes out, and the http check needs the function to run in a VPC.\n"+
+			"\tThe tag "+ReadinessChecksTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --readiness_checks ec2-status,target-group=10m\n")
+
+	flagSet.StringVar(&conf.ReadinessTimeout, "readiness_timeout", DefaultReadinessTimeout,
+		"\n\tDefault time a Spot instance has for passing each of the readiness checks.\n"+
+			"\tThe tag "+ReadinessTimeoutTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --readiness_timeout 10m\n")
+
+	flagSet.StringVar(&conf.ReadinessHTTPProbe, "readiness_http_probe", DefaultReadinessHTTPProbe,
+		"\n\tPort and path requested by the http readiness check, which passes on 2xx and 3xx responses.\n"+
+			"\tThe tag "+ReadinessHTTPProbeTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --readiness_http_probe 8080/health\n")
+
+	flagSet.StringVar(&conf.SwapWatchPeriod, "swap_watch_period", DefaultSwapWatchPeriod,
+		"\n\tTime during which the Spot instances swapped into a group are watched. The ones marked as\n"+
+			"\tunhealthy by the group or by its target groups are terminated and replaced by on-demand\n"+
+			"\tinstances, and their instance type is no longer used for the group. The value 0 disables\n"+
+			"\tthe rollback.\n"+
+			"\tThe tag "+SwapWatchPeriodTag+" can be used to override this on a group level.\n"+
+			"\tExample: ./AutoSpotting --swap_watch_period 1h\n")
+
+	flagSet.DurationVar(&conf.CircuitBreakerWindow, "circuit_breaker_window", DefaultCircuitBreakerWindow,
+		"\n\tTime interval in which the failures are counted by the circuit breaker.\n"+
+			"\tExample: ./AutoSpotting --circuit_breaker_window 30m\n")
+
+	flagSet.DurationVar(&conf.CircuitBreakerCooldown, "circuit_breaker_cooldown", DefaultCircuitBreakerCooldown,
+		"\n\tTime after which an open circuit breaker allows a trial replacement. The circuit breaker is\n"+
+			"\tclosed if the trial replacement succeeds, or opened again on the first failure.\n"+
+			"\tExample: ./AutoSpotting --circuit_breaker_cooldown 6h\n")
+
+	flagSet.StringVar(&conf.NotificationTopic, "notification_topic", "",
+		"\n\tARN of an SNS topic from the main region to which AutoSpotting publishes notifications,\n"+
+			"\tsuch as when the circuit breaker of a group opens or closes.\n"+
+			"\tExample: ./AutoSpotting --notification_topic arn:aws:sns:us-east-1:123456789012:autospotting\n")
+
+	flagSet.BoolVar(&conf.EnableReservationCoverage, "enable_reservation_coverage", false,
+		"\n\tControls whether AutoSpotting keeps the on-demand instances covered by active Reserved Instances,\n"+
+			"\tinstead of replacing them with Spot instances and leaving the reservations unused. The\n"+
+			"\tinstances outside of the enabled groups are assumed to use the reservations first.\n"+
+			"\tExample: ./AutoSpotting --enable_reservation_coverage true\n")
+
+	flagSet.Float64Var(&conf.SavingsPlanHourlyCommitment, "savings_plan_hourly_commitment", 0,
+		"\n\tHourly Savings Plan commitment available in each region, compared against the on-demand price\n"+
+			"\tof the running on-demand instances left uncovered by Reserved Instances. The instances fitting in\n"+
+			"\tit are kept running. The default value of 0 disables this check.\n"+
+			"\tExample: ./AutoSpotting --savings_plan_hourly_commitment 2.5\n")
+
+	flagSet.BoolVar(&conf.EnablePricingAPI, "enable_pricing_api", false,
+		"\n\tControls whether the on-demand prices are fetched from the AWS Pricing API, so they also cover\n"+
+			"\tthe price changes and, together with --enable_describe_instance_types, the instance types\n"+
+			"\treleased after AutoSpotting was built. The prices bundled in the binary are used when the API\n"+
+			"\tisn't reachable.\n"+
+			"\tExample: ./AutoSpotting --enable_pricing_api true\n")
+
+	flagSet.StringVar(&conf.PricingCache, "pricing_cache", "",
+		"\n\tLocal file or S3 URL where the prices fetched from the Pricing API are cached, the S3 bucket\n"+
+			"\tneeds to be in the main region. Without it the prices are only cached in memory.\n"+
+			"\tExample: ./AutoSpotting --pricing_cache s3://my-bucket/autospotting/prices.json\n")
+
+	flagSet.DurationVar(&conf.PricingCacheTTL, "pricing_cache_ttl", DefaultPricingCacheTTL,
+		"\n\tAmount of time for which the prices fetched from the Pricing API are used before being\n"+
+			"\tfetched again.\n"+
+			"\tExample: ./AutoSpotting --pricing_cache_ttl 12h\n")
+
+	flagSet.StringVar(&conf.InstanceCatalogOverlay, "instance_catalog_overlay", "",
+		"\n\tLocal JSON or YAML file used for adding new instance types to the instance data bundled in\n"+
+			"\tthe binary, or for correcting the specs and on-demand prices of the existing ones.\n"+
+			"\tExample: ./AutoSpotting --instance_catalog_overlay /opt/instance-overlay.yaml\n")
+
+	flagSet.StringVar(&conf.ConfigFile, "config_file", "",
+		"\n\tLocal JSON or YAML file or S3 URL with default settings and an ordered list of rules setting\n"+
+			"\tthe configuration of the groups they match by name, region or tags, using the same names\n"+
+			"\tand values as the configuration tags. The settings from the file override the command line\n"+
+			"\tflags and are overridden by the tags set on the groups. Rules can also enable or disable\n"+
+			"\tthe groups not matched by tag_filters. The S3 bucket needs to be in the main region.\n"+
+			"\tExample: ./AutoSpotting --config_file s3://my-bucket/autospotting/config.yaml\n")
+
+	flagSet.BoolVar(&conf.EnableDescribeInstanceTypes, "enable_describe_instance_types", false,
+		"\n\tEnables fetching the hardware specs of the instance types from the EC2 DescribeInstanceTypes API,\n"+
+			"\tsuch as the supported CPU architectures, which are then used instead of the bundled data.\n"+
+			"\tThe specs are cached for a day. Requires the ec2:DescribeInstanceTypes IAM permission.\n"+
+			"\tExample: ./AutoSpotting --enable_describe_instance_types true\n")
+
 	printVersion := flagSet.Bool("version", false, "Print version number and exit.\n")
 
 	if err := flagSet.Parse(os.Args[1:]); err != nil {
 		fmt.Printf("Error parsing config: %s\n", err.Error())
 	}
+	conf.Command = flagSet.Args()
 
 	if *printVersion {
 		fmt.Println("AutoSpotting build:", conf.Version)
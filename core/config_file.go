@@ -0,0 +1,317 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// config_file.go contains the logic used for loading the configuration file,
+// which sets the configuration of the groups matched by its rules without
+// having to tag them. The settings use the same names and values as the
+// configuration tags, and take precedence over the command line flags, while
+// the tags set on the groups take precedence over them.
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configurationTags are the tags which can be set on the groups for
+// overriding the global configuration, and which can also be set by the rules
+// of the configuration file.
+var configurationTags = []string{
+	AcceleratorCompatibilityTag,
+	AllowedInstanceTypesTag,
+	BareMetalTag,
+	BiddingPolicyTag,
+	BurstablePerformanceTag,
+	CanaryInstancesTag,
+	CanarySoakPeriodTag,
+	CircuitBreakerThresholdTag,
+	CPUManufacturersTag,
+	CronBlackoutDatesTag,
+	CronScheduleStateTag,
+	DisallowedInstanceTypesTag,
+	EnableInstanceLaunchEventHandlingTag,
+	EvacuateTag,
+	EvacuationBatchSizeTag,
+	GP2ConversionThresholdTag,
+	ImageARM64Tag,
+	ImageX86Tag,
+	InstanceGenerationMinTag,
+	LaunchInAllSubnetsTag,
+	MaxInstanceTypeShareTag,
+	MaxInterruptionRateTag,
+	MaxPoolShareTag,
+	MaxReplacementsInFlightTag,
+	MaxReplacementsPerHourTag,
+	MemoryMaxTag,
+	MemoryMinTag,
+	MemoryPerVCPUMaxTag,
+	MemoryPerVCPUMinTag,
+	MinOnDemandScheduleTag,
+	NetworkBandwidthMinTag,
+	OnDemandNumberLong,
+	OnDemandPercentageTag,
+	OnDemandPriceMultiplierTag,
+	PatchBeanstalkUserdataTag,
+	ReadinessChecksTag,
+	ReadinessHTTPProbeTag,
+	ReadinessTimeoutTag,
+	ScheduleTag,
+	ScoringStrategyTag,
+	ScoringWeightsTag,
+	SpotAllocationStrategyTag,
+	SpotPriceBufferPercentageTag,
+	SwapWatchPeriodTag,
+	TerminationNotificationActionTag,
+	TimezoneTag,
+	VCPUMaxTag,
+	VCPUMinTag,
+}
+
+// configFile is the content of the configuration file. Since JSON is a subset
+// of YAML, both formats are parsed the same way.
+type configFile struct {
+	// Defaults are applied to all the groups.
+	Defaults map[string]string `yaml:"defaults"`
+
+	// Rules are applied in order to the groups they match, the later rules
+	// overriding the settings of the earlier ones.
+	Rules []configRule `yaml:"rules"`
+}
+
+type configRule struct {
+	Name  string          `yaml:"name"`
+	Match configRuleMatch `yaml:"match"`
+
+	// Enabled enables or disables AutoSpotting for the matching groups which
+	// aren't matched by the filter tags, see groupEnabled.
+	Enabled *bool             `yaml:"enabled"`
+	Config  map[string]string `yaml:"config"`
+}
+
+// configRuleMatch selects the groups a rule applies to, all the given
+// conditions need to be met. The names, regions and tag values support globs.
+type configRuleMatch struct {
+	Names   []string          `yaml:"names"`
+	Regions []string          `yaml:"regions"`
+	Tags    map[string]string `yaml:"tags"`
+}
+
+// configFileSetting is a setting applied to a group from the configuration
+// file, along with the part of the file it comes from.
+type configFileSetting struct {
+	value  string
+	source string
+}
+
+// parseConfigFile decodes and validates the content of the configuration
+// file, rejecting the unknown fields and settings so typos don't go unnoticed.
+func parseConfigFile(body []byte) (*configFile, error) {
+	var cf configFile
+	if err := yaml.UnmarshalStrict(body, &cf); err != nil {
+		return nil, err
+	}
+	if err := cf.validate(); err != nil {
+		return nil, err
+	}
+	return &cf, nil
+}
+
+func (cf *configFile) validate() error {
+	var problems []string
+
+	checkSettings := func(source string, settings map[string]string) {
+		var unknown []string
+		for key := range settings {
+			if !itemInSlice(key, configurationTags) {
+				unknown = append(unknown, key)
+			}
+		}
+		sort.Strings(unknown)
+		for _, key := range unknown {
+			problems = append(problems, fmt.Sprintf("%s: unknown setting %s", source, key))
+		}
+	}
+
+	checkSettings("defaults", cf.Defaults)
+	for n, rule := range cf.Rules {
+		source := rule.source(n)
+		checkSettings(source, rule.Config)
+
+		for _, pattern := range append(append([]string{}, rule.Match.Names...), rule.Match.Regions...) {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid pattern %q", source, pattern))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration file:\n\t" + strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// source describes the rule in the logs and the effective configuration.
+func (rule configRule) source(n int) string {
+	if rule.Name != "" {
+		return fmt.Sprintf("rule %d (%s)", n+1, rule.Name)
+	}
+	return fmt.Sprintf("rule %d", n+1)
+}
+
+func (m configRuleMatch) matches(a *autoScalingGroup) bool {
+	if len(m.Names) > 0 && !matchesAny(m.Names, a.name) {
+		return false
+	}
+
+	if len(m.Regions) > 0 && !matchesAny(m.Regions, a.region.name) {
+		return false
+	}
+
+	for key, pattern := range m.Tags {
+		value := a.groupTagValue(key)
+		if value == nil {
+			return false
+		}
+		if match, _ := filepath.Match(pattern, *value); !match {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if match, _ := filepath.Match(pattern, value); match {
+			return true
+		}
+	}
+	return false
+}
+
+// settings returns the settings applied to the group by the defaults and the
+// matching rules of the configuration file.
+func (cf *configFile) settings(a *autoScalingGroup) map[string]configFileSetting {
+	result := make(map[string]configFileSetting)
+	if cf == nil {
+		return result
+	}
+
+	for key, value := range cf.Defaults {
+		result[key] = configFileSetting{value: value, source: "defaults"}
+	}
+
+	for n, rule := range cf.Rules {
+		if !rule.Match.matches(a) {
+			continue
+		}
+		for key, value := range rule.Config {
+			result[key] = configFileSetting{value: value, source: rule.source(n)}
+		}
+	}
+	return result
+}
+
+// groupEnabled decides whether AutoSpotting acts on the group, returning the
+// rule taking the decision, if any. The filter tags take precedence: groups
+// matching them are enabled in opt-in mode and disabled in opt-out mode. The
+// other groups are enabled or disabled by the last matching rule which sets
+// enabled, and otherwise follow the filtering mode.
+func (cf *configFile) groupEnabled(a *autoScalingGroup, optIn, matchesTags bool) (bool, string) {
+	if matchesTags {
+		return optIn, ""
+	}
+
+	enabled, source := !optIn, ""
+	if cf == nil {
+		return enabled, source
+	}
+
+	for n, rule := range cf.Rules {
+		if rule.Enabled != nil && rule.Match.matches(a) {
+			enabled, source = *rule.Enabled, rule.source(n)
+		}
+	}
+	return enabled, source
+}
+
+// loadConfigFile reads the configuration file from a local path or an S3 URL
+// like s3://bucket/key, returning nil if it's not set.
+func loadConfigFile(location string, sess *session.Session) (*configFile, error) {
+	if location == "" {
+		return nil, nil
+	}
+
+	var body []byte
+	var err error
+
+	if strings.HasPrefix(location, "s3://") {
+		parts := strings.SplitN(strings.TrimPrefix(location, "s3://"), "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid S3 URL %s", location)
+		}
+		body, err = s3Cache{client: s3.New(sess), bucket: parts[0], key: parts[1]}.load()
+	} else {
+		body, err = fileCache{path: location}.load()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the configuration file %s: %s", location, err.Error())
+	}
+
+	cf, err := parseConfigFile(body)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load the configuration file %s: %s", location, err.Error())
+	}
+
+	log.Printf("Loaded the configuration file %s with %d rules\n", location, len(cf.Rules))
+	return cf, nil
+}
+
+// loadConfigFileSettings applies the settings of the configuration file to
+// the group, before the configuration is loaded from its tags.
+func (a *autoScalingGroup) loadConfigFileSettings() {
+	a.fileSettings = a.region.conf.configFile.settings(a)
+
+	for _, key := range configurationTags {
+		setting, ok := a.fileSettings[key]
+		if !ok {
+			continue
+		}
+		if a.groupTagValue(key) != nil {
+			log.Printf("%s Tag %s overrides the value %q from the configuration file %s\n",
+				a.name, key, setting.value, setting.source)
+			continue
+		}
+		log.Printf("%s Using %s=%q from the configuration file %s\n", a.name, key, setting.value, setting.source)
+	}
+}
+
+// effectiveConfig describes the settings of the group which don't come from
+// the command line flags, along with their source.
+func (a *autoScalingGroup) effectiveConfig() string {
+	lines := []string{
+		fmt.Sprintf("Configuration of the group %s in %s", a.name, a.region.name),
+		"Precedence: command line flags < configuration file defaults < configuration file rules < group tags",
+	}
+
+	for _, key := range configurationTags {
+		if value := a.groupTagValue(key); value != nil {
+			lines = append(lines, fmt.Sprintf("\t%s=%q (group tag)", key, *value))
+		} else if setting, ok := a.fileSettings[key]; ok {
+			lines = append(lines, fmt.Sprintf("\t%s=%q (configuration file %s)", key, setting.value, setting.source))
+		}
+	}
+
+	lines = append(lines, "All the other settings use the command line flags.",
+		fmt.Sprintf("Effective configuration: %+v", a.config))
+	return strings.Join(lines, "\n")
+}
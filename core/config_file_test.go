@@ -0,0 +1,271 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+const testConfigFile = `
+defaults:
+  autospotting_min_on_demand_number: 1
+rules:
+  - name: team-a
+    match:
+      names: ["team-a-*"]
+    config:
+      autospotting_min_on_demand_number: 2
+      autospotting_evacuate: true
+  - name: production
+    match:
+      regions: ["eu-*"]
+      tags:
+        environment: prod*
+    config:
+      autospotting_min_on_demand_number: 3
+`
+
+func Test_parseConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{name: "yaml", body: testConfigFile},
+		{
+			name: "json",
+			body: `{"rules": [{"match": {"names": ["*"]}, "config": {"autospotting_scoring_strategy": "weighted"}}]}`,
+		},
+		{name: "empty"},
+		{name: "unknown field", body: "rules:\n  - matches: {}\n", wantErr: "field matches not found"},
+		{
+			name:    "unknown setting",
+			body:    "defaults:\n  autospotting_circuit_open: false\n",
+			wantErr: "defaults: unknown setting autospotting_circuit_open",
+		},
+		{
+			name:    "invalid pattern",
+			body:    "rules:\n  - match:\n      names: ['team-[a']\n",
+			wantErr: `rule 1: invalid pattern "team-[a"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cf, err := parseConfigFile([]byte(tt.body))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseConfigFile() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || cf == nil {
+				t.Errorf("parseConfigFile() = %v, %v", cf, err)
+			}
+		})
+	}
+}
+
+func Test_configFile_settings(t *testing.T) {
+	cf, err := parseConfigFile([]byte(testConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		group  string
+		region string
+		tags   map[string]string
+		want   map[string]configFileSetting
+	}{
+		{
+			name:   "defaults only",
+			group:  "team-b-web",
+			region: "us-east-1",
+			want: map[string]configFileSetting{
+				OnDemandNumberLong: {value: "1", source: "defaults"},
+			},
+		},
+		{
+			name:   "matched by name",
+			group:  "team-a-web",
+			region: "us-east-1",
+			want: map[string]configFileSetting{
+				OnDemandNumberLong: {value: "2", source: "rule 1 (team-a)"},
+				EvacuateTag:        {value: "true", source: "rule 1 (team-a)"},
+			},
+		},
+		{
+			name:   "later rule overriding an earlier one",
+			group:  "team-a-web",
+			region: "eu-west-1",
+			tags:   map[string]string{"environment": "production"},
+			want: map[string]configFileSetting{
+				OnDemandNumberLong: {value: "3", source: "rule 2 (production)"},
+				EvacuateTag:        {value: "true", source: "rule 1 (team-a)"},
+			},
+		},
+		{
+			name:   "tag not matching",
+			group:  "team-b-web",
+			region: "eu-west-1",
+			tags:   map[string]string{"environment": "staging"},
+			want: map[string]configFileSetting{
+				OnDemandNumberLong: {value: "1", source: "defaults"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				name:   tt.group,
+				region: &region{name: tt.region},
+			}
+			for key, value := range tt.tags {
+				a.Tags = append(a.Tags, &autoscaling.TagDescription{Key: aws.String(key), Value: aws.String(value)})
+			}
+
+			if got := cf.settings(a); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("settings() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_configFile_groupEnabled(t *testing.T) {
+	cf, err := parseConfigFile([]byte(`
+rules:
+  - name: team-a
+    match:
+      names: ["team-a-*"]
+    enabled: true
+  - name: team-a-legacy
+    match:
+      names: ["team-a-legacy-*"]
+    enabled: false
+  - name: settings only
+    match:
+      names: ["*"]
+    config:
+      autospotting_evacuate: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		cf          *configFile
+		group       string
+		optIn       bool
+		matchesTags bool
+		want        bool
+		wantRule    string
+	}{
+		{name: "opt-in matching the filter tags", cf: cf, group: "team-b-web", optIn: true, matchesTags: true, want: true},
+		{name: "opt-in without rules", cf: cf, group: "team-b-web", optIn: true},
+		{name: "opt-in enabled by a rule", cf: cf, group: "team-a-web", optIn: true, want: true, wantRule: "rule 1 (team-a)"},
+		{name: "opt-in disabled by a later rule", cf: cf, group: "team-a-legacy-web", optIn: true, wantRule: "rule 2 (team-a-legacy)"},
+		{name: "opt-out matching the filter tags despite a rule", cf: cf, group: "team-a-web", matchesTags: true},
+		{name: "opt-out disabled by a rule", cf: cf, group: "team-a-legacy-web", wantRule: "rule 2 (team-a-legacy)"},
+		{name: "opt-out without rules", cf: cf, group: "team-b-web", want: true},
+		{name: "no configuration file", group: "team-a-web", optIn: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				name:   tt.group,
+				region: &region{name: "us-east-1"},
+			}
+			got, rule := tt.cf.groupEnabled(a, tt.optIn, tt.matchesTags)
+			if got != tt.want || rule != tt.wantRule {
+				t.Errorf("groupEnabled() = %v, %q, want %v, %q", got, rule, tt.want, tt.wantRule)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_configFilePrecedence(t *testing.T) {
+	cf, err := parseConfigFile([]byte(testConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &autoScalingGroup{
+		Group: &autoscaling.Group{Tags: []*autoscaling.TagDescription{
+			{Key: aws.String(EvacuateTag), Value: aws.String("false")},
+		}},
+		name:   "team-a-web",
+		region: &region{name: "us-east-1", conf: &Config{configFile: cf}},
+	}
+	a.loadConfigFileSettings()
+
+	if got := aws.StringValue(a.getTagValue(EvacuateTag)); got != "false" {
+		t.Errorf("getTagValue(%s) = %q, want the group tag to take precedence", EvacuateTag, got)
+	}
+	if got := aws.StringValue(a.getTagValue(OnDemandNumberLong)); got != "2" {
+		t.Errorf("getTagValue(%s) = %q, want the value from the configuration file", OnDemandNumberLong, got)
+	}
+	if a.getTagValue(ScoringStrategyTag) != nil {
+		t.Errorf("getTagValue(%s) returned a value missing from the tags and the configuration file", ScoringStrategyTag)
+	}
+
+	config := a.effectiveConfig()
+	for _, want := range []string{
+		"command line flags < configuration file defaults < configuration file rules < group tags",
+		EvacuateTag + `="false" (group tag)`,
+		OnDemandNumberLong + `="2" (configuration file rule 1 (team-a))`,
+	} {
+		if !strings.Contains(config, want) {
+			t.Errorf("effectiveConfig() = %s\nmissing %q", config, want)
+		}
+	}
+}
+
+func Test_loadConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autospotting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(testConfigFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if cf, err := loadConfigFile("", nil); cf != nil || err != nil {
+		t.Errorf("loadConfigFile() without a location = %v, %v", cf, err)
+	}
+	if cf, err := loadConfigFile(path, nil); err != nil || len(cf.Rules) != 2 {
+		t.Errorf("loadConfigFile(%s) = %v, %v", path, cf, err)
+	}
+	if _, err := loadConfigFile(filepath.Join(dir, "missing.yaml"), nil); err == nil {
+		t.Errorf("loadConfigFile() didn't fail for a missing file")
+	}
+	if _, err := loadConfigFile("s3://bucket", nil); err == nil {
+		t.Errorf("loadConfigFile() didn't fail for an invalid S3 URL")
+	}
+}
+
+func Test_region_showGroupConfig(t *testing.T) {
+	r := &region{
+		name:     "us-east-1",
+		conf:     &Config{},
+		services: connections{autoScaling: mockASG{dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{}}},
+	}
+	if err := r.showGroupConfig("missing"); err == nil {
+		t.Errorf("showGroupConfig() didn't fail for a missing group")
+	}
+}
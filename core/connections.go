@@ -15,8 +15,12 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
@@ -25,9 +29,11 @@ type connections struct {
 	session        *session.Session
 	autoScaling    autoscalingiface.AutoScalingAPI
 	ec2            ec2iface.EC2API
+	elbv2          elbv2iface.ELBV2API
 	cloudFormation cloudformationiface.CloudFormationAPI
 	lambda         lambdaiface.LambdaAPI
 	sqs            sqsiface.SQSAPI
+	sns            snsiface.SNSAPI
 	region         string
 }
 
@@ -46,17 +52,21 @@ func (c *connections) connect(region, mainRegion string) {
 
 	asConn := make(chan *autoscaling.AutoScaling)
 	ec2Conn := make(chan *ec2.EC2)
+	elbv2Conn := make(chan *elbv2.ELBV2)
 	cloudformationConn := make(chan *cloudformation.CloudFormation)
 	lambdaConn := make(chan *lambda.Lambda)
 	sqsConn := make(chan *sqs.SQS)
+	snsConn := make(chan *sns.SNS)
 
 	go func() { asConn <- autoscaling.New(c.session) }()
 	go func() { ec2Conn <- ec2.New(c.session) }()
+	go func() { elbv2Conn <- elbv2.New(c.session) }()
 	go func() { lambdaConn <- lambda.New(c.session) }()
 	go func() { cloudformationConn <- cloudformation.New(c.session) }()
 	go func() { sqsConn <- sqs.New(c.session, aws.NewConfig().WithRegion(mainRegion)) }()
+	go func() { snsConn <- sns.New(c.session, aws.NewConfig().WithRegion(mainRegion)) }()
 
-	c.autoScaling, c.ec2, c.cloudFormation, c.lambda, c.sqs, c.region = <-asConn, <-ec2Conn, <-cloudformationConn, <-lambdaConn, <-sqsConn, region
+	c.autoScaling, c.ec2, c.elbv2, c.cloudFormation, c.lambda, c.sqs, c.sns, c.region = <-asConn, <-ec2Conn, <-elbv2Conn, <-cloudformationConn, <-lambdaConn, <-sqsConn, <-snsConn, region
 
 	debug.Println("Created service connections in", region)
 }
@@ -0,0 +1,237 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// diversification.go contains the logic used for limiting the share of a group
+// running on a single instance type or Spot pool, so that a single wave of
+// interruptions can't take out most of its capacity.
+
+import (
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// MaxInstanceTypeShareTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the MaxInstanceTypeShare
+	// parameter
+	MaxInstanceTypeShareTag = "autospotting_max_instance_type_share"
+
+	// MaxPoolShareTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the MaxPoolShare parameter
+	MaxPoolShareTag = "autospotting_max_pool_share"
+)
+
+// groupComposition counts the instances of a group per instance type and per
+// Spot pool, which is an instance type in an availability zone.
+type groupComposition struct {
+	total  int
+	byType map[string]int
+	byPool map[string]int
+}
+
+// composition returns the Spot instance counts of the group, leaving out the
+// given instance which is about to be replaced but still counting it in the
+// total, since it's replaced with a Spot instance. The on-demand instances
+// aren't counted, since they aren't exposed to the Spot interruptions.
+func (a *autoScalingGroup) composition(replaced *instance) groupComposition {
+	c := groupComposition{
+		byType: make(map[string]int),
+		byPool: make(map[string]int),
+	}
+
+	if a.instances == nil {
+		return c
+	}
+
+	for inst := range a.instances.instances() {
+		if inst.State != nil && (*inst.State.Name == ec2.InstanceStateNameTerminated ||
+			*inst.State.Name == ec2.InstanceStateNameShuttingDown) {
+			continue
+		}
+		if replaced != nil && aws.StringValue(inst.InstanceId) == aws.StringValue(replaced.InstanceId) {
+			c.total++
+			continue
+		}
+
+		if !inst.isSpot() {
+			continue
+		}
+		c.total++
+
+		instanceType := aws.StringValue(inst.InstanceType)
+		c.byType[instanceType]++
+		if inst.Placement != nil {
+			c.byPool[poolKey(instanceType, aws.StringValue(inst.Placement.AvailabilityZone))]++
+		}
+	}
+	return c
+}
+
+// shareAfterLaunch returns the percentage of the group that would run in the
+// instance type or pool after launching one more instance there.
+func (c groupComposition) shareAfterLaunch(count int) float64 {
+	if c.total == 0 {
+		return 0
+	}
+	return float64(count+1) * 100 / float64(c.total)
+}
+
+// diversifyInstanceTypes excludes the instance types that would exceed the
+// maximum share of the group, unless no other instance types are left, in
+// which case they're all kept and ordered by their share.
+func (i *instance) diversifyInstanceTypes(instanceTypes []*string) []*string {
+	if i.asg == nil || i.asg.config.MaxInstanceTypeShare <= 0 || len(instanceTypes) == 0 {
+		return instanceTypes
+	}
+
+	c := i.asg.composition(i)
+	limit := i.asg.config.MaxInstanceTypeShare
+
+	var allowed, overRepresented []*string
+	for _, it := range instanceTypes {
+		share := c.shareAfterLaunch(c.byType[*it])
+		if share > limit {
+			debug.Printf("%s Demoting %s which would run %.1f%% of the group, above the limit of %.1f%%\n",
+				i.asg.name, *it, share, limit)
+			overRepresented = append(overRepresented, it)
+			continue
+		}
+		allowed = append(allowed, it)
+	}
+
+	if len(allowed) > 0 {
+		if len(overRepresented) > 0 {
+			log.Println(i.asg.name, "Excluded the over-represented instance types",
+				aws.StringValueSlice(overRepresented), "from the replacement of", *i.InstanceId)
+		}
+		return allowed
+	}
+
+	log.Println(i.asg.name, "All the compatible instance types exceed the maximum share of",
+		limit, "percent of the group, preferring the least represented ones")
+
+	sort.SliceStable(overRepresented, func(a, b int) bool {
+		return c.byType[*overRepresented[a]] < c.byType[*overRepresented[b]]
+	})
+	return overRepresented
+}
+
+// diversifyOverrides excludes the fleet overrides of the Spot pools that would
+// exceed the maximum share of the group, unless all of them would, in which
+// case they're all kept and ordered by their share.
+func (i *instance) diversifyOverrides(overrides []*ec2.FleetLaunchTemplateOverridesRequest) []*ec2.FleetLaunchTemplateOverridesRequest {
+	if i.asg == nil || i.asg.config.MaxPoolShare <= 0 || len(overrides) == 0 {
+		return overrides
+	}
+
+	c := i.asg.composition(i)
+	limit := i.asg.config.MaxPoolShare
+	zones := i.subnetZones(overrides)
+
+	var result, overRepresented []*ec2.FleetLaunchTemplateOverridesRequest
+	poolCount := make(map[*ec2.FleetLaunchTemplateOverridesRequest]int)
+	for _, o := range overrides {
+		az, found := zones[aws.StringValue(o.SubnetId)]
+		if !found {
+			result = append(result, o)
+			continue
+		}
+
+		pool := poolKey(aws.StringValue(o.InstanceType), az)
+		if share := c.shareAfterLaunch(c.byPool[pool]); share > limit {
+			debug.Printf("%s Excluding the Spot pool %s which would run %.1f%% of the group, above the limit of %.1f%%\n",
+				i.asg.name, pool, share, limit)
+			poolCount[o] = c.byPool[pool]
+			overRepresented = append(overRepresented, o)
+			continue
+		}
+		result = append(result, o)
+	}
+
+	if len(result) > 0 {
+		return result
+	}
+
+	log.Println(i.asg.name, "All the Spot pools exceed the maximum share of", limit,
+		"percent of the group, preferring the least represented ones")
+
+	sort.SliceStable(overRepresented, func(a, b int) bool {
+		return poolCount[overRepresented[a]] < poolCount[overRepresented[b]]
+	})
+	return overRepresented
+}
+
+// subnetZones maps the subnets used by the overrides to their availability
+// zones, only describing the subnets of the group when the overrides use
+// others than the one of the replaced instance.
+func (i *instance) subnetZones(overrides []*ec2.FleetLaunchTemplateOverridesRequest) map[string]string {
+	zones := make(map[string]string)
+	if i.SubnetId != nil && i.Placement != nil {
+		zones[*i.SubnetId] = aws.StringValue(i.Placement.AvailabilityZone)
+	}
+
+	for _, o := range overrides {
+		if _, found := zones[aws.StringValue(o.SubnetId)]; found {
+			continue
+		}
+
+		if subnets, err := i.asg.subnetPlacements(); err == nil {
+			for _, s := range subnets {
+				zones[s.subnetID] = s.availabilityZone
+			}
+		}
+		break
+	}
+	return zones
+}
+
+func (a *autoScalingGroup) loadMaxInstanceTypeShare() bool {
+	a.config.MaxInstanceTypeShare = a.region.conf.MaxInstanceTypeShare
+
+	share, found := a.loadShareTag(MaxInstanceTypeShareTag)
+	if !found {
+		return false
+	}
+
+	log.Printf("Loaded MaxInstanceTypeShare value %v from tag %v\n", share, MaxInstanceTypeShareTag)
+	a.config.MaxInstanceTypeShare = share
+	return true
+}
+
+func (a *autoScalingGroup) loadMaxPoolShare() bool {
+	a.config.MaxPoolShare = a.region.conf.MaxPoolShare
+
+	share, found := a.loadShareTag(MaxPoolShareTag)
+	if !found {
+		return false
+	}
+
+	log.Printf("Loaded MaxPoolShare value %v from tag %v\n", share, MaxPoolShareTag)
+	a.config.MaxPoolShare = share
+	return true
+}
+
+// loadShareTag parses the percentage set in the given tag of the group.
+func (a *autoScalingGroup) loadShareTag(tag string) (float64, bool) {
+	tagValue := a.getTagValue(tag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", tag, "on the group", a.name, "using the default configuration")
+		return 0, false
+	}
+
+	share, err := strconv.ParseFloat(*tagValue, 64)
+	if err != nil {
+		log.Printf("Error with ParseFloat: %s\n", err.Error())
+		return 0, false
+	} else if share < 0 || share > 100 {
+		log.Printf("Ignoring out of range value : %f\n", share)
+		return 0, false
+	}
+	return share, true
+}
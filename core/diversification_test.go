@@ -0,0 +1,235 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func runningInstance(id, instanceType, az string) *instance {
+	return &instance{Instance: &ec2.Instance{
+		InstanceId:   aws.String(id),
+		InstanceType: aws.String(instanceType),
+		Placement:    &ec2.Placement{AvailabilityZone: aws.String(az)},
+		State:        &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+	}}
+}
+
+// diversifiedGroup returns a group of 9 Spot instances and 2 on-demand ones,
+// the first of which is being replaced.
+func diversifiedGroup(typeShare, poolShare float64) *instance {
+	catalog := instanceMap{
+		"i-0": runningInstance("i-0", "m5.large", "us-east-1a"),
+		"i-1": runningInstance("i-1", "m5.large", "us-east-1a"),
+		"i-2": runningInstance("i-2", "m5.large", "us-east-1a"),
+		"i-3": runningInstance("i-3", "m5.large", "us-east-1b"),
+		"i-4": runningInstance("i-4", "m5.large", "us-east-1b"),
+		"i-5": runningInstance("i-5", "c5.large", "us-east-1a"),
+		"i-6": runningInstance("i-6", "c5.large", "us-east-1b"),
+		"i-7": runningInstance("i-7", "c5.large", "us-east-1b"),
+		"i-8": runningInstance("i-8", "r5.large", "us-east-1b"),
+		"i-9": runningInstance("i-9", "m4.large", "us-east-1a"),
+	}
+	for _, spot := range catalog {
+		spot.InstanceLifecycle = aws.String(Spot)
+	}
+	catalog["i-0"].InstanceLifecycle = nil
+	catalog["i-11"] = runningInstance("i-11", "m5.large", "us-east-1a")
+
+	terminated := runningInstance("i-10", "r5.large", "us-east-1a")
+	terminated.State.Name = aws.String(ec2.InstanceStateNameTerminated)
+	terminated.InstanceLifecycle = aws.String(Spot)
+	catalog["i-10"] = terminated
+
+	i := catalog["i-0"]
+	i.SubnetId = aws.String("subnet-a")
+	i.asg = &autoScalingGroup{
+		Group:     &autoscaling.Group{AutoScalingGroupName: aws.String("asg")},
+		name:      "asg",
+		instances: makeInstancesWithCatalog(catalog),
+		config: AutoScalingConfig{
+			MaxInstanceTypeShare: typeShare,
+			MaxPoolShare:         poolShare,
+		},
+	}
+	return i
+}
+
+func Test_autoScalingGroup_composition(t *testing.T) {
+	i := diversifiedGroup(0, 0)
+	c := i.asg.composition(i)
+
+	if c.total != 10 {
+		t.Errorf("composition() total = %v, want 10", c.total)
+	}
+	wantTypes := map[string]int{"m5.large": 4, "c5.large": 3, "r5.large": 1, "m4.large": 1}
+	if !reflect.DeepEqual(c.byType, wantTypes) {
+		t.Errorf("composition() byType = %v, want %v", c.byType, wantTypes)
+	}
+	if got := c.byPool["m5.large/us-east-1a"]; got != 2 {
+		t.Errorf("composition() m5.large/us-east-1a = %v, want 2", got)
+	}
+}
+
+func Test_instance_diversifyInstanceTypes(t *testing.T) {
+	tests := []struct {
+		name          string
+		share         float64
+		instanceTypes []string
+		want          []string
+	}{
+		{
+			name:          "disabled",
+			instanceTypes: []string{"m5.large", "c5.large", "r5.large"},
+			want:          []string{"m5.large", "c5.large", "r5.large"},
+		},
+		{
+			name:          "over-represented types excluded",
+			share:         30,
+			instanceTypes: []string{"m5.large", "c5.large", "r5.large", "m4.large"},
+			want:          []string{"r5.large", "m4.large"},
+		},
+		{
+			name:          "within the limit",
+			share:         50,
+			instanceTypes: []string{"m5.large", "c5.large", "r5.large"},
+			want:          []string{"m5.large", "c5.large", "r5.large"},
+		},
+		{
+			name:          "all over-represented, least represented first",
+			share:         20,
+			instanceTypes: []string{"m5.large", "c5.large"},
+			want:          []string{"c5.large", "m5.large"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := diversifiedGroup(tt.share, 0)
+			got := aws.StringValueSlice(i.diversifyInstanceTypes(aws.StringSlice(tt.instanceTypes)))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diversifyInstanceTypes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_diversifyOverrides(t *testing.T) {
+	overrides := func(pools ...string) []*ec2.FleetLaunchTemplateOverridesRequest {
+		var result []*ec2.FleetLaunchTemplateOverridesRequest
+		for p := 0; p < len(pools); p += 2 {
+			result = append(result, &ec2.FleetLaunchTemplateOverridesRequest{
+				InstanceType: aws.String(pools[p]),
+				SubnetId:     aws.String(pools[p+1]),
+			})
+		}
+		return result
+	}
+
+	tests := []struct {
+		name      string
+		share     float64
+		overrides []*ec2.FleetLaunchTemplateOverridesRequest
+		subnets   *ec2.DescribeSubnetsOutput
+		want      []*ec2.FleetLaunchTemplateOverridesRequest
+	}{
+		{
+			name:      "disabled",
+			overrides: overrides("m5.large", "subnet-a", "c5.large", "subnet-a"),
+			want:      overrides("m5.large", "subnet-a", "c5.large", "subnet-a"),
+		},
+		{
+			name:      "over-represented pool excluded in the subnet of the instance",
+			share:     25,
+			overrides: overrides("m5.large", "subnet-a", "c5.large", "subnet-a"),
+			want:      overrides("c5.large", "subnet-a"),
+		},
+		{
+			name:      "over-represented pools excluded in other subnets",
+			share:     25,
+			overrides: overrides("m5.large", "subnet-a", "c5.large", "subnet-a", "m5.large", "subnet-b", "c5.large", "subnet-b"),
+			subnets: &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+				{SubnetId: aws.String("subnet-a"), AvailabilityZone: aws.String("us-east-1a")},
+				{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("us-east-1b")},
+			}},
+			want: overrides("c5.large", "subnet-a"),
+		},
+		{
+			name:      "unknown subnets are kept",
+			share:     25,
+			overrides: overrides("m5.large", "subnet-a", "m5.large", "subnet-c"),
+			subnets:   &ec2.DescribeSubnetsOutput{},
+			want:      overrides("m5.large", "subnet-c"),
+		},
+		{
+			name:      "all pools over-represented",
+			share:     10,
+			overrides: overrides("m5.large", "subnet-a", "c5.large", "subnet-a"),
+			want:      overrides("c5.large", "subnet-a", "m5.large", "subnet-a"),
+		},
+		{
+			name:      "all pools over-represented in several subnets",
+			share:     10,
+			overrides: overrides("m5.large", "subnet-a", "m5.large", "subnet-b", "r5.large", "subnet-b", "c5.large", "subnet-a"),
+			subnets: &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+				{SubnetId: aws.String("subnet-a"), AvailabilityZone: aws.String("us-east-1a")},
+				{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("us-east-1b")},
+			}},
+			want: overrides("r5.large", "subnet-b", "c5.large", "subnet-a", "m5.large", "subnet-a", "m5.large", "subnet-b"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := diversifiedGroup(0, tt.share)
+			i.asg.VPCZoneIdentifier = aws.String("subnet-a,subnet-b,subnet-c")
+			i.asg.region = &region{
+				name:     "us-east-1",
+				services: connections{ec2: mockEC2{dsno: tt.subnets}},
+			}
+
+			if got := i.diversifyOverrides(tt.overrides); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diversifyOverrides() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadMaxPoolShare(t *testing.T) {
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     float64
+		wantOK   bool
+	}{
+		{name: "default", want: 40},
+		{name: "overridden", tagValue: aws.String("30"), want: 30, wantOK: true},
+		{name: "out of range", tagValue: aws.String("130"), want: 40},
+		{name: "invalid", tagValue: aws.String("thirty"), want: 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				region: &region{conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{MaxPoolShare: 40, MaxInstanceTypeShare: 60},
+				}},
+			}
+			if tt.tagValue != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(MaxPoolShareTag), Value: tt.tagValue}}
+			}
+
+			ok := a.loadMaxPoolShare()
+			if ok != tt.wantOK || a.config.MaxPoolShare != tt.want {
+				t.Errorf("loadMaxPoolShare() = %v, %v, want %v, %v", ok, a.config.MaxPoolShare, tt.wantOK, tt.want)
+			}
+			if a.loadMaxInstanceTypeShare() || a.config.MaxInstanceTypeShare != 60 {
+				t.Errorf("loadMaxInstanceTypeShare() = %v, want the default 60", a.config.MaxInstanceTypeShare)
+			}
+		})
+	}
+}
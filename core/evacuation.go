@@ -0,0 +1,245 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// evacuation.go contains the logic used for moving a group back to on-demand
+// capacity, by launching on-demand instances before terminating the Spot
+// instances they replace, in batches of a configurable size.
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// EvacuateTag is the name of the tag set on the AutoScaling Group that can
+	// override the global value of the Evacuate parameter
+	EvacuateTag = "autospotting_evacuate"
+
+	// EvacuationBatchSizeTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the EvacuationBatchSize
+	// parameter
+	EvacuationBatchSizeTag = "autospotting_evacuation_batch_size"
+
+	// EvacuationStateTag is the name of the tag set by AutoSpotting on the
+	// AutoScaling groups to keep track of the evacuation batch in progress, as
+	// batch-size/original-maximum-size, or 0 when no batch is in progress.
+	EvacuationStateTag = "autospotting_evacuation_state"
+
+	// DefaultEvacuationBatchSize is the default number of Spot instances
+	// replaced with on-demand instances at once while evacuating a group
+	DefaultEvacuationBatchSize = 1
+
+	evacuationIdle = "0"
+)
+
+// evacuationState is the evacuation batch in progress for a group, if any.
+type evacuationState struct {
+	batch   int64
+	maxSize int64
+}
+
+func parseEvacuationState(value *string) evacuationState {
+	if value == nil {
+		return evacuationState{}
+	}
+
+	fields := strings.Split(*value, "/")
+	if len(fields) != 2 {
+		return evacuationState{}
+	}
+
+	batch, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || batch < 0 {
+		return evacuationState{}
+	}
+
+	maxSize, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || maxSize < 0 {
+		return evacuationState{}
+	}
+	return evacuationState{batch: batch, maxSize: maxSize}
+}
+
+func (s evacuationState) String() string {
+	if s.batch == 0 {
+		return evacuationIdle
+	}
+	return fmt.Sprintf("%d/%d", s.batch, s.maxSize)
+}
+
+// evacuationInProgress returns whether the group has a batch of on-demand
+// instances launched for replacing Spot instances, which needs to be finished
+// even after the evacuation is cancelled, so the capacity is brought back.
+func (a *autoScalingGroup) evacuationInProgress() bool {
+	return parseEvacuationState(a.getTagValue(EvacuationStateTag)).batch > 0
+}
+
+// attachedSpotInstances returns the running Spot instances of the group.
+func (a *autoScalingGroup) attachedSpotInstances() []*instance {
+	var spots []*instance
+	for i := range a.instances.instances() {
+		if i.isSpot() && *i.State.Name == ec2.InstanceStateNameRunning {
+			spots = append(spots, i)
+		}
+	}
+	return spots
+}
+
+// evacuationBatchReady returns whether all the instances of the group,
+// including the on-demand ones launched for the current batch, are InService.
+func (a *autoScalingGroup) evacuationBatchReady() bool {
+	if int64(len(a.Instances)) < *a.DesiredCapacity {
+		return false
+	}
+	for _, inst := range a.Instances {
+		if aws.StringValue(inst.LifecycleState) != "InService" {
+			return false
+		}
+	}
+	return true
+}
+
+// evacuationAction decides the next step of the evacuation of the group:
+// first raising the desired capacity by a batch of instances, which are
+// launched as on-demand instances by the group, then terminating as many Spot
+// instances once all of them are InService. A batch in progress is finished
+// even if the evacuation was cancelled meanwhile.
+func (a *autoScalingGroup) evacuationAction() runer {
+	state := parseEvacuationState(a.getTagValue(EvacuationStateTag))
+	spots := a.attachedSpotInstances()
+
+	if state.batch > 0 {
+		if !a.evacuationBatchReady() {
+			log.Println(a.region.name, a.name,
+				"Waiting for the on-demand instances of the evacuation batch to be InService")
+			return skipRun{reason: "evacuation-batch-not-ready"}
+		}
+		return terminateEvacuatedSpotInstances{target: target{asg: a}, state: state}
+	}
+
+	if len(spots) == 0 {
+		log.Println(a.region.name, a.name, "The group was fully evacuated to on-demand instances")
+		return skipRun{reason: "evacuated"}
+	}
+
+	batch := a.config.EvacuationBatchSize
+	if batch <= 0 {
+		batch = DefaultEvacuationBatchSize
+	}
+	if batch > int64(len(spots)) {
+		batch = int64(len(spots))
+	}
+	return launchEvacuationBatch{target: target{asg: a}, batch: batch}
+}
+
+// startEvacuationBatch raises the desired capacity of the group by the batch
+// size, together with the maximum size if needed, and records the batch.
+func (a *autoScalingGroup) startEvacuationBatch(batch int64) error {
+	state := evacuationState{batch: batch, maxSize: *a.MaxSize}
+	desired := *a.DesiredCapacity + batch
+
+	input := &autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(a.name),
+		DesiredCapacity:      aws.Int64(desired),
+	}
+	if desired > *a.MaxSize {
+		input.MaxSize = aws.Int64(desired)
+	}
+
+	// recorded first, so the next runs bring the capacity back even if this
+	// run is interrupted right after raising it
+	if err := a.setStateTags(map[string]string{EvacuationStateTag: state.String()}); err != nil {
+		return err
+	}
+
+	if _, err := a.region.services.autoScaling.UpdateAutoScalingGroup(input); err != nil {
+		log.Println(a.region.name, a.name, "Failed to raise the capacity for evacuation", err.Error())
+		a.setStateTags(map[string]string{EvacuationStateTag: evacuationIdle})
+		return err
+	}
+
+	log.Printf("%s %s Raised the desired capacity to %d for evacuating %d Spot instances",
+		a.region.name, a.name, desired, batch)
+	return nil
+}
+
+// finishEvacuationBatch terminates the Spot instances replaced by the current
+// batch, decreasing the desired capacity, and restores the maximum size.
+func (a *autoScalingGroup) finishEvacuationBatch(state evacuationState) error {
+	spots := a.attachedSpotInstances()
+
+	for n := int64(0); n < state.batch && n < int64(len(spots)); n++ {
+		id := spots[n].InstanceId
+		if err := a.terminateInstanceInAutoScalingGroup(id, false, true); err != nil {
+			log.Println(a.region.name, a.name, "Failed to terminate evacuated Spot instance", *id)
+			return err
+		}
+
+		recapText := fmt.Sprintf("%s Terminated spot instance %s [evacuated]", a.name, *id)
+		a.region.conf.FinalRecap[a.region.name] = append(a.region.conf.FinalRecap[a.region.name], recapText)
+	}
+
+	if state.maxSize < *a.MaxSize {
+		if err := a.setAutoScalingMaxSize(state.maxSize); err != nil {
+			return err
+		}
+	}
+
+	return a.setStateTags(map[string]string{EvacuationStateTag: evacuationIdle})
+}
+
+func (a *autoScalingGroup) loadEvacuate() bool {
+	a.config.Evacuate = a.region.conf.Evacuate
+
+	tagValue := a.getTagValue(EvacuateTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", EvacuateTag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	log.Printf("Loaded Evacuate value %v from tag %v\n", *tagValue, EvacuateTag)
+	val, err := strconv.ParseBool(*tagValue)
+	if err != nil {
+		log.Printf("Failed to parse Evacuate value %v as a boolean", *tagValue)
+		return false
+	}
+	a.config.Evacuate = val
+	return true
+}
+
+func (a *autoScalingGroup) loadEvacuationBatchSize() bool {
+	a.config.EvacuationBatchSize = a.region.conf.EvacuationBatchSize
+
+	tagValue := a.getTagValue(EvacuationBatchSizeTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", EvacuationBatchSizeTag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	batch, err := strconv.ParseInt(*tagValue, 10, 64)
+	if err != nil {
+		log.Printf("Error with ParseInt: %s\n", err.Error())
+		return false
+	} else if batch <= 0 {
+		log.Printf("Ignoring out of range value : %d\n", batch)
+		return false
+	}
+
+	log.Printf("Loaded EvacuationBatchSize value %d from tag %s\n", batch, EvacuationBatchSizeTag)
+	a.config.EvacuationBatchSize = batch
+	return true
+}
+
+// setGroupEvacuation sets the tag which starts or cancels the evacuation of
+// the given group, picked up by the next cron run.
+func (r *region) setGroupEvacuation(group string, evacuate bool) error {
+	return r.setGroupTags(group, map[string]string{EvacuateTag: strconv.FormatBool(evacuate)})
+}
@@ -0,0 +1,258 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_parseEvacuationState(t *testing.T) {
+	tests := []struct {
+		value *string
+		want  evacuationState
+	}{
+		{value: nil, want: evacuationState{}},
+		{value: aws.String("0"), want: evacuationState{}},
+		{value: aws.String("2/4"), want: evacuationState{batch: 2, maxSize: 4}},
+		{value: aws.String("2/four"), want: evacuationState{}},
+		{value: aws.String("-1/4"), want: evacuationState{}},
+	}
+	for _, tt := range tests {
+		t.Run(aws.StringValue(tt.value), func(t *testing.T) {
+			got := parseEvacuationState(tt.value)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseEvacuationState() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if got := (evacuationState{batch: 2, maxSize: 4}).String(); got != "2/4" {
+		t.Errorf("evacuationState.String() = %v, want 2/4", got)
+	}
+}
+
+func Test_autoScalingGroup_evacuationAction(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    AutoScalingConfig
+		state     string
+		spots     int
+		onDemands int
+		desired   int64
+		pending   bool
+		want      runer
+	}{
+		{
+			name:      "first batch",
+			config:    AutoScalingConfig{Evacuate: true, EvacuationBatchSize: 2},
+			spots:     3,
+			onDemands: 1,
+			desired:   4,
+			want:      launchEvacuationBatch{batch: 2},
+		},
+		{
+			name:    "batch limited to the Spot instances left",
+			config:  AutoScalingConfig{Evacuate: true, EvacuationBatchSize: 5},
+			spots:   2,
+			desired: 2,
+			want:    launchEvacuationBatch{batch: 2},
+		},
+		{
+			name:      "evacuated",
+			config:    AutoScalingConfig{Evacuate: true},
+			onDemands: 3,
+			desired:   3,
+			want:      skipRun{reason: "evacuated"},
+		},
+		{
+			name:      "batch launching",
+			config:    AutoScalingConfig{Evacuate: true},
+			state:     "1/4",
+			spots:     2,
+			onDemands: 2,
+			desired:   5,
+			want:      skipRun{reason: "evacuation-batch-not-ready"},
+		},
+		{
+			name:      "batch pending",
+			config:    AutoScalingConfig{Evacuate: true},
+			state:     "1/4",
+			spots:     2,
+			onDemands: 3,
+			desired:   5,
+			pending:   true,
+			want:      skipRun{reason: "evacuation-batch-not-ready"},
+		},
+		{
+			name:      "batch ready",
+			config:    AutoScalingConfig{Evacuate: true},
+			state:     "1/4",
+			spots:     2,
+			onDemands: 3,
+			desired:   5,
+			want:      terminateEvacuatedSpotInstances{state: evacuationState{batch: 1, maxSize: 4}},
+		},
+		{
+			name:      "batch finished after cancelling",
+			state:     "1/4",
+			spots:     2,
+			onDemands: 3,
+			desired:   5,
+			want:      terminateEvacuatedSpotInstances{state: evacuationState{batch: 1, maxSize: 4}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{
+					DesiredCapacity: aws.Int64(tt.desired),
+					MaxSize:         aws.Int64(4),
+				},
+				name:      "asg",
+				instances: makeInstances(),
+				region:    &region{name: "us-east-1"},
+				config:    tt.config,
+			}
+			if tt.state != "" {
+				a.setTagValue(EvacuationStateTag, tt.state)
+			}
+
+			for n := 0; n < tt.spots+tt.onDemands; n++ {
+				id := fmt.Sprintf("i-%d", n)
+				inst := &instance{Instance: &ec2.Instance{
+					InstanceId: aws.String(id),
+					State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+				}}
+				if n < tt.spots {
+					inst.InstanceLifecycle = aws.String(Spot)
+				}
+				a.instances.add(inst)
+
+				lifecycleState := "InService"
+				if tt.pending && n == tt.spots+tt.onDemands-1 {
+					lifecycleState = "Pending"
+				}
+				a.Instances = append(a.Instances, &autoscaling.Instance{
+					InstanceId:     aws.String(id),
+					LifecycleState: aws.String(lifecycleState),
+				})
+			}
+
+			got := a.evacuationAction()
+			switch action := got.(type) {
+			case launchEvacuationBatch:
+				action.target = target{}
+				got = action
+			case terminateEvacuatedSpotInstances:
+				action.target = target{}
+				got = action
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("evacuationAction() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_startEvacuationBatch(t *testing.T) {
+	tests := []struct {
+		name      string
+		updateErr error
+		wantErr   bool
+		wantState string
+	}{
+		{
+			name:      "capacity raised",
+			wantState: "2/3",
+		},
+		{
+			name:      "update failed",
+			updateErr: errors.New("access denied"),
+			wantErr:   true,
+			wantState: evacuationIdle,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{
+					AutoScalingGroupName: aws.String("asg"),
+					DesiredCapacity:      aws.Int64(3),
+					MaxSize:              aws.Int64(3),
+				},
+				name: "asg",
+				region: &region{
+					name: "us-east-1",
+					services: connections{autoScaling: mockASG{
+						couto:   &autoscaling.CreateOrUpdateTagsOutput{},
+						uasgo:   &autoscaling.UpdateAutoScalingGroupOutput{},
+						uasgerr: tt.updateErr,
+					}},
+				},
+			}
+
+			if err := a.startEvacuationBatch(2); (err != nil) != tt.wantErr {
+				t.Errorf("startEvacuationBatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := aws.StringValue(a.getTagValue(EvacuationStateTag)); got != tt.wantState {
+				t.Errorf("evacuation state = %v, want %v", got, tt.wantState)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadEvacuation(t *testing.T) {
+	a := &autoScalingGroup{
+		Group: &autoscaling.Group{Tags: []*autoscaling.TagDescription{
+			{Key: aws.String(EvacuateTag), Value: aws.String("true")},
+			{Key: aws.String(EvacuationBatchSizeTag), Value: aws.String("0")},
+		}},
+		region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{
+			EvacuationBatchSize: 3,
+		}}},
+	}
+
+	if !a.loadEvacuate() || !a.config.Evacuate {
+		t.Errorf("loadEvacuate() didn't enable the evacuation from the tag")
+	}
+	if a.loadEvacuationBatchSize() || a.config.EvacuationBatchSize != 3 {
+		t.Errorf("loadEvacuationBatchSize() = %v, want the default 3 for an invalid tag",
+			a.config.EvacuationBatchSize)
+	}
+
+	a.Tags[0].Value = aws.String("yes")
+	if a.loadEvacuate() || a.config.Evacuate {
+		t.Errorf("loadEvacuate() enabled the evacuation from an invalid tag")
+	}
+}
+
+func Test_region_setGroupEvacuation(t *testing.T) {
+	r := &region{name: "us-east-1", services: connections{autoScaling: mockASG{
+		couto: &autoscaling.CreateOrUpdateTagsOutput{},
+	}}}
+	if err := r.setGroupEvacuation("asg", true); err != nil {
+		t.Errorf("setGroupEvacuation() error = %v", err)
+	}
+
+	r.services.autoScaling = mockASG{couterr: errors.New("access denied")}
+	if err := r.setGroupEvacuation("asg", false); err == nil {
+		t.Errorf("setGroupEvacuation() didn't return the tagging error")
+	}
+}
+
+func TestAutoSpotting_RunCommand(t *testing.T) {
+	a := &AutoSpotting{config: &Config{}}
+	for _, args := range [][]string{nil, {"evacuate", "us-east-1"}, {"drain", "us-east-1", "asg"}} {
+		if err := a.RunCommand(args); err == nil {
+			t.Errorf("RunCommand(%v) didn't fail", args)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// fleet_backoff.go contains the logic used for remembering the Spot pools in
+// which CreateFleet recently failed to launch instances, so they're avoided
+// for a while, and for backing off the Spot launches of groups failing
+// repeatedly.
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// FailedPoolsTag is the name of the tag set by AutoSpotting on the
+	// AutoScaling groups to keep track of the Spot pools in which the recent
+	// launches failed for lack of capacity, as a space separated list of
+	// instance-type/availability-zone/unix-timestamp entries.
+	FailedPoolsTag = "autospotting_failed_pools"
+
+	// LaunchBackoffTag is the name of the tag set by AutoSpotting on the
+	// AutoScaling groups to keep track of the consecutive failed Spot
+	// launches, as failures/unix-timestamp of the last failure.
+	LaunchBackoffTag = "autospotting_launch_backoff"
+
+	// DefaultFailedPoolCooldown is the default amount of time for which the
+	// Spot pools that failed to launch instances are avoided.
+	DefaultFailedPoolCooldown = 30 * time.Minute
+
+	// DefaultMaxLaunchBackoff is the default maximum delay between the Spot
+	// launch attempts of a group failing repeatedly.
+	DefaultMaxLaunchBackoff = 4 * time.Hour
+
+	// launchBackoffThreshold is the number of consecutive failed launches
+	// after which the group starts backing off.
+	launchBackoffThreshold = 3
+
+	// launchBackoffBase is the delay applied after launchBackoffThreshold
+	// consecutive failures, doubled on each further failure.
+	launchBackoffBase = 5 * time.Minute
+)
+
+// fleetErrorKind is the category of a CreateFleet error.
+type fleetErrorKind int
+
+const (
+	// fleetErrorOther covers the errors unrelated to the Spot capacity, such as
+	// invalid parameters.
+	fleetErrorOther fleetErrorKind = iota
+
+	// fleetErrorCapacity covers the errors specific to a Spot pool, which may
+	// succeed in another pool.
+	fleetErrorCapacity
+
+	// fleetErrorLimit covers the account and API limits, affecting all the
+	// Spot pools.
+	fleetErrorLimit
+)
+
+func (k fleetErrorKind) String() string {
+	switch k {
+	case fleetErrorCapacity:
+		return "capacity"
+	case fleetErrorLimit:
+		return "limit"
+	}
+	return "other"
+}
+
+// classifyFleetError returns the category of a CreateFleet error code.
+func classifyFleetError(code string) fleetErrorKind {
+	switch code {
+	case "InsufficientInstanceCapacity",
+		"InsufficientCapacity",
+		"UnfulfillableCapacity",
+		"SpotMaxPriceTooLow":
+		return fleetErrorCapacity
+	case "MaxSpotInstanceCountExceeded",
+		"VcpuLimitExceeded",
+		"InstanceLimitExceeded",
+		"RequestLimitExceeded":
+		return fleetErrorLimit
+	}
+	return fleetErrorOther
+}
+
+// launchBackoff is the state of the consecutive failed Spot launches of a
+// group.
+type launchBackoff struct {
+	failures int64
+	last     time.Time
+}
+
+func parseLaunchBackoff(value *string) launchBackoff {
+	if value == nil {
+		return launchBackoff{}
+	}
+
+	fields := strings.Split(*value, "/")
+	if len(fields) != 2 {
+		return launchBackoff{}
+	}
+
+	failures, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || failures < 0 {
+		return launchBackoff{}
+	}
+
+	ts, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return launchBackoff{}
+	}
+	return launchBackoff{failures: failures, last: time.Unix(ts, 0)}
+}
+
+func (b launchBackoff) String() string {
+	return fmt.Sprintf("%d/%d", b.failures, b.last.Unix())
+}
+
+// delay returns how long to wait after the last failure before launching
+// again, growing exponentially once the failures reach the threshold.
+func (b launchBackoff) delay(limit time.Duration) time.Duration {
+	if b.failures < launchBackoffThreshold {
+		return 0
+	}
+
+	d := launchBackoffBase
+	for n := int64(launchBackoffThreshold); n < b.failures; n++ {
+		d *= 2
+		if d >= limit {
+			return limit
+		}
+	}
+	if d > limit {
+		return limit
+	}
+	return d
+}
+
+func (r *region) failedPoolCooldown() time.Duration {
+	if r.conf == nil || r.conf.FailedPoolCooldown <= 0 {
+		return DefaultFailedPoolCooldown
+	}
+	return r.conf.FailedPoolCooldown
+}
+
+func (r *region) maxLaunchBackoff() time.Duration {
+	if r.conf == nil || r.conf.MaxLaunchBackoff <= 0 {
+		return DefaultMaxLaunchBackoff
+	}
+	return r.conf.MaxLaunchBackoff
+}
+
+// failedPools returns the Spot pools of the group still cooling down after
+// failing to launch instances.
+func (a *autoScalingGroup) failedPools(now time.Time) map[string]bool {
+	result := make(map[string]bool)
+
+	tagValue := a.getTagValue(FailedPoolsTag)
+	if tagValue == nil {
+		return result
+	}
+
+	for _, fp := range parsePoolEntries(*tagValue, now, a.region.failedPoolCooldown()) {
+		result[poolKey(fp.instanceType, fp.availabilityZone)] = true
+	}
+	return result
+}
+
+// launchBackoffThrottled returns whether the group is backing off after
+// repeatedly failing to launch Spot instances.
+func (a *autoScalingGroup) launchBackoffThrottled(now time.Time) bool {
+	b := parseLaunchBackoff(a.getTagValue(LaunchBackoffTag))
+
+	delay := b.delay(a.region.maxLaunchBackoff())
+	if delay == 0 || !now.Before(b.last.Add(delay)) {
+		return false
+	}
+
+	log.Println(a.region.name, a.name, "Backing off after", b.failures,
+		"failed Spot launches, the next attempt is allowed after", b.last.Add(delay).UTC().Format(time.RFC3339))
+	return true
+}
+
+// excludeFailedPools drops the overrides of the Spot pools which recently
+// failed to launch instances, unless all of them failed, in which case they
+// are all kept.
+func (i *instance) excludeFailedPools(overrides []*ec2.FleetLaunchTemplateOverridesRequest) []*ec2.FleetLaunchTemplateOverridesRequest {
+	if i.asg == nil || i.asg.Group == nil || len(overrides) == 0 {
+		return overrides
+	}
+
+	failed := i.asg.failedPools(time.Now())
+	if len(failed) == 0 {
+		return overrides
+	}
+
+	zones := i.subnetZones(overrides)
+
+	var result []*ec2.FleetLaunchTemplateOverridesRequest
+	for _, o := range overrides {
+		az, found := zones[aws.StringValue(o.SubnetId)]
+		if found && failed[poolKey(aws.StringValue(o.InstanceType), az)] {
+			debug.Println("Excluding the recently failed Spot pool", aws.StringValue(o.InstanceType), az)
+			continue
+		}
+		result = append(result, o)
+	}
+
+	if len(result) == 0 {
+		log.Println(i.region.name, i.asg.name,
+			"All the Spot pools failed recently, trying them again")
+		return overrides
+	}
+	return result
+}
+
+// fleetErrorPools returns the Spot pools which failed to launch instances
+// because of their capacity, and the most severe category of the errors.
+func (i *instance) fleetErrorPools(errors []*ec2.CreateFleetError, overrides []*ec2.FleetLaunchTemplateOverridesRequest) ([]observedInterruption, fleetErrorKind) {
+	var pools []observedInterruption
+	kind := fleetErrorOther
+	zones := i.subnetZones(overrides)
+
+	for _, e := range errors {
+		k := classifyFleetError(aws.StringValue(e.ErrorCode))
+		if k == fleetErrorLimit || kind == fleetErrorOther {
+			kind = k
+		}
+
+		if k != fleetErrorCapacity || e.LaunchTemplateAndOverrides == nil ||
+			e.LaunchTemplateAndOverrides.Overrides == nil {
+			continue
+		}
+
+		o := e.LaunchTemplateAndOverrides.Overrides
+		az := aws.StringValue(o.AvailabilityZone)
+		if az == "" {
+			az = zones[aws.StringValue(o.SubnetId)]
+		}
+		if az == "" || o.InstanceType == nil {
+			continue
+		}
+		pools = append(pools, observedInterruption{instanceType: *o.InstanceType, availabilityZone: az})
+	}
+	return pools, kind
+}
+
+// recordLaunchFailure remembers the Spot pools which failed to launch
+// instances and counts the consecutive failures of the group.
+func (a *autoScalingGroup) recordLaunchFailure(now time.Time, pools []observedInterruption, kind fleetErrorKind) {
+	var failed []observedInterruption
+	recorded := make(map[string]bool)
+
+	for _, fp := range pools {
+		key := poolKey(fp.instanceType, fp.availabilityZone)
+		if recorded[key] {
+			continue
+		}
+		recorded[key] = true
+		fp.time = now
+		failed = append(failed, fp)
+	}
+
+	// the previous failures of the same pools are replaced by the new ones
+	if tagValue := a.getTagValue(FailedPoolsTag); tagValue != nil {
+		for _, fp := range parsePoolEntries(*tagValue, now, a.region.failedPoolCooldown()) {
+			if !recorded[poolKey(fp.instanceType, fp.availabilityZone)] {
+				failed = append(failed, fp)
+			}
+		}
+	}
+
+	b := parseLaunchBackoff(a.getTagValue(LaunchBackoffTag))
+	b.failures++
+	b.last = now
+
+	state := map[string]string{LaunchBackoffTag: b.String()}
+	if len(failed) > 0 {
+		state[FailedPoolsTag] = formatObservedInterruptions(failed)
+	}
+	a.setStateTags(state)
+
+	var poolNames []string
+	for _, fp := range pools {
+		poolNames = append(poolNames, poolKey(fp.instanceType, fp.availabilityZone))
+	}
+
+	recapText := fmt.Sprintf("%s Spot launch failed [%s error, %d in a row, failed pools: %s]",
+		a.name, kind, b.failures, strings.Join(poolNames, " "))
+	if delay := b.delay(a.region.maxLaunchBackoff()); delay > 0 {
+		recapText += fmt.Sprintf(" backing off for %s", delay)
+	}
+	a.region.conf.FinalRecap[a.region.name] = append(a.region.conf.FinalRecap[a.region.name], recapText)
+}
+
+// recordLaunchSuccess resets the consecutive failures of the group.
+func (a *autoScalingGroup) recordLaunchSuccess(now time.Time) {
+	if parseLaunchBackoff(a.getTagValue(LaunchBackoffTag)).failures == 0 {
+		return
+	}
+	a.setStateTags(map[string]string{LaunchBackoffTag: launchBackoff{last: now}.String()})
+}
+
+// fleetErrorKindOf returns the category of an error returned by the
+// CreateFleet API call itself.
+func fleetErrorKindOf(err error) fleetErrorKind {
+	if aerr, ok := err.(awserr.Error); ok {
+		return classifyFleetError(aerr.Code())
+	}
+	return fleetErrorOther
+}
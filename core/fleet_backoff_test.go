@@ -0,0 +1,214 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_classifyFleetError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want fleetErrorKind
+	}{
+		{err: awserr.New("InsufficientInstanceCapacity", "no capacity", nil), want: fleetErrorCapacity},
+		{err: awserr.New("MaxSpotInstanceCountExceeded", "too many", nil), want: fleetErrorLimit},
+		{err: awserr.New("InvalidParameterValue", "invalid", nil), want: fleetErrorOther},
+		{err: errors.New("connection reset"), want: fleetErrorOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			if got := fleetErrorKindOf(tt.err); got != tt.want {
+				t.Errorf("fleetErrorKindOf() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_launchBackoff_delay(t *testing.T) {
+	tests := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 2, want: 0},
+		{failures: 3, want: 5 * time.Minute},
+		{failures: 5, want: 20 * time.Minute},
+		{failures: 10, want: time.Hour},
+		{failures: 100, want: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.failures), func(t *testing.T) {
+			if got := (launchBackoff{failures: tt.failures}).delay(time.Hour); got != tt.want {
+				t.Errorf("delay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_launchBackoffThrottled(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name  string
+		state string
+		want  bool
+	}{
+		{name: "no failures"},
+		{name: "below the threshold", state: fmt.Sprintf("2/%d", now.Unix())},
+		{name: "backing off", state: fmt.Sprintf("4/%d", now.Add(-9*time.Minute).Unix()), want: true},
+		{name: "backoff expired", state: fmt.Sprintf("4/%d", now.Add(-10*time.Minute).Unix())},
+		{name: "invalid state", state: "four"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				name:   "asg",
+				region: &region{name: "us-east-1", conf: &Config{}},
+			}
+			if tt.state != "" {
+				a.setTagValue(LaunchBackoffTag, tt.state)
+			}
+			if got := a.launchBackoffThrottled(now); got != tt.want {
+				t.Errorf("launchBackoffThrottled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_excludeFailedPools(t *testing.T) {
+	recent := fmt.Sprint(time.Now().Add(-10 * time.Minute).Unix())
+	old := fmt.Sprint(time.Now().Add(-time.Hour).Unix())
+
+	overrides := []*ec2.FleetLaunchTemplateOverridesRequest{
+		{InstanceType: aws.String("m5.large"), SubnetId: aws.String("subnet-a")},
+		{InstanceType: aws.String("c5.large"), SubnetId: aws.String("subnet-a")},
+	}
+
+	tests := []struct {
+		name   string
+		failed string
+		want   []string
+	}{
+		{
+			name: "no failed pools",
+			want: []string{"m5.large", "c5.large"},
+		},
+		{
+			name:   "recently failed pool excluded",
+			failed: "m5.large/us-east-1a/" + recent + " c5.large/us-east-1b/" + recent,
+			want:   []string{"c5.large"},
+		},
+		{
+			name:   "cooled down pool kept",
+			failed: "m5.large/us-east-1a/" + old,
+			want:   []string{"m5.large", "c5.large"},
+		},
+		{
+			name:   "all pools failed",
+			failed: "m5.large/us-east-1a/" + recent + " c5.large/us-east-1a/" + recent,
+			want:   []string{"m5.large", "c5.large"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				name:   "asg",
+				region: &region{name: "us-east-1", conf: &Config{}},
+			}
+			if tt.failed != "" {
+				a.setTagValue(FailedPoolsTag, tt.failed)
+			}
+			i := &instance{
+				Instance: &ec2.Instance{
+					SubnetId:  aws.String("subnet-a"),
+					Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				},
+				asg:    a,
+				region: a.region,
+			}
+
+			var got []string
+			for _, o := range i.excludeFailedPools(overrides) {
+				got = append(got, *o.InstanceType)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("excludeFailedPools() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_recordLaunchFailure(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	a := &autoScalingGroup{
+		Group: &autoscaling.Group{AutoScalingGroupName: aws.String("asg")},
+		name:  "asg",
+		region: &region{
+			name:     "us-east-1",
+			conf:     &Config{FinalRecap: map[string][]string{}},
+			services: connections{autoScaling: mockASG{couto: &autoscaling.CreateOrUpdateTagsOutput{}}},
+		},
+	}
+	a.setTagValue(FailedPoolsTag, fmt.Sprintf("m5.large/us-east-1a/%d c5.large/us-east-1a/%d",
+		now.Add(-5*time.Minute).Unix(), now.Add(-5*time.Minute).Unix()))
+
+	i := &instance{
+		Instance: &ec2.Instance{
+			SubnetId:  aws.String("subnet-a"),
+			Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		},
+		asg:    a,
+		region: a.region,
+	}
+
+	pools, kind := i.fleetErrorPools([]*ec2.CreateFleetError{
+		{
+			ErrorCode: aws.String("InsufficientInstanceCapacity"),
+			LaunchTemplateAndOverrides: &ec2.LaunchTemplateAndOverridesResponse{
+				Overrides: &ec2.FleetLaunchTemplateOverrides{
+					InstanceType: aws.String("m5.large"),
+					SubnetId:     aws.String("subnet-a"),
+				},
+			},
+		},
+		{ErrorCode: aws.String("InvalidParameterValue")},
+	}, nil)
+
+	if kind != fleetErrorCapacity || len(pools) != 1 {
+		t.Fatalf("fleetErrorPools() = %v, %v", pools, kind)
+	}
+
+	for n := 0; n < 3; n++ {
+		a.recordLaunchFailure(now, pools, kind)
+	}
+
+	wantPools := fmt.Sprintf("c5.large/us-east-1a/%d m5.large/us-east-1a/%d", now.Add(-5*time.Minute).Unix(), now.Unix())
+	if got := aws.StringValue(a.getTagValue(FailedPoolsTag)); got != wantPools {
+		t.Errorf("failed pools = %v, want %v", got, wantPools)
+	}
+	if got := aws.StringValue(a.getTagValue(LaunchBackoffTag)); got != "3/1700000000" {
+		t.Errorf("launch backoff = %v, want 3/1700000000", got)
+	}
+	if len(a.region.conf.FinalRecap["us-east-1"]) != 3 {
+		t.Errorf("final recap = %v", a.region.conf.FinalRecap)
+	}
+
+	a.recordLaunchSuccess(now)
+	if got := aws.StringValue(a.getTagValue(LaunchBackoffTag)); got != "0/1700000000" {
+		t.Errorf("launch backoff = %v after a success, want 0/1700000000", got)
+	}
+}
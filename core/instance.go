@@ -12,4 +12,12 @@ type instance struct {
 	region    *region
 	protected bool
 	asg       *autoScalingGroup
+
+	// the CPU architecture of the Spot instance types being launched when it
+	// differs from the current one, see pickLaunchArchitecture()
+	launchArchitecture string
+
+	// the Spot placement scores per availability zone ID fetched while ranking
+	// the candidates, reused when picking the subnet of the Spot instance
+	placementScores map[string]int64
 }
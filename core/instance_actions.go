@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
@@ -35,15 +36,40 @@ func (i *instance) handleInstanceStates() (bool, error) {
 // returns an instance ID or error
 func (i *instance) launchSpotReplacement() (*string, error) {
 
-	ltData, err := i.createLaunchTemplateData()
+	instanceTypes, err := i.getCompatibleSpotInstanceTypesListSortedAscendingByPrice(
+		i.asg.getAllowedInstanceTypes(i),
+		i.asg.getDisallowedInstanceTypes(i))
 
-	debug.Printf("Launch template data: %+#v", ltData)
+	if err != nil {
+		log.Println("Couldn't determine the list of compatible spot instance types")
+		return nil, err
+	}
+
+	if instanceTypes, err = i.excludeIncompatibleInstanceTypes(instanceTypes); err != nil {
+		log.Println(i.region.name, i.asg.name, "Couldn't launch a spot replacement:", err.Error())
+		return nil, err
+	}
+
+	subnet := i.applySpotPlacementScores(instanceTypes)
+	instanceTypes = i.pickLaunchArchitecture(instanceTypes)
+	instanceTypes = i.diversifyInstanceTypes(instanceTypes)
+
+	ltData, err := i.createLaunchTemplateData()
 
 	if err != nil {
 		log.Println("failed to create LaunchTemplate data,", err.Error())
 		return nil, err
 	}
 
+	subnetIDs := i.launchSubnets(subnet)
+	if len(subnetIDs) > 1 {
+		unpinLaunchTemplateDataFromSubnet(ltData)
+	} else {
+		moveLaunchTemplateDataToSubnet(ltData, subnet)
+	}
+
+	debug.Printf("Launch template data: %+#v", ltData)
+
 	lt, err := i.createFleetLaunchTemplate(ltData)
 
 	debug.Printf("Fleet Launch Template: %+#v", lt)
@@ -54,16 +80,8 @@ func (i *instance) launchSpotReplacement() (*string, error) {
 	}
 
 	defer i.deleteLaunchTemplate(lt)
-	instanceTypes, err := i.getCompatibleSpotInstanceTypesListSortedAscendingByPrice(
-		i.asg.getAllowedInstanceTypes(i),
-		i.asg.getDisallowedInstanceTypes(i))
-
-	if err != nil {
-		log.Println("Couldn't determine the list of compatible spot instance types")
-		return nil, err
-	}
 
-	cfi := i.createFleetInput(lt, instanceTypes)
+	cfi := i.createFleetInput(lt, instanceTypes, subnetIDs)
 
 	debug.Printf("Fleet Input: %+#v", cfi)
 
@@ -71,16 +89,23 @@ func (i *instance) launchSpotReplacement() (*string, error) {
 
 	if err != nil {
 		log.Println(i.region.name, i.asg.name, "CreateFleet() failure:", err.Error())
+		i.asg.recordLaunchFailure(time.Now(), nil, fleetErrorKindOf(err))
 		return nil, err
 	}
 
 	if resp != nil && len(resp.Instances) > 0 && resp.Instances[0] != nil && len(resp.Instances[0].InstanceIds) > 0 {
+		i.asg.recordReplacement(time.Now())
+		i.asg.recordLaunchSuccess(time.Now())
 		return resp.Instances[0].InstanceIds[0], nil
 	}
 
+	var failedPools []observedInterruption
+	kind := fleetErrorOther
 	if resp != nil && len(resp.Errors) > 0 {
 		log.Println(i.region.name, i.asg.name, "CreateFleet, instances cannot be launched:", resp.Errors)
+		failedPools, kind = i.fleetErrorPools(resp.Errors, cfi.LaunchTemplateConfigs[0].Overrides)
 	}
+	i.asg.recordLaunchFailure(time.Now(), failedPools, kind)
 
 	return nil, fmt.Errorf("Couldn't launch spot instance replacement")
 }
@@ -93,6 +118,14 @@ func (i *instance) swapWithGroupMember(asg *autoScalingGroup) (*instance, error)
 		return nil, err
 	}
 
+	if err := asg.waitUntilReady(i, false); err != nil {
+		log.Printf("Spot instance %s isn't ready to be attached to the group %s, terminating it...",
+			*i.InstanceId, asg.name)
+		i.terminate()
+		asg.recordReplacementFailure(time.Now(), err.Error())
+		return nil, err
+	}
+
 	asg.suspendProcesses()
 	defer asg.resumeProcesses()
 
@@ -114,18 +147,30 @@ func (i *instance) swapWithGroupMember(asg *autoScalingGroup) (*instance, error)
 		log.Printf("Spot instance %s couldn't be attached to the group %s, terminating it...",
 			*i.InstanceId, asg.name)
 		i.terminate()
+		asg.recordReplacementFailure(time.Now(), "couldn't attach spot instance "+*i.InstanceId)
 		return nil, fmt.Errorf("couldn't attach spot instance %s ", *i.InstanceId)
 	}
 
+	if err := asg.waitUntilReady(i, true); err != nil {
+		log.Printf("Spot instance %s isn't ready after being attached to the group %s, terminating it...",
+			*i.InstanceId, asg.name)
+		asg.terminateInstanceInAutoScalingGroup(i.InstanceId, false, true)
+		asg.recordReplacementFailure(time.Now(), err.Error())
+		return nil, err
+	}
+
 	log.Printf("Terminating on-demand instance %s from the group %s",
 		*odInstance.InstanceId, asg.name)
 	if err := asg.terminateInstanceInAutoScalingGroup(odInstance.Instance.InstanceId, true, true); err != nil {
 		log.Printf("On-demand instance %s couldn't be terminated, re-trying...",
 			*odInstance.InstanceId)
+		asg.recordReplacementFailure(time.Now(), "couldn't terminate on-demand instance "+*odInstance.InstanceId)
 		return nil, fmt.Errorf("couldn't terminate on-demand instance %s",
 			*odInstance.InstanceId)
 	}
 
+	asg.recordReplacementSuccess(time.Now())
+	asg.watchSwappedInstance(time.Now(), i)
 	return odInstance, nil
 }
 
@@ -153,6 +198,19 @@ func (i *instance) getSwapCandidate() (*instance, error) {
 		return nil, fmt.Errorf("target instance %s should not be replaced with spot",
 			*odInstanceID)
 	}
+
+	// The Spot instance may have been launched in another subnet than the
+	// target on-demand instance, in which case we prefer to replace an
+	// on-demand instance from the same availability zone, keeping the group
+	// balanced.
+	if i.Placement != nil && odInstance.Placement != nil && odInstance.asg != nil &&
+		*i.Placement.AvailabilityZone != *odInstance.Placement.AvailabilityZone {
+		if sameAZInstance := odInstance.asg.getInstance(i.Placement.AvailabilityZone, true, true); sameAZInstance != nil {
+			log.Printf("Spot instance %s was launched in %s, replacing on-demand instance %s from the same availability zone instead of %s",
+				*i.InstanceId, *i.Placement.AvailabilityZone, *sameAZInstance.InstanceId, *odInstanceID)
+			return sameAZInstance, nil
+		}
+	}
 	return odInstance, nil
 }
 
@@ -0,0 +1,252 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// instance_catalog_overlay.go contains the logic used for adding or patching
+// instance types in the ec2-instances-info data bundled in the binary, using a
+// local JSON or YAML file. This is useful for new instance types released after
+// the data was updated, or for correcting the data of existing ones.
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+
+	ec2instancesinfo "github.com/mello7tre/ec2-instances-info"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// instanceCatalogOverlay is the content of the overlay file. Since JSON is a
+// subset of YAML, both formats are parsed the same way.
+type instanceCatalogOverlay struct {
+	InstanceTypes map[string]instanceTypeOverlay `yaml:"instance_types"`
+}
+
+// instanceTypeOverlay contains the attributes of an instance type, the ones
+// left unset keep the value from the bundled data.
+type instanceTypeOverlay struct {
+	VCPU                *int                           `yaml:"vcpu"`
+	Memory              *float32                       `yaml:"memory"`
+	GPU                 *int                           `yaml:"gpu"`
+	PhysicalProcessor   *string                        `yaml:"physical_processor"`
+	NetworkPerformance  *string                        `yaml:"network_performance"`
+	EBSOptimized        *bool                          `yaml:"ebs_optimized"`
+	EBSThroughput       *float32                       `yaml:"ebs_throughput"`
+	VirtualizationTypes []string                       `yaml:"virtualization_types"`
+	Storage             *storageOverlay                `yaml:"storage"`
+	Pricing             map[string]regionPricesOverlay `yaml:"pricing"`
+}
+
+type storageOverlay struct {
+	Devices *int     `yaml:"devices"`
+	Size    *float32 `yaml:"size"`
+	SSD     *bool    `yaml:"ssd"`
+}
+
+// regionPricesOverlay contains the hourly on-demand prices of an instance type
+// in a region, for each operating system.
+type regionPricesOverlay struct {
+	Linux        *float64 `yaml:"linux"`
+	Windows      *float64 `yaml:"windows"`
+	RHEL         *float64 `yaml:"rhel"`
+	SUSE         *float64 `yaml:"suse"`
+	EBSSurcharge *float64 `yaml:"ebs_surcharge"`
+}
+
+// loadInstanceCatalogOverlay reads and validates the overlay file, rejecting
+// the unknown fields so typos don't go unnoticed.
+func loadInstanceCatalogOverlay(path string) (*instanceCatalogOverlay, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var overlay instanceCatalogOverlay
+	if err := yaml.UnmarshalStrict(body, &overlay); err != nil {
+		return nil, fmt.Errorf("couldn't parse the instance catalog overlay %s: %s", path, err.Error())
+	}
+	return &overlay, nil
+}
+
+// validate checks the values of the overlay, as well as the presence of the
+// attributes required for the instance types missing from the bundled data.
+func (o *instanceCatalogOverlay) validate(data *ec2instancesinfo.InstanceData) error {
+	known := make(map[string]bool)
+	if data != nil {
+		for _, it := range *data {
+			known[it.InstanceType] = true
+		}
+	}
+
+	var problems []string
+	report := func(instanceType, format string, args ...interface{}) {
+		problems = append(problems, instanceType+": "+fmt.Sprintf(format, args...))
+	}
+
+	for _, instanceType := range o.instanceTypes() {
+		it := o.InstanceTypes[instanceType]
+
+		if it.VCPU != nil && *it.VCPU <= 0 {
+			report(instanceType, "vcpu must be positive")
+		}
+		if it.Memory != nil && *it.Memory <= 0 {
+			report(instanceType, "memory must be positive")
+		}
+		if it.GPU != nil && *it.GPU < 0 {
+			report(instanceType, "gpu can't be negative")
+		}
+		if it.EBSThroughput != nil && *it.EBSThroughput < 0 {
+			report(instanceType, "ebs_throughput can't be negative")
+		}
+		if it.Storage != nil && ((it.Storage.Devices != nil && *it.Storage.Devices < 0) ||
+			(it.Storage.Size != nil && *it.Storage.Size < 0)) {
+			report(instanceType, "storage can't be negative")
+		}
+
+		for region, prices := range it.Pricing {
+			for _, p := range []*float64{prices.Linux, prices.Windows, prices.RHEL, prices.SUSE, prices.EBSSurcharge} {
+				if p != nil && *p < 0 {
+					report(instanceType, "prices in %s can't be negative", region)
+					break
+				}
+			}
+		}
+
+		if !known[instanceType] {
+			if it.VCPU == nil || it.Memory == nil {
+				report(instanceType, "vcpu and memory are required for new instance types")
+			}
+			if len(it.Pricing) == 0 {
+				report(instanceType, "pricing is required for new instance types")
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid instance catalog overlay:\n\t" + strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+func (o *instanceCatalogOverlay) instanceTypes() []string {
+	var result []string
+	for instanceType := range o.InstanceTypes {
+		result = append(result, instanceType)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// apply patches the existing instance types and appends the new ones to the
+// instance data.
+func (o *instanceCatalogOverlay) apply(data *ec2instancesinfo.InstanceData) {
+	index := make(map[string]int)
+	for i, it := range *data {
+		index[it.InstanceType] = i
+	}
+
+	for _, instanceType := range o.instanceTypes() {
+		i, found := index[instanceType]
+		if !found {
+			// the element type isn't exported, so we grow the slice by a zero value
+			*data = append(*data, make(ec2instancesinfo.InstanceData, 1)...)
+			i = len(*data) - 1
+			(*data)[i].InstanceType = instanceType
+			log.Println("Adding instance type", instanceType, "from the instance catalog overlay")
+		} else {
+			log.Println("Patching instance type", instanceType, "from the instance catalog overlay")
+		}
+
+		it, e := o.InstanceTypes[instanceType], &(*data)[i]
+
+		if it.VCPU != nil {
+			e.VCPU = *it.VCPU
+		}
+		if it.Memory != nil {
+			e.Memory = *it.Memory
+		}
+		if it.GPU != nil {
+			e.GPU = *it.GPU
+		}
+		if it.PhysicalProcessor != nil {
+			e.PhysicalProcessor = *it.PhysicalProcessor
+		}
+		if it.NetworkPerformance != nil {
+			e.NetworkPerformance = *it.NetworkPerformance
+		}
+		if it.EBSOptimized != nil {
+			e.EBSOptimized = *it.EBSOptimized
+		}
+		if it.EBSThroughput != nil {
+			e.EBSThroughput = *it.EBSThroughput
+		}
+		if it.VirtualizationTypes != nil {
+			e.LinuxVirtualizationTypes = it.VirtualizationTypes
+		}
+
+		if it.Storage != nil {
+			if e.Storage == nil {
+				e.Storage = &ec2instancesinfo.StorageConfiguration{}
+			}
+			if it.Storage.Devices != nil {
+				e.Storage.Devices = *it.Storage.Devices
+			}
+			if it.Storage.Size != nil {
+				e.Storage.Size = *it.Storage.Size
+			}
+			if it.Storage.SSD != nil {
+				e.Storage.SSD = *it.Storage.SSD
+			}
+		}
+
+		if len(it.Pricing) > 0 && e.Pricing == nil {
+			e.Pricing = make(map[string]ec2instancesinfo.RegionPrices)
+		}
+		for region, overlay := range it.Pricing {
+			e.Pricing[region] = overlay.patch(e.Pricing[region])
+		}
+	}
+}
+
+func (o regionPricesOverlay) patch(prices ec2instancesinfo.RegionPrices) ec2instancesinfo.RegionPrices {
+	if o.Linux != nil {
+		prices.Linux.OnDemand = *o.Linux
+	}
+	if o.Windows != nil {
+		prices.MSWin.OnDemand = *o.Windows
+	}
+	if o.RHEL != nil {
+		prices.RHEL.OnDemand = *o.RHEL
+	}
+	if o.SUSE != nil {
+		prices.SLES.OnDemand = *o.SUSE
+	}
+	if o.EBSSurcharge != nil {
+		prices.EBSSurcharge = *o.EBSSurcharge
+	}
+	return prices
+}
+
+// applyInstanceCatalogOverlay loads the overlay file, if configured, and
+// applies it on the instance data.
+func applyInstanceCatalogOverlay(data *ec2instancesinfo.InstanceData, path string) error {
+	if path == "" || data == nil {
+		return nil
+	}
+
+	overlay, err := loadInstanceCatalogOverlay(path)
+	if err != nil {
+		return err
+	}
+
+	if err := overlay.validate(data); err != nil {
+		return err
+	}
+
+	overlay.apply(data)
+	return nil
+}
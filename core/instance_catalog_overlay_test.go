@@ -0,0 +1,224 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	ec2instancesinfo "github.com/mello7tre/ec2-instances-info"
+)
+
+func writeTempFile(t *testing.T, pattern, content string) string {
+	f, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func bundledInstanceData() *ec2instancesinfo.InstanceData {
+	data := make(ec2instancesinfo.InstanceData, 1)
+	data[0].InstanceType = "m5.large"
+	data[0].VCPU = 2
+	data[0].Memory = 8
+	data[0].EBSThroughput = 100
+	data[0].PhysicalProcessor = "Intel Xeon Platinum 8175"
+	data[0].Pricing = map[string]ec2instancesinfo.RegionPrices{
+		"us-east-1": {
+			Linux: ec2instancesinfo.Pricing{OnDemand: 0.096},
+			MSWin: ec2instancesinfo.Pricing{OnDemand: 0.188},
+		},
+	}
+	return &data
+}
+
+func Test_loadInstanceCatalogOverlay(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    int
+		wantErr string
+	}{
+		{
+			name: "YAML",
+			content: `
+instance_types:
+  m5.large:
+    ebs_throughput: 593.75
+  m7i.large:
+    vcpu: 2
+    memory: 8
+    pricing:
+      us-east-1:
+        linux: 0.1008
+`,
+			want: 2,
+		},
+		{
+			name:    "JSON",
+			content: `{"instance_types": {"m5.large": {"ebs_throughput": 593.75, "storage": {"devices": 1}}}}`,
+			want:    1,
+		},
+		{
+			name: "unknown field",
+			content: `
+instance_types:
+  m5.large:
+    ebs_througput: 593.75
+`,
+			wantErr: "ebs_througput",
+		},
+		{
+			name:    "unknown top level field",
+			content: `instances: {}`,
+			wantErr: "instances",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTempFile(t, "overlay", tt.content)
+			defer os.Remove(path)
+
+			got, err := loadInstanceCatalogOverlay(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("loadInstanceCatalogOverlay() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadInstanceCatalogOverlay() error = %v", err)
+			}
+			if len(got.InstanceTypes) != tt.want {
+				t.Errorf("loadInstanceCatalogOverlay() loaded %d instance types, want %d", len(got.InstanceTypes), tt.want)
+			}
+		})
+	}
+}
+
+func Test_instanceCatalogOverlay_validate(t *testing.T) {
+	negative, two := -1.0, 2
+	var memory float32 = 8
+
+	tests := []struct {
+		name    string
+		overlay instanceTypeOverlay
+		newType bool
+		wantErr string
+	}{
+		{
+			name:    "patch of an existing instance type",
+			overlay: instanceTypeOverlay{VCPU: &two},
+		},
+		{
+			name:    "negative price",
+			overlay: instanceTypeOverlay{Pricing: map[string]regionPricesOverlay{"us-east-1": {Linux: &negative}}},
+			wantErr: "prices in us-east-1 can't be negative",
+		},
+		{
+			name: "complete new instance type",
+			overlay: instanceTypeOverlay{
+				VCPU:    &two,
+				Memory:  &memory,
+				Pricing: map[string]regionPricesOverlay{"us-east-1": {}},
+			},
+			newType: true,
+		},
+		{
+			name:    "new instance type without specs",
+			overlay: instanceTypeOverlay{Pricing: map[string]regionPricesOverlay{"us-east-1": {}}},
+			newType: true,
+			wantErr: "vcpu and memory are required",
+		},
+		{
+			name:    "new instance type without prices",
+			overlay: instanceTypeOverlay{VCPU: &two, Memory: &memory},
+			newType: true,
+			wantErr: "pricing is required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instanceType := "m5.large"
+			if tt.newType {
+				instanceType = "m7i.large"
+			}
+			o := &instanceCatalogOverlay{InstanceTypes: map[string]instanceTypeOverlay{instanceType: tt.overlay}}
+
+			err := o.validate(bundledInstanceData())
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("validate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_applyInstanceCatalogOverlay(t *testing.T) {
+	path := writeTempFile(t, "overlay", `
+instance_types:
+  m5.large:
+    ebs_throughput: 593.75
+    pricing:
+      us-east-1:
+        linux: 0.1
+      eu-west-1:
+        linux: 0.107
+  m7i.large:
+    vcpu: 2
+    memory: 8
+    physical_processor: Intel Xeon Sapphire Rapids
+    virtualization_types: [HVM]
+    storage:
+      devices: 1
+      size: 75
+      ssd: true
+    pricing:
+      us-east-1:
+        linux: 0.1008
+`)
+	defer os.Remove(path)
+
+	data := bundledInstanceData()
+	if err := applyInstanceCatalogOverlay(data, path); err != nil {
+		t.Fatalf("applyInstanceCatalogOverlay() error = %v", err)
+	}
+
+	if len(*data) != 2 {
+		t.Fatalf("applyInstanceCatalogOverlay() resulted in %d instance types, want 2", len(*data))
+	}
+
+	m5 := (*data)[0]
+	if m5.EBSThroughput != 593.75 || m5.VCPU != 2 || m5.PhysicalProcessor != "Intel Xeon Platinum 8175" {
+		t.Errorf("applyInstanceCatalogOverlay() patched m5.large to %+v", m5)
+	}
+	if p := m5.Pricing["us-east-1"]; p.Linux.OnDemand != 0.1 || p.MSWin.OnDemand != 0.188 {
+		t.Errorf("applyInstanceCatalogOverlay() patched the us-east-1 prices to %+v", p)
+	}
+	if p := m5.Pricing["eu-west-1"]; p.Linux.OnDemand != 0.107 {
+		t.Errorf("applyInstanceCatalogOverlay() added the eu-west-1 prices as %+v", p)
+	}
+
+	m7i := (*data)[1]
+	if m7i.InstanceType != "m7i.large" || m7i.VCPU != 2 || m7i.Memory != 8 ||
+		m7i.Storage == nil || m7i.Storage.Size != 75 || !m7i.Storage.SSD ||
+		len(m7i.LinuxVirtualizationTypes) != 1 || m7i.Pricing["us-east-1"].Linux.OnDemand != 0.1008 {
+		t.Errorf("applyInstanceCatalogOverlay() added m7i.large as %+v", m7i)
+	}
+
+	invalid := writeTempFile(t, "overlay", "instance_types: {m8.large: {vcpu: 2}}")
+	defer os.Remove(invalid)
+
+	data = bundledInstanceData()
+	if err := applyInstanceCatalogOverlay(data, invalid); err == nil || len(*data) != 1 {
+		t.Errorf("applyInstanceCatalogOverlay() applied an invalid overlay, error = %v", err)
+	}
+}
@@ -257,8 +257,9 @@ func (i *instance) getlaunchTemplate(id, ver *string) (*ec2.ResponseLaunchTempla
 }
 
 func (i *instance) processLaunchTemplate(retval *ec2.RequestLaunchTemplateData) error {
-	ver := i.asg.LaunchTemplate.Version
-	id := i.asg.LaunchTemplate.LaunchTemplateId
+	lt := i.asg.launchTemplateSpecification()
+	ver := lt.Version
+	id := lt.LaunchTemplateId
 
 	ltData, err := i.getlaunchTemplate(id, ver)
 	if err != nil {
@@ -432,7 +433,7 @@ func (i *instance) createLaunchTemplateData() (*ec2.RequestLaunchTemplateData, e
 
 	i.processImageBlockDevices(&ltData)
 
-	if i.asg.LaunchTemplate != nil {
+	if i.asg.launchTemplateSpecification() != nil {
 		err := i.processLaunchTemplate(&ltData)
 		if err != nil {
 			log.Println("failed to process launch template, the resulting instance configuration may be incomplete", err.Error())
@@ -443,6 +444,8 @@ func (i *instance) createLaunchTemplateData() (*ec2.RequestLaunchTemplateData, e
 		i.processLaunchConfiguration(&ltData)
 	}
 
+	i.swapArchitectureImage(&ltData)
+
 	ltData.EbsOptimized = i.EbsOptimized
 
 	ltData.InstanceMarketOptions = &ec2.LaunchTemplateInstanceMarketOptionsRequest{
@@ -489,23 +492,30 @@ func (i *instance) createFleetLaunchTemplate(ltData *ec2.RequestLaunchTemplateDa
 	return &ltName, err
 }
 
-func (i *instance) createFleetInput(ltName *string, instanceTypes []*string) *ec2.CreateFleetInput {
+func (i *instance) createFleetInput(ltName *string, instanceTypes []*string, subnetIDs []*string) *ec2.CreateFleetInput {
 
 	var overrides []*ec2.FleetLaunchTemplateOverridesRequest
 
 	debug.Printf("instance Details: %+#v\n", i)
 
-	for p, inst := range instanceTypes {
-		override := ec2.FleetLaunchTemplateOverridesRequest{
-			InstanceType: inst,
-			SubnetId:     i.SubnetId,
-		}
-		if i.asg.config.SpotAllocationStrategy == "capacity-optimized-prioritized" {
-			override.Priority = aws.Float64(float64(p))
+	// the subnets are given in the order of preference, each of them getting
+	// the same instance type priorities
+	for s, subnetID := range subnetIDs {
+		for p, inst := range instanceTypes {
+			override := ec2.FleetLaunchTemplateOverridesRequest{
+				InstanceType: inst,
+				SubnetId:     subnetID,
+			}
+			if i.asg.config.SpotAllocationStrategy == "capacity-optimized-prioritized" {
+				override.Priority = aws.Float64(float64(s*len(instanceTypes) + p))
+			}
+			overrides = append(overrides, &override)
 		}
-		overrides = append(overrides, &override)
 	}
 
+	overrides = i.diversifyOverrides(overrides)
+	overrides = i.excludeFailedPools(overrides)
+
 	retval := &ec2.CreateFleetInput{
 		LaunchTemplateConfigs: []*ec2.FleetLaunchTemplateConfigRequest{
 			{
@@ -548,14 +558,14 @@ func (i *instance) generateTagsList() []*ec2.LaunchTemplateTagSpecificationReque
 		},
 	}
 
-	if i.asg.LaunchTemplate != nil {
+	if lt := i.asg.launchTemplateSpecification(); lt != nil {
 		tags.Tags = append(tags.Tags, &ec2.Tag{
 			Key:   aws.String("LaunchTemplateID"),
-			Value: i.asg.LaunchTemplate.LaunchTemplateId,
+			Value: lt.LaunchTemplateId,
 		})
 		tags.Tags = append(tags.Tags, &ec2.Tag{
 			Key:   aws.String("LaunchTemplateVersion"),
-			Value: i.asg.LaunchTemplate.Version,
+			Value: lt.Version,
 		})
 	} else if i.asg.LaunchConfigurationName != nil {
 		tags.Tags = append(tags.Tags, &ec2.Tag{
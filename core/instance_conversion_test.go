@@ -1525,6 +1525,7 @@ func Test_instance_createFleetInput(t *testing.T) {
 		i             *instance
 		ltName        *string
 		instanceTypes []*string
+		subnetIDs     []*string
 		want          *ec2.CreateFleetInput
 	}{
 		{
@@ -1635,12 +1636,80 @@ func Test_instance_createFleetInput(t *testing.T) {
 				Type: aws.String("instant"),
 			},
 		},
+		{
+			name:   "test generating list of overrides for several subnets",
+			ltName: aws.String("testLT"),
+			instanceTypes: []*string{
+				aws.String("instance-type1"),
+				aws.String("instance-type2"),
+			},
+			subnetIDs: []*string{
+				aws.String("subnet-id"),
+				aws.String("subnet-id2"),
+			},
+			i: &instance{
+				Instance: &ec2.Instance{
+					SubnetId: aws.String("subnet-id"),
+				},
+				asg: &autoScalingGroup{
+					config: AutoScalingConfig{
+						SpotAllocationStrategy: "capacity-optimized-prioritized",
+					},
+				},
+				region: &region{},
+			},
+			want: &ec2.CreateFleetInput{
+				LaunchTemplateConfigs: []*ec2.FleetLaunchTemplateConfigRequest{
+					{
+						LaunchTemplateSpecification: &ec2.FleetLaunchTemplateSpecificationRequest{
+							LaunchTemplateName: aws.String("testLT"),
+							Version:            aws.String("$Latest"),
+						},
+						Overrides: []*ec2.FleetLaunchTemplateOverridesRequest{
+							{
+								InstanceType: aws.String("instance-type1"),
+								Priority:     aws.Float64(0),
+								SubnetId:     aws.String("subnet-id"),
+							},
+							{
+								InstanceType: aws.String("instance-type2"),
+								Priority:     aws.Float64(1),
+								SubnetId:     aws.String("subnet-id"),
+							},
+							{
+								InstanceType: aws.String("instance-type1"),
+								Priority:     aws.Float64(2),
+								SubnetId:     aws.String("subnet-id2"),
+							},
+							{
+								InstanceType: aws.String("instance-type2"),
+								Priority:     aws.Float64(3),
+								SubnetId:     aws.String("subnet-id2"),
+							},
+						},
+					},
+				},
+				SpotOptions: &ec2.SpotOptionsRequest{
+					AllocationStrategy: aws.String("capacity-optimized-prioritized"),
+				},
+				TargetCapacitySpecification: &ec2.TargetCapacitySpecificationRequest{
+					DefaultTargetCapacityType: aws.String("spot"),
+					SpotTargetCapacity:        aws.Int64(1),
+					TotalTargetCapacity:       aws.Int64(1),
+				},
+				Type: aws.String("instant"),
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			got := tt.i.createFleetInput(tt.ltName, tt.instanceTypes)
+			subnetIDs := tt.subnetIDs
+			if subnetIDs == nil {
+				subnetIDs = []*string{tt.i.SubnetId}
+			}
+			got := tt.i.createFleetInput(tt.ltName, tt.instanceTypes, subnetIDs)
 
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("instance.createFleetInput() = %v, want %v", got, tt.want)
@@ -31,6 +31,9 @@ type instances interface {
 type acceptableInstance struct {
 	instanceTI instanceTypeInformation
 	price      float64
+
+	// the price adjusted with the estimated cost of interruptions
+	expectedCost float64
 }
 
 type instanceTypeInformation struct {
@@ -47,6 +50,13 @@ type instanceTypeInformation struct {
 	instanceStoreIsSSD       bool
 	hasEBSOptimization       bool
 	EBSThroughput            float32
+	networkPerformance       string
+
+	// hardware specs from the DescribeInstanceTypes API, nil if not available
+	specs *instanceTypeSpecs
+
+	// GPUs or other accelerators, nil if the instance type has none
+	accelerator *accelerator
 }
 
 func makeInstances() instances {
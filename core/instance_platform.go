@@ -0,0 +1,146 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// instance_platform.go contains the network and platform compatibility checks
+// of the Spot candidates, which compare the hardware specs returned by the
+// DescribeInstanceTypes API with the attributes of the AMI and the network
+// interfaces configured in the launch template.
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// The interface type of the Elastic Fabric Adapter network interfaces
+	networkInterfaceTypeEFA = "efa"
+
+	// The boot mode of the AMIs booting with UEFI on the instance types
+	// supporting it and with legacy BIOS on the others, missing from the SDK
+	bootModeUefiPreferred = "uefi-preferred"
+)
+
+// imageEnaSupport returns whether the AMI of the group supports ENA, using the
+// launch template image if available and otherwise the current instance, which
+// inherited this attribute from its AMI.
+func (i *instance) imageEnaSupport() bool {
+	if i.asg != nil && i.asg.launchTemplate != nil && i.asg.launchTemplate.Image != nil &&
+		i.asg.launchTemplate.Image.EnaSupport != nil {
+		return *i.asg.launchTemplate.Image.EnaSupport
+	}
+	return aws.BoolValue(i.EnaSupport)
+}
+
+// imageBootMode returns the boot mode of the AMI of the group, or an empty
+// string if unknown.
+func (i *instance) imageBootMode() string {
+	if i.asg != nil && i.asg.launchTemplate != nil && i.asg.launchTemplate.Image != nil &&
+		i.asg.launchTemplate.Image.BootMode != nil {
+		return *i.asg.launchTemplate.Image.BootMode
+	}
+	return aws.StringValue(i.BootMode)
+}
+
+// bootModeSupported returns whether an instance type supporting the given boot
+// modes can boot an AMI with the given boot mode.
+func bootModeSupported(bootMode string, supported []string) bool {
+	if bootMode == bootModeUefiPreferred {
+		return itemInSlice(ec2.BootModeValuesUefi, supported) ||
+			itemInSlice(ec2.BootModeValuesLegacyBios, supported)
+	}
+	return itemInSlice(bootMode, supported)
+}
+
+// launchTemplateNetworkInterfaces returns the network interfaces configured in
+// the launch template of the group.
+func (a *autoScalingGroup) launchTemplateNetworkInterfaces() []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification {
+	if a == nil || a.launchTemplate == nil || a.launchTemplate.LaunchTemplateVersion == nil ||
+		a.launchTemplate.LaunchTemplateData == nil {
+		return nil
+	}
+	return a.launchTemplate.LaunchTemplateData.NetworkInterfaces
+}
+
+// launchTemplateIPv4Addresses returns the largest number of private IPv4
+// addresses configured on a network interface of the launch template.
+func (a *autoScalingGroup) launchTemplateIPv4Addresses() int64 {
+	var result int64
+	for _, ni := range a.launchTemplateNetworkInterfaces() {
+		count := 1 + aws.Int64Value(ni.SecondaryPrivateIpAddressCount)
+		if n := int64(len(ni.PrivateIpAddresses)); n > count {
+			count = n
+		}
+		if count > result {
+			result = count
+		}
+	}
+	return result
+}
+
+func (a *autoScalingGroup) requiresEFA() bool {
+	for _, ni := range a.launchTemplateNetworkInterfaces() {
+		if aws.StringValue(ni.InterfaceType) == networkInterfaceTypeEFA {
+			return true
+		}
+	}
+	return false
+}
+
+// isPlatformCompatible checks that the Spot candidate can boot the AMI, attach
+// the network interfaces of the group with their addresses and expose the EBS
+// volumes like the current instance type, and that it doesn't have a lower
+// network performance than the current instance type. The checks based on the
+// hardware specs are skipped when they're not available.
+func (i *instance) isPlatformCompatible(spotCandidate *instanceTypeInformation) bool {
+	current := i.typeInfo
+
+	currentBandwidth := networkBandwidth(current.networkPerformance)
+	candidateBandwidth := networkBandwidth(spotCandidate.networkPerformance)
+
+	if candidateBandwidth < currentBandwidth && candidateBandwidth > 0 {
+		debug.Println("\tNetwork performance insufficient:", spotCandidate.networkPerformance,
+			"<", current.networkPerformance)
+		return false
+	}
+
+	specs := spotCandidate.specs
+	if specs == nil {
+		return true
+	}
+
+	bootMode := i.imageBootMode()
+	networkInterfaces := int64(len(i.asg.launchTemplateNetworkInterfaces()))
+	ipv4Addresses := i.asg.launchTemplateIPv4Addresses()
+	currentNVMe := ""
+	if current.specs != nil {
+		currentNVMe = current.specs.nvmeSupport
+	}
+
+	checks := []struct {
+		failed bool
+		reason string
+	}{
+		{specs.enaSupport == ec2.EnaSupportRequired && !i.imageEnaSupport(),
+			"ENA required but not supported by the AMI"},
+		{specs.maxENIs > 0 && networkInterfaces > specs.maxENIs,
+			"not enough network interfaces"},
+		{i.asg.requiresEFA() && !specs.efaSupported,
+			"EFA not supported"},
+		{specs.ipv4PerENI > 0 && ipv4Addresses > specs.ipv4PerENI,
+			"not enough IPv4 addresses per network interface"},
+		{specs.nvmeSupport == ec2.EbsNvmeSupportRequired && currentNVMe == ec2.EbsNvmeSupportUnsupported,
+			"EBS volumes exposed as NVMe devices, unlike on the current instance type"},
+		{bootMode != "" && len(specs.bootModes) > 0 && !bootModeSupported(bootMode, specs.bootModes),
+			"boot mode " + bootMode + " not supported"},
+	}
+
+	for _, c := range checks {
+		if c.failed {
+			debug.Println("\tNot platform compatible:", c.reason)
+			return false
+		}
+	}
+	return true
+}
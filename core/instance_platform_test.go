@@ -0,0 +1,167 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_instance_isPlatformCompatible(t *testing.T) {
+	efaTemplate := &launchTemplate{
+		LaunchTemplateVersion: &ec2.LaunchTemplateVersion{
+			LaunchTemplateData: &ec2.ResponseLaunchTemplateData{
+				NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification{
+					{DeviceIndex: aws.Int64(0), InterfaceType: aws.String("efa")},
+					{DeviceIndex: aws.Int64(1)},
+					{DeviceIndex: aws.Int64(2)},
+				},
+			},
+		},
+	}
+	secondaryIPsTemplate := &launchTemplate{
+		LaunchTemplateVersion: &ec2.LaunchTemplateVersion{
+			LaunchTemplateData: &ec2.ResponseLaunchTemplateData{
+				NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification{
+					{DeviceIndex: aws.Int64(0), SecondaryPrivateIpAddressCount: aws.Int64(9)},
+				},
+			},
+		},
+	}
+	uefiImage := &launchTemplate{
+		Image: &ec2.Image{BootMode: aws.String(ec2.BootModeValuesUefi), EnaSupport: aws.Bool(true)},
+	}
+
+	tests := []struct {
+		name       string
+		current    instanceTypeInformation
+		enaSupport *bool
+		bootMode   *string
+		lt         *launchTemplate
+		candidate  instanceTypeInformation
+		want       bool
+	}{
+		{
+			name:      "no specs, same network performance",
+			current:   instanceTypeInformation{networkPerformance: "Up to 10 Gigabit"},
+			candidate: instanceTypeInformation{networkPerformance: "10 Gigabit"},
+			want:      true,
+		},
+		{
+			name:      "lower network performance",
+			current:   instanceTypeInformation{networkPerformance: "25 Gigabit"},
+			candidate: instanceTypeInformation{networkPerformance: "Up to 10 Gigabit"},
+			want:      false,
+		},
+		{
+			name:      "unknown network performance",
+			current:   instanceTypeInformation{networkPerformance: "25 Gigabit"},
+			candidate: instanceTypeInformation{networkPerformance: "Moderate"},
+			want:      true,
+		},
+		{
+			name:       "ENA required and supported by the AMI",
+			enaSupport: aws.Bool(true),
+			candidate:  instanceTypeInformation{specs: &instanceTypeSpecs{enaSupport: "required"}},
+			want:       true,
+		},
+		{
+			name:      "ENA required but not supported by the AMI",
+			candidate: instanceTypeInformation{specs: &instanceTypeSpecs{enaSupport: "required"}},
+			want:      false,
+		},
+		{
+			name:      "ENA support taken from the launch template image",
+			lt:        uefiImage,
+			candidate: instanceTypeInformation{specs: &instanceTypeSpecs{enaSupport: "required"}},
+			want:      true,
+		},
+		{
+			name:       "ENA enabled AMI on an instance type without ENA",
+			enaSupport: aws.Bool(true),
+			candidate:  instanceTypeInformation{specs: &instanceTypeSpecs{enaSupport: "unsupported"}},
+			want:       true,
+		},
+		{
+			name:      "enough network interfaces and EFA",
+			lt:        efaTemplate,
+			candidate: instanceTypeInformation{specs: &instanceTypeSpecs{maxENIs: 4, efaSupported: true}},
+			want:      true,
+		},
+		{
+			name:      "not enough network interfaces",
+			lt:        efaTemplate,
+			candidate: instanceTypeInformation{specs: &instanceTypeSpecs{maxENIs: 2, efaSupported: true}},
+			want:      false,
+		},
+		{
+			name:      "EFA not supported",
+			lt:        efaTemplate,
+			candidate: instanceTypeInformation{specs: &instanceTypeSpecs{maxENIs: 4}},
+			want:      false,
+		},
+		{
+			name:      "enough IPv4 addresses per network interface",
+			lt:        secondaryIPsTemplate,
+			candidate: instanceTypeInformation{specs: &instanceTypeSpecs{ipv4PerENI: 10}},
+			want:      true,
+		},
+		{
+			name:      "not enough IPv4 addresses per network interface",
+			lt:        secondaryIPsTemplate,
+			candidate: instanceTypeInformation{specs: &instanceTypeSpecs{ipv4PerENI: 4}},
+			want:      false,
+		},
+		{
+			name:      "NVMe required on a current instance type without NVMe",
+			current:   instanceTypeInformation{specs: &instanceTypeSpecs{nvmeSupport: "unsupported"}},
+			candidate: instanceTypeInformation{specs: &instanceTypeSpecs{nvmeSupport: "required"}},
+			want:      false,
+		},
+		{
+			name:      "NVMe required on a current instance type with NVMe",
+			current:   instanceTypeInformation{specs: &instanceTypeSpecs{nvmeSupport: "required"}},
+			candidate: instanceTypeInformation{specs: &instanceTypeSpecs{nvmeSupport: "required"}},
+			want:      true,
+		},
+		{
+			name:      "UEFI AMI on a legacy BIOS instance type",
+			lt:        uefiImage,
+			candidate: instanceTypeInformation{specs: &instanceTypeSpecs{bootModes: []string{"legacy-bios"}}},
+			want:      false,
+		},
+		{
+			name:      "legacy BIOS instance on an instance type supporting both",
+			bootMode:  aws.String("legacy-bios"),
+			candidate: instanceTypeInformation{specs: &instanceTypeSpecs{bootModes: []string{"legacy-bios", "uefi"}}},
+			want:      true,
+		},
+		{
+			name:      "UEFI preferred AMI on a legacy BIOS instance type",
+			bootMode:  aws.String("uefi-preferred"),
+			candidate: instanceTypeInformation{specs: &instanceTypeSpecs{bootModes: []string{"legacy-bios"}}},
+			want:      true,
+		},
+		{
+			name:      "UEFI preferred AMI on a UEFI instance type",
+			bootMode:  aws.String("uefi-preferred"),
+			candidate: instanceTypeInformation{specs: &instanceTypeSpecs{bootModes: []string{"uefi"}}},
+			want:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{EnaSupport: tt.enaSupport, BootMode: tt.bootMode},
+				typeInfo: tt.current,
+				asg:      &autoScalingGroup{launchTemplate: tt.lt},
+			}
+			if got := i.isPlatformCompatible(&tt.candidate); got != tt.want {
+				t.Errorf("isPlatformCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -97,6 +97,7 @@ func (i *instance) canTerminate() bool {
 func (i *instance) shouldBeReplacedWithSpot() bool {
 	protT, _ := i.isProtectedFromTermination()
 	return i.belongsToEnabledASG() &&
+		!i.asg.config.Evacuate &&
 		i.asgNeedsReplacement() &&
 		!i.isSpot() &&
 		!i.isProtectedFromScaleIn() &&
@@ -120,6 +121,9 @@ func (i *instance) belongsToEnabledASG() bool {
 			asg.loadLaunchConfiguration()
 			asg.loadLaunchTemplate()
 			i.asg = &asg
+			if ti, found := i.asg.instanceTypeInformation()[i.typeInfo.instanceType]; found {
+				i.typeInfo = ti
+			}
 			i.price = i.typeInfo.pricing.onDemand / i.region.conf.OnDemandPriceMultiplier * i.asg.config.OnDemandPriceMultiplier
 			log.Printf("%s instace %s belongs to enabled ASG %s", i.region.name,
 				*i.InstanceId, i.asg.name)
@@ -175,10 +179,11 @@ func (i *instance) isClassCompatible(spotCandidate *instanceTypeInformation) boo
 	debug.Println("\tInstance CPU/memory/GPU: ", current.vCPU,
 		" / ", current.memory, " / ", current.GPU)
 
-	if i.isSameArch(spotCandidate) &&
+	if i.isArchCompatible(spotCandidate) &&
 		spotCandidate.vCPU >= current.vCPU &&
 		spotCandidate.memory >= current.memory &&
-		spotCandidate.GPU >= current.GPU {
+		spotCandidate.GPU >= current.GPU &&
+		i.isAcceleratorCompatible(spotCandidate) {
 		return true
 	}
 	debug.Println("\tNot class compatible (CPU/memory/GPU)")
@@ -189,8 +194,13 @@ func (i *instance) isSameArch(other *instanceTypeInformation) bool {
 	thisCPU := i.typeInfo.PhysicalProcessor
 	otherCPU := other.PhysicalProcessor
 
-	ret := (isIntelCompatible(thisCPU) && isIntelCompatible(otherCPU)) ||
-		(isARM(thisCPU) && isARM(otherCPU))
+	var ret bool
+	if i.typeInfo.specs != nil && other.specs != nil {
+		ret = sharesArchitecture(i.typeInfo.specs.architectures, other.specs.architectures)
+	} else {
+		ret = (isIntelCompatible(thisCPU) && isIntelCompatible(otherCPU)) ||
+			(isARM(thisCPU) && isARM(otherCPU))
+	}
 
 	if !ret {
 		debug.Println("\tInstance CPU architecture mismatch, current CPU architecture",
@@ -199,6 +209,15 @@ func (i *instance) isSameArch(other *instanceTypeInformation) bool {
 	return ret
 }
 
+func sharesArchitecture(these, others []string) bool {
+	for _, arch := range these {
+		if itemInSlice(arch, others) {
+			return true
+		}
+	}
+	return false
+}
+
 func isIntelCompatible(cpuName string) bool {
 	return isIntel(cpuName) || isAMD(cpuName)
 }
@@ -302,7 +321,7 @@ func (i *instance) isAllowed(instanceType string, allowedList []string, disallow
 func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allowedList []string,
 	disallowedList []string) ([]*string, error) {
 	current := i.typeInfo
-	var acceptableInstanceTypes []acceptableInstance
+	var acceptableInstanceTypes []scoringCandidate
 
 	// Count the ephemeral volumes attached to the original instance's block
 	// device mappings, this number is used later when comparing with each
@@ -313,9 +332,14 @@ func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allo
 	usedMappings := max(lcMappings, ltMappings)
 	attachedVolumesNumber := min(usedMappings, current.instanceStoreDeviceCount)
 
+	typeInformation := i.region.instanceTypeInformation
+	if i.asg != nil && i.asg.region != nil {
+		typeInformation = i.region.instanceTypeInformationForOS(i.asg.operatingSystem())
+	}
+
 	// Iterate alphabetically by instance type
 	keys := make([]string, 0)
-	for k := range i.region.instanceTypeInformation {
+	for k := range typeInformation {
 		keys = append(keys, k)
 	}
 
@@ -325,27 +349,37 @@ func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allo
 
 	sort.Strings(keys)
 
+	observedInterruptions := i.region.observedInterruptions()
+	launchZones := i.launchZones()
+
 	// Find all compatible and not blocked instance types
 	for _, k := range keys {
-		candidate := i.region.instanceTypeInformation[k]
+		candidate := typeInformation[k]
 
 		candidatePrice := i.calculatePrice(candidate)
 		debug.Println("Comparing current type", current.instanceType, "with price", i.price,
 			"with candidate", candidate.instanceType, "with price", candidatePrice)
 
 		if i.isAllowed(candidate.instanceType, allowedList, disallowedList) && i.isCompatible(&candidate, candidatePrice, attachedVolumesNumber) {
-			acceptableInstanceTypes = append(acceptableInstanceTypes, acceptableInstance{candidate, candidatePrice})
-			log.Println("\tMATCH FOUND, added", candidate.instanceType, "to launch candidates list for instance", *i.InstanceId)
+			interruptionRate := i.estimatedInterruptionRate(candidate.instanceType, launchZones, observedInterruptions)
+			acceptableInstanceTypes = append(acceptableInstanceTypes, scoringCandidate{
+				acceptableInstance: acceptableInstance{
+					instanceTI:   candidate,
+					price:        candidatePrice,
+					expectedCost: expectedCost(candidatePrice, interruptionRate),
+				},
+				interruptionRate: interruptionRate,
+			})
+			log.Println("\tMATCH FOUND, added", candidate.instanceType, "to launch candidates list for instance", *i.InstanceId,
+				"estimated interruption rate", interruptionRate, "percent")
 		} else if candidate.instanceType != "" {
 			debug.Println("Non compatible option found:", candidate.instanceType, "at", candidatePrice, " - discarding")
 		}
 	}
 
 	if acceptableInstanceTypes != nil {
-		sort.Slice(acceptableInstanceTypes, func(i, j int) bool {
-			return acceptableInstanceTypes[i].price < acceptableInstanceTypes[j].price
-		})
-		debug.Println("List of cheapest compatible spot instances found, sorted ascending by price: ",
+		acceptableInstanceTypes = i.rankCandidates(acceptableInstanceTypes)
+		debug.Println("List of compatible spot instances found, sorted by the scoring strategy: ",
 			acceptableInstanceTypes)
 		var result []*string
 		for _, ai := range acceptableInstanceTypes {
@@ -362,7 +396,10 @@ func (i *instance) isCompatible(candidate *instanceTypeInformation, candidatePri
 		i.isEBSCompatible(candidate) &&
 		i.isClassCompatible(candidate) &&
 		i.isStorageCompatible(candidate, attachedVolumesNumber) &&
-		i.isVirtualizationCompatible(candidate.virtualizationTypes)
+		i.isVirtualizationCompatible(candidate.virtualizationTypes) &&
+		i.isPlatformCompatible(candidate) &&
+		i.isInterruptionRateCompatible(candidate) &&
+		i.isInstanceRequirementsCompatible(candidate)
 }
 
 func (i *instance) getReplacementTargetInstanceID() *string {
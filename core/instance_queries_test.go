@@ -566,6 +566,90 @@ func Test_getCompatibleSpotInstanceTypesListSortedAscendingByPrice(t *testing.T)
 			expectedCandidateList: []string{"type1", "type2"},
 			expectedError:         nil,
 		},
+		{name: "cheaper but more frequently interrupted spot instance demoted or excluded",
+			spotInfos: map[string]instanceTypeInformation{
+				"1": {
+					instanceType: "type1", // cheapest, but interrupted frequently
+					pricing: prices{
+						spot: map[string]float64{
+							"eu-central-1": 0.5,
+						},
+					},
+					vCPU:                10,
+					PhysicalProcessor:   "Intel",
+					memory:              2.5,
+					virtualizationTypes: []string{"PV", "else"},
+				},
+				"2": {
+					instanceType: "type2", // less cheap, but rarely interrupted
+					pricing: prices{
+						spot: map[string]float64{
+							"eu-central-1": 0.55,
+						},
+					},
+					vCPU:                10,
+					PhysicalProcessor:   "Intel",
+					memory:              2.5,
+					virtualizationTypes: []string{"PV", "else"},
+				},
+				"3": {
+					instanceType: "type3", // more expensive, interrupted often enough to be excluded
+					pricing: prices{
+						spot: map[string]float64{
+							"eu-central-1": 0.6,
+						},
+					},
+					vCPU:                10,
+					PhysicalProcessor:   "Intel",
+					memory:              2.5,
+					virtualizationTypes: []string{"PV", "else"},
+				},
+			},
+			instanceInfo: &instance{
+				Instance: &ec2.Instance{
+					InstanceId:         aws.String("i-dummy"),
+					VirtualizationType: aws.String("paravirtual"),
+					Placement: &ec2.Placement{
+						AvailabilityZone: aws.String("eu-central-1"),
+					},
+				},
+				typeInfo: instanceTypeInformation{
+					instanceType:      "typeX",
+					PhysicalProcessor: "Intel",
+					vCPU:              10,
+					memory:            2.5,
+				},
+				price: 0.75,
+				region: &region{
+					name: "eu-central",
+					conf: &Config{
+						spotAdvisor: &spotAdvisorData{
+							SpotAdvisor: map[string]map[string]map[string]spotAdvisorEntry{
+								"eu-central": {
+									"Linux": {
+										"type1": {Interruptions: 3},
+										"type2": {Interruptions: 0},
+										"type3": {Interruptions: 4},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			asg: &autoScalingGroup{
+				name:      "test-asg",
+				instances: makeInstances(),
+				Group: &autoscaling.Group{
+					DesiredCapacity: aws.Int64(4),
+				},
+				config: AutoScalingConfig{
+					MaxInterruptionRate: 20,
+				},
+			},
+			expectedCandidateList: []string{"type2", "type1"},
+			expectedError:         nil,
+		},
 		{name: "better/cheaper spot instance found but marked as disallowed",
 			spotInfos: map[string]instanceTypeInformation{
 				"1": {
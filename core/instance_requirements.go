@@ -0,0 +1,301 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// instance_requirements.go contains the logic for the attribute-based instance
+// type selection, configured using tags similar to the EC2 InstanceRequirements.
+
+import (
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// VCPUMinTag and VCPUMaxTag limit the number of vCPUs of the Spot instance types
+	VCPUMinTag = "autospotting_vcpu_min"
+	VCPUMaxTag = "autospotting_vcpu_max"
+
+	// MemoryMinTag and MemoryMaxTag limit the memory of the Spot instance types, in GiB
+	MemoryMinTag = "autospotting_memory_min"
+	MemoryMaxTag = "autospotting_memory_max"
+
+	// MemoryPerVCPUMinTag and MemoryPerVCPUMaxTag limit the memory per vCPU
+	// ratio of the Spot instance types, in GiB
+	MemoryPerVCPUMinTag = "autospotting_memory_per_vcpu_min"
+	MemoryPerVCPUMaxTag = "autospotting_memory_per_vcpu_max"
+
+	// CPUManufacturersTag is a comma separated list of the allowed CPU
+	// manufacturers, which can be "intel", "amd" and "amazon-web-services"
+	CPUManufacturersTag = "autospotting_cpu_manufacturers"
+
+	// InstanceGenerationMinTag is the minimum generation of the Spot instance
+	// types, for example 5 allows m5.large but not m4.large
+	InstanceGenerationMinTag = "autospotting_instance_generation_min"
+
+	// BurstablePerformanceTag controls the use of burstable instance types, it
+	// can be "included", "excluded" or "required"
+	BurstablePerformanceTag = "autospotting_burstable_performance"
+
+	// BareMetalTag controls the use of bare metal instance types, it can be
+	// "included", "excluded" or "required"
+	BareMetalTag = "autospotting_bare_metal"
+
+	// NetworkBandwidthMinTag is the minimum network bandwidth of the Spot
+	// instance types, in Gbps
+	NetworkBandwidthMinTag = "autospotting_network_bandwidth_min"
+
+	// The values accepted by the BurstablePerformanceTag and BareMetalTag
+	requirementIncluded = "included"
+	requirementExcluded = "excluded"
+	requirementRequired = "required"
+
+	// The CPU manufacturer names accepted by the CPUManufacturersTag
+	cpuManufacturerIntel = "intel"
+	cpuManufacturerAMD   = "amd"
+	cpuManufacturerAWS   = "amazon-web-services"
+)
+
+// InstanceRequirements contains the attribute-based constraints of the Spot
+// instance types, the zero values mean there is no constraint.
+type InstanceRequirements struct {
+	VCPUMin, VCPUMax                   int
+	MemoryMin, MemoryMax               float64
+	MemoryPerVCPUMin, MemoryPerVCPUMax float64
+	CPUManufacturers                   []string
+	InstanceGenerationMin              int
+	BurstablePerformance               string
+	BareMetal                          string
+	NetworkBandwidthMin                float64
+}
+
+var (
+	instanceGenerationRegexp = regexp.MustCompile(`^[a-z]+(\d+)`)
+	networkBandwidthRegexp   = regexp.MustCompile(`([\d.]+) Gigabit`)
+	burstableRegexp          = regexp.MustCompile(`^t\d`)
+)
+
+// instanceGeneration extracts the generation number from the instance type
+// name, for example 5 for m5.large or c5n.large, or 0 if it can't be determined.
+func instanceGeneration(instanceType string) int {
+	m := instanceGenerationRegexp.FindStringSubmatch(instanceType)
+	if m == nil {
+		return 0
+	}
+	gen, _ := strconv.Atoi(m[1])
+	return gen
+}
+
+func isBurstable(instanceType string) bool {
+	return burstableRegexp.MatchString(instanceType)
+}
+
+func isBareMetal(instanceType string) bool {
+	return strings.Contains(instanceType, "metal")
+}
+
+// networkBandwidth returns the network bandwidth in Gbps from the network
+// performance description, such as "Up to 10 Gigabit", or 0 for the vague
+// descriptions like "Moderate".
+func networkBandwidth(networkPerformance string) float64 {
+	m := networkBandwidthRegexp.FindStringSubmatch(networkPerformance)
+	if m == nil {
+		return 0
+	}
+	bw, _ := strconv.ParseFloat(m[1], 64)
+	return bw
+}
+
+// isBurstable, isBareMetal and cpuManufacturer use the hardware specs from the
+// DescribeInstanceTypes API when available, falling back to heuristics based
+// on the instance type and processor names.
+func (it *instanceTypeInformation) isBurstable() bool {
+	if it.specs != nil {
+		return it.specs.burstable
+	}
+	return isBurstable(it.instanceType)
+}
+
+func (it *instanceTypeInformation) isBareMetal() bool {
+	if it.specs != nil {
+		return it.specs.bareMetal
+	}
+	return isBareMetal(it.instanceType)
+}
+
+func (it *instanceTypeInformation) cpuManufacturer() string {
+	if it.specs != nil && itemInSlice(ec2.ArchitectureTypeArm64, it.specs.architectures) {
+		return cpuManufacturerAWS
+	}
+	return cpuManufacturer(it.PhysicalProcessor)
+}
+
+func cpuManufacturer(cpuName string) string {
+	switch {
+	case isAMD(cpuName):
+		return cpuManufacturerAMD
+	case isARM(cpuName):
+		return cpuManufacturerAWS
+	case isIntel(cpuName):
+		return cpuManufacturerIntel
+	}
+	return ""
+}
+
+// matchesRequirement evaluates the included/excluded/required requirements.
+func matchesRequirement(requirement string, value bool) bool {
+	switch requirement {
+	case requirementExcluded:
+		return !value
+	case requirementRequired:
+		return value
+	}
+	return true
+}
+
+func (a *autoScalingGroup) loadIntRequirement(tag string, dest *int) bool {
+	tagValue := a.getTagValue(tag)
+	if tagValue == nil {
+		return false
+	}
+
+	val, err := strconv.Atoi(*tagValue)
+	if err != nil || val < 0 {
+		log.Printf("Ignoring invalid value %v of tag %v on the group %s\n", *tagValue, tag, a.name)
+		return false
+	}
+	*dest = val
+	return true
+}
+
+func (a *autoScalingGroup) loadFloatRequirement(tag string, dest *float64) bool {
+	tagValue := a.getTagValue(tag)
+	if tagValue == nil {
+		return false
+	}
+
+	val, err := strconv.ParseFloat(*tagValue, 64)
+	if err != nil || val < 0 {
+		log.Printf("Ignoring invalid value %v of tag %v on the group %s\n", *tagValue, tag, a.name)
+		return false
+	}
+	*dest = val
+	return true
+}
+
+func (a *autoScalingGroup) loadInclusionRequirement(tag string, dest *string) bool {
+	tagValue := a.getTagValue(tag)
+	if tagValue == nil {
+		return false
+	}
+
+	switch *tagValue {
+	case requirementIncluded, requirementExcluded, requirementRequired:
+		*dest = *tagValue
+		return true
+	}
+	log.Printf("Ignoring invalid value %v of tag %v on the group %s\n", *tagValue, tag, a.name)
+	return false
+}
+
+// loadInstanceRequirements loads the attribute-based constraints of the Spot
+// instance types from the group tags.
+func (a *autoScalingGroup) loadInstanceRequirements() bool {
+	var req InstanceRequirements
+	found := false
+
+	for tag, dest := range map[string]*int{
+		VCPUMinTag:               &req.VCPUMin,
+		VCPUMaxTag:               &req.VCPUMax,
+		InstanceGenerationMinTag: &req.InstanceGenerationMin,
+	} {
+		found = a.loadIntRequirement(tag, dest) || found
+	}
+
+	for tag, dest := range map[string]*float64{
+		MemoryMinTag:           &req.MemoryMin,
+		MemoryMaxTag:           &req.MemoryMax,
+		MemoryPerVCPUMinTag:    &req.MemoryPerVCPUMin,
+		MemoryPerVCPUMaxTag:    &req.MemoryPerVCPUMax,
+		NetworkBandwidthMinTag: &req.NetworkBandwidthMin,
+	} {
+		found = a.loadFloatRequirement(tag, dest) || found
+	}
+
+	for tag, dest := range map[string]*string{
+		BurstablePerformanceTag: &req.BurstablePerformance,
+		BareMetalTag:            &req.BareMetal,
+	} {
+		found = a.loadInclusionRequirement(tag, dest) || found
+	}
+
+	if tagValue := a.getTagValue(CPUManufacturersTag); tagValue != nil {
+		for _, m := range strings.FieldsFunc(*tagValue, func(c rune) bool {
+			return c == ',' || c == ' '
+		}) {
+			m = strings.ToLower(m)
+			switch m {
+			case cpuManufacturerIntel, cpuManufacturerAMD, cpuManufacturerAWS:
+				req.CPUManufacturers = append(req.CPUManufacturers, m)
+			default:
+				log.Printf("Ignoring unknown CPU manufacturer %v of tag %v on the group %s\n",
+					m, CPUManufacturersTag, a.name)
+			}
+		}
+		found = found || len(req.CPUManufacturers) > 0
+	}
+
+	a.config.InstanceRequirements = req
+	return found
+}
+
+// isInstanceRequirementsCompatible evaluates the attribute-based constraints
+// configured on the group against the Spot candidate.
+func (i *instance) isInstanceRequirementsCompatible(spotCandidate *instanceTypeInformation) bool {
+	if i.asg == nil {
+		return true
+	}
+
+	req := i.asg.config.InstanceRequirements
+	it := spotCandidate.instanceType
+	vCPU := spotCandidate.vCPU
+	memory := float64(spotCandidate.memory)
+
+	memoryPerVCPU := 0.0
+	if vCPU > 0 {
+		memoryPerVCPU = memory / float64(vCPU)
+	}
+
+	checks := []struct {
+		failed bool
+		reason string
+	}{
+		{req.VCPUMin > 0 && vCPU < req.VCPUMin, "too few vCPUs"},
+		{req.VCPUMax > 0 && vCPU > req.VCPUMax, "too many vCPUs"},
+		{req.MemoryMin > 0 && memory < req.MemoryMin, "too little memory"},
+		{req.MemoryMax > 0 && memory > req.MemoryMax, "too much memory"},
+		{req.MemoryPerVCPUMin > 0 && memoryPerVCPU < req.MemoryPerVCPUMin, "too little memory per vCPU"},
+		{req.MemoryPerVCPUMax > 0 && memoryPerVCPU > req.MemoryPerVCPUMax, "too much memory per vCPU"},
+		{len(req.CPUManufacturers) > 0 &&
+			!itemInSlice(spotCandidate.cpuManufacturer(), req.CPUManufacturers),
+			"CPU manufacturer not allowed"},
+		{req.InstanceGenerationMin > 0 && instanceGeneration(it) < req.InstanceGenerationMin,
+			"instance generation too old"},
+		{!matchesRequirement(req.BurstablePerformance, spotCandidate.isBurstable()), "burstable performance mismatch"},
+		{!matchesRequirement(req.BareMetal, spotCandidate.isBareMetal()), "bare metal mismatch"},
+		{req.NetworkBandwidthMin > 0 && networkBandwidth(spotCandidate.networkPerformance) < req.NetworkBandwidthMin,
+			"network bandwidth insufficient"},
+	}
+
+	for _, c := range checks {
+		if c.failed {
+			debug.Println("\tInstance requirements not met:", c.reason)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func Test_instanceGeneration(t *testing.T) {
+	tests := []struct {
+		instanceType string
+		want         int
+	}{
+		{instanceType: "m5.large", want: 5},
+		{instanceType: "c5n.18xlarge", want: 5},
+		{instanceType: "m7i.large", want: 7},
+		{instanceType: "x2iedn.xlarge", want: 2},
+		{instanceType: "u-6tb1.metal", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.instanceType, func(t *testing.T) {
+			if got := instanceGeneration(tt.instanceType); got != tt.want {
+				t.Errorf("instanceGeneration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_networkBandwidth(t *testing.T) {
+	tests := []struct {
+		networkPerformance string
+		want               float64
+	}{
+		{networkPerformance: "Up to 10 Gigabit", want: 10},
+		{networkPerformance: "Up to 12.5 Gigabit", want: 12.5},
+		{networkPerformance: "100 Gigabit", want: 100},
+		{networkPerformance: "Moderate", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.networkPerformance, func(t *testing.T) {
+			if got := networkBandwidth(tt.networkPerformance); got != tt.want {
+				t.Errorf("networkBandwidth() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadInstanceRequirements(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []*autoscaling.TagDescription
+		want bool
+		req  InstanceRequirements
+	}{
+		{
+			name: "no tags",
+			want: false,
+		},
+		{
+			name: "all tags",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(VCPUMinTag), Value: aws.String("2")},
+				{Key: aws.String(VCPUMaxTag), Value: aws.String("8")},
+				{Key: aws.String(MemoryMinTag), Value: aws.String("4")},
+				{Key: aws.String(MemoryMaxTag), Value: aws.String("32")},
+				{Key: aws.String(MemoryPerVCPUMinTag), Value: aws.String("2")},
+				{Key: aws.String(MemoryPerVCPUMaxTag), Value: aws.String("4")},
+				{Key: aws.String(CPUManufacturersTag), Value: aws.String("Intel, amd")},
+				{Key: aws.String(InstanceGenerationMinTag), Value: aws.String("5")},
+				{Key: aws.String(BurstablePerformanceTag), Value: aws.String("excluded")},
+				{Key: aws.String(BareMetalTag), Value: aws.String("required")},
+				{Key: aws.String(NetworkBandwidthMinTag), Value: aws.String("12.5")},
+			},
+			want: true,
+			req: InstanceRequirements{
+				VCPUMin:               2,
+				VCPUMax:               8,
+				MemoryMin:             4,
+				MemoryMax:             32,
+				MemoryPerVCPUMin:      2,
+				MemoryPerVCPUMax:      4,
+				CPUManufacturers:      []string{"intel", "amd"},
+				InstanceGenerationMin: 5,
+				BurstablePerformance:  "excluded",
+				BareMetal:             "required",
+				NetworkBandwidthMin:   12.5,
+			},
+		},
+		{
+			name: "invalid values ignored",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(VCPUMinTag), Value: aws.String("two")},
+				{Key: aws.String(MemoryMinTag), Value: aws.String("-1")},
+				{Key: aws.String(CPUManufacturersTag), Value: aws.String("ibm")},
+				{Key: aws.String(BareMetalTag), Value: aws.String("yes")},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{Tags: tt.tags},
+			}
+			if got := a.loadInstanceRequirements(); got != tt.want {
+				t.Errorf("loadInstanceRequirements() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(a.config.InstanceRequirements, tt.req) {
+				t.Errorf("loadInstanceRequirements() loaded %+v, want %+v",
+					a.config.InstanceRequirements, tt.req)
+			}
+		})
+	}
+}
+
+func Test_instance_isInstanceRequirementsCompatible(t *testing.T) {
+	m5large := &instanceTypeInformation{
+		instanceType:       "m5.large",
+		vCPU:               2,
+		memory:             8,
+		PhysicalProcessor:  "Intel Xeon Platinum 8175",
+		networkPerformance: "Up to 10 Gigabit",
+	}
+	t3large := &instanceTypeInformation{
+		instanceType:       "t3.large",
+		vCPU:               2,
+		memory:             8,
+		PhysicalProcessor:  "Intel Skylake E5 2686 v5",
+		networkPerformance: "Up to 5 Gigabit",
+	}
+	m6gmetal := &instanceTypeInformation{
+		instanceType:       "m6g.metal",
+		vCPU:               64,
+		memory:             256,
+		PhysicalProcessor:  "AWS Graviton2 Processor",
+		networkPerformance: "25 Gigabit",
+	}
+	m4large := &instanceTypeInformation{
+		instanceType:       "m4.large",
+		vCPU:               2,
+		memory:             8,
+		PhysicalProcessor:  "Intel Xeon E5-2676 v3",
+		networkPerformance: "Moderate",
+	}
+
+	tests := []struct {
+		name      string
+		req       InstanceRequirements
+		candidate *instanceTypeInformation
+		want      bool
+	}{
+		{name: "no requirements", candidate: m5large, want: true},
+		{name: "vCPU range met", req: InstanceRequirements{VCPUMin: 2, VCPUMax: 4}, candidate: m5large, want: true},
+		{name: "too many vCPUs", req: InstanceRequirements{VCPUMax: 8}, candidate: m6gmetal, want: false},
+		{name: "too little memory", req: InstanceRequirements{MemoryMin: 16}, candidate: m5large, want: false},
+		{name: "too much memory", req: InstanceRequirements{MemoryMax: 64}, candidate: m6gmetal, want: false},
+		{name: "memory per vCPU met", req: InstanceRequirements{MemoryPerVCPUMin: 4, MemoryPerVCPUMax: 4}, candidate: m5large, want: true},
+		{name: "memory per vCPU too low", req: InstanceRequirements{MemoryPerVCPUMin: 8}, candidate: m5large, want: false},
+		{name: "CPU manufacturer allowed", req: InstanceRequirements{CPUManufacturers: []string{"amazon-web-services"}}, candidate: m6gmetal, want: true},
+		{name: "CPU manufacturer not allowed", req: InstanceRequirements{CPUManufacturers: []string{"amd"}}, candidate: m5large, want: false},
+		{name: "generation too old", req: InstanceRequirements{InstanceGenerationMin: 5}, candidate: m4large, want: false},
+		{name: "burstable excluded", req: InstanceRequirements{BurstablePerformance: "excluded"}, candidate: t3large, want: false},
+		{name: "burstable required", req: InstanceRequirements{BurstablePerformance: "required"}, candidate: m5large, want: false},
+		{name: "bare metal excluded", req: InstanceRequirements{BareMetal: "excluded"}, candidate: m6gmetal, want: false},
+		{name: "bare metal included", req: InstanceRequirements{BareMetal: "included"}, candidate: m6gmetal, want: true},
+		{name: "network bandwidth met", req: InstanceRequirements{NetworkBandwidthMin: 10}, candidate: m5large, want: true},
+		{name: "network bandwidth unknown", req: InstanceRequirements{NetworkBandwidthMin: 1}, candidate: m4large, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				asg: &autoScalingGroup{
+					config: AutoScalingConfig{InstanceRequirements: tt.req},
+				},
+			}
+			if got := i.isInstanceRequirementsCompatible(tt.candidate); got != tt.want {
+				t.Errorf("isInstanceRequirementsCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
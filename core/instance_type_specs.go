@@ -0,0 +1,185 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// instance_type_specs.go contains the logic used for enriching the instance
+// type information with the hardware specs returned by the EC2
+// DescribeInstanceTypes API, which are authoritative and also cover the
+// instance types described incompletely by the bundled instance data.
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// instanceTypeSpecsTTL is the amount of time for which the specs returned by
+// the API are cached, they rarely change so we only refresh them daily.
+const instanceTypeSpecsTTL = 24 * time.Hour
+
+// instanceTypeSpecs contains the hardware specs of an instance type returned by
+// the DescribeInstanceTypes API.
+type instanceTypeSpecs struct {
+	vCPU                int
+	memory              float32
+	GPU                 int
+	architectures       []string
+	virtualizationTypes []string
+	enaSupport          string
+	efaSupported        bool
+	maxENIs             int64
+	ipv4PerENI          int64
+	nvmeSupport         string
+	bootModes           []string
+	burstable           bool
+	bareMetal           bool
+	accelerator         *accelerator
+}
+
+type instanceTypeSpecsCacheEntry struct {
+	specs      map[string]*instanceTypeSpecs
+	expiration time.Time
+}
+
+// instanceTypeSpecsCache keeps the specs per region, it's kept across the
+// executions of a warm Lambda function.
+var instanceTypeSpecsCache = struct {
+	sync.Mutex
+	entries map[string]instanceTypeSpecsCacheEntry
+}{entries: make(map[string]instanceTypeSpecsCacheEntry)}
+
+func (r *region) instanceTypeSpecsEnabled() bool {
+	return r.conf != nil && r.conf.EnableDescribeInstanceTypes
+}
+
+// instanceTypeSpecs returns the hardware specs of the instance types available
+// in the region, keyed by the instance type, or nil if they're not enabled or
+// can't be fetched.
+func (r *region) instanceTypeSpecs() map[string]*instanceTypeSpecs {
+	if !r.instanceTypeSpecsEnabled() {
+		return nil
+	}
+
+	now := time.Now()
+
+	instanceTypeSpecsCache.Lock()
+	entry, found := instanceTypeSpecsCache.entries[r.name]
+	instanceTypeSpecsCache.Unlock()
+
+	if found && now.Before(entry.expiration) {
+		return entry.specs
+	}
+
+	specs := make(map[string]*instanceTypeSpecs)
+
+	err := r.services.ec2.DescribeInstanceTypesPages(
+		&ec2.DescribeInstanceTypesInput{},
+		func(page *ec2.DescribeInstanceTypesOutput, lastPage bool) bool {
+			for _, it := range page.InstanceTypes {
+				if it.InstanceType != nil {
+					specs[*it.InstanceType] = newInstanceTypeSpecs(it)
+				}
+			}
+			return true
+		})
+
+	if err != nil {
+		log.Println(r.name, "Failed to describe the instance types, using the bundled data:", err.Error())
+		// keep using the expired specs if we have them
+		return entry.specs
+	}
+
+	debug.Println(r.name, "Loaded the specs of", len(specs), "instance types")
+
+	instanceTypeSpecsCache.Lock()
+	instanceTypeSpecsCache.entries[r.name] = instanceTypeSpecsCacheEntry{
+		specs:      specs,
+		expiration: now.Add(instanceTypeSpecsTTL),
+	}
+	instanceTypeSpecsCache.Unlock()
+
+	return specs
+}
+
+func newInstanceTypeSpecs(it *ec2.InstanceTypeInfo) *instanceTypeSpecs {
+	s := &instanceTypeSpecs{
+		bootModes:   aws.StringValueSlice(it.SupportedBootModes),
+		burstable:   aws.BoolValue(it.BurstablePerformanceSupported),
+		bareMetal:   aws.BoolValue(it.BareMetal),
+		accelerator: acceleratorFromSpecs(it),
+	}
+
+	// the bundled data names the virtualization types HVM and PV
+	for _, vt := range aws.StringValueSlice(it.SupportedVirtualizationTypes) {
+		switch vt {
+		case ec2.VirtualizationTypeHvm:
+			s.virtualizationTypes = append(s.virtualizationTypes, "HVM")
+		case ec2.VirtualizationTypeParavirtual:
+			s.virtualizationTypes = append(s.virtualizationTypes, "PV")
+		}
+	}
+
+	if it.VCpuInfo != nil && it.VCpuInfo.DefaultVCpus != nil {
+		s.vCPU = int(*it.VCpuInfo.DefaultVCpus)
+	}
+
+	if it.MemoryInfo != nil && it.MemoryInfo.SizeInMiB != nil {
+		s.memory = float32(*it.MemoryInfo.SizeInMiB) / 1024
+	}
+
+	if it.GpuInfo != nil {
+		for _, gpu := range it.GpuInfo.Gpus {
+			if gpu.Count != nil {
+				s.GPU += int(*gpu.Count)
+			}
+		}
+	}
+
+	if it.ProcessorInfo != nil {
+		s.architectures = aws.StringValueSlice(it.ProcessorInfo.SupportedArchitectures)
+	}
+
+	if n := it.NetworkInfo; n != nil {
+		s.enaSupport = aws.StringValue(n.EnaSupport)
+		s.efaSupported = aws.BoolValue(n.EfaSupported)
+		if n.MaximumNetworkInterfaces != nil {
+			s.maxENIs = *n.MaximumNetworkInterfaces
+		}
+		if n.Ipv4AddressesPerInterface != nil {
+			s.ipv4PerENI = *n.Ipv4AddressesPerInterface
+		}
+	}
+
+	if it.EbsInfo != nil {
+		s.nvmeSupport = aws.StringValue(it.EbsInfo.NvmeSupport)
+	}
+
+	return s
+}
+
+// mergeSpecs fills the instance type information with the hardware specs
+// returned by the API, which take precedence over the bundled data.
+func (info *instanceTypeInformation) mergeSpecs(specs *instanceTypeSpecs) {
+	if specs == nil {
+		return
+	}
+
+	info.specs = specs
+
+	if specs.vCPU > 0 {
+		info.vCPU = specs.vCPU
+	}
+	if specs.memory > 0 {
+		info.memory = specs.memory
+	}
+	if specs.GPU > info.GPU {
+		info.GPU = specs.GPU
+	}
+	if len(specs.virtualizationTypes) > 0 {
+		info.virtualizationTypes = specs.virtualizationTypes
+	}
+}
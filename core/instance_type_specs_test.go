@@ -0,0 +1,257 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	ec2instancesinfo "github.com/mello7tre/ec2-instances-info"
+)
+
+var describedM6g = &ec2.InstanceTypeInfo{
+	InstanceType:                  aws.String("m6g.large"),
+	BareMetal:                     aws.Bool(false),
+	BurstablePerformanceSupported: aws.Bool(false),
+	Hypervisor:                    aws.String(ec2.InstanceTypeHypervisorNitro),
+	SupportedBootModes:            []*string{aws.String(ec2.BootModeTypeUefi)},
+	SupportedVirtualizationTypes:  []*string{aws.String(ec2.VirtualizationTypeHvm)},
+	VCpuInfo:                      &ec2.VCpuInfo{DefaultVCpus: aws.Int64(2)},
+	MemoryInfo:                    &ec2.MemoryInfo{SizeInMiB: aws.Int64(8192)},
+	ProcessorInfo: &ec2.ProcessorInfo{
+		SupportedArchitectures: []*string{aws.String(ec2.ArchitectureTypeArm64)},
+	},
+	NetworkInfo: &ec2.NetworkInfo{
+		EnaSupport:                aws.String(ec2.EnaSupportRequired),
+		EfaSupported:              aws.Bool(false),
+		MaximumNetworkInterfaces:  aws.Int64(3),
+		Ipv4AddressesPerInterface: aws.Int64(10),
+	},
+	EbsInfo: &ec2.EbsInfo{NvmeSupport: aws.String(ec2.EbsNvmeSupportRequired)},
+}
+
+func Test_newInstanceTypeSpecs(t *testing.T) {
+	want := &instanceTypeSpecs{
+		vCPU:                2,
+		memory:              8,
+		architectures:       []string{"arm64"},
+		virtualizationTypes: []string{"HVM"},
+		enaSupport:          "required",
+		maxENIs:             3,
+		ipv4PerENI:          10,
+		nvmeSupport:         "required",
+		bootModes:           []string{"uefi"},
+	}
+
+	got := newInstanceTypeSpecs(describedM6g)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("newInstanceTypeSpecs() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_region_instanceTypeSpecs(t *testing.T) {
+	tests := []struct {
+		name    string
+		region  string
+		conf    *Config
+		ec2     mockEC2
+		cached  map[string]*instanceTypeSpecs
+		wantLen int
+	}{
+		{
+			name:   "disabled",
+			region: "specs-disabled",
+			conf:   &Config{},
+			ec2: mockEC2{ditpo: []*ec2.DescribeInstanceTypesOutput{
+				{InstanceTypes: []*ec2.InstanceTypeInfo{describedM6g}},
+			}},
+		},
+		{
+			name:   "paginated",
+			region: "specs-paginated",
+			conf:   &Config{EnableDescribeInstanceTypes: true},
+			ec2: mockEC2{ditpo: []*ec2.DescribeInstanceTypesOutput{
+				{InstanceTypes: []*ec2.InstanceTypeInfo{describedM6g}},
+				{InstanceTypes: []*ec2.InstanceTypeInfo{{InstanceType: aws.String("m5.large")}}},
+			}},
+			wantLen: 2,
+		},
+		{
+			name:   "cached",
+			region: "specs-cached",
+			conf:   &Config{EnableDescribeInstanceTypes: true},
+			ec2:    mockEC2{ditperr: errors.New("throttled")},
+			cached: map[string]*instanceTypeSpecs{
+				"m5.large": {}, "m6g.large": {}, "c5.large": {},
+			},
+			wantLen: 3,
+		},
+		{
+			name:   "API failure",
+			region: "specs-failure",
+			conf:   &Config{EnableDescribeInstanceTypes: true},
+			ec2:    mockEC2{ditperr: errors.New("access denied")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.cached != nil {
+				instanceTypeSpecsCache.Lock()
+				instanceTypeSpecsCache.entries[tt.region] = instanceTypeSpecsCacheEntry{
+					specs:      tt.cached,
+					expiration: time.Now().Add(time.Hour),
+				}
+				instanceTypeSpecsCache.Unlock()
+			}
+
+			r := &region{
+				name:     tt.region,
+				conf:     tt.conf,
+				services: connections{ec2: tt.ec2},
+			}
+
+			if got := r.instanceTypeSpecs(); len(got) != tt.wantLen {
+				t.Errorf("instanceTypeSpecs() returned %d instance types, want %d", len(got), tt.wantLen)
+			}
+		})
+	}
+}
+
+func Test_region_buildInstanceTypeInformation_mergesSpecs(t *testing.T) {
+	data := make(ec2instancesinfo.InstanceData, 1)
+	data[0].InstanceType = "m6g.large"
+	data[0].PhysicalProcessor = "Graviton2"
+	data[0].LinuxVirtualizationTypes = []string{}
+	data[0].Pricing = map[string]ec2instancesinfo.RegionPrices{
+		"specs-merge": {Linux: ec2instancesinfo.Pricing{OnDemand: 0.077}},
+	}
+
+	r := &region{
+		name: "specs-merge",
+		conf: &Config{
+			InstanceData:                &data,
+			EnableDescribeInstanceTypes: true,
+			AutoScalingConfig:           AutoScalingConfig{OnDemandPriceMultiplier: 1},
+		},
+		services: connections{ec2: mockEC2{ditpo: []*ec2.DescribeInstanceTypesOutput{
+			{InstanceTypes: []*ec2.InstanceTypeInfo{describedM6g}},
+		}}},
+	}
+
+	info := r.buildInstanceTypeInformation(r.conf, osLinux, 0)["m6g.large"]
+	if info.vCPU != 2 || info.memory != 8 || info.specs == nil ||
+		!reflect.DeepEqual(info.virtualizationTypes, []string{"HVM"}) {
+		t.Errorf("buildInstanceTypeInformation() = %+v", info)
+	}
+	if got := info.cpuManufacturer(); got != cpuManufacturerAWS {
+		t.Errorf("cpuManufacturer() = %v, want %v", got, cpuManufacturerAWS)
+	}
+}
+
+func Test_region_buildInstanceTypeInformation_pricingAPITypes(t *testing.T) {
+	data := make(ec2instancesinfo.InstanceData, 0)
+	api := &pricingAPI{
+		client: mockPricing{gppo: []*pricing.GetProductsOutput{{
+			PriceList: []aws.JSONValue{
+				priceListItem("m6g.large", "Linux", "No License required", "0.077"),
+				priceListItem("m7g.large", "Linux", "No License required", "0.0816"),
+			},
+		}}},
+		ttl:      DefaultPricingCacheTTL,
+		fallback: &bundledPricing{data: &data},
+	}
+
+	r := &region{
+		name: "pricing-merge",
+		conf: &Config{
+			InstanceData:                &data,
+			EnableDescribeInstanceTypes: true,
+			AutoScalingConfig:           AutoScalingConfig{OnDemandPriceMultiplier: 1},
+			pricing:                     api,
+		},
+		services: connections{ec2: mockEC2{ditpo: []*ec2.DescribeInstanceTypesOutput{
+			{InstanceTypes: []*ec2.InstanceTypeInfo{describedM6g}},
+		}}},
+	}
+
+	result := r.buildInstanceTypeInformation(r.conf, osLinux, 0)
+
+	info, found := result["m6g.large"]
+	if !found || info.vCPU != 2 || info.memory != 8 || info.pricing.onDemand != 0.077 {
+		t.Errorf("buildInstanceTypeInformation() = %+v, want m6g.large added from the Pricing API", info)
+	}
+	if _, found := result["m7g.large"]; found {
+		t.Errorf("buildInstanceTypeInformation() added m7g.large without its specs")
+	}
+}
+
+func Test_instance_isSameArch_specs(t *testing.T) {
+	x86 := &instanceTypeSpecs{architectures: []string{"i386", "x86_64"}}
+	x86Only := &instanceTypeSpecs{architectures: []string{"x86_64"}}
+	arm := &instanceTypeSpecs{architectures: []string{"arm64"}}
+
+	tests := []struct {
+		name      string
+		current   instanceTypeInformation
+		candidate instanceTypeInformation
+		want      bool
+	}{
+		{
+			name:      "heuristics, both Intel compatible",
+			current:   instanceTypeInformation{PhysicalProcessor: "Intel Xeon"},
+			candidate: instanceTypeInformation{PhysicalProcessor: "AMD EPYC"},
+			want:      true,
+		},
+		{
+			name:      "heuristics, processor name not recognized",
+			current:   instanceTypeInformation{PhysicalProcessor: "AWS Graviton2 Processor"},
+			candidate: instanceTypeInformation{PhysicalProcessor: "Graviton3"},
+			want:      false,
+		},
+		{
+			name:      "specs, same architecture despite the processor names",
+			current:   instanceTypeInformation{PhysicalProcessor: "AWS Graviton2 Processor", specs: arm},
+			candidate: instanceTypeInformation{PhysicalProcessor: "Graviton3", specs: arm},
+			want:      true,
+		},
+		{
+			name:      "specs, overlapping architectures",
+			current:   instanceTypeInformation{specs: x86Only},
+			candidate: instanceTypeInformation{specs: x86},
+			want:      true,
+		},
+		{
+			name:      "specs, different architectures",
+			current:   instanceTypeInformation{specs: x86},
+			candidate: instanceTypeInformation{specs: arm},
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{typeInfo: tt.current}
+			if got := i.isSameArch(&tt.candidate); got != tt.want {
+				t.Errorf("isSameArch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instanceTypeInformation_specsOverrideHeuristics(t *testing.T) {
+	// the heuristics consider this name burstable and bare metal, the specs win
+	it := instanceTypeInformation{instanceType: "t4g.metal", specs: &instanceTypeSpecs{bareMetal: false, burstable: false}}
+	if it.isBurstable() || it.isBareMetal() {
+		t.Errorf("isBurstable() = %v, isBareMetal() = %v, want false", it.isBurstable(), it.isBareMetal())
+	}
+
+	it.specs = nil
+	if !it.isBurstable() || !it.isBareMetal() {
+		t.Errorf("isBurstable() = %v, isBareMetal() = %v, want true", it.isBurstable(), it.isBareMetal())
+	}
+}
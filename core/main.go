@@ -42,6 +42,23 @@ func (a *AutoSpotting) Init(cfg *Config) {
 	}
 
 	cfg.InstanceData = data
+
+	if err := applyInstanceCatalogOverlay(cfg.InstanceData, cfg.InstanceCatalogOverlay); err != nil {
+		log.Println("Couldn't apply the instance catalog overlay, using the bundled instance data:", err.Error())
+	}
+
+	if cfg.configFile, err = loadConfigFile(cfg.ConfigFile, session.Must(
+		session.NewSession(&aws.Config{Region: aws.String(cfg.MainRegion)}))); err != nil {
+		log.Fatal(err.Error())
+	}
+
+	cfg.pricing = newPricingProvider(cfg)
+
+	if cfg.spotAdvisor, err = loadSpotAdvisorData(cfg.SpotAdvisorData); err != nil {
+		log.Println("Couldn't load the Spot Instance Advisor data, ranking the Spot instance types only by price:",
+			err.Error())
+	}
+
 	a.config = cfg
 	a.config.setupLogging()
 	// use this only to list all the other regions
@@ -58,12 +75,21 @@ func RunningFromLambda() bool {
 	return false
 }
 
+// SetDeadline sets the time at which the current Lambda invocation times out,
+// so that the replacements which can't complete by then are not started.
+func (a *AutoSpotting) SetDeadline(deadline time.Time) {
+	a.config.deadline = deadline
+}
+
 // ProcessCronEvent starts processing all AWS regions looking for AutoScaling groups
 // enabled and taking action by replacing more pricy on-demand instances with
 // compatible and cheaper spot instances.
 func (a *AutoSpotting) ProcessCronEvent() {
 	// Clear FinalRecap map
 	a.config.FinalRecap = make(map[string][]string)
+	resetRunReplacements()
+	resetGlobalReplacements()
+	a.config.resetPricingFailures()
 
 	a.config.addDefaultFilteringMode()
 	a.config.addDefaultFilter()
@@ -155,20 +181,79 @@ func (a *AutoSpotting) processRegions(regions []string) {
 		return
 	}
 
-	for _, r := range regions {
+	// all the regions are scanned before processing any of them, so the global
+	// replacement limits account for the groups of all the regions
+	scanned := make([]*region, len(regions))
+	for n, name := range regions {
 		wg.Add(1)
-		r := region{name: r, conf: a.config}
+		r := &region{name: name, conf: a.config}
 
-		go func() {
+		go func(n int) {
 			if r.enabled() {
 				log.Printf("Enabled to run in %s, processing region.\n", r.name)
-				r.processRegion()
+				if r.scanRegion() {
+					scanned[n] = r
+				}
 			} else {
 				debug.Println("Not enabled to run in", r.name)
 				debug.Println("List of enabled regions:", r.conf.Regions)
 			}
 
 			wg.Done()
+		}(n)
+	}
+	wg.Wait()
+
+	for _, r := range scanned {
+		if r == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(r *region) {
+			log.Println("Processing enabled AutoScaling groups in", r.name)
+			r.processEnabledAutoScalingGroups()
+			wg.Done()
+		}(r)
+	}
+	wg.Wait()
+}
+
+// scanReplacementUsage records the recent and in-flight replacements of the
+// enabled groups of all the regions, needed by the global replacement limits
+// when handling an event from the given region.
+func (a *AutoSpotting) scanReplacementUsage(current *region) {
+	if !current.globalReplacementLimitsEnabled() {
+		return
+	}
+	resetGlobalReplacements()
+	current.registerReplacementUsage(time.Now())
+
+	regions, err := a.getRegions()
+	if err != nil {
+		log.Println("Couldn't list the regions for the global replacement limits:", err.Error())
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range regions {
+		r := &region{name: name, conf: a.config}
+		if name == current.name || !r.enabled() {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.services.connect(r.name, a.config.MainRegion)
+			r.setupAsgFilters()
+			r.scanForEnabledAutoScalingGroups()
+			if r.hasEnabledAutoScalingGroups() {
+				if err := r.scanInstances(); err != nil {
+					log.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
+					return
+				}
+			}
+			r.registerReplacementUsage(time.Now())
 		}()
 	}
 	wg.Wait()
@@ -263,10 +348,16 @@ func (a *AutoSpotting) processEventInstance(eventType string, region string, ins
 		}
 		// If the event is for an Instance Spot Interruption/Rebalance
 		spotTermination := newSpotTermination(region)
+		spotTermination.configFile = a.config.configFile
 
 		if spotTermination.IsInAutoSpottingASG(instanceID, a.config.TagFilteringMode, a.config.FilterByTags) {
 		        asgTermAction := spotTermination.getTermAction(a.config.TerminationNotificationAction)
 			//log.Printf("asgTermAction: %s", asgTermAction)
+			if eventType == SpotInstanceInterruptionWarningCode {
+				if err := spotTermination.recordInterruption(instanceID); err != nil {
+					log.Printf("Error recording the spot interruption: %s\n", err.Error())
+				}
+			}
 			err := spotTermination.executeAction(instanceID, asgTermAction, eventType)
 			if err != nil {
 				log.Printf("Error executing spot termination/rebalance action: %s\n", err.Error())
@@ -328,6 +419,7 @@ func (a *AutoSpotting) EventHandler(event *json.RawMessage) {
 		return
 	}
 
+	a.config.resetPricingFailures()
 	a.processEvent(event)
 	log.SetPrefix("")
 }
@@ -482,12 +574,50 @@ func (a *AutoSpotting) handleNewOnDemandInstanceLaunch(r *region, i *instance) e
 		if err := r.scanInstances(); err != nil {
 			log.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
 		}
+		if i.coveredByReservationOnLaunch() {
+			log.Printf("%s Not replacing %s, it is covered by Reserved Instances or Savings Plans",
+				i.region.name, *i.InstanceId)
+			return nil
+		}
+		i.asg.checkSwappedInstances(time.Now())
+		if i.asg.circuitOpen(time.Now()) {
+			log.Printf("%s Not replacing %s, the circuit breaker of %s is open",
+				i.region.name, *i.InstanceId, i.asg.name)
+			return nil
+		}
+		if reason := i.asg.replacementsPaused(time.Now()); reason != "" {
+			log.Printf("%s Not replacing %s: %s",
+				i.region.name, *i.InstanceId, reason)
+			return nil
+		}
+		if err := i.asg.readinessFitsDeadline(time.Now()); err != nil {
+			log.Printf("%s Not replacing %s: %s",
+				i.region.name, *i.InstanceId, err.Error())
+			return nil
+		}
+
 		spotInstance := i.asg.findUnattachedInstanceLaunchedForThisASG()
 
 		if spotInstance != nil {
 			spotInstanceID := *spotInstance.InstanceId
 			log.Println("Found unattached spot instance", spotInstanceID)
 		} else {
+			if reason := i.asg.replacementThrottled(time.Now()); reason != "" {
+				log.Printf("%s Delaying the replacement of %s: %s",
+					i.region.name, *i.InstanceId, reason)
+				return nil
+			}
+			a.scanReplacementUsage(r)
+			if reason := r.reserveGlobalReplacement(time.Now()); reason != "" {
+				log.Printf("%s Delaying the replacement of %s: %s",
+					i.region.name, *i.InstanceId, reason)
+				return nil
+			}
+			if !r.reserveRunReplacement() {
+				log.Printf("%s Delaying the replacement of %s: %s",
+					i.region.name, *i.InstanceId, "max-replacements-per-run")
+				return nil
+			}
 			log.Printf("Attempting to launch spot replacement")
 			if spotInstanceID, err = i.launchSpotReplacement(); err != nil {
 				log.Printf("%s Couldn't launch spot replacement for %s",
@@ -0,0 +1,171 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// mixed_instances_policy.go contains the logic used for supporting the groups
+// configured with a MixedInstancesPolicy, which define their own launch
+// template, instance types and on-demand capacity.
+
+import (
+	"log"
+	"math"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// defaultWeightedCapacity is the weight of the instance types for which the
+// group doesn't specify any.
+const defaultWeightedCapacity = "1"
+
+func (a *autoScalingGroup) hasMixedInstancesPolicy() bool {
+	return a.Group != nil && a.MixedInstancesPolicy != nil
+}
+
+// launchTemplateSpecification returns the launch template used by the group,
+// either configured directly on the group or as part of its mixed instances
+// policy.
+func (a *autoScalingGroup) launchTemplateSpecification() *autoscaling.LaunchTemplateSpecification {
+	if a.Group == nil {
+		return nil
+	}
+
+	if a.LaunchTemplate != nil {
+		return a.LaunchTemplate
+	}
+
+	if a.hasMixedInstancesPolicy() && a.MixedInstancesPolicy.LaunchTemplate != nil {
+		return a.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification
+	}
+	return nil
+}
+
+// mixedInstancesPolicyWeights returns the instance types allowed by the
+// overrides of the mixed instances policy, mapped to their weights. The
+// overrides using a different launch template than the one of the policy are
+// skipped, since the Spot instances are always launched from the latter.
+func (a *autoScalingGroup) mixedInstancesPolicyWeights() map[string]string {
+	if !a.hasMixedInstancesPolicy() || a.MixedInstancesPolicy.LaunchTemplate == nil {
+		return nil
+	}
+
+	policyLT := a.launchTemplateSpecification()
+	result := make(map[string]string)
+
+	for _, o := range a.MixedInstancesPolicy.LaunchTemplate.Overrides {
+		if o.InstanceType == nil {
+			continue
+		}
+
+		if lt := o.LaunchTemplateSpecification; lt != nil && policyLT != nil &&
+			aws.StringValue(lt.LaunchTemplateId) != aws.StringValue(policyLT.LaunchTemplateId) {
+			debug.Println(a.name, "skipping instance type", *o.InstanceType,
+				"configured with a different launch template")
+			continue
+		}
+
+		weight := defaultWeightedCapacity
+		if o.WeightedCapacity != nil {
+			weight = *o.WeightedCapacity
+		}
+		result[*o.InstanceType] = weight
+	}
+	return result
+}
+
+// filterMixedInstancesPolicyInstanceTypes restricts the allowed instance types
+// to those configured on the mixed instances policy of the group, having the
+// same weight as the instance being replaced so the group capacity doesn't
+// change after the replacement. The configured allowed list, if any, is further
+// applied on top of the group's instance types.
+func (a *autoScalingGroup) filterMixedInstancesPolicyInstanceTypes(allowed []string, baseInstance *instance) []string {
+	weights := a.mixedInstancesPolicyWeights()
+	if len(weights) == 0 {
+		return allowed
+	}
+
+	baseWeight := defaultWeightedCapacity
+	if baseInstance != nil {
+		if w, found := weights[baseInstance.typeInfo.instanceType]; found {
+			baseWeight = w
+		}
+	}
+
+	var result []string
+	for _, o := range a.MixedInstancesPolicy.LaunchTemplate.Overrides {
+		it := aws.StringValue(o.InstanceType)
+		weight, found := weights[it]
+
+		if !found || weight != baseWeight {
+			continue
+		}
+
+		if len(allowed) == 0 {
+			result = append(result, it)
+			continue
+		}
+
+		for _, pattern := range allowed {
+			if match, _ := filepath.Match(pattern, it); match {
+				result = append(result, it)
+				break
+			}
+		}
+	}
+
+	debug.Println(a.name, "instance types allowed by the mixed instances policy:", result)
+	return result
+}
+
+// loadMixedInstancesPolicyOnDemand sets the minimum on-demand capacity from the
+// instances distribution of the mixed instances policy. Only the values set
+// explicitly in the policy take precedence over the global configuration and
+// the group tags, when the percentage above the base capacity isn't set the
+// base capacity is only used as a lower bound of the configured minimum.
+func (a *autoScalingGroup) loadMixedInstancesPolicyOnDemand() bool {
+	if !a.hasMixedInstancesPolicy() || a.MixedInstancesPolicy.InstancesDistribution == nil {
+		return false
+	}
+
+	dist := a.MixedInstancesPolicy.InstancesDistribution
+	if dist.OnDemandBaseCapacity == nil && dist.OnDemandPercentageAboveBaseCapacity == nil {
+		return false
+	}
+
+	base := aws.Int64Value(dist.OnDemandBaseCapacity)
+
+	total := int64(0)
+	if a.instances != nil {
+		total = a.instances.count64()
+	}
+
+	onDemand := total
+	if total > base {
+		onDemand = base
+	}
+
+	if dist.OnDemandPercentageAboveBaseCapacity == nil {
+		if a.config.MinOnDemand > onDemand {
+			onDemand = a.config.MinOnDemand
+		}
+		log.Printf("Loaded on-demand capacity %d from the mixed instances policy of %s "+
+			"(base capacity %d, percentage above base from the configuration)\n",
+			onDemand, a.name, base)
+		a.config.MinOnDemand = onDemand
+		return true
+	}
+
+	percentage := *dist.OnDemandPercentageAboveBaseCapacity
+	if total > base {
+		onDemand = base + int64(math.Ceil(float64(total-base)*float64(percentage)/100.0))
+	}
+
+	log.Printf("Loaded on-demand capacity %d from the mixed instances policy of %s "+
+		"(base capacity %d, percentage above base %d)\n",
+		onDemand, a.name, base, percentage)
+
+	a.config.MinOnDemand = onDemand
+	return true
+}
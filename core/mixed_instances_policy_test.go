@@ -0,0 +1,228 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func mixedInstancesPolicyGroup(overrides []*autoscaling.LaunchTemplateOverrides,
+	dist *autoscaling.InstancesDistribution) *autoscaling.Group {
+	return &autoscaling.Group{
+		MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{
+			LaunchTemplate: &autoscaling.LaunchTemplate{
+				LaunchTemplateSpecification: &autoscaling.LaunchTemplateSpecification{
+					LaunchTemplateId: aws.String("lt-policy"),
+					Version:          aws.String("$Default"),
+				},
+				Overrides: overrides,
+			},
+			InstancesDistribution: dist,
+		},
+	}
+}
+
+func Test_autoScalingGroup_launchTemplateSpecification(t *testing.T) {
+	tests := []struct {
+		name  string
+		group *autoscaling.Group
+		want  *autoscaling.LaunchTemplateSpecification
+	}{
+		{
+			name:  "launch configuration",
+			group: &autoscaling.Group{LaunchConfigurationName: aws.String("lc")},
+			want:  nil,
+		},
+		{
+			name: "launch template",
+			group: &autoscaling.Group{
+				LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+					LaunchTemplateId: aws.String("lt-group"),
+					Version:          aws.String("1"),
+				},
+			},
+			want: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String("lt-group"),
+				Version:          aws.String("1"),
+			},
+		},
+		{
+			name:  "mixed instances policy",
+			group: mixedInstancesPolicyGroup(nil, nil),
+			want: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String("lt-policy"),
+				Version:          aws.String("$Default"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{Group: tt.group}
+			if got := a.launchTemplateSpecification(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("launchTemplateSpecification() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_filterMixedInstancesPolicyInstanceTypes(t *testing.T) {
+	overrides := []*autoscaling.LaunchTemplateOverrides{
+		{InstanceType: aws.String("m5.large")},
+		{InstanceType: aws.String("c5.large"), WeightedCapacity: aws.String("1")},
+		{InstanceType: aws.String("m5.xlarge"), WeightedCapacity: aws.String("2")},
+		{
+			InstanceType: aws.String("m6g.large"),
+			LaunchTemplateSpecification: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String("lt-arm"),
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		group    *autoscaling.Group
+		allowed  []string
+		baseType string
+		want     []string
+	}{
+		{
+			name:     "group without mixed instances policy",
+			group:    &autoscaling.Group{},
+			allowed:  []string{"t3.*"},
+			baseType: "m5.large",
+			want:     []string{"t3.*"},
+		},
+		{
+			name:     "instance types of the same weight",
+			group:    mixedInstancesPolicyGroup(overrides, nil),
+			baseType: "m5.large",
+			want:     []string{"m5.large", "c5.large"},
+		},
+		{
+			name:     "instance types of a larger weight",
+			group:    mixedInstancesPolicyGroup(overrides, nil),
+			baseType: "m5.xlarge",
+			want:     []string{"m5.xlarge"},
+		},
+		{
+			name:     "further restricted by the allowed list",
+			group:    mixedInstancesPolicyGroup(overrides, nil),
+			allowed:  []string{"c5.*"},
+			baseType: "m5.large",
+			want:     []string{"c5.large"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{Group: tt.group}
+			base := &instance{typeInfo: instanceTypeInformation{instanceType: tt.baseType}}
+
+			if got := a.filterMixedInstancesPolicyInstanceTypes(tt.allowed, base); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterMixedInstancesPolicyInstanceTypes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadMixedInstancesPolicyOnDemand(t *testing.T) {
+	fourInstances := makeInstancesWithCatalog(instanceMap{
+		"i-1": {}, "i-2": {}, "i-3": {}, "i-4": {},
+	})
+
+	tests := []struct {
+		name            string
+		group           *autoscaling.Group
+		instances       instances
+		configured      int64
+		want            bool
+		wantMinOnDemand int64
+	}{
+		{
+			name:            "group without mixed instances policy",
+			group:           &autoscaling.Group{},
+			instances:       fourInstances,
+			configured:      7,
+			want:            false,
+			wantMinOnDemand: 7,
+		},
+		{
+			name: "base capacity and no on-demand above it",
+			group: mixedInstancesPolicyGroup(nil, &autoscaling.InstancesDistribution{
+				OnDemandBaseCapacity:                aws.Int64(1),
+				OnDemandPercentageAboveBaseCapacity: aws.Int64(0),
+			}),
+			instances:       fourInstances,
+			want:            true,
+			wantMinOnDemand: 1,
+		},
+		{
+			name: "base capacity and percentage above it rounded up",
+			group: mixedInstancesPolicyGroup(nil, &autoscaling.InstancesDistribution{
+				OnDemandBaseCapacity:                aws.Int64(1),
+				OnDemandPercentageAboveBaseCapacity: aws.Int64(50),
+			}),
+			instances:       fourInstances,
+			want:            true,
+			wantMinOnDemand: 3,
+		},
+		{
+			name: "base capacity larger than the group",
+			group: mixedInstancesPolicyGroup(nil, &autoscaling.InstancesDistribution{
+				OnDemandBaseCapacity:                aws.Int64(10),
+				OnDemandPercentageAboveBaseCapacity: aws.Int64(0),
+			}),
+			instances:       fourInstances,
+			want:            true,
+			wantMinOnDemand: 4,
+		},
+		{
+			name: "percentage not set falls back to the configuration",
+			group: mixedInstancesPolicyGroup(nil, &autoscaling.InstancesDistribution{
+				OnDemandBaseCapacity: aws.Int64(0),
+			}),
+			instances:       fourInstances,
+			configured:      2,
+			want:            true,
+			wantMinOnDemand: 2,
+		},
+		{
+			name: "percentage not set and base capacity above the configuration",
+			group: mixedInstancesPolicyGroup(nil, &autoscaling.InstancesDistribution{
+				OnDemandBaseCapacity: aws.Int64(3),
+			}),
+			instances:       fourInstances,
+			configured:      1,
+			want:            true,
+			wantMinOnDemand: 3,
+		},
+		{
+			name:            "nothing set in the instances distribution",
+			group:           mixedInstancesPolicyGroup(nil, &autoscaling.InstancesDistribution{}),
+			instances:       fourInstances,
+			configured:      2,
+			want:            false,
+			wantMinOnDemand: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:     tt.group,
+				instances: tt.instances,
+				config:    AutoScalingConfig{MinOnDemand: tt.configured},
+			}
+			if got := a.loadMixedInstancesPolicyOnDemand(); got != tt.want {
+				t.Errorf("loadMixedInstancesPolicyOnDemand() = %v, want %v", got, tt.want)
+			}
+			if a.config.MinOnDemand != tt.wantMinOnDemand {
+				t.Errorf("loadMixedInstancesPolicyOnDemand() MinOnDemand = %v, want %v",
+					a.config.MinOnDemand, tt.wantMinOnDemand)
+			}
+		})
+	}
+}
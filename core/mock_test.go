@@ -7,12 +7,21 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
@@ -37,6 +46,14 @@ type mockEC2 struct {
 	cfo   *ec2.CreateFleetOutput
 	cferr error
 
+	// DescribeInstanceTypesPages output and error
+	ditpo   []*ec2.DescribeInstanceTypesOutput
+	ditperr error
+
+	// DescribeReservedInstances output and error
+	drio   *ec2.DescribeReservedInstancesOutput
+	drierr error
+
 	// DescribeSpotPriceHistoryPages output
 	dsphpo   []*ec2.DescribeSpotPriceHistoryOutput
 	dsphperr error
@@ -47,6 +64,9 @@ type mockEC2 struct {
 	// DescribeInstancesPages error
 	diperr error
 
+	// DescribeInstances error
+	dierr error
+
 	// DescribeInstanceAttribute
 	diao   *ec2.DescribeInstanceAttributeOutput
 	diaerr error
@@ -54,6 +74,8 @@ type mockEC2 struct {
 	// DescribeImagesOutput
 	damio   *ec2.DescribeImagesOutput
 	damierr error
+	// DescribeImagesOutput keyed by the image ID, used when set
+	damiid map[string]*ec2.DescribeImagesOutput
 
 	// Terminate Instance
 	tio   *ec2.TerminateInstancesOutput
@@ -77,6 +99,19 @@ type mockEC2 struct {
 
 	// WaitUntilInstanceRunning error
 	wuirerr error
+
+	// DescribeSubnets
+	dsno   *ec2.DescribeSubnetsOutput
+	dsnerr error
+
+	// GetSpotPlacementScoresPages output per comma separated list of instance
+	// types, and error
+	gspspo   map[string]*ec2.GetSpotPlacementScoresOutput
+	gspsperr error
+
+	// DescribeInstanceStatus
+	disto   *ec2.DescribeInstanceStatusOutput
+	disterr error
 }
 
 func (m mockEC2) CreateFleet(in *ec2.CreateFleetInput) (*ec2.CreateFleetOutput, error) {
@@ -98,16 +133,34 @@ func (m mockEC2) DescribeSpotPriceHistoryPages(in *ec2.DescribeSpotPriceHistoryI
 	return m.dsphperr
 }
 
+func (m mockEC2) DescribeInstanceTypesPages(in *ec2.DescribeInstanceTypesInput, f func(*ec2.DescribeInstanceTypesOutput, bool) bool) error {
+	for i, page := range m.ditpo {
+		f(page, i == len(m.ditpo)-1)
+	}
+	return m.ditperr
+}
+
 func (m mockEC2) DescribeInstancesPages(in *ec2.DescribeInstancesInput, f func(*ec2.DescribeInstancesOutput, bool) bool) error {
 	f(m.dio, true)
 	return m.diperr
 }
 
+func (m mockEC2) DescribeInstances(in *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	return m.dio, m.dierr
+}
+
 func (m mockEC2) DescribeInstanceAttribute(in *ec2.DescribeInstanceAttributeInput) (*ec2.DescribeInstanceAttributeOutput, error) {
 	return m.diao, m.diaerr
 }
 
+func (m mockEC2) DescribeReservedInstances(*ec2.DescribeReservedInstancesInput) (*ec2.DescribeReservedInstancesOutput, error) {
+	return m.drio, m.drierr
+}
+
 func (m mockEC2) DescribeImages(in *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	if m.damiid != nil && len(in.ImageIds) > 0 {
+		return m.damiid[aws.StringValue(in.ImageIds[0])], m.damierr
+	}
 	return m.damio, m.damierr
 }
 
@@ -131,6 +184,24 @@ func (m mockEC2) WaitUntilInstanceRunning(*ec2.DescribeInstancesInput) error {
 	return m.wuirerr
 }
 
+func (m mockEC2) DescribeSubnets(*ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	return m.dsno, m.dsnerr
+}
+
+func (m mockEC2) DescribeInstanceStatus(*ec2.DescribeInstanceStatusInput) (*ec2.DescribeInstanceStatusOutput, error) {
+	return m.disto, m.disterr
+}
+
+func (m mockEC2) GetSpotPlacementScoresPages(in *ec2.GetSpotPlacementScoresInput, f func(*ec2.GetSpotPlacementScoresOutput, bool) bool) error {
+	if m.gspsperr != nil {
+		return m.gspsperr
+	}
+	if page, ok := m.gspspo[strings.Join(aws.StringValueSlice(in.InstanceTypes), ",")]; ok {
+		f(page, true)
+	}
+	return nil
+}
+
 // All fields are composed of the abbreviation of their method
 // This is useful when methods are doing multiple calls to AWS API
 type mockASG struct {
@@ -168,6 +239,10 @@ type mockASG struct {
 	// CreateOrUpdateTags
 	couto   *autoscaling.CreateOrUpdateTagsOutput
 	couterr error
+
+	// DescribeScalingActivities
+	dsao   *autoscaling.DescribeScalingActivitiesOutput
+	dsaerr error
 }
 
 func (m mockASG) DetachInstances(*autoscaling.DetachInstancesInput) (*autoscaling.DetachInstancesOutput, error) {
@@ -216,6 +291,10 @@ func (m mockASG) CreateOrUpdateTags(*autoscaling.CreateOrUpdateTagsInput) (*auto
 	return m.couto, m.couterr
 }
 
+func (m mockASG) DescribeScalingActivities(*autoscaling.DescribeScalingActivitiesInput) (*autoscaling.DescribeScalingActivitiesOutput, error) {
+	return m.dsao, m.dsaerr
+}
+
 // All fields are composed of the abbreviation of their method
 // This is useful when methods are doing multiple calls to AWS API
 type mockCloudFormation struct {
@@ -250,6 +329,74 @@ func (m mockSQS) DeleteMessage(*sqs.DeleteMessageInput) (*sqs.DeleteMessageOutpu
 	return m.dmo, m.dmerr
 }
 
+// All fields are composed of the abbreviation of their method
+// This is useful when methods are doing multiple calls to AWS API
+type mockELBV2 struct {
+	elbv2iface.ELBV2API
+	// DescribeTargetHealth
+	dtho   *elbv2.DescribeTargetHealthOutput
+	dtherr error
+}
+
+func (m mockELBV2) DescribeTargetHealth(*elbv2.DescribeTargetHealthInput) (*elbv2.DescribeTargetHealthOutput, error) {
+	return m.dtho, m.dtherr
+}
+
+// All fields are composed of the abbreviation of their method
+// This is useful when methods are doing multiple calls to AWS API
+type mockSNS struct {
+	snsiface.SNSAPI
+	// Publish, the subjects of the published messages are appended to
+	// published
+	po        *sns.PublishOutput
+	perr      error
+	published *[]string
+}
+
+func (m mockSNS) Publish(in *sns.PublishInput) (*sns.PublishOutput, error) {
+	if m.published != nil {
+		*m.published = append(*m.published, *in.Subject)
+	}
+	return m.po, m.perr
+}
+
+// All fields are composed of the abbreviation of their method
+// This is useful when methods are doing multiple calls to AWS API
+type mockPricing struct {
+	pricingiface.PricingAPI
+	// GetProductsPages
+	gppo   []*pricing.GetProductsOutput
+	gpperr error
+}
+
+func (m mockPricing) GetProductsPages(in *pricing.GetProductsInput, f func(*pricing.GetProductsOutput, bool) bool) error {
+	for i, page := range m.gppo {
+		f(page, i == len(m.gppo)-1)
+	}
+	return m.gpperr
+}
+
+// All fields are composed of the abbreviation of their method
+// This is useful when methods are doing multiple calls to AWS API
+type mockS3 struct {
+	s3iface.S3API
+	// GetObject
+	goo   *s3.GetObjectOutput
+	goerr error
+
+	// PutObject
+	poo   *s3.PutObjectOutput
+	poerr error
+}
+
+func (m mockS3) GetObject(*s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	return m.goo, m.goerr
+}
+
+func (m mockS3) PutObject(*s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	return m.poo, m.poerr
+}
+
 // utility function for checking if error messages are matching
 func errorMatches(got error, wanted error) bool {
 	if got == nil {
@@ -0,0 +1,36 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// maxNotificationSubjectLength is the maximum length of an SNS message subject.
+const maxNotificationSubjectLength = 100
+
+// notify publishes a message to the SNS topic configured for notifications,
+// if any. Failures are only logged, the notifications are best effort.
+func (r *region) notify(subject, message string) {
+	if r.conf == nil || r.conf.NotificationTopic == "" || r.services.sns == nil {
+		return
+	}
+
+	if len(subject) > maxNotificationSubjectLength {
+		subject = subject[:maxNotificationSubjectLength]
+	}
+
+	_, err := r.services.sns.Publish(&sns.PublishInput{
+		TopicArn: aws.String(r.conf.NotificationTopic),
+		Subject:  aws.String(subject),
+		Message:  aws.String(message),
+	})
+
+	if err != nil {
+		log.Println(r.name, "Failed to publish notification to", r.conf.NotificationTopic, err.Error())
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// on_demand_schedule.go contains the logic used for changing the minimum
+// on-demand capacity of a group depending on the time of day, for example
+// keeping more on-demand instances during the business hours, and for raising
+// the on-demand capacity when a window of the schedule starts.
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MinOnDemandScheduleTag is the name of the tag set on the AutoScaling Group
+// that can override the global value of the MinOnDemandSchedule parameter
+const MinOnDemandScheduleTag = "autospotting_min_on_demand_schedule"
+
+// onDemandScheduleEntry is a schedule window and the minimum on-demand
+// capacity applied inside it, either as a number or as a percentage.
+type onDemandScheduleEntry struct {
+	window     string
+	number     int64
+	percentage float64
+	isPercent  bool
+}
+
+// parseMinOnDemandSchedule decodes schedules such as "9-18 1-5=50%;*=1", made
+// of semicolon separated window=value entries. The windows use the same format
+// as the CronSchedule, and "*" matches all the time.
+func parseMinOnDemandSchedule(value string) ([]onDemandScheduleEntry, error) {
+	var result []onDemandScheduleEntry
+
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		separator := strings.LastIndex(item, "=")
+		if separator < 0 {
+			return nil, fmt.Errorf("invalid on-demand schedule entry %q, expected window=value", item)
+		}
+
+		entry := onDemandScheduleEntry{window: strings.TrimSpace(item[:separator])}
+		if entry.window == "*" {
+			entry.window = DefaultCronSchedule
+		}
+
+		if _, err := insideSchedule(time.Now(), entry.window, "UTC"); err != nil {
+			return nil, fmt.Errorf("invalid on-demand schedule window %q: %s", entry.window, err.Error())
+		}
+
+		amount := strings.TrimSpace(item[separator+1:])
+		if strings.HasSuffix(amount, "%") {
+			percentage, err := strconv.ParseFloat(strings.TrimSuffix(amount, "%"), 64)
+			if err != nil || percentage < 0 || percentage > 100 {
+				return nil, fmt.Errorf("invalid on-demand percentage %q", amount)
+			}
+			entry.percentage, entry.isPercent = percentage, true
+		} else {
+			number, err := strconv.ParseInt(amount, 10, 64)
+			if err != nil || number < 0 {
+				return nil, fmt.Errorf("invalid on-demand number %q", amount)
+			}
+			entry.number = number
+		}
+
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+// cronTimezone returns the timezone in which the schedules of the group are
+// evaluated, which may be overridden by a tag.
+func (a *autoScalingGroup) cronTimezone() string {
+	if tagValue := a.getTagValue(TimezoneTag); tagValue != nil {
+		return *tagValue
+	}
+	if a.region.conf.CronTimezone != "" {
+		return a.region.conf.CronTimezone
+	}
+	return "UTC"
+}
+
+// scheduledMinOnDemand returns the minimum on-demand capacity given by the
+// first window of the schedule matching the given time, if any.
+func (a *autoScalingGroup) scheduledMinOnDemand(schedule string, t time.Time) (int64, bool) {
+	entries, err := parseMinOnDemandSchedule(schedule)
+	if err != nil {
+		log.Println(a.name, "Ignoring the on-demand schedule,", err.Error())
+		return DefaultMinOnDemandValue, false
+	}
+
+	timezone := a.cronTimezone()
+	for _, e := range entries {
+		inside, err := insideSchedule(t, e.window, timezone)
+		if err != nil {
+			return DefaultMinOnDemandValue, false
+		}
+		if !inside {
+			continue
+		}
+
+		if e.isPercent {
+			instanceNumber := float64(a.instances.count())
+			onDemand := int64(math.Floor((instanceNumber * e.percentage / 100.0) + .5))
+			log.Printf("%s Loaded MinOnDemand value %d from the %v%% scheduled for %q\n",
+				a.name, onDemand, e.percentage, e.window)
+			return onDemand, true
+		}
+
+		if a.MaxSize != nil && e.number > *a.MaxSize {
+			log.Printf("%s Ignoring scheduled on-demand value out of range %d\n", a.name, e.number)
+			return DefaultMinOnDemandValue, false
+		}
+
+		log.Printf("%s Loaded MinOnDemand value %d scheduled for %q\n", a.name, e.number, e.window)
+		return e.number, true
+	}
+
+	debug.Println(a.name, "None of the on-demand schedule windows match the current time")
+	return DefaultMinOnDemandValue, false
+}
+
+// loadMinOnDemandSchedule applies the on-demand schedule set in the tag of the
+// group, which takes precedence over the other on-demand tags while any of its
+// windows match the current time.
+func (a *autoScalingGroup) loadMinOnDemandSchedule(t time.Time) bool {
+	tagValue := a.getTagValue(MinOnDemandScheduleTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", MinOnDemandScheduleTag)
+		return false
+	}
+
+	onDemand, found := a.scheduledMinOnDemand(*tagValue, t)
+	if !found {
+		return false
+	}
+
+	log.Printf("Loaded MinOnDemand value %d from tag %s\n", onDemand, MinOnDemandScheduleTag)
+	a.config.MinOnDemand = onDemand
+	return true
+}
+
+// onDemandScheduleConfigured returns whether the minimum on-demand capacity of
+// the group changes over time.
+func (a *autoScalingGroup) onDemandScheduleConfigured() bool {
+	return a.getTagValue(MinOnDemandScheduleTag) != nil || a.region.conf.MinOnDemandSchedule != ""
+}
+
+// scheduledOnDemandAction raises the on-demand capacity of a group having an
+// on-demand schedule once a window requires more on-demand instances than it
+// runs. The missing on-demand instances are launched at once as an evacuation
+// batch, so the group first grows and then terminates as many Spot instances.
+// It returns nil if the group doesn't have a schedule or any missing on-demand
+// instances to launch in place of its Spot instances.
+func (a *autoScalingGroup) scheduledOnDemandAction() runer {
+	if !a.onDemandScheduleConfigured() {
+		return nil
+	}
+
+	onDemandRunning, _ := a.alreadyRunningInstanceCount(false, nil)
+	missing := a.config.MinOnDemand - onDemandRunning
+	if spots := int64(len(a.attachedSpotInstances())); missing > spots {
+		missing = spots
+	}
+	if missing <= 0 {
+		return nil
+	}
+
+	log.Println(a.region.name, a.name, "The on-demand schedule requires", a.config.MinOnDemand,
+		"on-demand instances, launching", missing, "on-demand instances in place of Spot instances")
+	return launchEvacuationBatch{target: target{asg: a}, batch: missing}
+}
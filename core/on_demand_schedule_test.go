@@ -0,0 +1,218 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_parseMinOnDemandSchedule(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []onDemandScheduleEntry
+		wantErr bool
+	}{
+		{
+			value: "9-18 1-5=50%;*=1",
+			want: []onDemandScheduleEntry{
+				{window: "9-18 1-5", percentage: 50, isPercent: true},
+				{window: "* *", number: 1},
+			},
+		},
+		{
+			value: " * 9-17 * * 1-5 = 3 ; ",
+			want:  []onDemandScheduleEntry{{window: "* 9-17 * * 1-5", number: 3}},
+		},
+		{value: "9-18 1-5", wantErr: true},
+		{value: "9-18 1-5=150%", wantErr: true},
+		{value: "9-18 1-5=-1", wantErr: true},
+		{value: "9-18=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseMinOnDemandSchedule(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMinOnDemandSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMinOnDemandSchedule() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_scheduledMinOnDemand(t *testing.T) {
+	// a Thursday
+	businessHours := time.Date(2019, time.May, 9, 10, 0, 0, 0, time.UTC)
+	night := time.Date(2019, time.May, 9, 22, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		schedule string
+		timezone string
+		t        time.Time
+		want     int64
+		wantOK   bool
+	}{
+		{
+			name:     "percentage during business hours",
+			schedule: "9-18 1-5=50%;*=1",
+			t:        businessHours,
+			want:     5,
+			wantOK:   true,
+		},
+		{
+			name:     "number at night",
+			schedule: "9-18 1-5=50%;*=1",
+			t:        night,
+			want:     1,
+			wantOK:   true,
+		},
+		{
+			name:     "no matching window",
+			schedule: "9-18 1-5=50%",
+			t:        night,
+		},
+		{
+			name:     "evaluated in the timezone of the group",
+			schedule: "9-18 1-5=50%;*=1",
+			timezone: "America/Los_Angeles",
+			t:        businessHours,
+			want:     1,
+			wantOK:   true,
+		},
+		{
+			name:     "number above the maximum size",
+			schedule: "*=20",
+			t:        night,
+		},
+		{
+			name:     "invalid schedule",
+			schedule: "9-18 1-5=half",
+			t:        businessHours,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			catalog := instanceMap{}
+			for n := 0; n < 10; n++ {
+				id := fmt.Sprintf("i-%d", n)
+				catalog[id] = &instance{Instance: &ec2.Instance{InstanceId: aws.String(id)}}
+			}
+
+			a := &autoScalingGroup{
+				Group:     &autoscaling.Group{MaxSize: aws.Int64(10)},
+				instances: makeInstancesWithCatalog(catalog),
+				region:    &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{CronTimezone: "UTC"}}},
+			}
+			if tt.timezone != "" {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(TimezoneTag), Value: aws.String(tt.timezone)}}
+			}
+
+			got, ok := a.scheduledMinOnDemand(tt.schedule, tt.t)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("scheduledMinOnDemand() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadConfOnDemand_schedule(t *testing.T) {
+	a := &autoScalingGroup{
+		Group: &autoscaling.Group{
+			MaxSize: aws.Int64(10),
+			Tags: []*autoscaling.TagDescription{
+				{Key: aws.String(OnDemandNumberLong), Value: aws.String("5")},
+				{Key: aws.String(MinOnDemandScheduleTag), Value: aws.String("*=2")},
+			},
+		},
+		instances: makeInstances(),
+		region:    &region{conf: &Config{}},
+	}
+
+	if !a.loadConfOnDemand() || a.config.MinOnDemand != 2 {
+		t.Errorf("loadConfOnDemand() set MinOnDemand to %v, want the scheduled 2", a.config.MinOnDemand)
+	}
+
+	a.Tags[1].Value = aws.String("0 0 1 1 *=2")
+	if !a.loadConfOnDemand() || a.config.MinOnDemand != 5 {
+		t.Errorf("loadConfOnDemand() set MinOnDemand to %v, want 5 outside the schedule", a.config.MinOnDemand)
+	}
+}
+
+func Test_autoScalingGroup_scheduledOnDemandAction(t *testing.T) {
+	groupInstance := func(id string, lifecycle *string) *instance {
+		return &instance{Instance: &ec2.Instance{
+			InstanceId:        aws.String(id),
+			InstanceLifecycle: lifecycle,
+			State:             &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		}}
+	}
+	catalog := instanceMap{
+		"i-od":    groupInstance("i-od", nil),
+		"i-spot1": groupInstance("i-spot1", aws.String(Spot)),
+		"i-spot2": groupInstance("i-spot2", aws.String(Spot)),
+	}
+
+	tests := []struct {
+		name        string
+		schedule    string
+		minOnDemand int64
+		want        runer
+	}{
+		{
+			name:        "without schedule",
+			minOnDemand: 3,
+		},
+		{
+			name:        "enough on-demand instances",
+			schedule:    "*=1",
+			minOnDemand: 1,
+		},
+		{
+			name:        "missing on-demand instances",
+			schedule:    "*=3",
+			minOnDemand: 3,
+			want:        launchEvacuationBatch{batch: 2},
+		},
+		{
+			name:        "more missing on-demand instances than Spot instances",
+			schedule:    "*=100%",
+			minOnDemand: 5,
+			want:        launchEvacuationBatch{batch: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:     &autoscaling.Group{},
+				name:      "asg",
+				instances: makeInstancesWithCatalog(catalog),
+				region:    &region{name: "us-east-1", conf: &Config{}},
+				config:    AutoScalingConfig{MinOnDemand: tt.minOnDemand},
+			}
+			if tt.schedule != "" {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(MinOnDemandScheduleTag), Value: aws.String(tt.schedule)}}
+			}
+
+			got := a.scheduledOnDemandAction()
+			if batch, ok := got.(launchEvacuationBatch); ok {
+				if batch.target.asg != a {
+					t.Errorf("scheduledOnDemandAction() targets %v, want the group", batch.target.asg)
+				}
+				got = launchEvacuationBatch{batch: batch.batch}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("scheduledOnDemandAction() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// operating_system.go contains the logic used for detecting the operating
+// system of each group, so that the on-demand and Spot prices of its license
+// are used when comparing instance types.
+
+import (
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	ec2instancesinfo "github.com/mello7tre/ec2-instances-info"
+)
+
+// operatingSystem describes how the prices of an operating system are looked up
+type operatingSystem struct {
+	name string
+
+	// the product description used for the Spot price history
+	spotProductDescription string
+
+	// the operating system key of the Spot Instance Advisor data
+	spotAdvisorName string
+
+	// the on-demand price column of the ec2-instances-info data
+	onDemandPrice func(ec2instancesinfo.RegionPrices) float64
+
+	// the operating system name used by the Pricing API
+	pricingName string
+}
+
+var (
+	osLinux = &operatingSystem{
+		name:                   "Linux/UNIX",
+		spotProductDescription: "Linux/UNIX (Amazon VPC)",
+		spotAdvisorName:        "Linux",
+		onDemandPrice:          func(p ec2instancesinfo.RegionPrices) float64 { return p.Linux.OnDemand },
+		pricingName:            "Linux",
+	}
+	osWindows = &operatingSystem{
+		name:                   "Windows",
+		spotProductDescription: "Windows (Amazon VPC)",
+		spotAdvisorName:        "Windows",
+		onDemandPrice:          func(p ec2instancesinfo.RegionPrices) float64 { return p.MSWin.OnDemand },
+		pricingName:            "Windows",
+	}
+	osRHEL = &operatingSystem{
+		name:                   "Red Hat Enterprise Linux",
+		spotProductDescription: "Red Hat Enterprise Linux (Amazon VPC)",
+		spotAdvisorName:        "Linux",
+		onDemandPrice:          func(p ec2instancesinfo.RegionPrices) float64 { return p.RHEL.OnDemand },
+		pricingName:            "RHEL",
+	}
+	osSUSE = &operatingSystem{
+		name:                   "SUSE Linux",
+		spotProductDescription: "SUSE Linux (Amazon VPC)",
+		spotAdvisorName:        "Linux",
+		onDemandPrice:          func(p ec2instancesinfo.RegionPrices) float64 { return p.SLES.OnDemand },
+		pricingName:            "SUSE",
+	}
+)
+
+// operatingSystemFromPlatform maps the PlatformDetails and UsageOperation
+// attributes of an AMI or instance to the operating system, returning nil
+// for the unknown or unsupported ones.
+func operatingSystemFromPlatform(platformDetails, usageOperation *string) *operatingSystem {
+	details := aws.StringValue(platformDetails)
+
+	switch {
+	case strings.HasPrefix(details, "Windows"):
+		return osWindows
+	case strings.HasPrefix(details, "Red Hat"):
+		return osRHEL
+	case strings.HasPrefix(details, "SUSE"):
+		return osSUSE
+	case details == "Linux/UNIX":
+		return osLinux
+	}
+
+	// See https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/billing-info-fields.html
+	switch op := aws.StringValue(usageOperation); {
+	case op == "RunInstances":
+		return osLinux
+	case op == "RunInstances:0010":
+		return osRHEL
+	case op == "RunInstances:000g":
+		return osSUSE
+	case op == "RunInstances:0002" || op == "RunInstances:0800" ||
+		op == "RunInstances:0006" || op == "RunInstances:0102" || op == "RunInstances:0202":
+		return osWindows
+	}
+	return nil
+}
+
+// operatingSystem detects the operating system of the group from the AMI of
+// its launch template or launch configuration, and otherwise from its running
+// instances, which inherit these attributes from their AMI. It returns nil if
+// the operating system can't be determined.
+func (a *autoScalingGroup) operatingSystem() *operatingSystem {
+	if a.os != nil {
+		return a.os
+	}
+
+	if a.launchTemplate != nil && a.launchTemplate.Image != nil {
+		a.os = operatingSystemFromPlatform(a.launchTemplate.Image.PlatformDetails,
+			a.launchTemplate.Image.UsageOperation)
+	}
+
+	if a.os == nil && a.launchConfiguration != nil && a.launchConfiguration.ImageId != nil {
+		resp, err := a.region.services.ec2.DescribeImages(&ec2.DescribeImagesInput{
+			ImageIds: []*string{a.launchConfiguration.ImageId},
+		})
+		if err != nil {
+			log.Println(a.name, "Failed to describe image", *a.launchConfiguration.ImageId, err.Error())
+		} else if resp != nil && len(resp.Images) > 0 {
+			a.os = operatingSystemFromPlatform(resp.Images[0].PlatformDetails, resp.Images[0].UsageOperation)
+		}
+	}
+
+	if a.os == nil && a.Group != nil && a.region != nil && a.region.instances != nil {
+		for _, inst := range a.Instances {
+			if i := a.region.instances.get(aws.StringValue(inst.InstanceId)); i != nil {
+				if a.os = operatingSystemFromPlatform(i.PlatformDetails, i.UsageOperation); a.os != nil {
+					break
+				}
+			}
+		}
+	}
+
+	if a.os != nil {
+		debug.Println(a.name, "Detected operating system", a.os.name)
+	}
+	return a.os
+}
+
+// instanceTypeInformation returns the instance type information priced for the
+// operating system of the group.
+func (a *autoScalingGroup) instanceTypeInformation() map[string]instanceTypeInformation {
+	return a.region.instanceTypeInformationForOS(a.operatingSystem())
+}
+
+// instanceTypeInformationForOS returns the instance type information priced
+// for the given operating system, lazily building it on first use. The globally
+// configured instance type information is used when the operating system is
+// unknown or matches the configured Spot product description. The prices of
+// the other operating systems already include the license cost, so no premium
+// is added to them. The groups of a region are processed concurrently, so the
+// prices of an operating system are loaded under a lock.
+func (r *region) instanceTypeInformationForOS(os *operatingSystem) map[string]instanceTypeInformation {
+	if os == nil || r.conf == nil || r.conf.InstanceData == nil ||
+		os.spotProductDescription == r.conf.SpotProductDescription {
+		return r.instanceTypeInformation
+	}
+
+	r.osInstanceTypeInformationMutex.Lock()
+	defer r.osInstanceTypeInformationMutex.Unlock()
+
+	if info, found := r.osInstanceTypeInformation[os.spotProductDescription]; found {
+		return info
+	}
+
+	log.Println(r.name, "Loading the prices of", os.name, "instances")
+
+	info := r.buildInstanceTypeInformation(r.conf, os, 0)
+	if err := r.requestSpotPrices(os.spotProductDescription, info); err != nil {
+		log.Println(err.Error())
+	}
+
+	if r.osInstanceTypeInformation == nil {
+		r.osInstanceTypeInformation = make(map[string]map[string]instanceTypeInformation)
+	}
+	r.osInstanceTypeInformation[os.spotProductDescription] = info
+	return info
+}
+
+// spotAdvisorOperatingSystem returns the operating system key used when
+// looking up the instance types of the group in the Spot Instance Advisor data.
+func (i *instance) spotAdvisorOperatingSystem() string {
+	if i.asg != nil && i.asg.region != nil {
+		if os := i.asg.operatingSystem(); os != nil {
+			return os.spotAdvisorName
+		}
+	}
+	return osLinux.spotAdvisorName
+}
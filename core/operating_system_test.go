@@ -0,0 +1,206 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	ec2instancesinfo "github.com/mello7tre/ec2-instances-info"
+)
+
+func Test_operatingSystemFromPlatform(t *testing.T) {
+	tests := []struct {
+		name            string
+		platformDetails *string
+		usageOperation  *string
+		want            *operatingSystem
+	}{
+		{name: "Linux", platformDetails: aws.String("Linux/UNIX"), want: osLinux},
+		{name: "Windows", platformDetails: aws.String("Windows"), want: osWindows},
+		{name: "Windows with SQL Server", platformDetails: aws.String("Windows with SQL Server Standard"), want: osWindows},
+		{name: "RHEL", platformDetails: aws.String("Red Hat Enterprise Linux"), want: osRHEL},
+		{name: "SUSE", platformDetails: aws.String("SUSE Linux"), want: osSUSE},
+		{name: "Linux usage operation", usageOperation: aws.String("RunInstances"), want: osLinux},
+		{name: "Windows usage operation", usageOperation: aws.String("RunInstances:0002"), want: osWindows},
+		{name: "RHEL usage operation", usageOperation: aws.String("RunInstances:0010"), want: osRHEL},
+		{name: "SUSE usage operation", usageOperation: aws.String("RunInstances:000g"), want: osSUSE},
+		{name: "unsupported", platformDetails: aws.String("Linux with SQL Server Web"),
+			usageOperation: aws.String("RunInstances:0200"), want: nil},
+		{name: "missing", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := operatingSystemFromPlatform(tt.platformDetails, tt.usageOperation); got != tt.want {
+				t.Errorf("operatingSystemFromPlatform() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_operatingSystem(t *testing.T) {
+	tests := []struct {
+		name string
+		asg  *autoScalingGroup
+		want *operatingSystem
+	}{
+		{
+			name: "from the launch template image",
+			asg: &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				launchTemplate: &launchTemplate{
+					Image: &ec2.Image{PlatformDetails: aws.String("Windows")},
+				},
+				region: &region{},
+			},
+			want: osWindows,
+		},
+		{
+			name: "from the launch configuration image",
+			asg: &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				launchConfiguration: &launchConfiguration{
+					LaunchConfiguration: &autoscaling.LaunchConfiguration{ImageId: aws.String("ami-1")},
+				},
+				region: &region{
+					services: connections{
+						ec2: mockEC2{
+							damio: &ec2.DescribeImagesOutput{
+								Images: []*ec2.Image{{PlatformDetails: aws.String("Red Hat Enterprise Linux")}},
+							},
+						},
+					},
+				},
+			},
+			want: osRHEL,
+		},
+		{
+			name: "from the running instances",
+			asg: &autoScalingGroup{
+				Group: &autoscaling.Group{
+					Instances: []*autoscaling.Instance{{InstanceId: aws.String("i-1")}},
+				},
+				launchConfiguration: &launchConfiguration{
+					LaunchConfiguration: &autoscaling.LaunchConfiguration{ImageId: aws.String("ami-1")},
+				},
+				region: &region{
+					services: connections{
+						ec2: mockEC2{damierr: errors.New("access denied")},
+					},
+					instances: makeInstancesWithCatalog(instanceMap{
+						"i-1": {Instance: &ec2.Instance{
+							InstanceId:     aws.String("i-1"),
+							UsageOperation: aws.String("RunInstances:000g"),
+						}},
+					}),
+				},
+			},
+			want: osSUSE,
+		},
+		{
+			name: "unknown",
+			asg: &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				region: &region{},
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.asg.operatingSystem(); got != tt.want {
+				t.Errorf("operatingSystem() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_region_instanceTypeInformationForOS(t *testing.T) {
+	cfg := &Config{
+		InstanceData: &ec2instancesinfo.InstanceData{
+			0: {
+				InstanceType: "m5.large",
+				Pricing: map[string]ec2instancesinfo.RegionPrices{
+					"us-east-1": {
+						Linux: ec2instancesinfo.Pricing{OnDemand: 0.096},
+						MSWin: ec2instancesinfo.Pricing{OnDemand: 0.188},
+					},
+				},
+			},
+		},
+		AutoScalingConfig: AutoScalingConfig{
+			OnDemandPriceMultiplier: 1.0,
+			SpotProductDescription:  "Linux/UNIX (Amazon VPC)",
+			SpotProductPremium:      0.05,
+		},
+	}
+
+	r := &region{
+		name: "us-east-1",
+		conf: cfg,
+		services: connections{
+			ec2: mockEC2{
+				dsphpo: []*ec2.DescribeSpotPriceHistoryOutput{{
+					SpotPriceHistory: []*ec2.SpotPrice{{
+						InstanceType:     aws.String("m5.large"),
+						AvailabilityZone: aws.String("us-east-1a"),
+						SpotPrice:        aws.String("0.09"),
+					}},
+				}},
+			},
+		},
+	}
+	r.determineInstanceTypeInformation(cfg)
+
+	tests := []struct {
+		name        string
+		os          *operatingSystem
+		wantOD      float64
+		wantPremium float64
+	}{
+		{name: "unknown operating system", os: nil, wantOD: 0.096, wantPremium: 0.05},
+		{name: "configured operating system", os: osLinux, wantOD: 0.096, wantPremium: 0.05},
+		{name: "Windows", os: osWindows, wantOD: 0.188, wantPremium: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := r.instanceTypeInformationForOS(tt.os)["m5.large"].pricing
+
+			if math.Abs(got.onDemand-tt.wantOD) > 0.000001 {
+				t.Errorf("instanceTypeInformationForOS() on-demand = %v, want %v", got.onDemand, tt.wantOD)
+			}
+			if got.premium != tt.wantPremium {
+				t.Errorf("instanceTypeInformationForOS() premium = %v, want %v", got.premium, tt.wantPremium)
+			}
+			if got.spot["us-east-1a"] != 0.09 {
+				t.Errorf("instanceTypeInformationForOS() spot = %v, want 0.09", got.spot["us-east-1a"])
+			}
+		})
+	}
+
+	if _, found := r.osInstanceTypeInformation[osWindows.spotProductDescription]; !found {
+		t.Errorf("instanceTypeInformationForOS() didn't cache the Windows prices")
+	}
+
+	// the groups of a region are processed concurrently
+	r.osInstanceTypeInformation = nil
+	var wg sync.WaitGroup
+	for _, os := range []*operatingSystem{osWindows, osRHEL, osSUSE, osWindows, osRHEL, osSUSE} {
+		wg.Add(1)
+		go func(os *operatingSystem) {
+			defer wg.Done()
+			r.instanceTypeInformationForOS(os)
+		}(os)
+	}
+	wg.Wait()
+	if len(r.osInstanceTypeInformation) != 3 {
+		t.Errorf("instanceTypeInformationForOS() cached %d operating systems concurrently, want 3",
+			len(r.osInstanceTypeInformation))
+	}
+}
@@ -0,0 +1,412 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// pricing_provider.go contains the providers of the on-demand prices, which
+// are either taken from the ec2-instances-info data bundled in the binary or
+// fetched from the AWS Pricing API and cached for a configurable amount of
+// time, falling back to the bundled data when the API isn't reachable.
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/pricing/pricingiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	ec2instancesinfo "github.com/mello7tre/ec2-instances-info"
+)
+
+const (
+	// DefaultPricingCacheTTL is the default amount of time for which the prices
+	// fetched from the Pricing API are used before being fetched again.
+	DefaultPricingCacheTTL = 24 * time.Hour
+
+	// pricingAPIRegion is the region of the Pricing API endpoint we use, the
+	// API is only available in a few regions but serves the prices of all of
+	// them.
+	pricingAPIRegion = "us-east-1"
+
+	// The license model of the Pricing API products we ignore, since the
+	// license cost isn't included in their price
+	pricingBringYourOwnLicense = "Bring your own license"
+)
+
+// pricingProvider returns the on-demand prices used for comparing instance
+// types.
+type pricingProvider interface {
+	// onDemandPrice returns the hourly on-demand price of an instance type
+	// running the given operating system in a region, or false if unknown.
+	onDemandPrice(region, instanceType string, os *operatingSystem) (float64, bool)
+}
+
+// instanceTypeLister is implemented by the pricing providers which may know
+// about instance types missing from the bundled data.
+type instanceTypeLister interface {
+	// instanceTypes returns the instance types priced in a region.
+	instanceTypes(region string) []string
+}
+
+// bundledPricing serves the prices from the ec2-instances-info data compiled
+// into the binary.
+type bundledPricing struct {
+	data *ec2instancesinfo.InstanceData
+
+	once  sync.Once
+	index map[string]map[string]ec2instancesinfo.RegionPrices
+}
+
+func (b *bundledPricing) onDemandPrice(region, instanceType string, os *operatingSystem) (float64, bool) {
+	b.once.Do(func() {
+		b.index = make(map[string]map[string]ec2instancesinfo.RegionPrices)
+		if b.data == nil {
+			return
+		}
+		for _, it := range *b.data {
+			b.index[it.InstanceType] = it.Pricing
+		}
+	})
+
+	prices, found := b.index[instanceType][region]
+	if !found {
+		return 0, false
+	}
+	return os.onDemandPrice(prices), true
+}
+
+// pricingCache persists the prices fetched from the Pricing API.
+type pricingCache interface {
+	load() ([]byte, error)
+	save([]byte) error
+}
+
+// fileCache stores the prices in a local file, such as one from /tmp when
+// running on Lambda.
+type fileCache struct {
+	path string
+}
+
+func (f fileCache) load() ([]byte, error) {
+	return ioutil.ReadFile(f.path)
+}
+
+func (f fileCache) save(data []byte) error {
+	return ioutil.WriteFile(f.path, data, 0644)
+}
+
+// s3Cache stores the prices in an S3 object, which can be shared by multiple
+// AutoSpotting installations.
+type s3Cache struct {
+	client s3iface.S3API
+	bucket string
+	key    string
+}
+
+func (c s3Cache) load() ([]byte, error) {
+	resp, err := c.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (c s3Cache) save(data []byte) error {
+	_, err := c.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.key),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+// newPricingCache parses the cache location, which can be either a local file
+// path or an S3 URL like s3://bucket/key, returning nil if it's not set.
+func newPricingCache(location string, sess *session.Session) pricingCache {
+	if location == "" {
+		return nil
+	}
+
+	if strings.HasPrefix(location, "s3://") {
+		parts := strings.SplitN(strings.TrimPrefix(location, "s3://"), "/", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			log.Println("Ignoring invalid pricing cache location", location)
+			return nil
+		}
+		return s3Cache{client: s3.New(sess), bucket: parts[0], key: parts[1]}
+	}
+	return fileCache{path: location}
+}
+
+// pricingCacheData is the content of the pricing cache
+type pricingCacheData struct {
+	Regions map[string]*pricingCacheRegion `json:"regions"`
+}
+
+// pricingCacheRegion contains the prices of a region, keyed by the instance
+// type and then by the operating system name used by the Pricing API.
+type pricingCacheRegion struct {
+	Updated time.Time                     `json:"updated"`
+	Prices  map[string]map[string]float64 `json:"prices"`
+}
+
+// pricingAPI serves the prices fetched from the AWS Pricing API.
+type pricingAPI struct {
+	client   pricingiface.PricingAPI
+	cache    pricingCache
+	ttl      time.Duration
+	fallback pricingProvider
+
+	sync.Mutex
+	data        pricingCacheData
+	cacheLoaded bool
+
+	// regions for which the API call failed during this run, we don't retry
+	// them for every instance type
+	failed map[string]bool
+}
+
+// newPricingProvider returns the pricing provider configured by the user.
+func newPricingProvider(cfg *Config) pricingProvider {
+	bundled := &bundledPricing{data: cfg.InstanceData}
+
+	if !cfg.EnablePricingAPI {
+		return bundled
+	}
+
+	ttl := cfg.PricingCacheTTL
+	if ttl <= 0 {
+		ttl = DefaultPricingCacheTTL
+	}
+
+	return &pricingAPI{
+		client: pricing.New(session.Must(
+			session.NewSession(&aws.Config{Region: aws.String(pricingAPIRegion)}))),
+		cache: newPricingCache(cfg.PricingCache, session.Must(
+			session.NewSession(&aws.Config{Region: aws.String(cfg.MainRegion)}))),
+		ttl:      ttl,
+		fallback: bundled,
+	}
+}
+
+func (p *pricingAPI) onDemandPrice(region, instanceType string, os *operatingSystem) (float64, bool) {
+	p.Lock()
+	prices := p.regionPrices(region)
+	p.Unlock()
+
+	if prices != nil {
+		if price, found := prices.Prices[instanceType][os.pricingName]; found {
+			return price, true
+		}
+	}
+	return p.fallback.onDemandPrice(region, instanceType, os)
+}
+
+func (p *pricingAPI) instanceTypes(region string) []string {
+	p.Lock()
+	defer p.Unlock()
+
+	prices := p.regionPrices(region)
+	if prices == nil {
+		return nil
+	}
+
+	result := make([]string, 0, len(prices.Prices))
+	for instanceType := range prices.Prices {
+		result = append(result, instanceType)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// resetFailures forgets the regions for which the API call failed, so they're
+// retried on the next run instead of for as long as the Lambda function is
+// kept warm.
+func (p *pricingAPI) resetFailures() {
+	p.Lock()
+	defer p.Unlock()
+	p.failed = nil
+}
+
+// resetPricingFailures is called at the start of each run.
+func (cfg *Config) resetPricingFailures() {
+	if p, ok := cfg.pricing.(*pricingAPI); ok {
+		p.resetFailures()
+	}
+}
+
+// regionPrices returns the prices of the region, from memory, the cache or the
+// Pricing API, in this order. Stale prices are used if the API call fails.
+// It must be called while holding the lock.
+func (p *pricingAPI) regionPrices(region string) *pricingCacheRegion {
+	if !p.cacheLoaded {
+		p.loadCache()
+	}
+
+	current := p.data.Regions[region]
+	if (current != nil && time.Since(current.Updated) < p.ttl) || p.failed[region] {
+		return current
+	}
+
+	log.Println(region, "Fetching the on-demand prices from the Pricing API")
+	prices, err := p.fetch(region)
+	if err != nil {
+		log.Println(region, "Couldn't fetch the on-demand prices from the Pricing API:", err.Error())
+		if p.failed == nil {
+			p.failed = make(map[string]bool)
+		}
+		p.failed[region] = true
+		return current
+	}
+
+	p.data.Regions[region] = &pricingCacheRegion{Updated: time.Now(), Prices: prices}
+	p.saveCache()
+	return p.data.Regions[region]
+}
+
+func (p *pricingAPI) loadCache() {
+	p.cacheLoaded = true
+	p.data.Regions = make(map[string]*pricingCacheRegion)
+
+	if p.cache == nil {
+		return
+	}
+
+	body, err := p.cache.load()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Couldn't load the pricing cache:", err.Error())
+		}
+		return
+	}
+
+	var data pricingCacheData
+	if err := json.Unmarshal(body, &data); err != nil {
+		log.Println("Couldn't parse the pricing cache:", err.Error())
+		return
+	}
+
+	if data.Regions != nil {
+		p.data = data
+	}
+}
+
+func (p *pricingAPI) saveCache() {
+	if p.cache == nil {
+		return
+	}
+
+	body, err := json.Marshal(p.data)
+	if err != nil {
+		log.Println("Couldn't encode the pricing cache:", err.Error())
+		return
+	}
+
+	if err := p.cache.save(body); err != nil {
+		log.Println("Couldn't save the pricing cache:", err.Error())
+	}
+}
+
+// fetch retrieves the on-demand prices of the shared tenancy instances of a
+// region, without any pre-installed software.
+func (p *pricingAPI) fetch(region string) (map[string]map[string]float64, error) {
+	filter := func(field, value string) *pricing.Filter {
+		return &pricing.Filter{
+			Type:  aws.String(pricing.FilterTypeTermMatch),
+			Field: aws.String(field),
+			Value: aws.String(value),
+		}
+	}
+
+	result := make(map[string]map[string]float64)
+
+	err := p.client.GetProductsPages(&pricing.GetProductsInput{
+		ServiceCode: aws.String("AmazonEC2"),
+		Filters: []*pricing.Filter{
+			filter("regionCode", region),
+			filter("tenancy", "Shared"),
+			filter("capacitystatus", "Used"),
+			filter("preInstalledSw", "NA"),
+		},
+	}, func(page *pricing.GetProductsOutput, lastPage bool) bool {
+		for _, item := range page.PriceList {
+			instanceType, osName, price, ok := parsePriceListItem(item)
+			if !ok {
+				continue
+			}
+			if result[instanceType] == nil {
+				result[instanceType] = make(map[string]float64)
+			}
+			result[instanceType][osName] = price
+		}
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("no prices returned")
+	}
+	return result, nil
+}
+
+// parsePriceListItem extracts the instance type, operating system and hourly
+// on-demand price from a Pricing API product.
+func parsePriceListItem(item aws.JSONValue) (string, string, float64, bool) {
+	product, _ := item["product"].(map[string]interface{})
+	attributes, _ := product["attributes"].(map[string]interface{})
+
+	instanceType, _ := attributes["instanceType"].(string)
+	osName, _ := attributes["operatingSystem"].(string)
+	license, _ := attributes["licenseModel"].(string)
+
+	if instanceType == "" || osName == "" || license == pricingBringYourOwnLicense {
+		return "", "", 0, false
+	}
+
+	terms, _ := item["terms"].(map[string]interface{})
+	onDemand, _ := terms["OnDemand"].(map[string]interface{})
+
+	for _, term := range onDemand {
+		term, _ := term.(map[string]interface{})
+		dimensions, _ := term["priceDimensions"].(map[string]interface{})
+
+		for _, dimension := range dimensions {
+			dimension, _ := dimension.(map[string]interface{})
+			pricePerUnit, _ := dimension["pricePerUnit"].(map[string]interface{})
+			usd, _ := pricePerUnit["USD"].(string)
+
+			if price, err := strconv.ParseFloat(usd, 64); err == nil && price > 0 {
+				return instanceType, osName, price, true
+			}
+		}
+	}
+	return "", "", 0, false
+}
+
+// pricingProvider returns the configured pricing provider, or the bundled data
+// if none was configured.
+func (r *region) pricingProvider() pricingProvider {
+	if r.conf.pricing != nil {
+		return r.conf.pricing
+	}
+	return &bundledPricing{data: r.conf.InstanceData}
+}
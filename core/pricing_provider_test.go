@@ -0,0 +1,323 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/pricing"
+	"github.com/aws/aws-sdk-go/service/s3"
+	ec2instancesinfo "github.com/mello7tre/ec2-instances-info"
+)
+
+func priceListItem(instanceType, osName, license, usd string) aws.JSONValue {
+	var item aws.JSONValue
+	json.Unmarshal([]byte(`{
+		"product": {"attributes": {
+			"instanceType": "`+instanceType+`",
+			"operatingSystem": "`+osName+`",
+			"licenseModel": "`+license+`"
+		}},
+		"terms": {"OnDemand": {"ABC.JRTCKXETXF": {"priceDimensions": {
+			"ABC.JRTCKXETXF.6YS6EN2CT7": {"unit": "Hrs", "pricePerUnit": {"USD": "`+usd+`"}}
+		}}}}
+	}`), &item)
+	return item
+}
+
+func Test_parsePriceListItem(t *testing.T) {
+	tests := []struct {
+		name             string
+		item             aws.JSONValue
+		wantInstanceType string
+		wantOS           string
+		wantPrice        float64
+		wantOK           bool
+	}{
+		{
+			name:             "Linux",
+			item:             priceListItem("m5.large", "Linux", "No License required", "0.0960000000"),
+			wantInstanceType: "m5.large",
+			wantOS:           "Linux",
+			wantPrice:        0.096,
+			wantOK:           true,
+		},
+		{
+			name:             "Windows with license included",
+			item:             priceListItem("m5.large", "Windows", "License included", "0.1880000000"),
+			wantInstanceType: "m5.large",
+			wantOS:           "Windows",
+			wantPrice:        0.188,
+			wantOK:           true,
+		},
+		{
+			name: "bring your own license",
+			item: priceListItem("m5.large", "Windows", "Bring your own license", "0.0960000000"),
+		},
+		{
+			name: "zero price",
+			item: priceListItem("m5.large", "Linux", "No License required", "0.0000000000"),
+		},
+		{
+			name: "not an instance",
+			item: aws.JSONValue{"product": map[string]interface{}{}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instanceType, osName, price, ok := parsePriceListItem(tt.item)
+			if instanceType != tt.wantInstanceType || osName != tt.wantOS || price != tt.wantPrice || ok != tt.wantOK {
+				t.Errorf("parsePriceListItem() = %v, %v, %v, %v, want %v, %v, %v, %v",
+					instanceType, osName, price, ok, tt.wantInstanceType, tt.wantOS, tt.wantPrice, tt.wantOK)
+			}
+		})
+	}
+}
+
+func Test_bundledPricing_onDemandPrice(t *testing.T) {
+	b := &bundledPricing{data: &ec2instancesinfo.InstanceData{
+		0: {
+			InstanceType: "m5.large",
+			Pricing: map[string]ec2instancesinfo.RegionPrices{
+				"us-east-1": {
+					Linux: ec2instancesinfo.Pricing{OnDemand: 0.096},
+					MSWin: ec2instancesinfo.Pricing{OnDemand: 0.188},
+				},
+			},
+		},
+	}}
+
+	tests := []struct {
+		name         string
+		region       string
+		instanceType string
+		os           *operatingSystem
+		want         float64
+		wantFound    bool
+	}{
+		{name: "Linux", region: "us-east-1", instanceType: "m5.large", os: osLinux, want: 0.096, wantFound: true},
+		{name: "Windows", region: "us-east-1", instanceType: "m5.large", os: osWindows, want: 0.188, wantFound: true},
+		{name: "unknown region", region: "eu-west-1", instanceType: "m5.large", os: osLinux},
+		{name: "unknown instance type", region: "us-east-1", instanceType: "m7i.large", os: osLinux},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := b.onDemandPrice(tt.region, tt.instanceType, tt.os)
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("onDemandPrice() = %v, %v, want %v, %v", got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func writePricingCache(t *testing.T, path string, updated time.Time, price float64) {
+	data := pricingCacheData{Regions: map[string]*pricingCacheRegion{
+		"us-east-1": {
+			Updated: updated,
+			Prices:  map[string]map[string]float64{"m5.large": {"Linux": price}},
+		},
+	}}
+	body, _ := json.Marshal(data)
+	if err := ioutil.WriteFile(path, body, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_pricingAPI_onDemandPrice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pricing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fallback := &bundledPricing{data: &ec2instancesinfo.InstanceData{
+		0: {
+			InstanceType: "m5.large",
+			Pricing: map[string]ec2instancesinfo.RegionPrices{
+				"us-east-1": {Linux: ec2instancesinfo.Pricing{OnDemand: 0.5}},
+			},
+		},
+		1: {
+			InstanceType: "c5.large",
+			Pricing: map[string]ec2instancesinfo.RegionPrices{
+				"us-east-1": {Linux: ec2instancesinfo.Pricing{OnDemand: 0.085}},
+			},
+		},
+	}}
+
+	apiPages := []*pricing.GetProductsOutput{{
+		PriceList: []aws.JSONValue{
+			priceListItem("m5.large", "Linux", "No License required", "0.096"),
+			priceListItem("m7i.large", "Linux", "No License required", "0.1008"),
+		},
+	}}
+
+	tests := []struct {
+		name         string
+		cache        func(path string)
+		client       mockPricing
+		instanceType string
+		want         float64
+		wantCached   float64
+	}{
+		{
+			name:         "fetched from the API and cached",
+			client:       mockPricing{gppo: apiPages},
+			instanceType: "m5.large",
+			want:         0.096,
+			wantCached:   0.096,
+		},
+		{
+			name:         "instance type missing from the bundled data",
+			client:       mockPricing{gppo: apiPages},
+			instanceType: "m7i.large",
+			want:         0.1008,
+			wantCached:   0.096,
+		},
+		{
+			name:         "instance type missing from the API",
+			client:       mockPricing{gppo: apiPages},
+			instanceType: "c5.large",
+			want:         0.085,
+			wantCached:   0.096,
+		},
+		{
+			name: "fresh cache used without calling the API",
+			cache: func(path string) {
+				writePricingCache(t, path, time.Now().Add(-time.Hour), 0.09)
+			},
+			client:       mockPricing{gpperr: errors.New("offline")},
+			instanceType: "m5.large",
+			want:         0.09,
+			wantCached:   0.09,
+		},
+		{
+			name: "expired cache refreshed",
+			cache: func(path string) {
+				writePricingCache(t, path, time.Now().Add(-48*time.Hour), 0.09)
+			},
+			client:       mockPricing{gppo: apiPages},
+			instanceType: "m5.large",
+			want:         0.096,
+			wantCached:   0.096,
+		},
+		{
+			name: "expired cache used when offline",
+			cache: func(path string) {
+				writePricingCache(t, path, time.Now().Add(-48*time.Hour), 0.09)
+			},
+			client:       mockPricing{gpperr: errors.New("offline")},
+			instanceType: "m5.large",
+			want:         0.09,
+			wantCached:   0.09,
+		},
+		{
+			name:         "bundled data used when offline without cache",
+			client:       mockPricing{gpperr: errors.New("offline")},
+			instanceType: "m5.large",
+			want:         0.5,
+		},
+	}
+	for n, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.Repeat("x", n+1)+".json")
+			if tt.cache != nil {
+				tt.cache(path)
+			}
+
+			p := &pricingAPI{
+				client:   tt.client,
+				cache:    fileCache{path: path},
+				ttl:      DefaultPricingCacheTTL,
+				fallback: fallback,
+			}
+
+			if got, _ := p.onDemandPrice("us-east-1", tt.instanceType, osLinux); got != tt.want {
+				t.Errorf("onDemandPrice() = %v, want %v", got, tt.want)
+			}
+
+			var cached pricingCacheData
+			body, _ := ioutil.ReadFile(path)
+			json.Unmarshal(body, &cached)
+
+			var gotCached float64
+			if r := cached.Regions["us-east-1"]; r != nil {
+				gotCached = r.Prices["m5.large"]["Linux"]
+			}
+			if gotCached != tt.wantCached {
+				t.Errorf("onDemandPrice() cached %v, want %v", gotCached, tt.wantCached)
+			}
+		})
+	}
+}
+
+func Test_pricingAPI_resetFailures(t *testing.T) {
+	p := &pricingAPI{
+		client:   mockPricing{gpperr: errors.New("offline")},
+		ttl:      DefaultPricingCacheTTL,
+		fallback: &bundledPricing{},
+	}
+	cfg := &Config{pricing: p}
+
+	if _, found := p.onDemandPrice("us-east-1", "m5.large", osLinux); found {
+		t.Errorf("onDemandPrice() found a price while offline")
+	}
+
+	p.client = mockPricing{gppo: []*pricing.GetProductsOutput{{
+		PriceList: []aws.JSONValue{priceListItem("m5.large", "Linux", "No License required", "0.096")},
+	}}}
+	if _, found := p.onDemandPrice("us-east-1", "m5.large", osLinux); found {
+		t.Errorf("onDemandPrice() retried the API during the same run")
+	}
+
+	cfg.resetPricingFailures()
+	if got, _ := p.onDemandPrice("us-east-1", "m5.large", osLinux); got != 0.096 {
+		t.Errorf("onDemandPrice() = %v after the reset, want 0.096", got)
+	}
+}
+
+func Test_s3Cache(t *testing.T) {
+	c := s3Cache{
+		client: mockS3{
+			goo:   &s3.GetObjectOutput{Body: ioutil.NopCloser(strings.NewReader(`{"regions":{}}`))},
+			poerr: errors.New("access denied"),
+		},
+		bucket: "bucket",
+		key:    "prices.json",
+	}
+
+	if body, err := c.load(); err != nil || string(body) != `{"regions":{}}` {
+		t.Errorf("load() = %s, %v", body, err)
+	}
+
+	if err := c.save([]byte("{}")); err == nil {
+		t.Errorf("save() didn't return the S3 error")
+	}
+}
+
+func Test_newPricingCache(t *testing.T) {
+	tests := []struct {
+		location string
+		want     pricingCache
+	}{
+		{location: "", want: nil},
+		{location: "/tmp/prices.json", want: fileCache{path: "/tmp/prices.json"}},
+		{location: "s3://bucket", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.location, func(t *testing.T) {
+			if got := newPricingCache(tt.location, nil); got != tt.want {
+				t.Errorf("newPricingCache() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
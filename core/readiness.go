@@ -0,0 +1,345 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// readiness.go contains the checks a Spot instance needs to pass before the
+// on-demand instance it replaces is terminated, such as the EC2 status checks,
+// the health of the instance in the target groups of the group or an HTTP
+// probe against the application running on the instance.
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+const (
+	// ReadinessChecksTag is the name of the tag set on the AutoScaling Group
+	// that can override the global value of the ReadinessChecks parameter
+	ReadinessChecksTag = "autospotting_readiness_checks"
+
+	// ReadinessTimeoutTag is the name of the tag set on the AutoScaling Group
+	// that can override the global value of the ReadinessTimeout parameter
+	ReadinessTimeoutTag = "autospotting_readiness_timeout"
+
+	// ReadinessHTTPProbeTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the ReadinessHTTPProbe
+	// parameter
+	ReadinessHTTPProbeTag = "autospotting_readiness_http_probe"
+
+	// DefaultReadinessTimeout is the default time a Spot instance has for
+	// passing each of the readiness checks.
+	DefaultReadinessTimeout = "5m"
+
+	// DefaultReadinessHTTPProbe is the port and path requested by the HTTP
+	// readiness check when none is configured.
+	DefaultReadinessHTTPProbe = "80/"
+
+	readinessPollInterval = 15 * time.Second
+
+	// readinessDeadlineMargin is the time kept before the Lambda timeout for
+	// attaching the Spot instance, terminating the on-demand instance and
+	// resuming the processes of the group.
+	readinessDeadlineMargin = time.Minute
+)
+
+// readinessHTTPClient is used by the HTTP readiness check, its timeout applies
+// to each of the requests.
+var readinessHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// readinessCheck is a condition a Spot instance needs to meet before replacing
+// an on-demand instance.
+type readinessCheck interface {
+	// afterAttach returns whether the check can only pass once the instance
+	// was attached to the group.
+	afterAttach() bool
+
+	// ready returns whether the instance currently passes the check. Errors
+	// are considered transient, the check is retried until its timeout.
+	ready(a *autoScalingGroup, i *instance) (bool, error)
+}
+
+var readinessChecks = map[string]readinessCheck{
+	"ec2-status":   ec2StatusCheck{},
+	"target-group": targetGroupCheck{},
+	"http":         httpProbeCheck{},
+}
+
+// ec2StatusCheck waits for both the instance and system status checks of the
+// instance to pass.
+type ec2StatusCheck struct{}
+
+func (ec2StatusCheck) afterAttach() bool { return false }
+
+func (ec2StatusCheck) ready(a *autoScalingGroup, i *instance) (bool, error) {
+	resp, err := a.region.services.ec2.DescribeInstanceStatus(&ec2.DescribeInstanceStatusInput{
+		InstanceIds: []*string{i.InstanceId},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, status := range resp.InstanceStatuses {
+		if status.InstanceStatus == nil || status.SystemStatus == nil {
+			continue
+		}
+		debug.Println(a.name, *i.InstanceId, "instance status", aws.StringValue(status.InstanceStatus.Status),
+			"system status", aws.StringValue(status.SystemStatus.Status))
+		return aws.StringValue(status.InstanceStatus.Status) == ec2.SummaryStatusOk &&
+			aws.StringValue(status.SystemStatus.Status) == ec2.SummaryStatusOk, nil
+	}
+	return false, nil
+}
+
+// targetGroupCheck waits for the instance to be healthy in all the target
+// groups of the group, which register it once it's attached.
+type targetGroupCheck struct{}
+
+func (targetGroupCheck) afterAttach() bool { return true }
+
+func (targetGroupCheck) ready(a *autoScalingGroup, i *instance) (bool, error) {
+	for _, arn := range a.TargetGroupARNs {
+		resp, err := a.region.services.elbv2.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{
+			TargetGroupArn: arn,
+			Targets:        []*elbv2.TargetDescription{{Id: i.InstanceId}},
+		})
+		if err != nil {
+			return false, err
+		}
+
+		for _, target := range resp.TargetHealthDescriptions {
+			health := target.TargetHealth
+			if health == nil {
+				return false, nil
+			}
+			debug.Println(a.name, *i.InstanceId, "target health in", *arn, aws.StringValue(health.State))
+
+			switch aws.StringValue(health.State) {
+			case elbv2.TargetHealthStateEnumHealthy:
+			case elbv2.TargetHealthStateEnumUnused:
+				// target groups not used by any load balancer never report the
+				// instance as healthy
+				if aws.StringValue(health.Reason) == elbv2.TargetHealthReasonEnumTargetNotRegistered {
+					return false, nil
+				}
+			default:
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// httpProbeCheck waits for an HTTP request sent to the private IP address of
+// the instance to succeed.
+type httpProbeCheck struct{}
+
+func (httpProbeCheck) afterAttach() bool { return false }
+
+func (httpProbeCheck) ready(a *autoScalingGroup, i *instance) (bool, error) {
+	if i.PrivateIpAddress == nil {
+		return false, nil
+	}
+
+	url := readinessProbeURL(*i.PrivateIpAddress, a.config.ReadinessHTTPProbe)
+	resp, err := readinessHTTPClient.Get(url)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	debug.Println(a.name, *i.InstanceId, "got status", resp.StatusCode, "from", url)
+	return resp.StatusCode >= 200 && resp.StatusCode < 400, nil
+}
+
+// readinessProbeURL builds the URL requested by the HTTP readiness check from
+// the address of the instance and the probe, given as a port followed by the
+// path, such as 8080/health. The port defaults to 80.
+func readinessProbeURL(address, probe string) string {
+	if probe == "" {
+		probe = DefaultReadinessHTTPProbe
+	}
+
+	port, path := probe, "/"
+	if idx := strings.Index(probe, "/"); idx >= 0 {
+		port, path = probe[:idx], probe[idx:]
+	}
+	if port == "" {
+		port = "80"
+	}
+	return "http://" + net.JoinHostPort(address, port) + path
+}
+
+// configuredReadinessCheck is a readiness check enabled for a group, with the
+// time the instances have for passing it.
+type configuredReadinessCheck struct {
+	name    string
+	check   readinessCheck
+	timeout time.Duration
+}
+
+// parseReadinessChecks decodes a comma separated list of readiness checks,
+// each optionally followed by its own timeout, such as
+// "ec2-status,target-group=10m".
+func parseReadinessChecks(value string, timeout time.Duration) ([]configuredReadinessCheck, error) {
+	var checks []configuredReadinessCheck
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		c := configuredReadinessCheck{name: entry, timeout: timeout}
+		if idx := strings.Index(entry, "="); idx >= 0 {
+			d, err := time.ParseDuration(strings.TrimSpace(entry[idx+1:]))
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid timeout in %q", entry)
+			}
+			c.name, c.timeout = strings.TrimSpace(entry[:idx]), d
+		}
+
+		check, valid := readinessChecks[c.name]
+		if !valid {
+			return nil, fmt.Errorf("unknown readiness check %q", c.name)
+		}
+		c.check = check
+		checks = append(checks, c)
+	}
+	return checks, nil
+}
+
+func (a *autoScalingGroup) readinessTimeout() time.Duration {
+	timeout, err := time.ParseDuration(a.config.ReadinessTimeout)
+	if err != nil || timeout < 0 {
+		timeout, _ = time.ParseDuration(DefaultReadinessTimeout)
+	}
+	return timeout
+}
+
+// readinessDuration returns the longest time the readiness checks configured
+// for the group can take, before and after attaching the instance.
+func (a *autoScalingGroup) readinessDuration() time.Duration {
+	checks, err := parseReadinessChecks(a.config.ReadinessChecks, a.readinessTimeout())
+	if err != nil {
+		return 0
+	}
+
+	var total time.Duration
+	for _, c := range checks {
+		total += c.timeout
+	}
+	return total
+}
+
+// readinessFitsDeadline returns an error when the readiness checks configured
+// for the group can't complete before the Lambda invocation times out, so the
+// instance isn't left attached with the processes of the group suspended.
+func (a *autoScalingGroup) readinessFitsDeadline(now time.Time) error {
+	deadline := a.region.conf.deadline
+	needed := a.readinessDuration()
+	if deadline.IsZero() || needed == 0 {
+		return nil
+	}
+
+	if left := deadline.Sub(now); needed+readinessDeadlineMargin > left {
+		return fmt.Errorf("the readiness checks take up to %s, which doesn't fit the %s left before the Lambda timeout",
+			needed, left.Round(time.Second))
+	}
+	return nil
+}
+
+// waitUntilReady runs the readiness checks configured for the group which run
+// either before or after attaching the instance, retrying each of them until
+// it passes or its timeout expires.
+func (a *autoScalingGroup) waitUntilReady(i *instance, afterAttach bool) error {
+	checks, err := parseReadinessChecks(a.config.ReadinessChecks, a.readinessTimeout())
+	if err != nil {
+		log.Println(a.region.name, a.name, "Ignoring the readiness checks:", err.Error())
+		return nil
+	}
+
+	if !afterAttach {
+		if err := a.readinessFitsDeadline(time.Now()); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range checks {
+		if c.check.afterAttach() != afterAttach {
+			continue
+		}
+		if err := a.waitForReadinessCheck(i, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *autoScalingGroup) waitForReadinessCheck(i *instance, c configuredReadinessCheck) error {
+	log.Println(a.region.name, a.name, "Waiting up to", c.timeout, "for instance",
+		*i.InstanceId, "to pass the", c.name, "readiness check")
+
+	deadline := time.Now().Add(c.timeout)
+	if lambda := a.region.conf.deadline; !lambda.IsZero() && lambda.Add(-readinessDeadlineMargin).Before(deadline) {
+		deadline = lambda.Add(-readinessDeadlineMargin)
+	}
+	for {
+		ready, err := c.check.ready(a, i)
+		if err != nil {
+			debug.Println(a.name, *i.InstanceId, c.name, "readiness check failed:", err.Error())
+		}
+		if ready {
+			log.Println(a.region.name, a.name, "Instance", *i.InstanceId, "passed the", c.name, "readiness check")
+			return nil
+		}
+
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("instance %s didn't pass the %s readiness check within %s",
+				*i.InstanceId, c.name, c.timeout)
+		}
+		time.Sleep(readinessPollInterval * a.region.conf.SleepMultiplier)
+	}
+}
+
+func (a *autoScalingGroup) loadReadinessChecks() bool {
+	a.config.ReadinessChecks = a.region.conf.ReadinessChecks
+	a.config.ReadinessTimeout = a.region.conf.ReadinessTimeout
+	a.config.ReadinessHTTPProbe = a.region.conf.ReadinessHTTPProbe
+	found := false
+
+	if tagValue := a.getTagValue(ReadinessChecksTag); tagValue != nil {
+		if _, err := parseReadinessChecks(*tagValue, 0); err == nil {
+			log.Printf("Loaded ReadinessChecks value %v from tag %v\n", *tagValue, ReadinessChecksTag)
+			a.config.ReadinessChecks = *tagValue
+			found = true
+		} else {
+			log.Printf("Ignoring ReadinessChecks value from tag %v: %s\n", ReadinessChecksTag, err.Error())
+		}
+	}
+
+	if tagValue := a.getTagValue(ReadinessTimeoutTag); tagValue != nil {
+		if timeout, err := time.ParseDuration(*tagValue); err == nil && timeout >= 0 {
+			log.Printf("Loaded ReadinessTimeout value %v from tag %v\n", *tagValue, ReadinessTimeoutTag)
+			a.config.ReadinessTimeout = *tagValue
+			found = true
+		} else {
+			log.Printf("Ignoring invalid ReadinessTimeout value %v from tag %v\n", *tagValue, ReadinessTimeoutTag)
+		}
+	}
+
+	if tagValue := a.getTagValue(ReadinessHTTPProbeTag); tagValue != nil {
+		log.Printf("Loaded ReadinessHTTPProbe value %v from tag %v\n", *tagValue, ReadinessHTTPProbeTag)
+		a.config.ReadinessHTTPProbe = *tagValue
+		found = true
+	}
+	return found
+}
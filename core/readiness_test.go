@@ -0,0 +1,256 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+func Test_parseReadinessChecks(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]time.Duration
+		wantErr bool
+	}{
+		{value: "", want: map[string]time.Duration{}},
+		{
+			value: "ec2-status, target-group=10m",
+			want:  map[string]time.Duration{"ec2-status": 5 * time.Minute, "target-group": 10 * time.Minute},
+		},
+		{value: "http=0s", want: map[string]time.Duration{"http": 0}},
+		{value: "ec2-status,ping", wantErr: true},
+		{value: "http=soon", wantErr: true},
+		{value: "http=-1m", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			checks, err := parseReadinessChecks(tt.value, 5*time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseReadinessChecks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got := map[string]time.Duration{}
+			for _, c := range checks {
+				if c.check != readinessChecks[c.name] {
+					t.Errorf("parseReadinessChecks() returned the wrong check for %s", c.name)
+				}
+				got[c.name] = c.timeout
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseReadinessChecks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_readinessProbeURL(t *testing.T) {
+	tests := []struct {
+		probe string
+		want  string
+	}{
+		{probe: "", want: "http://10.0.0.1:80/"},
+		{probe: "8080/health", want: "http://10.0.0.1:8080/health"},
+		{probe: "/health", want: "http://10.0.0.1:80/health"},
+		{probe: "8080", want: "http://10.0.0.1:8080/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.probe, func(t *testing.T) {
+			if got := readinessProbeURL("10.0.0.1", tt.probe); got != tt.want {
+				t.Errorf("readinessProbeURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_readinessCheck_ready(t *testing.T) {
+	status := func(instance, system string) *ec2.DescribeInstanceStatusOutput {
+		return &ec2.DescribeInstanceStatusOutput{InstanceStatuses: []*ec2.InstanceStatus{{
+			InstanceStatus: &ec2.InstanceStatusSummary{Status: aws.String(instance)},
+			SystemStatus:   &ec2.InstanceStatusSummary{Status: aws.String(system)},
+		}}}
+	}
+	health := func(state, reason string) *elbv2.DescribeTargetHealthOutput {
+		return &elbv2.DescribeTargetHealthOutput{TargetHealthDescriptions: []*elbv2.TargetHealthDescription{{
+			TargetHealth: &elbv2.TargetHealth{State: aws.String(state), Reason: aws.String(reason)},
+		}}}
+	}
+
+	tests := []struct {
+		name     string
+		check    readinessCheck
+		services connections
+		want     bool
+		wantErr  bool
+	}{
+		{
+			name:     "status checks passed",
+			check:    ec2StatusCheck{},
+			services: connections{ec2: mockEC2{disto: status("ok", "ok")}},
+			want:     true,
+		},
+		{
+			name:     "status checks initializing",
+			check:    ec2StatusCheck{},
+			services: connections{ec2: mockEC2{disto: status("initializing", "ok")}},
+		},
+		{
+			name:     "status not reported yet",
+			check:    ec2StatusCheck{},
+			services: connections{ec2: mockEC2{disto: &ec2.DescribeInstanceStatusOutput{}}},
+		},
+		{
+			name:     "status error",
+			check:    ec2StatusCheck{},
+			services: connections{ec2: mockEC2{disterr: errors.New("throttled")}},
+			wantErr:  true,
+		},
+		{
+			name:     "healthy target",
+			check:    targetGroupCheck{},
+			services: connections{elbv2: mockELBV2{dtho: health("healthy", "")}},
+			want:     true,
+		},
+		{
+			name:     "target in unused target group",
+			check:    targetGroupCheck{},
+			services: connections{elbv2: mockELBV2{dtho: health("unused", "Target.NotInUse")}},
+			want:     true,
+		},
+		{
+			name:     "target not registered yet",
+			check:    targetGroupCheck{},
+			services: connections{elbv2: mockELBV2{dtho: health("unused", "Target.NotRegistered")}},
+		},
+		{
+			name:     "target still initializing",
+			check:    targetGroupCheck{},
+			services: connections{elbv2: mockELBV2{dtho: health("initial", "Elb.RegistrationInProgress")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{
+					TargetGroupARNs: []*string{aws.String("arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/tg/1")},
+				},
+				name:   "asg",
+				region: &region{name: "us-east-1", services: tt.services},
+			}
+			i := &instance{Instance: &ec2.Instance{InstanceId: aws.String("i-1")}}
+
+			got, err := tt.check.ready(a, i)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ready() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ready() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_httpProbeCheck_ready(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	host, port, _ := net.SplitHostPort(u.Host)
+
+	tests := []struct {
+		probe string
+		want  bool
+	}{
+		{probe: port + "/health", want: true},
+		{probe: port + "/starting"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.probe, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name:   "asg",
+				config: AutoScalingConfig{ReadinessHTTPProbe: tt.probe},
+			}
+			i := &instance{Instance: &ec2.Instance{InstanceId: aws.String("i-1"), PrivateIpAddress: aws.String(host)}}
+
+			if got, err := (httpProbeCheck{}).ready(a, i); got != tt.want || err != nil {
+				t.Errorf("ready() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_waitUntilReady(t *testing.T) {
+	tests := []struct {
+		name        string
+		checks      string
+		afterAttach bool
+		lambdaLeft  time.Duration
+		wantErr     bool
+	}{
+		{name: "no checks"},
+		{name: "passing check", checks: "ec2-status=0s"},
+		{name: "failing check", checks: "target-group=0s", afterAttach: true, wantErr: true},
+		{name: "after attach check skipped before attaching", checks: "target-group=0s"},
+		{name: "invalid checks ignored", checks: "ping"},
+		{name: "checks fitting the Lambda timeout", checks: "ec2-status=5m", lambdaLeft: 10 * time.Minute},
+		{
+			name:       "checks not fitting the Lambda timeout",
+			checks:     "ec2-status=0s,target-group=10m",
+			lambdaLeft: 10 * time.Minute,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{
+					TargetGroupARNs: []*string{aws.String("arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/tg/1")},
+				},
+				name:   "asg",
+				config: AutoScalingConfig{ReadinessChecks: tt.checks},
+				region: &region{
+					name: "us-east-1",
+					conf: &Config{},
+					services: connections{
+						ec2: mockEC2{disto: &ec2.DescribeInstanceStatusOutput{InstanceStatuses: []*ec2.InstanceStatus{{
+							InstanceStatus: &ec2.InstanceStatusSummary{Status: aws.String("ok")},
+							SystemStatus:   &ec2.InstanceStatusSummary{Status: aws.String("ok")},
+						}}}},
+						elbv2: mockELBV2{dtho: &elbv2.DescribeTargetHealthOutput{
+							TargetHealthDescriptions: []*elbv2.TargetHealthDescription{{
+								TargetHealth: &elbv2.TargetHealth{State: aws.String("unhealthy")},
+							}},
+						}},
+					},
+				},
+			}
+			if tt.lambdaLeft > 0 {
+				a.region.conf.deadline = time.Now().Add(tt.lambdaLeft)
+			}
+			i := &instance{Instance: &ec2.Instance{InstanceId: aws.String("i-1")}}
+
+			if err := a.waitUntilReady(i, tt.afterAttach); (err != nil) != tt.wantErr {
+				t.Errorf("waitUntilReady() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
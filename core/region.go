@@ -34,6 +34,19 @@ type region struct {
 	// The key in this map is the instance type.
 	instanceTypeInformation map[string]instanceTypeInformation
 
+	// Instance type information using the prices of other operating systems
+	// than the globally configured one, keyed by the Spot product description
+	// and then by the instance type.
+	osInstanceTypeInformation map[string]map[string]instanceTypeInformation
+
+	// osInstanceTypeInformationMutex guards osInstanceTypeInformation, which
+	// is lazily filled from the goroutines processing the groups.
+	osInstanceTypeInformationMutex sync.Mutex
+
+	// IDs of the on-demand instances estimated to be covered by Reserved
+	// Instances or Savings Plans, which are left in place.
+	coveredInstances map[string]bool
+
 	instances instances
 
 	enabledASGs []autoScalingGroup
@@ -77,7 +90,9 @@ func (r *region) enabled() bool {
 	return false
 }
 
-func (r *region) processRegion() {
+// scanRegion fetches the enabled groups of the region and the data needed for
+// processing them, returning whether there are any enabled groups.
+func (r *region) scanRegion() bool {
 
 	log.Println("Creating connections to the required AWS services in", r.name)
 	r.services.connect(r.name, r.conf.MainRegion)
@@ -101,11 +116,13 @@ func (r *region) processRegion() {
 			log.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
 		}
 
-		log.Println("Processing enabled AutoScaling groups in", r.name)
-		r.processEnabledAutoScalingGroups()
-	} else {
-		log.Println(r.name, "has no enabled AutoScaling groups")
+		r.determineReservationCoverage()
+		r.registerReplacementUsage(time.Now())
+		return true
 	}
+
+	log.Println(r.name, "has no enabled AutoScaling groups")
+	return false
 }
 
 func (r *region) setupAsgFilters() {
@@ -219,7 +236,27 @@ func (r *region) addInstance(inst *ec2.Instance) {
 
 func (r *region) determineInstanceTypeInformation(cfg *Config) {
 
-	r.instanceTypeInformation = make(map[string]instanceTypeInformation)
+	r.instanceTypeInformation = r.buildInstanceTypeInformation(cfg, osLinux, r.conf.SpotProductPremium)
+
+	// this is safe to do once outside of the loop because the call will only
+	// return entries about the available instance types, so no invalid instance
+	// types would be returned
+
+	if err := r.requestSpotPrices(r.conf.SpotProductDescription, r.instanceTypeInformation); err != nil {
+		log.Println(err.Error())
+	}
+
+}
+
+// buildInstanceTypeInformation populates the hardware specs and on-demand
+// prices of the instance types available in the region, using the on-demand
+// prices of the given operating system from the configured pricing provider.
+func (r *region) buildInstanceTypeInformation(cfg *Config, os *operatingSystem,
+	premium float64) map[string]instanceTypeInformation {
+
+	result := make(map[string]instanceTypeInformation)
+	pricing := r.pricingProvider()
+	specs := r.instanceTypeSpecs()
 
 	var info instanceTypeInformation
 
@@ -228,10 +265,11 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 		var price prices
 
 		// populate on-demand information
-		price.onDemand = it.Pricing[r.name].Linux.OnDemand * cfg.OnDemandPriceMultiplier
+		onDemand, _ := pricing.onDemandPrice(r.name, it.InstanceType, os)
+		price.onDemand = onDemand * cfg.OnDemandPriceMultiplier
 		price.spot = make(spotPriceMap)
 		price.ebsSurcharge = it.Pricing[r.name].EBSSurcharge
-		price.premium = r.conf.SpotProductPremium
+		price.premium = premium
 
 		// if at this point the instance price is still zero, then that
 		// particular instance type doesn't even exist in the current
@@ -249,6 +287,7 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 				virtualizationTypes: it.LinuxVirtualizationTypes,
 				hasEBSOptimization:  it.EBSOptimized,
 				EBSThroughput:       it.EBSThroughput,
+				networkPerformance:  it.NetworkPerformance,
 			}
 
 			if it.Storage != nil {
@@ -257,26 +296,47 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 				info.instanceStoreDeviceCount = it.Storage.Devices
 				info.instanceStoreIsSSD = it.Storage.SSD
 			}
-			r.instanceTypeInformation[it.InstanceType] = info
+			info.mergeSpecs(specs[it.InstanceType])
+			info.accelerator = acceleratorOf(it.InstanceType, info.GPU, info.specs)
+			result[it.InstanceType] = info
 		}
 	}
-	// this is safe to do once outside of the loop because the call will only
-	// return entries about the available instance types, so no invalid instance
-	// types would be returned
 
-	if err := r.requestSpotPrices(); err != nil {
-		log.Println(err.Error())
-	}
+	// the instance types missing from the bundled data can only be compared
+	// with the others when their hardware specs are returned by the EC2 API
+	if lister, ok := pricing.(instanceTypeLister); ok && len(specs) > 0 {
+		for _, instanceType := range lister.instanceTypes(r.name) {
+			if _, found := result[instanceType]; found || specs[instanceType] == nil {
+				continue
+			}
+
+			onDemand, _ := pricing.onDemandPrice(r.name, instanceType, os)
+			if onDemand <= 0 {
+				continue
+			}
 
+			info = instanceTypeInformation{
+				instanceType: instanceType,
+				pricing: prices{
+					onDemand: onDemand * cfg.OnDemandPriceMultiplier,
+					spot:     make(spotPriceMap),
+					premium:  premium,
+				},
+			}
+			info.mergeSpecs(specs[instanceType])
+			info.accelerator = acceleratorOf(instanceType, info.GPU, info.specs)
+			result[instanceType] = info
+		}
+	}
+	return result
 }
 
-func (r *region) requestSpotPrices() error {
+func (r *region) requestSpotPrices(product string, typeInformation map[string]instanceTypeInformation) error {
 
 	s := spotPrices{conn: r.services}
 
-	// Retrieve all current spot prices from the current region.
-	// TODO: add support for other OSes
-	err := s.fetch(r.conf.SpotProductDescription, 0, nil, nil)
+	// Retrieve all current spot prices of the given product from the current region.
+	err := s.fetch(product, 0, nil, nil)
 
 	if err != nil {
 		return errors.New("Couldn't fetch spot prices in " + r.name)
@@ -297,13 +357,13 @@ func (r *region) requestSpotPrices() error {
 			continue
 		}
 
-		if r.instanceTypeInformation[instType].pricing.spot == nil {
+		if typeInformation[instType].pricing.spot == nil {
 			debug.Println(r.name, "Instance data missing for", instType, "in", az,
 				"skipping because this region is currently not supported")
 			continue
 		}
 
-		r.instanceTypeInformation[instType].pricing.spot[az] = price
+		typeInformation[instType].pricing.spot[az] = price
 
 	}
 
@@ -388,20 +448,23 @@ func (r *region) findMatchingASGsInPageOfResults(groups []*autoscaling.Group,
 	for _, group := range groups {
 		asgName := *group.AutoScalingGroupName
 
-		if group.MixedInstancesPolicy != nil {
-			debug.Printf("Skipping group %s because it's using a mixed instances policy",
-				asgName)
-			continue
+		asg := autoScalingGroup{
+			Group:  group,
+			name:   asgName,
+			region: r,
 		}
 
 		groupMatchesExpectedTags := isASGWithMatchingTags(group, tagsToMatch)
-		// Go lacks a logical XOR operator, this is the equivalent to that logical
-		// expression. The goal is to add the matching ASGs when running in opt-in
-		// mode and the other way round.
-		if optInFilterMode != groupMatchesExpectedTags {
-			debug.Printf("Skipping group %s because its tags, the currently "+
-				"configured filtering mode (%s) and tag filters do not align\n",
-				asgName, r.conf.TagFilteringMode)
+		enabled, rule := r.conf.configFile.groupEnabled(&asg, optInFilterMode, groupMatchesExpectedTags)
+		if !enabled {
+			if rule != "" {
+				debug.Printf("Skipping group %s because it is disabled by the "+
+					"configuration file %s\n", asgName, rule)
+			} else {
+				debug.Printf("Skipping group %s because its tags, the currently "+
+					"configured filtering mode (%s) and tag filters do not align\n",
+					asgName, r.conf.TagFilteringMode)
+			}
 			continue
 		}
 
@@ -414,14 +477,15 @@ func (r *region) findMatchingASGsInPageOfResults(groups []*autoscaling.Group,
 			}
 		}
 
-		log.Printf("Enabling group %s for processing because its tags, the "+
-			"currently configured  filtering mode (%s) and tag filters are aligned\n",
-			asgName, r.conf.TagFilteringMode)
-		asgs = append(asgs, autoScalingGroup{
-			Group:  group,
-			name:   asgName,
-			region: r,
-		})
+		if rule != "" {
+			log.Printf("Enabling group %s for processing because it is enabled "+
+				"by the configuration file %s\n", asgName, rule)
+		} else {
+			log.Printf("Enabling group %s for processing because its tags, the "+
+				"currently configured  filtering mode (%s) and tag filters are aligned\n",
+				asgName, r.conf.TagFilteringMode)
+		}
+		asgs = append(asgs, asg)
 	}
 	return asgs
 }
@@ -0,0 +1,235 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// reservation_coverage.go contains the logic used for estimating which of the
+// running on-demand instances are covered by Reserved Instances or a Savings
+// Plan, so they are left in place instead of being replaced with Spot
+// instances, which would leave the reservation unused.
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// reservedCapacity is the number of instances of a given type that can be
+// covered by a Reserved Instance purchase.
+type reservedCapacity struct {
+	instanceType string
+
+	// empty for the regional Reserved Instances, which apply in any
+	// availability zone
+	availabilityZone string
+
+	// nil if the product description isn't supported, in which case it matches
+	// any operating system
+	os *operatingSystem
+
+	count int64
+}
+
+func (r *region) reservationCoverageEnabled() bool {
+	return r.conf != nil &&
+		(r.conf.EnableReservationCoverage || r.conf.SavingsPlanHourlyCommitment > 0)
+}
+
+// loadReservedInstances returns the active Reserved Instances of the region,
+// having the zonal ones first since they can only cover their own
+// availability zone.
+func (r *region) loadReservedInstances() ([]*reservedCapacity, error) {
+	resp, err := r.services.ec2.DescribeReservedInstances(&ec2.DescribeReservedInstancesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("state"),
+				Values: []*string{aws.String(ec2.ReservedInstanceStateActive)},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*reservedCapacity
+	for _, ri := range resp.ReservedInstances {
+		rc := &reservedCapacity{
+			instanceType: aws.StringValue(ri.InstanceType),
+			count:        aws.Int64Value(ri.InstanceCount),
+			os: operatingSystemFromPlatform(aws.String(
+				strings.TrimSuffix(aws.StringValue(ri.ProductDescription), " (Amazon VPC)")), nil),
+		}
+		if aws.StringValue(ri.Scope) == ec2.ScopeAvailabilityZone {
+			rc.availabilityZone = aws.StringValue(ri.AvailabilityZone)
+		}
+		result = append(result, rc)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].availabilityZone != "" && result[j].availabilityZone == ""
+	})
+	return result, nil
+}
+
+func (rc *reservedCapacity) covers(i *instance) bool {
+	if rc.count <= 0 || rc.instanceType != aws.StringValue(i.InstanceType) {
+		return false
+	}
+
+	if rc.availabilityZone != "" &&
+		(i.Placement == nil || rc.availabilityZone != aws.StringValue(i.Placement.AvailabilityZone)) {
+		return false
+	}
+
+	if rc.os == nil {
+		return true
+	}
+
+	os := operatingSystemFromPlatform(i.PlatformDetails, i.UsageOperation)
+	if os == nil {
+		os = osLinux
+	}
+	return rc.os == os
+}
+
+// onDemandInstancesByCoveragePriority returns the running on-demand instances
+// of the region in the order in which they consume the reservations. The
+// instances outside of the enabled groups come first, since AutoSpotting never
+// replaces them, so only the reservations left after covering them would be
+// wasted by replacing the instances of the enabled groups.
+func (r *region) onDemandInstancesByCoveragePriority() []*instance {
+	var result []*instance
+	for i := range r.instances.instances() {
+		if i.State == nil || aws.StringValue(i.State.Name) != ec2.InstanceStateNameRunning || i.isSpot() {
+			continue
+		}
+		result = append(result, i)
+	}
+
+	sort.Slice(result, func(a, b int) bool {
+		ea, eb := r.enabledASGOf(result[a]) != "", r.enabledASGOf(result[b]) != ""
+		if ea != eb {
+			return !ea
+		}
+		return aws.StringValue(result[a].InstanceId) < aws.StringValue(result[b].InstanceId)
+	})
+	return result
+}
+
+// determineReservationCoverage estimates which of the running on-demand
+// instances are covered by the active Reserved Instances and then by the
+// configured Savings Plan commitment, which is compared against their
+// on-demand price.
+func (r *region) determineReservationCoverage() {
+	r.coveredInstances = make(map[string]bool)
+
+	if !r.reservationCoverageEnabled() {
+		return
+	}
+
+	candidates := r.onDemandInstancesByCoveragePriority()
+
+	if r.conf.EnableReservationCoverage {
+		reservations, err := r.loadReservedInstances()
+		if err != nil {
+			log.Println(r.name, "Failed to describe the Reserved Instances", err.Error())
+		}
+
+		for _, rc := range reservations {
+			for _, i := range candidates {
+				if !r.coveredInstances[*i.InstanceId] && rc.covers(i) {
+					r.coveredInstances[*i.InstanceId] = true
+					rc.count--
+				}
+			}
+		}
+	}
+
+	commitment := r.conf.SavingsPlanHourlyCommitment
+	for _, i := range candidates {
+		price := i.typeInfo.pricing.onDemand
+		if r.coveredInstances[*i.InstanceId] || price <= 0 || price > commitment {
+			continue
+		}
+		r.coveredInstances[*i.InstanceId] = true
+		commitment -= price
+	}
+
+	r.reportReservationCoverage(candidates)
+}
+
+// reportReservationCoverage adds to the final recap the on-demand instances of
+// the enabled groups that were left in place because of their coverage.
+func (r *region) reportReservationCoverage(candidates []*instance) {
+	var count int
+	var hourlyCost float64
+
+	for _, i := range candidates {
+		asgName := r.enabledASGOf(i)
+		if asgName == "" || !r.coveredInstances[*i.InstanceId] {
+			continue
+		}
+		debug.Println(r.name, "on-demand instance", *i.InstanceId, "of", asgName,
+			"is covered by Reserved Instances or Savings Plans")
+		count++
+		hourlyCost += i.typeInfo.pricing.onDemand
+	}
+
+	log.Println(r.name, "Found", count, "on-demand instances of enabled groups covered by reservations")
+
+	if count == 0 || r.conf.FinalRecap == nil {
+		return
+	}
+
+	recapText := fmt.Sprintf("Left %d on-demand instances in place, covered by Reserved Instances "+
+		"or Savings Plans worth $%.4f/hour", count, hourlyCost)
+	r.conf.FinalRecap[r.name] = append(r.conf.FinalRecap[r.name], recapText)
+}
+
+// enabledASGOf returns the name of the enabled group the instance belongs to,
+// or an empty string if it doesn't belong to any.
+func (r *region) enabledASGOf(i *instance) string {
+	belongs, asgName := i.belongsToAnASG()
+	if !belongs {
+		return ""
+	}
+	for _, asg := range r.enabledASGs {
+		if asg.name == *asgName {
+			return asg.name
+		}
+	}
+	return ""
+}
+
+func (i *instance) isCoveredByReservation() bool {
+	return i.region != nil && i.region.coveredInstances[aws.StringValue(i.InstanceId)]
+}
+
+// coveredByReservationOnLaunch determines the coverage of the instances of the
+// region before replacing a newly launched on-demand instance, since the
+// launch events are handled outside of the regular runs which compute it.
+// It expects the instances and the enabled groups of the region to be scanned.
+func (i *instance) coveredByReservationOnLaunch() bool {
+	if i.region == nil || !i.region.reservationCoverageEnabled() {
+		return false
+	}
+	i.region.determineReservationCoverage()
+	return i.isCoveredByReservation()
+}
+
+// coveredOnDemandInstanceCount returns the number of running on-demand
+// instances of the group covered by Reserved Instances or Savings Plans.
+func (a *autoScalingGroup) coveredOnDemandInstanceCount() int64 {
+	var count int64
+	for i := range a.instances.instances() {
+		if aws.StringValue(i.State.Name) == ec2.InstanceStateNameRunning &&
+			!i.isSpot() && i.isCoveredByReservation() {
+			count++
+		}
+	}
+	return count
+}
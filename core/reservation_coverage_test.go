@@ -0,0 +1,298 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func coverageInstance(id, instanceType, az, asgName string, onDemandPrice float64) *instance {
+	i := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:   aws.String(id),
+			InstanceType: aws.String(instanceType),
+			Placement:    &ec2.Placement{AvailabilityZone: aws.String(az)},
+			State:        &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		},
+		typeInfo: instanceTypeInformation{
+			instanceType: instanceType,
+			pricing:      prices{onDemand: onDemandPrice},
+		},
+	}
+	if asgName != "" {
+		i.Tags = []*ec2.Tag{{Key: aws.String("aws:autoscaling:groupName"), Value: aws.String(asgName)}}
+	}
+	return i
+}
+
+func Test_region_determineReservationCoverage(t *testing.T) {
+	regionalRI := &ec2.ReservedInstances{
+		InstanceType:       aws.String("m5.large"),
+		InstanceCount:      aws.Int64(1),
+		ProductDescription: aws.String("Linux/UNIX (Amazon VPC)"),
+		Scope:              aws.String(ec2.ScopeRegion),
+	}
+	zonalRI := &ec2.ReservedInstances{
+		InstanceType:       aws.String("m5.large"),
+		InstanceCount:      aws.Int64(1),
+		ProductDescription: aws.String("Linux/UNIX"),
+		Scope:              aws.String(ec2.ScopeAvailabilityZone),
+		AvailabilityZone:   aws.String("us-east-1b"),
+	}
+	windowsRI := &ec2.ReservedInstances{
+		InstanceType:       aws.String("m5.large"),
+		InstanceCount:      aws.Int64(2),
+		ProductDescription: aws.String("Windows"),
+		Scope:              aws.String(ec2.ScopeRegion),
+	}
+
+	tests := []struct {
+		name         string
+		conf         *Config
+		reservations []*ec2.ReservedInstances
+		drierr       error
+		instances    []*instance
+		want         map[string]bool
+		wantRecap    []string
+	}{
+		{
+			name: "disabled",
+			conf: &Config{},
+			instances: []*instance{
+				coverageInstance("i-1", "m5.large", "us-east-1a", "asg", 0.096),
+			},
+			reservations: []*ec2.ReservedInstances{regionalRI},
+			want:         map[string]bool{},
+		},
+		{
+			name: "regional reservation",
+			conf: &Config{EnableReservationCoverage: true},
+			instances: []*instance{
+				coverageInstance("i-1", "m5.large", "us-east-1a", "asg", 0.096),
+				coverageInstance("i-2", "m5.large", "us-east-1a", "asg", 0.096),
+				coverageInstance("i-3", "c5.large", "us-east-1a", "asg", 0.085),
+			},
+			reservations: []*ec2.ReservedInstances{regionalRI},
+			want:         map[string]bool{"i-1": true},
+			wantRecap: []string{"Left 1 on-demand instances in place, covered by Reserved Instances " +
+				"or Savings Plans worth $0.0960/hour"},
+		},
+		{
+			name: "zonal reservation applied first",
+			conf: &Config{EnableReservationCoverage: true},
+			instances: []*instance{
+				coverageInstance("i-1", "m5.large", "us-east-1a", "asg", 0.096),
+				coverageInstance("i-2", "m5.large", "us-east-1b", "asg", 0.096),
+			},
+			reservations: []*ec2.ReservedInstances{regionalRI, zonalRI},
+			want:         map[string]bool{"i-1": true, "i-2": true},
+			wantRecap: []string{"Left 2 on-demand instances in place, covered by Reserved Instances " +
+				"or Savings Plans worth $0.1920/hour"},
+		},
+		{
+			name: "instances outside of the enabled groups are covered first",
+			conf: &Config{EnableReservationCoverage: true},
+			instances: []*instance{
+				coverageInstance("i-1", "m5.large", "us-east-1a", "asg", 0.096),
+				coverageInstance("i-2", "m5.large", "us-east-1a", "", 0.096),
+			},
+			reservations: []*ec2.ReservedInstances{regionalRI},
+			want:         map[string]bool{"i-2": true},
+		},
+		{
+			name: "reservation for another operating system",
+			conf: &Config{EnableReservationCoverage: true},
+			instances: []*instance{
+				coverageInstance("i-1", "m5.large", "us-east-1a", "asg", 0.096),
+			},
+			reservations: []*ec2.ReservedInstances{windowsRI},
+			want:         map[string]bool{},
+		},
+		{
+			name: "savings plan commitment",
+			conf: &Config{SavingsPlanHourlyCommitment: 0.2},
+			instances: []*instance{
+				coverageInstance("i-1", "m5.large", "us-east-1a", "asg", 0.096),
+				coverageInstance("i-2", "m5.xlarge", "us-east-1a", "asg", 0.192),
+				coverageInstance("i-3", "c5.large", "us-east-1a", "asg", 0.085),
+			},
+			want: map[string]bool{"i-1": true, "i-3": true},
+			wantRecap: []string{"Left 2 on-demand instances in place, covered by Reserved Instances " +
+				"or Savings Plans worth $0.1810/hour"},
+		},
+		{
+			name: "reservations and savings plan",
+			conf: &Config{EnableReservationCoverage: true, SavingsPlanHourlyCommitment: 0.1},
+			instances: []*instance{
+				coverageInstance("i-1", "m5.large", "us-east-1a", "asg", 0.096),
+				coverageInstance("i-2", "m5.large", "us-east-1a", "asg", 0.096),
+				coverageInstance("i-3", "m5.large", "us-east-1a", "asg", 0.096),
+			},
+			reservations: []*ec2.ReservedInstances{regionalRI},
+			want:         map[string]bool{"i-1": true, "i-2": true},
+			wantRecap: []string{"Left 2 on-demand instances in place, covered by Reserved Instances " +
+				"or Savings Plans worth $0.1920/hour"},
+		},
+		{
+			name: "failure to describe the reservations",
+			conf: &Config{EnableReservationCoverage: true},
+			instances: []*instance{
+				coverageInstance("i-1", "m5.large", "us-east-1a", "asg", 0.096),
+			},
+			drierr: errors.New("access denied"),
+			want:   map[string]bool{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.conf.FinalRecap = make(map[string][]string)
+
+			im := make(instanceMap)
+			for _, i := range tt.instances {
+				im[*i.InstanceId] = i
+			}
+
+			r := &region{
+				name:        "us-east-1",
+				conf:        tt.conf,
+				instances:   makeInstancesWithCatalog(im),
+				enabledASGs: []autoScalingGroup{{name: "asg"}},
+				services: connections{
+					ec2: mockEC2{
+						drio:   &ec2.DescribeReservedInstancesOutput{ReservedInstances: tt.reservations},
+						drierr: tt.drierr,
+					},
+				},
+			}
+
+			r.determineReservationCoverage()
+
+			if !reflect.DeepEqual(r.coveredInstances, tt.want) {
+				t.Errorf("determineReservationCoverage() covered %v, want %v", r.coveredInstances, tt.want)
+			}
+			if !reflect.DeepEqual(tt.conf.FinalRecap[r.name], tt.wantRecap) {
+				t.Errorf("determineReservationCoverage() recap %v, want %v", tt.conf.FinalRecap[r.name], tt.wantRecap)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_coveredOnDemandInstances(t *testing.T) {
+	r := &region{
+		coveredInstances: map[string]bool{"i-1": true},
+		services:         connections{ec2: mockEC2{}},
+	}
+
+	covered := coverageInstance("i-1", "m5.large", "us-east-1a", "asg", 0.096)
+	uncovered := coverageInstance("i-2", "m5.large", "us-east-1a", "asg", 0.096)
+	covered.region, uncovered.region = r, r
+
+	tests := []struct {
+		name        string
+		minOnDemand int64
+		instances   instanceMap
+		wantNeed    bool
+		wantTarget  *instance
+	}{
+		{
+			name:        "uncovered instance replaced",
+			minOnDemand: 0,
+			instances:   instanceMap{"i-1": covered, "i-2": uncovered},
+			wantNeed:    true,
+			wantTarget:  uncovered,
+		},
+		{
+			name:        "covered instances count towards the minimum",
+			minOnDemand: 1,
+			instances:   instanceMap{"i-1": covered, "i-2": uncovered},
+			wantNeed:    true,
+			wantTarget:  uncovered,
+		},
+		{
+			name:        "only covered instances",
+			minOnDemand: 0,
+			instances:   instanceMap{"i-1": covered},
+			wantNeed:    false,
+			wantTarget:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name:      "asg",
+				region:    r,
+				instances: makeInstancesWithCatalog(tt.instances),
+				config:    AutoScalingConfig{MinOnDemand: tt.minOnDemand},
+			}
+
+			if gotNeed, _ := a.needReplaceOnDemandInstances(); gotNeed != tt.wantNeed {
+				t.Errorf("needReplaceOnDemandInstances() = %v, want %v", gotNeed, tt.wantNeed)
+			}
+			if got := a.getAnyUnprotectedOnDemandInstance(); got != tt.wantTarget {
+				t.Errorf("getAnyUnprotectedOnDemandInstance() = %v, want %v", got, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func Test_instance_coveredByReservationOnLaunch(t *testing.T) {
+	tests := []struct {
+		name          string
+		conf          *Config
+		reservedCount int64
+		want          bool
+	}{
+		{
+			name:          "coverage disabled",
+			conf:          &Config{},
+			reservedCount: 2,
+			want:          false,
+		},
+		{
+			name:          "reservation left for the new instance",
+			conf:          &Config{EnableReservationCoverage: true},
+			reservedCount: 2,
+			want:          true,
+		},
+		{
+			name:          "reservation used by the existing instance",
+			conf:          &Config{EnableReservationCoverage: true},
+			reservedCount: 1,
+			want:          false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing := coverageInstance("i-1", "m5.large", "us-east-1a", "asg", 0.096)
+			launched := coverageInstance("i-2", "m5.large", "us-east-1a", "asg", 0.096)
+
+			r := &region{
+				name:        "us-east-1",
+				conf:        tt.conf,
+				instances:   makeInstancesWithCatalog(instanceMap{"i-1": existing, "i-2": launched}),
+				enabledASGs: []autoScalingGroup{{name: "asg"}},
+				services: connections{
+					ec2: mockEC2{
+						drio: &ec2.DescribeReservedInstancesOutput{ReservedInstances: []*ec2.ReservedInstances{{
+							InstanceType:       aws.String("m5.large"),
+							InstanceCount:      aws.Int64(tt.reservedCount),
+							ProductDescription: aws.String("Linux/UNIX"),
+							Scope:              aws.String(ec2.ScopeRegion),
+						}}},
+					},
+				},
+			}
+			launched.region = r
+
+			if got := launched.coveredByReservationOnLaunch(); got != tt.want {
+				t.Errorf("coveredByReservationOnLaunch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
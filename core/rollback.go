@@ -0,0 +1,316 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// rollback.go contains the logic used for watching the Spot instances swapped
+// into a group for a while, and for rolling back the ones turning unhealthy,
+// in which case their instance type is no longer used for the group.
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+const (
+	// SwapWatchPeriodTag is the name of the tag set on the AutoScaling Group
+	// that can override the global value of the SwapWatchPeriod parameter
+	SwapWatchPeriodTag = "autospotting_swap_watch_period"
+
+	// SwappedInstancesTag is the name of the tag set by AutoSpotting on the
+	// AutoScaling groups to keep track of the Spot instances swapped in during
+	// the watch period, as a space separated list of
+	// instance-id/instance-type/unix-timestamp entries.
+	SwappedInstancesTag = "autospotting_swapped_instances"
+
+	// IncompatibleInstanceTypesTag is the name of the tag set by AutoSpotting
+	// on the AutoScaling groups to list the instance types of the rolled back
+	// Spot instances, which are no longer launched for the group. It can be
+	// edited or removed for using those instance types again.
+	IncompatibleInstanceTypesTag = "autospotting_incompatible_instance_types"
+
+	// DefaultSwapWatchPeriod is the default time during which the swapped
+	// Spot instances are watched, the value 0 disabling the rollback.
+	DefaultSwapWatchPeriod = "0"
+)
+
+// swappedInstance is a Spot instance swapped into a group, as recorded in the
+// SwappedInstancesTag.
+type swappedInstance struct {
+	id           string
+	instanceType string
+	time         time.Time
+}
+
+// parseSwappedInstances decodes the value of the SwappedInstancesTag, ignoring
+// malformed entries and those older than the given window.
+func parseSwappedInstances(value string, now time.Time, window time.Duration) []swappedInstance {
+	var result []swappedInstance
+
+	for _, entry := range strings.Fields(value) {
+		fields := strings.Split(entry, "/")
+		if len(fields) != 3 {
+			debug.Println("Ignoring malformed swapped instance entry", entry)
+			continue
+		}
+
+		ts, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			debug.Println("Ignoring swapped instance entry with invalid timestamp", entry)
+			continue
+		}
+
+		t := time.Unix(ts, 0)
+		if now.Sub(t) > window {
+			continue
+		}
+
+		result = append(result, swappedInstance{id: fields[0], instanceType: fields[1], time: t})
+	}
+	return result
+}
+
+// formatSwappedInstances encodes the swapped instances as the value of the
+// SwappedInstancesTag, keeping the most recent entries that fit the tag value
+// length limit.
+func formatSwappedInstances(swapped []swappedInstance) string {
+	sorted := make([]swappedInstance, len(swapped))
+	copy(sorted, swapped)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].time.Before(sorted[j].time) })
+
+	entries := make([]string, 0, len(sorted))
+	for _, s := range sorted {
+		entries = append(entries, fmt.Sprintf("%s/%s/%d", s.id, s.instanceType, s.time.Unix()))
+	}
+
+	for len(strings.Join(entries, " ")) > maxTagValueLength {
+		entries = entries[1:]
+	}
+	return strings.Join(entries, " ")
+}
+
+func (a *autoScalingGroup) swapWatchPeriod() time.Duration {
+	period, err := time.ParseDuration(a.config.SwapWatchPeriod)
+	if err != nil || period < 0 {
+		return 0
+	}
+	return period
+}
+
+// incompatibleInstanceTypes returns the instance types of the Spot instances
+// rolled back from the group.
+func (a *autoScalingGroup) incompatibleInstanceTypes() []string {
+	if tagValue := a.getTagValue(IncompatibleInstanceTypesTag); tagValue != nil {
+		return strings.Fields(*tagValue)
+	}
+	return nil
+}
+
+// formatIncompatibleInstanceTypes encodes the instance types as the value of
+// the IncompatibleInstanceTypesTag, dropping the oldest entries that don't fit
+// the tag value length limit, since an oversized value would fail the update
+// of all the state tags.
+func formatIncompatibleInstanceTypes(instanceTypes []string) string {
+	entries := instanceTypes
+	for len(strings.Join(entries, " ")) > maxTagValueLength {
+		entries = entries[1:]
+	}
+	return strings.Join(entries, " ")
+}
+
+// excludeIncompatibleInstanceTypes removes the instance types of the rolled
+// back Spot instances from the candidates launched for the group.
+func (i *instance) excludeIncompatibleInstanceTypes(instanceTypes []*string) ([]*string, error) {
+	incompatible := i.asg.incompatibleInstanceTypes()
+	if len(incompatible) == 0 {
+		return instanceTypes, nil
+	}
+
+	var result []*string
+	for _, it := range instanceTypes {
+		if !itemInSlice(*it, incompatible) {
+			result = append(result, it)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, errors.New("all the compatible instance types were rolled back before")
+	}
+	return result, nil
+}
+
+// watchSwappedInstance starts watching a Spot instance swapped into the group.
+func (a *autoScalingGroup) watchSwappedInstance(now time.Time, spot *instance) {
+	period := a.swapWatchPeriod()
+	if period <= 0 {
+		return
+	}
+
+	var swapped []swappedInstance
+	if tagValue := a.getTagValue(SwappedInstancesTag); tagValue != nil {
+		swapped = parseSwappedInstances(*tagValue, now, period)
+	}
+	swapped = append(swapped, swappedInstance{
+		id:           *spot.InstanceId,
+		instanceType: *spot.InstanceType,
+		time:         now,
+	})
+	a.setStateTags(map[string]string{SwappedInstancesTag: formatSwappedInstances(swapped)})
+}
+
+// checkSwappedInstances rolls back the Spot instances swapped into the group
+// during the watch period which turned unhealthy since then, and stops
+// watching the older ones.
+func (a *autoScalingGroup) checkSwappedInstances(now time.Time) {
+	period := a.swapWatchPeriod()
+	tagValue := a.getTagValue(SwappedInstancesTag)
+	if period <= 0 || tagValue == nil || *tagValue == "" {
+		return
+	}
+
+	swapped := parseSwappedInstances(*tagValue, now, period)
+	if len(swapped) == 0 {
+		a.setStateTags(map[string]string{SwappedInstancesTag: ""})
+		return
+	}
+
+	since := swapped[0].time
+	for _, s := range swapped {
+		if s.time.Before(since) {
+			since = s.time
+		}
+	}
+
+	replaced := map[string]bool{}
+	if replacements, err := a.healthReplacements(since); err == nil {
+		for _, r := range replacements {
+			if r.id != "" {
+				replaced[r.id] = true
+			}
+		}
+	}
+
+	var watched []swappedInstance
+	var failed []swappedInstance
+	for _, s := range swapped {
+		if reason := a.swappedInstanceFailure(now, s, replaced); reason != "" {
+			log.Println(a.region.name, a.name, "Swapped Spot instance", s.id, "of type", s.instanceType, reason)
+			failed = append(failed, s)
+			continue
+		}
+		watched = append(watched, s)
+	}
+
+	tags := map[string]string{}
+	if value := formatSwappedInstances(watched); value != *tagValue {
+		tags[SwappedInstancesTag] = value
+	}
+
+	incompatible := a.incompatibleInstanceTypes()
+	for _, s := range failed {
+		if !itemInSlice(s.instanceType, incompatible) {
+			incompatible = append(incompatible, s.instanceType)
+		}
+	}
+	if len(failed) > 0 {
+		tags[IncompatibleInstanceTypesTag] = formatIncompatibleInstanceTypes(incompatible)
+	}
+
+	if len(tags) > 0 {
+		a.setStateTags(tags)
+	}
+
+	for _, s := range failed {
+		a.rollBackSwappedInstance(now, s)
+	}
+}
+
+// swappedInstanceFailure returns the reason for which a watched Spot instance
+// is considered failed, or an empty string if it's healthy.
+func (a *autoScalingGroup) swappedInstanceFailure(now time.Time, s swappedInstance, replaced map[string]bool) string {
+	if replaced[s.id] {
+		return "was replaced by the group because of failed health checks"
+	}
+
+	for _, inst := range a.Instances {
+		if aws.StringValue(inst.InstanceId) != s.id {
+			continue
+		}
+
+		if aws.StringValue(inst.HealthStatus) == "Unhealthy" {
+			return "is marked as unhealthy by the group"
+		}
+
+		// give the application the same time to start as the group does
+		if a.HealthCheckGracePeriod != nil &&
+			now.Sub(s.time) < time.Duration(*a.HealthCheckGracePeriod)*time.Second {
+			return ""
+		}
+		return a.unhealthyTargetGroup(s.id)
+	}
+	return ""
+}
+
+// unhealthyTargetGroup returns why the instance is unhealthy in one of the
+// target groups of the group, or an empty string if it isn't.
+func (a *autoScalingGroup) unhealthyTargetGroup(instanceID string) string {
+	for _, arn := range a.TargetGroupARNs {
+		resp, err := a.region.services.elbv2.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{
+			TargetGroupArn: arn,
+			Targets:        []*elbv2.TargetDescription{{Id: aws.String(instanceID)}},
+		})
+		if err != nil {
+			log.Println(a.region.name, a.name, "Failed to describe the target health in", *arn, err.Error())
+			continue
+		}
+
+		for _, target := range resp.TargetHealthDescriptions {
+			if target.TargetHealth != nil &&
+				aws.StringValue(target.TargetHealth.State) == elbv2.TargetHealthStateEnumUnhealthy {
+				return fmt.Sprintf("is unhealthy in the target group %s (%s)",
+					*arn, aws.StringValue(target.TargetHealth.Reason))
+			}
+		}
+	}
+	return ""
+}
+
+// rollBackSwappedInstance terminates a failed Spot instance still attached to
+// the group without decreasing its capacity, so the group launches an
+// on-demand instance in its place, and counts it as a failed replacement.
+func (a *autoScalingGroup) rollBackSwappedInstance(now time.Time, s swappedInstance) {
+	for _, inst := range a.Instances {
+		if aws.StringValue(inst.InstanceId) == s.id {
+			a.terminateInstanceInAutoScalingGroup(inst.InstanceId, false, false)
+			break
+		}
+	}
+
+	recapText := fmt.Sprintf("%s Rolled back Spot instance %s, no longer using the instance type %s",
+		a.name, s.id, s.instanceType)
+	a.region.conf.FinalRecap[a.region.name] = append(a.region.conf.FinalRecap[a.region.name], recapText)
+
+	a.recordReplacementFailure(now, fmt.Sprintf("rolled back spot instance %s of type %s", s.id, s.instanceType))
+}
+
+func (a *autoScalingGroup) loadSwapWatchPeriod() bool {
+	a.config.SwapWatchPeriod = a.region.conf.SwapWatchPeriod
+
+	if tagValue := a.getTagValue(SwapWatchPeriodTag); tagValue != nil {
+		if period, err := time.ParseDuration(*tagValue); err == nil && period >= 0 {
+			log.Printf("Loaded SwapWatchPeriod value %v from tag %v\n", *tagValue, SwapWatchPeriodTag)
+			a.config.SwapWatchPeriod = *tagValue
+			return true
+		}
+		log.Printf("Ignoring invalid SwapWatchPeriod value %v from tag %v\n", *tagValue, SwapWatchPeriodTag)
+	}
+	return false
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+func Test_parseSwappedInstances(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	value := fmt.Sprintf("i-1/m5.large/%d i-2/c5.large/%d malformed i-3/r5.large/soon",
+		now.Add(-2*time.Hour).Unix(), now.Add(-10*time.Minute).Unix())
+
+	got := parseSwappedInstances(value, now, time.Hour)
+	want := []swappedInstance{{id: "i-2", instanceType: "c5.large", time: now.Add(-10 * time.Minute)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSwappedInstances() = %+v, want %+v", got, want)
+	}
+
+	if got := formatSwappedInstances(want); got != fmt.Sprintf("i-2/c5.large/%d", now.Add(-10*time.Minute).Unix()) {
+		t.Errorf("formatSwappedInstances() = %v", got)
+	}
+
+	var many []swappedInstance
+	for n := 0; n < 10; n++ {
+		many = append(many, swappedInstance{id: "i-0123456789abcdef0", instanceType: "m5.large", time: now.Add(time.Duration(n) * time.Minute)})
+	}
+	if got := formatSwappedInstances(many); len(got) > maxTagValueLength ||
+		len(parseSwappedInstances(got, now.Add(time.Hour), time.Hour)) == 0 {
+		t.Errorf("formatSwappedInstances() = %v, want the most recent entries within the tag length limit", got)
+	}
+}
+
+func Test_instance_excludeIncompatibleInstanceTypes(t *testing.T) {
+	tests := []struct {
+		name         string
+		incompatible string
+		want         []string
+		wantErr      bool
+	}{
+		{name: "none rolled back", want: []string{"m5.large", "c5.large"}},
+		{name: "one rolled back", incompatible: "m5.large r5.large", want: []string{"c5.large"}},
+		{name: "all rolled back", incompatible: "c5.large m5.large", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{Group: &autoscaling.Group{}, name: "asg"}
+			if tt.incompatible != "" {
+				a.setTagValue(IncompatibleInstanceTypesTag, tt.incompatible)
+			}
+			i := &instance{Instance: &ec2.Instance{}, asg: a}
+
+			got, err := i.excludeIncompatibleInstanceTypes([]*string{aws.String("m5.large"), aws.String("c5.large")})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("excludeIncompatibleInstanceTypes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(aws.StringValueSlice(got), tt.want) {
+				t.Errorf("excludeIncompatibleInstanceTypes() = %v, want %v", aws.StringValueSlice(got), tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_checkSwappedInstances(t *testing.T) {
+	now := time.Now()
+	swapped := func(d time.Duration) string {
+		return fmt.Sprintf("i-0abc123/m5.large/%d", now.Add(-d).Unix())
+	}
+
+	tests := []struct {
+		name             string
+		watchPeriod      string
+		swapped          string
+		healthStatus     string
+		activities       *autoscaling.DescribeScalingActivitiesOutput
+		instances        *ec2.DescribeInstancesOutput
+		targetHealth     string
+		wantSwapped      bool
+		wantIncompatible string
+	}{
+		{
+			name:        "disabled",
+			swapped:     swapped(time.Minute),
+			wantSwapped: true,
+		},
+		{
+			name:         "healthy",
+			watchPeriod:  "1h",
+			swapped:      swapped(10 * time.Minute),
+			healthStatus: "Healthy",
+			targetHealth: elbv2.TargetHealthStateEnumHealthy,
+			wantSwapped:  true,
+		},
+		{
+			name:        "watch period over",
+			watchPeriod: "1h",
+			swapped:     swapped(2 * time.Hour),
+		},
+		{
+			name:             "unhealthy in the group",
+			watchPeriod:      "1h",
+			swapped:          swapped(10 * time.Minute),
+			healthStatus:     "Unhealthy",
+			wantIncompatible: "m5.large",
+		},
+		{
+			name:             "unhealthy in a target group",
+			watchPeriod:      "1h",
+			swapped:          swapped(10 * time.Minute),
+			healthStatus:     "Healthy",
+			targetHealth:     elbv2.TargetHealthStateEnumUnhealthy,
+			wantIncompatible: "m5.large",
+		},
+		{
+			name:         "unhealthy in a target group during the grace period",
+			watchPeriod:  "1h",
+			swapped:      swapped(time.Minute),
+			healthStatus: "Healthy",
+			targetHealth: elbv2.TargetHealthStateEnumUnhealthy,
+			wantSwapped:  true,
+		},
+		{
+			name:        "replaced by the group",
+			watchPeriod: "1h",
+			swapped:     swapped(10 * time.Minute),
+			activities: &autoscaling.DescribeScalingActivitiesOutput{Activities: []*autoscaling.Activity{{
+				StartTime:   aws.Time(now.Add(-5 * time.Minute)),
+				Description: aws.String("Terminating EC2 instance: i-0abc123"),
+				Cause:       aws.String("an instance was taken out of service in response to an ELB system health check failure."),
+			}}},
+			wantIncompatible: "m5.large",
+		},
+		{
+			name:         "replaced by the group after a Spot interruption",
+			watchPeriod:  "1h",
+			swapped:      swapped(10 * time.Minute),
+			healthStatus: "Healthy",
+			targetHealth: elbv2.TargetHealthStateEnumHealthy,
+			activities: &autoscaling.DescribeScalingActivitiesOutput{Activities: []*autoscaling.Activity{{
+				StartTime:   aws.Time(now.Add(-5 * time.Minute)),
+				Description: aws.String("Terminating EC2 instance: i-0abc123"),
+				Cause: aws.String("an instance was taken out of service in response to an EC2 health check " +
+					"indicating it has been terminated or stopped."),
+			}}},
+			instances: &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{
+				Instances: []*ec2.Instance{{
+					InstanceId:        aws.String("i-0abc123"),
+					InstanceLifecycle: aws.String(Spot),
+					StateReason:       &ec2.StateReason{Code: aws.String("Server.SpotInstanceTermination")},
+				}},
+			}}},
+			wantSwapped: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var instances []*autoscaling.Instance
+			if tt.healthStatus != "" {
+				instances = []*autoscaling.Instance{{InstanceId: aws.String("i-0abc123"), HealthStatus: aws.String(tt.healthStatus)}}
+			}
+			activities := tt.activities
+			if activities == nil {
+				activities = &autoscaling.DescribeScalingActivitiesOutput{}
+			}
+			describedInstances := tt.instances
+			if describedInstances == nil {
+				describedInstances = &ec2.DescribeInstancesOutput{}
+			}
+
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{
+					AutoScalingGroupName:   aws.String("asg"),
+					HealthCheckGracePeriod: aws.Int64(300),
+					Instances:              instances,
+					TargetGroupARNs:        []*string{aws.String("arn:aws:elasticloadbalancing:us-east-1:123456789012:targetgroup/tg/1")},
+				},
+				name:   "asg",
+				config: AutoScalingConfig{SwapWatchPeriod: tt.watchPeriod},
+				region: &region{
+					name: "us-east-1",
+					conf: &Config{FinalRecap: map[string][]string{}},
+					services: connections{
+						autoScaling: mockASG{
+							dsao:    activities,
+							couto:   &autoscaling.CreateOrUpdateTagsOutput{},
+							dlho:    &autoscaling.DescribeLifecycleHooksOutput{},
+							tiiasgo: &autoscaling.TerminateInstanceInAutoScalingGroupOutput{},
+						},
+						ec2: mockEC2{dio: describedInstances},
+						elbv2: mockELBV2{dtho: &elbv2.DescribeTargetHealthOutput{
+							TargetHealthDescriptions: []*elbv2.TargetHealthDescription{{
+								TargetHealth: &elbv2.TargetHealth{State: aws.String(tt.targetHealth)},
+							}},
+						}},
+					},
+				},
+			}
+			a.setTagValue(SwappedInstancesTag, tt.swapped)
+
+			a.checkSwappedInstances(now)
+
+			if got := aws.StringValue(a.getTagValue(SwappedInstancesTag)) != ""; got != tt.wantSwapped {
+				t.Errorf("still watching the swapped instance = %v, want %v", got, tt.wantSwapped)
+			}
+			if got := aws.StringValue(a.getTagValue(IncompatibleInstanceTypesTag)); got != tt.wantIncompatible {
+				t.Errorf("incompatible instance types = %q, want %q", got, tt.wantIncompatible)
+			}
+			if rolledBack := len(a.region.conf.FinalRecap["us-east-1"]) > 0; rolledBack != (tt.wantIncompatible != "") {
+				t.Errorf("final recap = %v", a.region.conf.FinalRecap)
+			}
+		})
+	}
+}
+
+func Test_formatIncompatibleInstanceTypes(t *testing.T) {
+	var instanceTypes []string
+	for i := 0; i < 30; i++ {
+		instanceTypes = append(instanceTypes, fmt.Sprintf("m5.%dxlarge", i))
+	}
+
+	got := formatIncompatibleInstanceTypes(instanceTypes)
+	if len(got) > maxTagValueLength {
+		t.Errorf("formatIncompatibleInstanceTypes() length = %d, want at most %d", len(got), maxTagValueLength)
+	}
+	if !strings.HasSuffix(got, "m5.29xlarge") || strings.HasPrefix(got, "m5.0xlarge ") {
+		t.Errorf("formatIncompatibleInstanceTypes() = %q, want the oldest entries dropped", got)
+	}
+	if got := formatIncompatibleInstanceTypes([]string{"m5.large", "c5.large"}); got != "m5.large c5.large" {
+		t.Errorf("formatIncompatibleInstanceTypes() = %q", got)
+	}
+}
+
+func Test_autoScalingGroup_watchSwappedInstance(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	spot := &instance{Instance: &ec2.Instance{InstanceId: aws.String("i-2"), InstanceType: aws.String("c5.large")}}
+
+	for _, period := range []string{"0", "1h"} {
+		a := &autoScalingGroup{
+			Group:  &autoscaling.Group{AutoScalingGroupName: aws.String("asg")},
+			name:   "asg",
+			config: AutoScalingConfig{SwapWatchPeriod: period},
+			region: &region{
+				name:     "us-east-1",
+				services: connections{autoScaling: mockASG{couto: &autoscaling.CreateOrUpdateTagsOutput{}}},
+			},
+		}
+		a.setTagValue(SwappedInstancesTag, fmt.Sprintf("i-1/m5.large/%d", now.Add(-time.Minute).Unix()))
+
+		a.watchSwappedInstance(now, spot)
+
+		want := fmt.Sprintf("i-1/m5.large/%d", now.Add(-time.Minute).Unix())
+		if period != "0" {
+			want += " i-2/c5.large/1700000000"
+		}
+		if got := aws.StringValue(a.getTagValue(SwappedInstancesTag)); got != want {
+			t.Errorf("watchSwappedInstance() with period %s set %q, want %q", period, got, want)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// rollout.go contains the logic used for limiting the pace at which the
+// on-demand instances of a group are replaced with Spot instances, including
+// the optional canary step performed when a group is first enabled.
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+const (
+	// MaxReplacementsPerHourTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the MaxReplacementsPerHour
+	// parameter
+	MaxReplacementsPerHourTag = "autospotting_max_replacements_per_hour"
+
+	// MaxReplacementsInFlightTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the MaxReplacementsInFlight
+	// parameter
+	MaxReplacementsInFlightTag = "autospotting_max_replacements_in_flight"
+
+	// CanaryInstancesTag is the name of the tag set on the AutoScaling Group
+	// that can override the global value of the CanaryInstances parameter
+	CanaryInstancesTag = "autospotting_canary_instances"
+
+	// CanarySoakPeriodTag is the name of the tag set on the AutoScaling Group
+	// that can override the global value of the CanarySoakPeriod parameter
+	CanarySoakPeriodTag = "autospotting_canary_soak_period"
+
+	// RecentReplacementsTag is the name of the tag set by AutoSpotting on the
+	// AutoScaling groups to keep track of the Spot replacements launched in
+	// the last hour, as a space separated list of unix timestamps.
+	RecentReplacementsTag = "autospotting_recent_replacements"
+
+	// CanaryStateTag is the name of the tag set by AutoSpotting on the
+	// AutoScaling groups to keep track of the canary step, either as
+	// replacements/unix-timestamp of the last canary replacement, or as
+	// "done" once the soak period ended without health replacements.
+	CanaryStateTag = "autospotting_canary_state"
+
+	// DefaultCanarySoakPeriod is the default time to wait after the canary
+	// replacements before converting the rest of the group
+	DefaultCanarySoakPeriod = "1h"
+
+	canaryDone = "done"
+
+	replacementsWindow = time.Hour
+)
+
+// runReplacements counts the replacements started during the current cron
+// run, across all the groups and regions.
+var runReplacements = struct {
+	sync.Mutex
+	count int64
+}{}
+
+func resetRunReplacements() {
+	runReplacements.Lock()
+	defer runReplacements.Unlock()
+	runReplacements.count = 0
+}
+
+// reserveRunReplacement returns whether another replacement is allowed during
+// the current cron run, counting it if so.
+func (r *region) reserveRunReplacement() bool {
+	if r.conf == nil || r.conf.MaxReplacementsPerRun <= 0 {
+		return true
+	}
+
+	runReplacements.Lock()
+	defer runReplacements.Unlock()
+
+	if runReplacements.count >= r.conf.MaxReplacementsPerRun {
+		return false
+	}
+	runReplacements.count++
+	return true
+}
+
+// globalReplacements keeps the recent and in-flight Spot replacements of the
+// enabled groups of each region, used for enforcing the global limits.
+var globalReplacements = struct {
+	sync.Mutex
+	regions map[string]*replacementUsage
+}{regions: make(map[string]*replacementUsage)}
+
+type replacementUsage struct {
+	recent   []time.Time
+	inFlight int64
+}
+
+func resetGlobalReplacements() {
+	globalReplacements.Lock()
+	defer globalReplacements.Unlock()
+	globalReplacements.regions = make(map[string]*replacementUsage)
+}
+
+func (r *region) globalReplacementLimitsEnabled() bool {
+	return r.conf != nil &&
+		(r.conf.MaxGlobalReplacementsPerHour > 0 || r.conf.MaxGlobalReplacementsInFlight > 0)
+}
+
+// registerReplacementUsage records the replacements launched in the last hour
+// and the ones not yet attached for all the enabled groups of the region,
+// replacing the previously recorded ones.
+func (r *region) registerReplacementUsage(now time.Time) {
+	if !r.globalReplacementLimitsEnabled() {
+		return
+	}
+
+	usage := &replacementUsage{}
+	for i := range r.enabledASGs {
+		a := &r.enabledASGs[i]
+		if tagValue := a.groupTagValue(RecentReplacementsTag); tagValue != nil {
+			usage.recent = append(usage.recent, parseRecentReplacements(*tagValue, now)...)
+		}
+		usage.inFlight += a.unattachedReplacementCount()
+	}
+
+	debug.Println(r.name, "Found", len(usage.recent), "Spot replacements in the last hour and",
+		usage.inFlight, "in flight")
+
+	globalReplacements.Lock()
+	defer globalReplacements.Unlock()
+	globalReplacements.regions[r.name] = usage
+}
+
+// reserveGlobalReplacement returns the global limit which doesn't allow
+// another replacement, or an empty string after counting the replacement.
+func (r *region) reserveGlobalReplacement(now time.Time) string {
+	if !r.globalReplacementLimitsEnabled() {
+		return ""
+	}
+
+	globalReplacements.Lock()
+	defer globalReplacements.Unlock()
+
+	var recent, inFlight int64
+	for _, usage := range globalReplacements.regions {
+		for _, t := range usage.recent {
+			if now.Sub(t) <= replacementsWindow {
+				recent++
+			}
+		}
+		inFlight += usage.inFlight
+	}
+
+	if limit := r.conf.MaxGlobalReplacementsPerHour; limit > 0 && recent >= limit {
+		log.Println(r.name, "Already launched", recent,
+			"Spot replacements in the last hour across all the groups, the maximum is", limit)
+		return "max-global-replacements-per-hour"
+	}
+
+	if limit := r.conf.MaxGlobalReplacementsInFlight; limit > 0 && inFlight >= limit {
+		log.Println(r.name, "Already having", inFlight,
+			"Spot replacements in flight across all the groups, the maximum is", limit)
+		return "max-global-replacements-in-flight"
+	}
+
+	usage := globalReplacements.regions[r.name]
+	if usage == nil {
+		usage = &replacementUsage{}
+		globalReplacements.regions[r.name] = usage
+	}
+	usage.recent = append(usage.recent, now)
+	usage.inFlight++
+	return ""
+}
+
+// parseRecentReplacements decodes the value of the RecentReplacementsTag,
+// ignoring malformed entries and those older than the replacements window.
+func parseRecentReplacements(value string, now time.Time) []time.Time {
+	return parseTimestamps(value, now, replacementsWindow)
+}
+
+// parseTimestamps decodes a space separated list of unix timestamps, ignoring
+// the invalid ones and those older than the given window.
+func parseTimestamps(value string, now time.Time, window time.Duration) []time.Time {
+	var result []time.Time
+
+	for _, entry := range strings.Fields(value) {
+		ts, err := strconv.ParseInt(entry, 10, 64)
+		if err != nil {
+			debug.Println("Ignoring entry with invalid timestamp", entry)
+			continue
+		}
+
+		t := time.Unix(ts, 0)
+		if now.Sub(t) > window {
+			continue
+		}
+		result = append(result, t)
+	}
+	return result
+}
+
+func formatRecentReplacements(replacements []time.Time) string {
+	sorted := make([]time.Time, len(replacements))
+	copy(sorted, replacements)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+
+	entries := make([]string, 0, len(sorted))
+	for _, t := range sorted {
+		entries = append(entries, strconv.FormatInt(t.Unix(), 10))
+	}
+
+	// keep the most recent entries that fit the tag value length limit
+	for len(strings.Join(entries, " ")) > maxTagValueLength {
+		entries = entries[1:]
+	}
+	return strings.Join(entries, " ")
+}
+
+type canaryState struct {
+	done         bool
+	replacements int64
+	last         time.Time
+}
+
+// parseCanaryState decodes the value of the CanaryStateTag, a missing or
+// malformed value meaning the canary step hasn't started yet.
+func parseCanaryState(value *string) canaryState {
+	if value == nil {
+		return canaryState{}
+	}
+
+	if *value == canaryDone {
+		return canaryState{done: true}
+	}
+
+	fields := strings.Split(*value, "/")
+	if len(fields) != 2 {
+		debug.Println("Ignoring malformed canary state", *value)
+		return canaryState{}
+	}
+
+	replacements, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		debug.Println("Ignoring canary state with invalid replacements", *value)
+		return canaryState{}
+	}
+
+	ts, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		debug.Println("Ignoring canary state with invalid timestamp", *value)
+		return canaryState{}
+	}
+
+	return canaryState{replacements: replacements, last: time.Unix(ts, 0)}
+}
+
+func (s canaryState) String() string {
+	if s.done {
+		return canaryDone
+	}
+	return fmt.Sprintf("%d/%d", s.replacements, s.last.Unix())
+}
+
+// canaryEnabled returns whether the group still needs to go through the
+// canary step.
+func (a *autoScalingGroup) canaryEnabled() bool {
+	return a.config.CanaryInstances > 0 && !parseCanaryState(a.getTagValue(CanaryStateTag)).done
+}
+
+func (a *autoScalingGroup) canarySoakPeriod() time.Duration {
+	period, err := time.ParseDuration(a.config.CanarySoakPeriod)
+	if err != nil || period < 0 {
+		period, _ = time.ParseDuration(DefaultCanarySoakPeriod)
+	}
+	return period
+}
+
+// unattachedReplacementCount counts the Spot instances launched for the group
+// which weren't attached to it yet.
+func (a *autoScalingGroup) unattachedReplacementCount() int64 {
+	var count int64
+	for inst := range a.region.instances.instances() {
+		for _, tag := range inst.Tags {
+			if *tag.Key == "launched-for-asg" && *tag.Value == a.name && !a.hasMemberInstance(inst) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// lastHealthReplacement returns the time of the most recent instance
+// replaced by the group because of a failed health check since the given
+// time, or the zero time if there was none.
+func (a *autoScalingGroup) lastHealthReplacement(since time.Time) (time.Time, error) {
+	var last time.Time
+
+	replacements, err := a.healthReplacements(since)
+	if err != nil {
+		return last, err
+	}
+
+	for _, r := range replacements {
+		if r.time.After(last) {
+			last = r.time
+		}
+	}
+	return last, nil
+}
+
+// replacementThrottled returns the reason for which the group shouldn't
+// start another Spot replacement yet, or an empty string if it can.
+func (a *autoScalingGroup) replacementThrottled(now time.Time) string {
+	if a.circuitHalfOpen(now) && a.unattachedReplacementCount() > 0 {
+		log.Println(a.region.name, a.name, "Circuit breaker half-open, waiting for the trial replacement")
+		return "circuit-half-open"
+	}
+
+	if a.launchBackoffThrottled(now) {
+		return "launch-backoff"
+	}
+
+	if limit := a.config.MaxReplacementsInFlight; limit > 0 {
+		if inFlight := a.unattachedReplacementCount(); inFlight >= limit {
+			log.Println(a.region.name, a.name, "Already having", inFlight,
+				"Spot replacements in flight, the maximum is", limit)
+			return "max-replacements-in-flight"
+		}
+	}
+
+	if limit := a.config.MaxReplacementsPerHour; limit > 0 {
+		recent := int64(0)
+		if tagValue := a.getTagValue(RecentReplacementsTag); tagValue != nil {
+			recent = int64(len(parseRecentReplacements(*tagValue, now)))
+		}
+		if recent >= limit {
+			log.Println(a.region.name, a.name, "Already launched", recent,
+				"Spot replacements in the last hour, the maximum is", limit)
+			return "max-replacements-per-hour"
+		}
+	}
+
+	if a.config.CanaryInstances > 0 {
+		return a.canaryThrottled(now)
+	}
+	return ""
+}
+
+// canaryThrottled allows the first CanaryInstances replacements of the group,
+// then waits for a soak period without any instances replaced by the group
+// because of failed health checks before allowing the others.
+func (a *autoScalingGroup) canaryThrottled(now time.Time) string {
+	state := parseCanaryState(a.getTagValue(CanaryStateTag))
+
+	if state.done || state.replacements < a.config.CanaryInstances {
+		return ""
+	}
+
+	soakStart := state.last
+	healthReplacement, err := a.lastHealthReplacement(state.last)
+	if err != nil {
+		log.Println(a.region.name, a.name, "Couldn't verify the health of the canary replacements")
+		return "canary-soak"
+	}
+
+	if healthReplacement.After(soakStart) {
+		log.Println(a.region.name, a.name, "The group replaced unhealthy instances at",
+			healthReplacement.Format(time.RFC3339), "after the canary replacements, extending the soak period")
+		soakStart = healthReplacement
+	}
+
+	if soakEnd := soakStart.Add(a.canarySoakPeriod()); now.Before(soakEnd) {
+		log.Println(a.region.name, a.name, "Canary soak period in progress until",
+			soakEnd.Format(time.RFC3339))
+		return "canary-soak"
+	}
+
+	log.Println(a.region.name, a.name, "Canary soak period ended without health replacements,",
+		"continuing with the rest of the group")
+	if err := a.setStateTags(map[string]string{CanaryStateTag: canaryDone}); err != nil {
+		return "canary-soak"
+	}
+	return ""
+}
+
+// recordReplacement keeps track of a newly launched Spot replacement on the
+// tags of the group, when the group's or the global replacements are rate
+// limited.
+func (a *autoScalingGroup) recordReplacement(now time.Time) {
+	tags := make(map[string]string)
+
+	// the recent replacements of all the groups are counted by the global limit
+	if a.config.MaxReplacementsPerHour > 0 || (a.region.conf != nil && a.region.conf.MaxGlobalReplacementsPerHour > 0) {
+		var recent []time.Time
+		if tagValue := a.getTagValue(RecentReplacementsTag); tagValue != nil {
+			recent = parseRecentReplacements(*tagValue, now)
+		}
+		tags[RecentReplacementsTag] = formatRecentReplacements(append(recent, now))
+	}
+
+	if a.canaryEnabled() {
+		state := parseCanaryState(a.getTagValue(CanaryStateTag))
+		if state.replacements < a.config.CanaryInstances {
+			state.replacements++
+			state.last = now
+			log.Printf("%s %s Launched canary replacement %d of %d\n", a.region.name, a.name,
+				state.replacements, a.config.CanaryInstances)
+			tags[CanaryStateTag] = state.String()
+		}
+	}
+
+	if len(tags) > 0 {
+		a.setStateTags(tags)
+	}
+}
+
+// setStateTags stores the given values on the tags of the group, also
+// updating them in memory.
+func (a *autoScalingGroup) setStateTags(values map[string]string) error {
+	var tags []*autoscaling.Tag
+	for key, value := range values {
+		tags = append(tags, &autoscaling.Tag{
+			ResourceId:        aws.String(a.name),
+			ResourceType:      aws.String("auto-scaling-group"),
+			Key:               aws.String(key),
+			Value:             aws.String(value),
+			PropagateAtLaunch: aws.Bool(false),
+		})
+	}
+
+	_, err := a.region.services.autoScaling.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{
+		Tags: tags,
+	})
+
+	if err != nil {
+		log.Println(a.region.name, a.name, "Failed to update the replacement state tags", err.Error())
+		return err
+	}
+
+	for key, value := range values {
+		a.setTagValue(key, value)
+	}
+	return nil
+}
+
+func (a *autoScalingGroup) setTagValue(key, value string) {
+	for _, tag := range a.Tags {
+		if *tag.Key == key {
+			tag.Value = aws.String(value)
+			return
+		}
+	}
+	a.Tags = append(a.Tags, &autoscaling.TagDescription{
+		Key:               aws.String(key),
+		Value:             aws.String(value),
+		ResourceId:        aws.String(a.name),
+		ResourceType:      aws.String("auto-scaling-group"),
+		PropagateAtLaunch: aws.Bool(false),
+	})
+}
+
+func (a *autoScalingGroup) loadRolloutLimit(tag string, dest *int64) bool {
+	tagValue := a.getTagValue(tag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", tag, "on the group", a.name, "using the default configuration")
+		return false
+	}
+
+	limit, err := strconv.ParseInt(*tagValue, 10, 64)
+	if err != nil {
+		log.Printf("Error with ParseInt: %s\n", err.Error())
+		return false
+	} else if limit < 0 {
+		log.Printf("Ignoring out of range value : %d\n", limit)
+		return false
+	}
+
+	log.Printf("Loaded value %d from tag %v\n", limit, tag)
+	*dest = limit
+	return true
+}
+
+func (a *autoScalingGroup) loadRolloutConfiguration() bool {
+	a.config.MaxReplacementsPerHour = a.region.conf.MaxReplacementsPerHour
+	a.config.MaxReplacementsInFlight = a.region.conf.MaxReplacementsInFlight
+	a.config.CanaryInstances = a.region.conf.CanaryInstances
+	a.config.CanarySoakPeriod = a.region.conf.CanarySoakPeriod
+
+	found := a.loadRolloutLimit(MaxReplacementsPerHourTag, &a.config.MaxReplacementsPerHour)
+	found = a.loadRolloutLimit(MaxReplacementsInFlightTag, &a.config.MaxReplacementsInFlight) || found
+	found = a.loadRolloutLimit(CanaryInstancesTag, &a.config.CanaryInstances) || found
+
+	if tagValue := a.getTagValue(CanarySoakPeriodTag); tagValue != nil {
+		if period, err := time.ParseDuration(*tagValue); err == nil && period >= 0 {
+			log.Printf("Loaded CanarySoakPeriod value %v from tag %v\n", *tagValue, CanarySoakPeriodTag)
+			a.config.CanarySoakPeriod = *tagValue
+			found = true
+		} else {
+			log.Printf("Ignoring invalid CanarySoakPeriod value %v from tag %v\n", *tagValue, CanarySoakPeriodTag)
+		}
+	}
+	return found
+}
@@ -0,0 +1,322 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_parseRecentReplacements(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	got := parseRecentReplacements(fmt.Sprintf("%d invalid %d %d",
+		now.Add(-2*time.Hour).Unix(), now.Add(-30*time.Minute).Unix(), now.Unix()), now)
+
+	want := []time.Time{now.Add(-30 * time.Minute), now}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRecentReplacements() = %v, want %v", got, want)
+	}
+
+	if formatted := formatRecentReplacements([]time.Time{now, now.Add(-30 * time.Minute)}); formatted != "1699998200 1700000000" {
+		t.Errorf("formatRecentReplacements() = %v", formatted)
+	}
+}
+
+func Test_parseCanaryState(t *testing.T) {
+	tests := []struct {
+		value *string
+		want  canaryState
+	}{
+		{value: nil, want: canaryState{}},
+		{value: aws.String("done"), want: canaryState{done: true}},
+		{value: aws.String("2/1700000000"), want: canaryState{replacements: 2, last: time.Unix(1700000000, 0)}},
+		{value: aws.String("2"), want: canaryState{}},
+		{value: aws.String("two/1700000000"), want: canaryState{}},
+	}
+	for _, tt := range tests {
+		t.Run(aws.StringValue(tt.value), func(t *testing.T) {
+			got := parseCanaryState(tt.value)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCanaryState() = %+v, want %+v", got, tt.want)
+			}
+			if tt.value != nil && got != (canaryState{}) && got.String() != *tt.value {
+				t.Errorf("canaryState.String() = %v, want %v", got.String(), *tt.value)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_replacementThrottled(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	ago := func(d time.Duration) string { return fmt.Sprint(now.Add(-d).Unix()) }
+
+	healthActivity := func(d time.Duration) *autoscaling.DescribeScalingActivitiesOutput {
+		return &autoscaling.DescribeScalingActivitiesOutput{Activities: []*autoscaling.Activity{
+			{
+				StartTime: aws.Time(now.Add(-d)),
+				Cause: aws.String("At 2023-11-14T21:00:00Z an instance was taken out of service " +
+					"in response to an ELB system health check failure."),
+			},
+			{
+				StartTime: aws.Time(now.Add(-d)),
+				Cause:     aws.String("At 2023-11-14T21:00:00Z a user request update of AutoScalingGroup constraints"),
+			},
+		}}
+	}
+
+	interruption := &autoscaling.DescribeScalingActivitiesOutput{Activities: []*autoscaling.Activity{{
+		StartTime:   aws.Time(now.Add(-20 * time.Minute)),
+		Description: aws.String("Terminating EC2 instance: i-0abc123"),
+		Cause: aws.String("At 2023-11-14T21:00:00Z an instance was taken out of service " +
+			"in response to an EC2 health check indicating it has been terminated or stopped."),
+	}}}
+	interrupted := &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{
+		Instances: []*ec2.Instance{{
+			InstanceId:        aws.String("i-0abc123"),
+			InstanceLifecycle: aws.String(Spot),
+			StateReason:       &ec2.StateReason{Code: aws.String("Server.SpotInstanceTermination")},
+		}},
+	}}}
+
+	tests := []struct {
+		name       string
+		config     AutoScalingConfig
+		tags       map[string]string
+		unattached int
+		activities *autoscaling.DescribeScalingActivitiesOutput
+		activErr   error
+		instances  *ec2.DescribeInstancesOutput
+		want       string
+	}{
+		{
+			name: "no limits",
+		},
+		{
+			name:       "in flight limit reached",
+			config:     AutoScalingConfig{MaxReplacementsInFlight: 2},
+			unattached: 2,
+			want:       "max-replacements-in-flight",
+		},
+		{
+			name:       "in flight limit not reached",
+			config:     AutoScalingConfig{MaxReplacementsInFlight: 2},
+			unattached: 1,
+		},
+		{
+			name:   "hourly limit reached",
+			config: AutoScalingConfig{MaxReplacementsPerHour: 2},
+			tags:   map[string]string{RecentReplacementsTag: ago(10*time.Minute) + " " + ago(20*time.Minute)},
+			want:   "max-replacements-per-hour",
+		},
+		{
+			name:   "hourly limit with old replacements",
+			config: AutoScalingConfig{MaxReplacementsPerHour: 2},
+			tags:   map[string]string{RecentReplacementsTag: ago(10*time.Minute) + " " + ago(2*time.Hour)},
+		},
+		{
+			name:   "canary in progress",
+			config: AutoScalingConfig{CanaryInstances: 2, CanarySoakPeriod: "1h"},
+			tags:   map[string]string{CanaryStateTag: "1/" + ago(time.Minute)},
+		},
+		{
+			name:       "canary soaking",
+			config:     AutoScalingConfig{CanaryInstances: 2, CanarySoakPeriod: "1h"},
+			tags:       map[string]string{CanaryStateTag: "2/" + ago(30*time.Minute)},
+			activities: &autoscaling.DescribeScalingActivitiesOutput{},
+			want:       "canary-soak",
+		},
+		{
+			name:       "canary soaked",
+			config:     AutoScalingConfig{CanaryInstances: 2, CanarySoakPeriod: "1h"},
+			tags:       map[string]string{CanaryStateTag: "2/" + ago(90*time.Minute)},
+			activities: &autoscaling.DescribeScalingActivitiesOutput{},
+		},
+		{
+			name:       "canary soak extended by health replacements",
+			config:     AutoScalingConfig{CanaryInstances: 2, CanarySoakPeriod: "1h"},
+			tags:       map[string]string{CanaryStateTag: "2/" + ago(90*time.Minute)},
+			activities: healthActivity(20 * time.Minute),
+			want:       "canary-soak",
+		},
+		{
+			name:       "canary soak not extended by Spot interruptions",
+			config:     AutoScalingConfig{CanaryInstances: 2, CanarySoakPeriod: "1h"},
+			tags:       map[string]string{CanaryStateTag: "2/" + ago(90*time.Minute)},
+			activities: interruption,
+			instances:  interrupted,
+		},
+		{
+			name:     "canary health unknown",
+			config:   AutoScalingConfig{CanaryInstances: 2, CanarySoakPeriod: "1h"},
+			tags:     map[string]string{CanaryStateTag: "2/" + ago(90*time.Minute)},
+			activErr: errors.New("access denied"),
+			want:     "canary-soak",
+		},
+		{
+			name:   "canary done",
+			config: AutoScalingConfig{CanaryInstances: 2, CanarySoakPeriod: "1h"},
+			tags:   map[string]string{CanaryStateTag: "done"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name:      "us-east-1",
+				instances: makeInstances(),
+				services: connections{
+					autoScaling: mockASG{
+						dsao:   tt.activities,
+						dsaerr: tt.activErr,
+						couto:  &autoscaling.CreateOrUpdateTagsOutput{},
+					},
+					ec2: mockEC2{dio: tt.instances},
+				},
+			}
+			for n := 0; n < tt.unattached; n++ {
+				r.instances.add(&instance{Instance: &ec2.Instance{
+					InstanceId: aws.String(fmt.Sprintf("i-%d", n)),
+					Tags:       []*ec2.Tag{{Key: aws.String("launched-for-asg"), Value: aws.String("asg")}},
+				}})
+			}
+
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{AutoScalingGroupName: aws.String("asg")},
+				name:   "asg",
+				region: r,
+				config: tt.config,
+			}
+			for key, value := range tt.tags {
+				a.setTagValue(key, value)
+			}
+
+			if got := a.replacementThrottled(now); got != tt.want {
+				t.Errorf("replacementThrottled() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_recordReplacement(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	a := &autoScalingGroup{
+		Group: &autoscaling.Group{AutoScalingGroupName: aws.String("asg")},
+		name:  "asg",
+		region: &region{
+			name:     "us-east-1",
+			services: connections{autoScaling: mockASG{couto: &autoscaling.CreateOrUpdateTagsOutput{}}},
+		},
+		config: AutoScalingConfig{MaxReplacementsPerHour: 5, CanaryInstances: 2},
+	}
+	a.setTagValue(RecentReplacementsTag, fmt.Sprint(now.Add(-2*time.Hour).Unix()))
+
+	a.recordReplacement(now.Add(-time.Minute))
+	a.recordReplacement(now)
+	a.recordReplacement(now)
+
+	if got := aws.StringValue(a.getTagValue(RecentReplacementsTag)); got != "1699999940 1700000000 1700000000" {
+		t.Errorf("recent replacements = %v", got)
+	}
+	if got := aws.StringValue(a.getTagValue(CanaryStateTag)); got != "2/1700000000" {
+		t.Errorf("canary state = %v, want 2/1700000000", got)
+	}
+}
+
+func Test_region_reserveRunReplacement(t *testing.T) {
+	resetRunReplacements()
+	defer resetRunReplacements()
+
+	r := &region{conf: &Config{MaxReplacementsPerRun: 2}}
+	var got []bool
+	for n := 0; n < 3; n++ {
+		got = append(got, r.reserveRunReplacement())
+	}
+	if want := []bool{true, true, false}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reserveRunReplacement() = %v, want %v", got, want)
+	}
+
+	if !(&region{conf: &Config{}}).reserveRunReplacement() {
+		t.Errorf("reserveRunReplacement() = false without a limit")
+	}
+}
+
+func Test_region_reserveGlobalReplacement(t *testing.T) {
+	resetGlobalReplacements()
+	defer resetGlobalReplacements()
+
+	now := time.Unix(1700000000, 0)
+	conf := &Config{MaxGlobalReplacementsPerHour: 3, MaxGlobalReplacementsInFlight: 2}
+
+	newRegion := func(name, recent string, unattached int) *region {
+		r := &region{name: name, conf: conf, instances: makeInstances()}
+		a := autoScalingGroup{Group: &autoscaling.Group{}, name: name + "-asg", region: r}
+		a.setTagValue(RecentReplacementsTag, recent)
+		r.enabledASGs = []autoScalingGroup{a}
+		for n := 0; n < unattached; n++ {
+			r.instances.add(&instance{Instance: &ec2.Instance{
+				InstanceId: aws.String(fmt.Sprintf("i-%s-%d", name, n)),
+				Tags:       []*ec2.Tag{{Key: aws.String("launched-for-asg"), Value: aws.String(name + "-asg")}},
+			}})
+		}
+		return r
+	}
+
+	east := newRegion("us-east-1", fmt.Sprintf("%d %d", now.Add(-2*time.Hour).Unix(), now.Add(-10*time.Minute).Unix()), 0)
+	west := newRegion("eu-west-1", fmt.Sprint(now.Add(-5*time.Minute).Unix()), 1)
+	east.registerReplacementUsage(now)
+	west.registerReplacementUsage(now)
+
+	if got := east.reserveGlobalReplacement(now); got != "" {
+		t.Errorf("reserveGlobalReplacement() = %q, want the third replacement of the hour to be allowed", got)
+	}
+	if got := west.reserveGlobalReplacement(now); got != "max-global-replacements-per-hour" {
+		t.Errorf("reserveGlobalReplacement() = %q, want the hourly limit to be reached across the regions", got)
+	}
+
+	conf.MaxGlobalReplacementsPerHour = 0
+	if got := west.reserveGlobalReplacement(now); got != "max-global-replacements-in-flight" {
+		t.Errorf("reserveGlobalReplacement() = %q, want the in-flight limit to be reached across the regions", got)
+	}
+
+	if got := (&region{name: "us-east-1", conf: &Config{}}).reserveGlobalReplacement(now); got != "" {
+		t.Errorf("reserveGlobalReplacement() = %q without limits", got)
+	}
+}
+
+func Test_autoScalingGroup_loadRolloutConfiguration(t *testing.T) {
+	a := &autoScalingGroup{
+		Group: &autoscaling.Group{Tags: []*autoscaling.TagDescription{
+			{Key: aws.String(MaxReplacementsPerHourTag), Value: aws.String("3")},
+			{Key: aws.String(MaxReplacementsInFlightTag), Value: aws.String("-1")},
+			{Key: aws.String(CanarySoakPeriodTag), Value: aws.String("45m")},
+		}},
+		region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{
+			MaxReplacementsInFlight: 4,
+			CanaryInstances:         1,
+			CanarySoakPeriod:        DefaultCanarySoakPeriod,
+		}}},
+	}
+
+	if !a.loadRolloutConfiguration() {
+		t.Errorf("loadRolloutConfiguration() = false, want true")
+	}
+
+	want := AutoScalingConfig{
+		MaxReplacementsPerHour:  3,
+		MaxReplacementsInFlight: 4,
+		CanaryInstances:         1,
+		CanarySoakPeriod:        "45m",
+	}
+	if !reflect.DeepEqual(a.config, want) {
+		t.Errorf("loadRolloutConfiguration() = %+v, want %+v", a.config, want)
+	}
+}
@@ -4,18 +4,32 @@
 package autospotting
 
 import (
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/robfig/cron/v3"
 )
 
+// The parsers of the schedule windows, the simplified format only having the
+// hour and day of week fields and the full five fields crontab format.
+var (
+	hourlyScheduleParser = cron.NewParser(cron.Hour | cron.Dow)
+	fullScheduleParser   = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+)
+
+// scheduleWindowSeparator separates the windows of a schedule, for example
+// "9-18 1-5; 10-14 6".
+const scheduleWindowSeparator = ";"
+
 // insideSchedule returns true if the time given in the t parameter is matching
-// the implified cronrab-like interval restricted to only hours and days of the
-// week. Because the cron library be use only supports the local time, the
-// crontab entry will have to be created accoringly. When executed in Lambda the
-// runtime's local time will always be UTC, so users have to be made aware of
-// this through the documentation.
+// any of the windows of the crontab, separated by semicolons. Each window can
+// use the simplified cronrab-like interval restricted to only hours and days of
+// the week, or the full five fields crontab format with minute resolution. The
+// windows are evaluated in the given timezone, which defaults to UTC when
+// executed in Lambda, so users have to be made aware of this through the
+// documentation.
 func insideSchedule(t time.Time, crontab string, timezone string) (bool, error) {
 	// Get the timezone, will cause an error if timezone is incorrect
 	tz, err := time.LoadLocation(timezone)
@@ -25,26 +39,133 @@ func insideSchedule(t time.Time, crontab string, timezone string) (bool, error)
 		return false, err
 	}
 
-	// Create a new cron job runner using the location details and a custom parser
-	c := cron.New(cron.WithLocation(tz), cron.WithParser(cron.NewParser(cron.Hour|cron.Dow)))
-	// Schedule an empty job based on out crontab
-	entry, err := c.AddFunc(crontab, nil)
+	inside := false
+	for _, window := range strings.Split(crontab, scheduleWindowSeparator) {
+		window = strings.TrimSpace(window)
+		if window == "" {
+			continue
+		}
+
+		insideWindow, err := insideScheduleWindow(t.In(tz), window)
+		if err != nil {
+			log.Println(err)
+			return false, err
+		}
+		inside = inside || insideWindow
+	}
+	return inside, nil
+}
 
+func insideScheduleWindow(t time.Time, window string) (bool, error) {
+	if len(strings.Fields(window)) == 2 {
+		sched, err := hourlyScheduleParser.Parse(window)
+		if err != nil {
+			return false, err
+		}
+
+		// When inside the cron interval, the next event from exactly an hour ago and the
+		// next event from now are exactly one hour apart
+		prev := sched.Next(t.Add(-1 * time.Hour))
+		next := sched.Next(t)
+
+		return next == prev.Add(1*time.Hour), nil
+	}
+
+	sched, err := fullScheduleParser.Parse(window)
 	if err != nil {
-		log.Println(err)
 		return false, err
 	}
 
-	// Grab the schedule for the job created above to use for calculations below.
-	sched := c.Entry(entry).Schedule
+	// The full crontab format matches individual minutes, we're inside the
+	// window when the current minute is one of them
+	minute := t.Truncate(time.Minute)
+	return sched.Next(minute.Add(-1 * time.Second)).Equal(minute), nil
+}
+
+// blackoutDateLayouts are the accepted formats of the blackout dates, either
+// whole days or a specific minute.
+var blackoutDateLayouts = []string{"2006-01-02T15:04", "2006-01-02"}
 
-	// When inside the cron interval, the next event from exactly an hour ago and the
-	// next event from now are exactly one hour apart
-	prev := sched.Next(t.In(tz).Add(-1 * time.Hour))
-	next := sched.Next(t.In(tz))
+// blackoutPeriod is a time interval in which no replacement actions are taken,
+// the end being exclusive.
+type blackoutPeriod struct {
+	start time.Time
+	end   time.Time
+}
 
-	if next == prev.Add(1*time.Hour) {
-		return true, nil
+func parseBlackoutDate(value string, tz *time.Location, end bool) (time.Time, error) {
+	for _, layout := range blackoutDateLayouts {
+		t, err := time.ParseInLocation(layout, value, tz)
+		if err != nil {
+			continue
+		}
+		if end && layout == "2006-01-02" {
+			// whole days are included in the period
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid blackout date %q, expected YYYY-MM-DD or YYYY-MM-DDTHH:MM", value)
+}
+
+// parseBlackoutPeriods decodes a comma separated list of dates and date
+// ranges such as "2023-11-24/2023-11-27,2023-12-24T18:00/2023-12-26T06:00",
+// interpreted in the given timezone.
+func parseBlackoutPeriods(value string, tz *time.Location) ([]blackoutPeriod, error) {
+	var result []blackoutPeriod
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(entry, "/", 2)
+		if len(bounds) == 1 {
+			bounds = append(bounds, bounds[0])
+		}
+
+		start, err := parseBlackoutDate(strings.TrimSpace(bounds[0]), tz, false)
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := parseBlackoutDate(strings.TrimSpace(bounds[1]), tz, true)
+		if err != nil {
+			return nil, err
+		}
+
+		if !end.After(start) {
+			return nil, fmt.Errorf("invalid blackout period %q, it ends before it starts", entry)
+		}
+		result = append(result, blackoutPeriod{start: start, end: end})
+	}
+	return result, nil
+}
+
+// insideBlackout returns true if the time given in the t parameter is inside
+// any of the blackout periods, evaluated in the given timezone.
+func insideBlackout(t time.Time, blackoutDates string, timezone string) (bool, error) {
+	if strings.TrimSpace(blackoutDates) == "" {
+		return false, nil
+	}
+
+	tz, err := time.LoadLocation(timezone)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	periods, err := parseBlackoutPeriods(blackoutDates, tz)
+	if err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	for _, p := range periods {
+		if !t.Before(p.start) && t.Before(p.end) {
+			return true, nil
+		}
 	}
 	return false, nil
 }
@@ -66,3 +187,14 @@ func cronRunAction(t time.Time, crontab string, timezone string, scheduleType st
 
 	return false
 }
+
+// blackoutRunAction returns true unless the time given in the t parameter is
+// inside one of the blackout periods. Invalid blackout periods also prevent
+// taking any actions, since they were most likely meant to protect a sensitive
+// time interval.
+func blackoutRunAction(t time.Time, blackoutDates string, timezone string) bool {
+	inside, err := insideBlackout(t, blackoutDates, timezone)
+	debug.Println("Inside blackout periods", blackoutDates, ":", inside)
+
+	return err == nil && !inside
+}
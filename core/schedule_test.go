@@ -117,6 +117,70 @@ func Test_insideSchedule(t *testing.T) {
 			want:     true,
 			wantErr:  nil,
 		},
+		{
+			name:     "Full crontab, inside the minutes",
+			crontab:  "30-59 8 * * 1-5",
+			t:        time.Date(2019, time.May, 9, 8, 45, 30, 0, time.UTC),
+			timezone: "UTC",
+			want:     true,
+			wantErr:  nil,
+		},
+		{
+			name:     "Full crontab, outside the minutes",
+			crontab:  "30-59 8 * * 1-5",
+			t:        time.Date(2019, time.May, 9, 8, 15, 0, 0, time.UTC),
+			timezone: "UTC",
+			want:     false,
+			wantErr:  nil,
+		},
+		{
+			name:     "Full crontab, inside the day of month and month",
+			crontab:  "* * 1-10 5 *",
+			t:        time.Date(2019, time.May, 9, 23, 59, 0, 0, time.UTC),
+			timezone: "UTC",
+			want:     true,
+			wantErr:  nil,
+		},
+		{
+			name:     "Full crontab, outside the month",
+			crontab:  "* * 1-10 6 *",
+			t:        time.Date(2019, time.May, 9, 12, 0, 0, 0, time.UTC),
+			timezone: "UTC",
+			want:     false,
+			wantErr:  nil,
+		},
+		{
+			name:     "Multiple windows, inside the second one",
+			crontab:  "9-18 1-5; 10-14 6",
+			t:        time.Date(2019, time.May, 11, 12, 0, 0, 0, time.UTC),
+			timezone: "UTC",
+			want:     true,
+			wantErr:  nil,
+		},
+		{
+			name:     "Multiple windows, outside all of them",
+			crontab:  "9-18 1-5; 10-14 6",
+			t:        time.Date(2019, time.May, 12, 12, 0, 0, 0, time.UTC),
+			timezone: "UTC",
+			want:     false,
+			wantErr:  nil,
+		},
+		{
+			name:     "Multiple windows, mixing the formats",
+			crontab:  "9-18 1-5; 30-59 20 * * 1-5",
+			t:        time.Date(2019, time.May, 9, 20, 31, 0, 0, time.UTC),
+			timezone: "UTC",
+			want:     true,
+			wantErr:  nil,
+		},
+		{
+			name:     "Multiple windows, one of them incorrect",
+			crontab:  "9-18 1-5; 10-14",
+			t:        time.Date(2019, time.May, 9, 10, 0, 0, 0, time.UTC),
+			timezone: "UTC",
+			want:     false,
+			wantErr:  errors.New("expected exactly 5 fields"),
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -202,3 +266,80 @@ func Test_runAction(t *testing.T) {
 		})
 	}
 }
+
+func Test_insideBlackout(t *testing.T) {
+
+	tests := []struct {
+		name          string
+		blackoutDates string
+		t             time.Time
+		timezone      string
+		want          bool
+		wantErr       bool
+	}{
+		{
+			name: "No blackout dates",
+			t:    time.Date(2023, time.November, 24, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:          "Inside a single day",
+			blackoutDates: "2023-11-24",
+			t:             time.Date(2023, time.November, 24, 23, 59, 0, 0, time.UTC),
+			timezone:      "UTC",
+			want:          true,
+		},
+		{
+			name:          "After a single day",
+			blackoutDates: "2023-11-24",
+			t:             time.Date(2023, time.November, 25, 0, 0, 0, 0, time.UTC),
+			timezone:      "UTC",
+			want:          false,
+		},
+		{
+			name:          "Inside the last day of a range",
+			blackoutDates: "2023-12-24, 2023-11-24/2023-11-27",
+			t:             time.Date(2023, time.November, 27, 18, 0, 0, 0, time.UTC),
+			timezone:      "UTC",
+			want:          true,
+		},
+		{
+			name:          "Range with times, after its end",
+			blackoutDates: "2023-12-24T18:00/2023-12-26T06:00",
+			t:             time.Date(2023, time.December, 26, 6, 0, 0, 0, time.UTC),
+			timezone:      "UTC",
+			want:          false,
+		},
+		{
+			name:          "Inside in the timezone, outside in UTC",
+			blackoutDates: "2023-11-24",
+			t:             time.Date(2023, time.November, 24, 5, 0, 0, 0, time.UTC),
+			timezone:      "America/New_York",
+			want:          true,
+		},
+		{
+			name:          "Range ending before it starts",
+			blackoutDates: "2023-11-27/2023-11-24",
+			t:             time.Date(2023, time.November, 25, 0, 0, 0, 0, time.UTC),
+			timezone:      "UTC",
+			wantErr:       true,
+		},
+		{
+			name:          "Invalid date",
+			blackoutDates: "Black Friday",
+			t:             time.Date(2023, time.November, 24, 10, 0, 0, 0, time.UTC),
+			timezone:      "UTC",
+			wantErr:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := insideBlackout(tt.t, tt.blackoutDates, tt.timezone)
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("insideBlackout() = %v, %v want %v, %v", got, err, tt.want, tt.wantErr)
+			}
+			if run := blackoutRunAction(tt.t, tt.blackoutDates, tt.timezone); run != (!tt.want && !tt.wantErr) {
+				t.Errorf("blackoutRunAction() = %v", run)
+			}
+		})
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// scoring_strategy.go contains the strategies used for ordering the compatible
+// Spot instance types, which then determine the priorities of the instance
+// types passed to CreateFleet.
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// ScoringStrategyTag is the name of the tag set on the AutoScaling Group
+	// that can override the global value of the ScoringStrategy parameter
+	ScoringStrategyTag = "autospotting_scoring_strategy"
+
+	// ScoringWeightsTag is the name of the tag set on the AutoScaling Group
+	// that can override the global value of the ScoringWeights parameter
+	ScoringWeightsTag = "autospotting_scoring_weights"
+
+	// DefaultScoringStrategy orders the instance types by their expected cost
+	DefaultScoringStrategy = "cheapest"
+
+	// DefaultScoringWeights are the default weights of the price, stability and
+	// capacity factors used by the weighted scoring strategy
+	DefaultScoringWeights = "price=0.6,stability=0.3,capacity=0.1"
+
+	// the score used for the capacity factor of the instance types without a
+	// known Spot placement score, halfway between the best and the worst
+	neutralCapacityFactor = 0.5
+)
+
+// scoringCandidate is a compatible Spot instance type being scored
+type scoringCandidate struct {
+	acceptableInstance
+
+	// interruption rate percentage estimated for the Spot pool
+	interruptionRate float64
+}
+
+// scoringContext contains the data shared by the scoring of all the
+// candidates of an instance.
+type scoringContext struct {
+	current *instance
+
+	// lowest expected cost among the candidates
+	cheapest float64
+
+	// best Spot placement score of the candidates for which it's known
+	placementScores map[string]int64
+
+	weights scoringWeights
+}
+
+// scoringStrategy orders the compatible Spot instance types.
+type scoringStrategy interface {
+	// score returns the score of a candidate, the lower the better, as well
+	// as an explanation of how it was computed. Candidates with the same
+	// score are ordered by their expected cost.
+	score(ctx *scoringContext, c *scoringCandidate) (float64, string)
+}
+
+type cheapestStrategy struct{}
+
+func (cheapestStrategy) score(ctx *scoringContext, c *scoringCandidate) (float64, string) {
+	return c.expectedCost, fmt.Sprintf("expected cost $%.4f/hour with a %.1f%% interruption rate",
+		c.expectedCost, c.interruptionRate)
+}
+
+type cheapestPerVCPUStrategy struct{}
+
+func (cheapestPerVCPUStrategy) score(ctx *scoringContext, c *scoringCandidate) (float64, string) {
+	vCPU := c.instanceTI.vCPU
+	if vCPU <= 0 {
+		vCPU = 1
+	}
+	perVCPU := c.expectedCost / float64(vCPU)
+	return perVCPU, fmt.Sprintf("expected cost $%.4f/hour for %d vCPUs, $%.4f/vCPU",
+		c.expectedCost, c.instanceTI.vCPU, perVCPU)
+}
+
+type cheapestPerGiBStrategy struct{}
+
+func (cheapestPerGiBStrategy) score(ctx *scoringContext, c *scoringCandidate) (float64, string) {
+	memory := float64(c.instanceTI.memory)
+	if memory <= 0 {
+		memory = 1
+	}
+	perGiB := c.expectedCost / memory
+	return perGiB, fmt.Sprintf("expected cost $%.4f/hour for %.1f GiB, $%.4f/GiB",
+		c.expectedCost, c.instanceTI.memory, perGiB)
+}
+
+type preferSameFamilyStrategy struct{}
+
+func (preferSameFamilyStrategy) score(ctx *scoringContext, c *scoringCandidate) (float64, string) {
+	current := instanceFamily(ctx.current.typeInfo.instanceType)
+	if instanceFamily(c.instanceTI.instanceType) == current {
+		return 0, fmt.Sprintf("same %s family, expected cost $%.4f/hour", current, c.expectedCost)
+	}
+	return 1, fmt.Sprintf("other family than %s, expected cost $%.4f/hour", current, c.expectedCost)
+}
+
+type preferNewestGenerationStrategy struct{}
+
+func (preferNewestGenerationStrategy) score(ctx *scoringContext, c *scoringCandidate) (float64, string) {
+	generation := instanceGeneration(c.instanceTI.instanceType)
+	return -float64(generation), fmt.Sprintf("generation %d, expected cost $%.4f/hour",
+		generation, c.expectedCost)
+}
+
+// weightedStrategy combines the price relative to the cheapest candidate, the
+// interruption rate and the Spot placement score into a single score.
+type weightedStrategy struct{}
+
+func (weightedStrategy) score(ctx *scoringContext, c *scoringCandidate) (float64, string) {
+	price := 1.0
+	if ctx.cheapest > 0 {
+		price = c.expectedCost / ctx.cheapest
+	}
+
+	stability := c.interruptionRate / 100
+
+	capacity := neutralCapacityFactor
+	if s, found := ctx.placementScores[c.instanceTI.instanceType]; found {
+		// placement scores range from 1 to 10, higher is better
+		capacity = float64(10-s) / 9
+	}
+
+	w := ctx.weights
+	score := w.price*price + w.stability*stability + w.capacity*capacity
+	return score, fmt.Sprintf("%.2f*%.3f price + %.2f*%.3f interruptions + %.2f*%.3f capacity",
+		w.price, price, w.stability, stability, w.capacity, capacity)
+}
+
+// scoringStrategies are the available strategies, keyed by their name
+var scoringStrategies = map[string]scoringStrategy{
+	"cheapest":                 cheapestStrategy{},
+	"cheapest-per-vcpu":        cheapestPerVCPUStrategy{},
+	"cheapest-per-gib":         cheapestPerGiBStrategy{},
+	"prefer-same-family":       preferSameFamilyStrategy{},
+	"prefer-newest-generation": preferNewestGenerationStrategy{},
+	"weighted":                 weightedStrategy{},
+}
+
+// scoringWeights are the weights of the factors of the weighted strategy
+type scoringWeights struct {
+	price     float64
+	stability float64
+	capacity  float64
+}
+
+// parseScoringWeights parses weights such as "price=0.6,stability=0.3,capacity=0.1",
+// the factors missing from the list have a weight of 0.
+func parseScoringWeights(value string) (scoringWeights, error) {
+	var w scoringWeights
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return w, fmt.Errorf("invalid scoring weight %q, expected factor=weight", item)
+		}
+
+		weight, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || weight < 0 {
+			return w, fmt.Errorf("invalid scoring weight %q", item)
+		}
+
+		switch strings.TrimSpace(parts[0]) {
+		case "price":
+			w.price = weight
+		case "stability":
+			w.stability = weight
+		case "capacity":
+			w.capacity = weight
+		default:
+			return w, fmt.Errorf("unknown scoring factor %q, expected price, stability or capacity", parts[0])
+		}
+	}
+	return w, nil
+}
+
+func instanceFamily(instanceType string) string {
+	return strings.SplitN(instanceType, ".", 2)[0]
+}
+
+// scoringStrategy returns the strategy configured for the group, falling back
+// to ordering by the expected cost.
+func (i *instance) scoringStrategy() (string, scoringStrategy) {
+	name := DefaultScoringStrategy
+	if i.asg != nil && i.asg.config.ScoringStrategy != "" {
+		name = i.asg.config.ScoringStrategy
+	}
+
+	if s, found := scoringStrategies[name]; found {
+		return name, s
+	}
+	log.Println("Unknown scoring strategy", name, "using", DefaultScoringStrategy)
+	return DefaultScoringStrategy, scoringStrategies[DefaultScoringStrategy]
+}
+
+func (i *instance) scoringWeights() scoringWeights {
+	value := DefaultScoringWeights
+	if i.asg != nil && i.asg.config.ScoringWeights != "" {
+		value = i.asg.config.ScoringWeights
+	}
+
+	w, err := parseScoringWeights(value)
+	if err != nil {
+		log.Println("Using the default scoring weights,", err.Error())
+		w, _ = parseScoringWeights(DefaultScoringWeights)
+	}
+	return w
+}
+
+// newScoringContext prepares the data needed by the strategies. The Spot
+// placement scores are only fetched for the weighted strategy, with a single
+// query for the cheapest candidates so we don't run into the API limits.
+func (i *instance) newScoringContext(strategy scoringStrategy, candidates []scoringCandidate) *scoringContext {
+	ctx := &scoringContext{current: i}
+
+	for _, c := range candidates {
+		if ctx.cheapest == 0 || c.expectedCost < ctx.cheapest {
+			ctx.cheapest = c.expectedCost
+		}
+	}
+
+	if _, weighted := strategy.(weightedStrategy); !weighted {
+		return ctx
+	}
+
+	ctx.weights = i.scoringWeights()
+
+	if ctx.weights.capacity == 0 || i.region == nil || !i.region.spotPlacementScoresEnabled() {
+		return ctx
+	}
+
+	byCost := make([]scoringCandidate, len(candidates))
+	copy(byCost, candidates)
+	sort.SliceStable(byCost, func(a, b int) bool {
+		return byCost[a].expectedCost < byCost[b].expectedCost
+	})
+	if len(byCost) > spotPlacementScoreMaxInstanceTypes {
+		byCost = byCost[:spotPlacementScoreMaxInstanceTypes]
+	}
+
+	queried := make([]*string, 0, len(byCost))
+	for _, c := range byCost {
+		queried = append(queried, aws.String(c.instanceTI.instanceType))
+	}
+
+	// the API scores all the candidates together, so a single call is made and
+	// each candidate gets the best score of the availability zones of the group
+	// in which it's available as Spot
+	scores, err := i.region.spotPlacementScores(queried)
+	if err != nil || i.asg == nil {
+		return ctx
+	}
+	i.placementScores = scores
+
+	subnets, err := i.asg.subnetPlacements()
+	if err != nil {
+		return ctx
+	}
+
+	ctx.placementScores = make(map[string]int64)
+	for _, c := range byCost {
+		for _, s := range subnets {
+			if _, offered := c.instanceTI.pricing.spot[s.availabilityZone]; !offered {
+				continue
+			}
+			if score := scores[s.availabilityZoneID]; score > ctx.placementScores[c.instanceTI.instanceType] {
+				ctx.placementScores[c.instanceTI.instanceType] = score
+			}
+		}
+	}
+	return ctx
+}
+
+// rankCandidates orders the candidates using the strategy configured for the
+// group, logging the score of each of them.
+func (i *instance) rankCandidates(candidates []scoringCandidate) []scoringCandidate {
+	name, strategy := i.scoringStrategy()
+	ctx := i.newScoringContext(strategy, candidates)
+
+	scores := make(map[string]float64, len(candidates))
+	for idx := range candidates {
+		c := &candidates[idx]
+		score, explanation := strategy.score(ctx, c)
+		scores[c.instanceTI.instanceType] = score
+		debug.Printf("\t%s scored %.4f by the %s strategy: %s\n",
+			c.instanceTI.instanceType, score, name, explanation)
+	}
+
+	sort.SliceStable(candidates, func(a, b int) bool {
+		sa := scores[candidates[a].instanceTI.instanceType]
+		sb := scores[candidates[b].instanceTI.instanceType]
+		if sa != sb {
+			return sa < sb
+		}
+		return candidates[a].expectedCost < candidates[b].expectedCost
+	})
+
+	if len(candidates) > 0 {
+		best := &candidates[0]
+		_, explanation := strategy.score(ctx, best)
+		log.Printf("%s picked %s as the best Spot instance type for replacing %s using the %s strategy: %s\n",
+			i.region.name, best.instanceTI.instanceType, *i.InstanceId, name, explanation)
+	}
+	return candidates
+}
+
+func (a *autoScalingGroup) loadScoringStrategy() bool {
+	a.config.ScoringStrategy = a.region.conf.ScoringStrategy
+	a.config.ScoringWeights = a.region.conf.ScoringWeights
+	found := false
+
+	if tagValue := a.getTagValue(ScoringStrategyTag); tagValue != nil {
+		if _, valid := scoringStrategies[*tagValue]; valid {
+			log.Printf("Loaded ScoringStrategy value %v from tag %v\n", *tagValue, ScoringStrategyTag)
+			a.config.ScoringStrategy = *tagValue
+			found = true
+		} else {
+			log.Printf("Ignoring unknown ScoringStrategy value %v from tag %v\n", *tagValue, ScoringStrategyTag)
+		}
+	}
+
+	if tagValue := a.getTagValue(ScoringWeightsTag); tagValue != nil {
+		if _, err := parseScoringWeights(*tagValue); err == nil {
+			log.Printf("Loaded ScoringWeights value %v from tag %v\n", *tagValue, ScoringWeightsTag)
+			a.config.ScoringWeights = *tagValue
+			found = true
+		} else {
+			log.Printf("Ignoring ScoringWeights value from tag %v: %s\n", ScoringWeightsTag, err.Error())
+		}
+	}
+	return found
+}
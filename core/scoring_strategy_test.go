@@ -0,0 +1,259 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_parseScoringWeights(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    scoringWeights
+		wantErr bool
+	}{
+		{value: DefaultScoringWeights, want: scoringWeights{price: 0.6, stability: 0.3, capacity: 0.1}},
+		{value: "price=1", want: scoringWeights{price: 1}},
+		{value: " price = 0.5 , stability=0.5 ", want: scoringWeights{price: 0.5, stability: 0.5}},
+		{value: "price", wantErr: true},
+		{value: "price=-1", wantErr: true},
+		{value: "speed=1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseScoringWeights(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseScoringWeights() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseScoringWeights() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func scoredCandidate(instanceType string, vCPU int, memory float32, expectedCost, interruptionRate float64) scoringCandidate {
+	return scoringCandidate{
+		acceptableInstance: acceptableInstance{
+			instanceTI:   instanceTypeInformation{instanceType: instanceType, vCPU: vCPU, memory: memory},
+			price:        expectedCost,
+			expectedCost: expectedCost,
+		},
+		interruptionRate: interruptionRate,
+	}
+}
+
+func Test_instance_rankCandidates(t *testing.T) {
+	candidates := func() []scoringCandidate {
+		return []scoringCandidate{
+			scoredCandidate("m5.large", 2, 8, 0.040, 10),
+			scoredCandidate("c5.xlarge", 4, 8, 0.056, 5),
+			scoredCandidate("r6i.large", 2, 16, 0.050, 3),
+			scoredCandidate("m4.large", 2, 8, 0.030, 22),
+		}
+	}
+
+	tests := []struct {
+		name     string
+		strategy string
+		weights  string
+		want     []string
+	}{
+		{
+			name:     "default",
+			strategy: "",
+			want:     []string{"m4.large", "m5.large", "r6i.large", "c5.xlarge"},
+		},
+		{
+			name:     "unknown strategy",
+			strategy: "random",
+			want:     []string{"m4.large", "m5.large", "r6i.large", "c5.xlarge"},
+		},
+		{
+			name:     "cheapest per vCPU",
+			strategy: "cheapest-per-vcpu",
+			want:     []string{"c5.xlarge", "m4.large", "m5.large", "r6i.large"},
+		},
+		{
+			name:     "cheapest per GiB",
+			strategy: "cheapest-per-gib",
+			want:     []string{"r6i.large", "m4.large", "m5.large", "c5.xlarge"},
+		},
+		{
+			name:     "prefer same family",
+			strategy: "prefer-same-family",
+			want:     []string{"m5.large", "m4.large", "r6i.large", "c5.xlarge"},
+		},
+		{
+			name:     "prefer newest generation",
+			strategy: "prefer-newest-generation",
+			want:     []string{"r6i.large", "m5.large", "c5.xlarge", "m4.large"},
+		},
+		{
+			name:     "weighted towards stability",
+			strategy: "weighted",
+			weights:  "price=0.1,stability=0.9",
+			want:     []string{"r6i.large", "m5.large", "c5.xlarge", "m4.large"},
+		},
+		{
+			name:     "weighted towards price",
+			strategy: "weighted",
+			weights:  "price=1",
+			want:     []string{"m4.large", "m5.large", "r6i.large", "c5.xlarge"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-1")},
+				typeInfo: instanceTypeInformation{instanceType: "m5.xlarge"},
+				region:   &region{name: "us-east-1", conf: &Config{}},
+				asg: &autoScalingGroup{config: AutoScalingConfig{
+					ScoringStrategy: tt.strategy,
+					ScoringWeights:  tt.weights,
+				}},
+			}
+
+			var got []string
+			for _, c := range i.rankCandidates(candidates()) {
+				got = append(got, c.instanceTI.instanceType)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rankCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_newScoringContext_placementScores(t *testing.T) {
+	offeredIn := func(c scoringCandidate, zone string) scoringCandidate {
+		c.instanceTI.pricing.spot = spotPriceMap{zone: c.price}
+		return c
+	}
+	candidates := []scoringCandidate{
+		offeredIn(scoredCandidate("c5.large", 2, 4, 0.050, 5), "us-east-1b"),
+		offeredIn(scoredCandidate("m5.large", 2, 8, 0.040, 10), "us-east-1a"),
+	}
+
+	r := &region{
+		name: "scoring-batch",
+		conf: &Config{EnableSpotPlacementScores: true},
+		services: connections{ec2: mockEC2{
+			// a single query for all the candidates, cheapest first
+			gspspo: map[string]*ec2.GetSpotPlacementScoresOutput{
+				"m5.large,c5.large": {SpotPlacementScores: []*ec2.SpotPlacementScore{
+					{AvailabilityZoneId: aws.String("use1-az1"), Score: aws.Int64(9)},
+					{AvailabilityZoneId: aws.String("use1-az2"), Score: aws.Int64(2)},
+				}},
+			},
+			dsno: &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+				{SubnetId: aws.String("subnet-a"), AvailabilityZone: aws.String("us-east-1a"), AvailabilityZoneId: aws.String("use1-az1")},
+				{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("us-east-1b"), AvailabilityZoneId: aws.String("use1-az2")},
+			}},
+		}},
+	}
+	i := &instance{
+		Instance: &ec2.Instance{InstanceId: aws.String("i-1"), SubnetId: aws.String("subnet-b")},
+		region:   r,
+		asg: &autoScalingGroup{
+			Group:  &autoscaling.Group{VPCZoneIdentifier: aws.String("subnet-a,subnet-b")},
+			region: r,
+			config: AutoScalingConfig{ScoringWeights: DefaultScoringWeights},
+		},
+	}
+
+	ctx := i.newScoringContext(weightedStrategy{}, candidates)
+	if want := map[string]int64{"m5.large": 9, "c5.large": 2}; !reflect.DeepEqual(ctx.placementScores, want) {
+		t.Errorf("newScoringContext() placement scores = %v, want %v", ctx.placementScores, want)
+	}
+
+	// picking the subnet reuses the scores instead of querying them again
+	r.services.ec2 = mockEC2{gspsperr: errors.New("throttled"), dsno: r.services.ec2.(mockEC2).dsno}
+	if subnet := i.applySpotPlacementScores([]*string{aws.String("m5.large")}); subnet == nil || subnet.subnetID != "subnet-a" {
+		t.Errorf("applySpotPlacementScores() = %v, want subnet-a", subnet)
+	}
+}
+
+func Test_weightedStrategy_capacity(t *testing.T) {
+	ctx := &scoringContext{
+		cheapest:        0.04,
+		placementScores: map[string]int64{"m5.large": 9, "c5.large": 1},
+		weights:         scoringWeights{capacity: 1},
+	}
+
+	tests := []struct {
+		instanceType string
+		want         float64
+	}{
+		{instanceType: "m5.large", want: 1.0 / 9},
+		{instanceType: "c5.large", want: 1},
+		{instanceType: "r5.large", want: neutralCapacityFactor},
+	}
+	for _, tt := range tests {
+		t.Run(tt.instanceType, func(t *testing.T) {
+			c := scoredCandidate(tt.instanceType, 2, 8, 0.04, 0)
+			if got, _ := (weightedStrategy{}).score(ctx, &c); got != tt.want {
+				t.Errorf("score() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_loadScoringStrategy(t *testing.T) {
+	tests := []struct {
+		name         string
+		tags         []*autoscaling.TagDescription
+		wantStrategy string
+		wantWeights  string
+		wantOK       bool
+	}{
+		{
+			name:         "defaults",
+			wantStrategy: "cheapest",
+			wantWeights:  DefaultScoringWeights,
+		},
+		{
+			name: "overridden",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(ScoringStrategyTag), Value: aws.String("weighted")},
+				{Key: aws.String(ScoringWeightsTag), Value: aws.String("price=1")},
+			},
+			wantStrategy: "weighted",
+			wantWeights:  "price=1",
+			wantOK:       true,
+		},
+		{
+			name: "invalid",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(ScoringStrategyTag), Value: aws.String("random")},
+				{Key: aws.String(ScoringWeightsTag), Value: aws.String("luck=1")},
+			},
+			wantStrategy: "cheapest",
+			wantWeights:  DefaultScoringWeights,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{Tags: tt.tags},
+				region: &region{conf: &Config{AutoScalingConfig: AutoScalingConfig{
+					ScoringStrategy: DefaultScoringStrategy,
+					ScoringWeights:  DefaultScoringWeights,
+				}}},
+			}
+
+			ok := a.loadScoringStrategy()
+			if ok != tt.wantOK || a.config.ScoringStrategy != tt.wantStrategy || a.config.ScoringWeights != tt.wantWeights {
+				t.Errorf("loadScoringStrategy() = %v, %v, %v, want %v, %v, %v", ok, a.config.ScoringStrategy,
+					a.config.ScoringWeights, tt.wantOK, tt.wantStrategy, tt.wantWeights)
+			}
+		})
+	}
+}
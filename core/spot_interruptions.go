@@ -0,0 +1,380 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// spot_interruptions.go contains the logic used for estimating how often Spot
+// pools get interrupted, based on the public Spot Instance Advisor data and on
+// the interruptions observed by AutoSpotting itself.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// PublicSpotAdvisorData is the location of the public Spot Instance
+	// Advisor dataset, the same data shown on the AWS Spot Instance Advisor
+	// page.
+	PublicSpotAdvisorData = "https://spot-bid-advisor.s3.amazonaws.com/spot-advisor-data.json"
+
+	// DefaultSpotAdvisorData is empty so the dataset is only downloaded when
+	// requested, and offline runs don't depend on the network.
+	DefaultSpotAdvisorData = ""
+
+	// ObservedInterruptionsTag is the name of the tag set by AutoSpotting on
+	// the AutoScaling groups to keep track of the Spot interruptions it has
+	// observed recently in each group, as a space separated list of
+	// instance-type/availability-zone/unix-timestamp entries.
+	ObservedInterruptionsTag = "autospotting_observed_interruptions"
+
+	// observedInterruptionsWindow is the time interval for which an observed
+	// interruption is considered relevant, matching the monthly rates reported
+	// by the Spot Instance Advisor.
+	observedInterruptionsWindow = 30 * 24 * time.Hour
+
+	// observedInterruptionPenalty is the amount of percentage points added to
+	// the estimated interruption rate of a Spot pool for each interruption
+	// observed in it during the last observedInterruptionsWindow.
+	observedInterruptionPenalty = 5.0
+
+	// maxTagValueLength is the maximum length of an AutoScaling tag value.
+	maxTagValueLength = 256
+)
+
+// The interruption frequency buckets used by the Spot Instance Advisor, indexed
+// by the "r" field of the dataset. The upper bound is used when excluding
+// volatile pools and the midpoint is used when estimating the expected cost.
+var interruptionBuckets = []struct {
+	label      string
+	upperBound float64
+	midpoint   float64
+}{
+	{label: "<5%", upperBound: 5, midpoint: 2.5},
+	{label: "5-10%", upperBound: 10, midpoint: 7.5},
+	{label: "10-15%", upperBound: 15, midpoint: 12.5},
+	{label: "15-20%", upperBound: 20, midpoint: 17.5},
+	{label: ">20%", upperBound: 100, midpoint: 25},
+}
+
+// spotAdvisorData maps the Spot Instance Advisor JSON document, we only need
+// the per region, operating system and instance type information.
+type spotAdvisorData struct {
+	SpotAdvisor map[string]map[string]map[string]spotAdvisorEntry `json:"spot_advisor"`
+}
+
+type spotAdvisorEntry struct {
+	Savings       int `json:"s"`
+	Interruptions int `json:"r"`
+}
+
+// loadSpotAdvisorData reads the Spot Instance Advisor dataset from a local file
+// or from a http(s) URL.
+func loadSpotAdvisorData(source string) (*spotAdvisorData, error) {
+	var body []byte
+	var err error
+
+	if source == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		client := &http.Client{Timeout: 30 * time.Second}
+		resp, err := client.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected HTTP status %s while downloading %s", resp.Status, source)
+		}
+
+		if body, err = ioutil.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+	} else if body, err = ioutil.ReadFile(source); err != nil {
+		return nil, err
+	}
+
+	var data spotAdvisorData
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("couldn't parse Spot Advisor data from %s: %s", source, err.Error())
+	}
+	return &data, nil
+}
+
+// interruptionBucket returns the Spot Instance Advisor interruption bucket of
+// an instance type, or false if the dataset doesn't know about it.
+func (d *spotAdvisorData) interruptionBucket(region, operatingSystem, instanceType string) (int, bool) {
+	if d == nil {
+		return 0, false
+	}
+
+	entry, found := d.SpotAdvisor[region][operatingSystem][instanceType]
+	if !found || entry.Interruptions < 0 || entry.Interruptions >= len(interruptionBuckets) {
+		return 0, false
+	}
+	return entry.Interruptions, true
+}
+
+// spotAdvisorData returns the Spot Instance Advisor data loaded at startup, if
+// any.
+func (r *region) spotAdvisorData() *spotAdvisorData {
+	if r.conf == nil {
+		return nil
+	}
+	return r.conf.spotAdvisor
+}
+
+// poolKey identifies a Spot capacity pool
+func poolKey(instanceType, availabilityZone string) string {
+	return instanceType + "/" + availabilityZone
+}
+
+type observedInterruption struct {
+	instanceType     string
+	availabilityZone string
+	time             time.Time
+}
+
+// parseObservedInterruptions decodes the value of the ObservedInterruptionsTag,
+// ignoring malformed entries and those older than the observation window.
+func parseObservedInterruptions(value string, now time.Time) []observedInterruption {
+	return parsePoolEntries(value, now, observedInterruptionsWindow)
+}
+
+// parsePoolEntries decodes a space separated list of
+// instance-type/availability-zone/unix-timestamp entries, ignoring malformed
+// entries and those older than the given window.
+func parsePoolEntries(value string, now time.Time, window time.Duration) []observedInterruption {
+	var result []observedInterruption
+
+	for _, entry := range strings.Fields(value) {
+		fields := strings.Split(entry, "/")
+		if len(fields) != 3 {
+			debug.Println("Ignoring malformed pool entry", entry)
+			continue
+		}
+
+		ts, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			debug.Println("Ignoring pool entry with invalid timestamp", entry)
+			continue
+		}
+
+		t := time.Unix(ts, 0)
+		if now.Sub(t) > window {
+			continue
+		}
+
+		result = append(result, observedInterruption{
+			instanceType:     fields[0],
+			availabilityZone: fields[1],
+			time:             t,
+		})
+	}
+	return result
+}
+
+// formatObservedInterruptions encodes the observed interruptions as the value
+// of the ObservedInterruptionsTag, keeping the most recent entries that fit
+// the tag value length limit.
+func formatObservedInterruptions(interruptions []observedInterruption) string {
+	sorted := make([]observedInterruption, len(interruptions))
+	copy(sorted, interruptions)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].time.After(sorted[j].time)
+	})
+
+	var entries []string
+	length := 0
+	for _, oi := range sorted {
+		entry := fmt.Sprintf("%s/%s/%d", oi.instanceType, oi.availabilityZone, oi.time.Unix())
+		if length+len(entry)+len(entries) > maxTagValueLength {
+			break
+		}
+		entries = append(entries, entry)
+		length += len(entry)
+	}
+
+	// store them chronologically, it's easier to read them this way
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return strings.Join(entries, " ")
+}
+
+// observedInterruptions counts the recent interruptions recorded on the tags of
+// all the enabled groups from the region, per Spot pool.
+func (r *region) observedInterruptions() map[string]int {
+	result := make(map[string]int)
+	now := time.Now()
+
+	for _, asg := range r.enabledASGs {
+		tagValue := asg.getTagValue(ObservedInterruptionsTag)
+		if tagValue == nil {
+			continue
+		}
+		for _, oi := range parseObservedInterruptions(*tagValue, now) {
+			result[poolKey(oi.instanceType, oi.availabilityZone)]++
+		}
+	}
+	return result
+}
+
+// launchZones returns the availability zones in which the Spot instance may
+// be launched, which are those of all the subnets of the group when it can be
+// launched in any of them or moved by the Spot placement scores, otherwise the
+// one of the instance being replaced.
+func (i *instance) launchZones() []string {
+	current := []string{aws.StringValue(i.Placement.AvailabilityZone)}
+
+	if i.asg == nil || (!i.asg.config.LaunchInAllSubnets && !i.region.spotPlacementScoresEnabled()) {
+		return current
+	}
+
+	subnets, err := i.asg.subnetPlacements()
+	if err != nil || len(subnets) == 0 {
+		return current
+	}
+
+	var zones []string
+	for _, s := range subnets {
+		if !itemInSlice(s.availabilityZone, zones) {
+			zones = append(zones, s.availabilityZone)
+		}
+	}
+	return zones
+}
+
+// estimatedInterruptionRate returns the estimated monthly interruption rate of
+// an instance type as a percentage, based on the Spot Instance Advisor data and
+// the interruptions observed by AutoSpotting in its Spot pools from the given
+// availability zones, taking the least interrupted of them since the Spot
+// instance may be launched in any of them.
+func (i *instance) estimatedInterruptionRate(instanceType string, zones []string, observed map[string]int) float64 {
+	var rate float64
+
+	if bucket, found := i.region.spotAdvisorData().interruptionBucket(
+		i.region.name, i.spotAdvisorOperatingSystem(), instanceType); found {
+		rate = interruptionBuckets[bucket].midpoint
+	}
+
+	interruptions := -1
+	for _, az := range zones {
+		if n := observed[poolKey(instanceType, az)]; interruptions < 0 || n < interruptions {
+			interruptions = n
+		}
+	}
+	if interruptions > 0 {
+		rate += float64(interruptions) * observedInterruptionPenalty
+	}
+
+	if rate > 100 {
+		rate = 100
+	}
+	return rate
+}
+
+// expectedCost increases the Spot price by the estimated monthly interruption
+// rate, so a pool interrupted 10% of the time costs 10% more. This is a simple
+// penalty for the churn caused by interruptions rather than a model of their
+// actual cost.
+func expectedCost(price, interruptionRate float64) float64 {
+	return price * (1 + interruptionRate/100.0)
+}
+
+// isInterruptionRateCompatible excludes the instance types whose Spot Instance
+// Advisor interruption bucket exceeds the maximum allowed for the group.
+func (i *instance) isInterruptionRateCompatible(spotCandidate *instanceTypeInformation) bool {
+	if i.asg == nil || i.asg.config.MaxInterruptionRate <= 0 {
+		return true
+	}
+
+	bucket, found := i.region.spotAdvisorData().interruptionBucket(
+		i.region.name, i.spotAdvisorOperatingSystem(), spotCandidate.instanceType)
+
+	if !found {
+		return true
+	}
+
+	if interruptionBuckets[bucket].upperBound > i.asg.config.MaxInterruptionRate {
+		debug.Println("\tInterruption frequency", interruptionBuckets[bucket].label,
+			"exceeds the maximum allowed of", i.asg.config.MaxInterruptionRate, "percent")
+		return false
+	}
+	return true
+}
+
+// isSpotInterrupted returns whether the instance was stopped or terminated by
+// EC2 because of a Spot interruption.
+func isSpotInterrupted(inst *ec2.Instance) bool {
+	return inst.StateReason != nil &&
+		strings.HasPrefix(aws.StringValue(inst.StateReason.Code), "Server.SpotInstance")
+}
+
+// recordInterruption stores the instance type and availability zone of an
+// interrupted Spot instance on the tags of its AutoScaling group.
+func (s *SpotTermination) recordInterruption(instanceID *string) error {
+	resp, err := s.ec2Svc.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{instanceID},
+	})
+
+	if err != nil {
+		log.Printf("Failed to describe interrupted instance %s: %s\n", *instanceID, err.Error())
+		return err
+	}
+
+	if len(resp.Reservations) == 0 || len(resp.Reservations[0].Instances) == 0 {
+		return fmt.Errorf("interrupted instance %s is missing", *instanceID)
+	}
+
+	inst := resp.Reservations[0].Instances[0]
+	now := time.Now()
+
+	var interruptions []observedInterruption
+	if tagValue := s.asg.getTagValue(ObservedInterruptionsTag); tagValue != nil {
+		interruptions = parseObservedInterruptions(*tagValue, now)
+	}
+
+	interruptions = append(interruptions, observedInterruption{
+		instanceType:     *inst.InstanceType,
+		availabilityZone: *inst.Placement.AvailabilityZone,
+		time:             now,
+	})
+
+	_, err = s.asSvc.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{
+		Tags: []*autoscaling.Tag{
+			{
+				ResourceId:        aws.String(s.asg.name),
+				ResourceType:      aws.String("auto-scaling-group"),
+				Key:               aws.String(ObservedInterruptionsTag),
+				Value:             aws.String(formatObservedInterruptions(interruptions)),
+				PropagateAtLaunch: aws.Bool(false),
+			},
+		},
+	})
+
+	if err != nil {
+		log.Printf("Failed to record the interruption of %s on the group %s: %s\n",
+			*instanceID, s.asg.name, err.Error())
+		return err
+	}
+
+	log.Printf("Recorded interruption of %s instance %s in %s on the group %s\n",
+		*inst.InstanceType, *instanceID, *inst.Placement.AvailabilityZone, s.asg.name)
+	return nil
+}
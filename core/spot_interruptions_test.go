@@ -0,0 +1,450 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_loadSpotAdvisorData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autospotting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "valid.json")
+	ioutil.WriteFile(valid, []byte(`{"spot_advisor":{"us-east-1":{"Linux":{"m5.large":{"s":70,"r":1}}}}}`), 0644)
+
+	invalid := filepath.Join(dir, "invalid.json")
+	ioutil.WriteFile(invalid, []byte(`{"spot_advisor":`), 0644)
+
+	tests := []struct {
+		name    string
+		source  string
+		want    *spotAdvisorData
+		wantErr bool
+	}{
+		{
+			name:   "No source configured",
+			source: "",
+			want:   nil,
+		},
+		{
+			name:   "Valid local file",
+			source: valid,
+			want: &spotAdvisorData{
+				SpotAdvisor: map[string]map[string]map[string]spotAdvisorEntry{
+					"us-east-1": {
+						"Linux": {
+							"m5.large": {Savings: 70, Interruptions: 1},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:    "Invalid local file",
+			source:  invalid,
+			wantErr: true,
+		},
+		{
+			name:    "Missing local file",
+			source:  filepath.Join(dir, "missing.json"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadSpotAdvisorData(tt.source)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadSpotAdvisorData() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("loadSpotAdvisorData() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_spotAdvisorData_interruptionBucket(t *testing.T) {
+	data := &spotAdvisorData{
+		SpotAdvisor: map[string]map[string]map[string]spotAdvisorEntry{
+			"us-east-1": {
+				"Linux": {
+					"m5.large":  {Interruptions: 1},
+					"c5.large":  {Interruptions: 4},
+					"bogus.big": {Interruptions: 9},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		data         *spotAdvisorData
+		instanceType string
+		want         int
+		wantFound    bool
+	}{
+		{name: "No data loaded", data: nil, instanceType: "m5.large", want: 0, wantFound: false},
+		{name: "Known instance type", data: data, instanceType: "m5.large", want: 1, wantFound: true},
+		{name: "Most volatile bucket", data: data, instanceType: "c5.large", want: 4, wantFound: true},
+		{name: "Unknown instance type", data: data, instanceType: "m6g.large", want: 0, wantFound: false},
+		{name: "Out of range bucket", data: data, instanceType: "bogus.big", want: 0, wantFound: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := tt.data.interruptionBucket("us-east-1", "Linux", tt.instanceType)
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("interruptionBucket() = %v, %v, want %v, %v", got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func Test_parseObservedInterruptions(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name  string
+		value string
+		want  []observedInterruption
+	}{
+		{
+			name:  "Empty value",
+			value: "",
+			want:  nil,
+		},
+		{
+			name:  "Recent and expired entries",
+			value: "m5.large/us-east-1a/1699990000 c5.large/us-east-1b/1600000000",
+			want: []observedInterruption{
+				{instanceType: "m5.large", availabilityZone: "us-east-1a", time: time.Unix(1699990000, 0)},
+			},
+		},
+		{
+			name:  "Malformed entries are ignored",
+			value: "m5.large/us-east-1a c5.large/us-east-1b/yesterday r5.large/us-east-1c/1699999000",
+			want: []observedInterruption{
+				{instanceType: "r5.large", availabilityZone: "us-east-1c", time: time.Unix(1699999000, 0)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseObservedInterruptions(tt.value, now); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseObservedInterruptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_formatObservedInterruptions(t *testing.T) {
+	var many []observedInterruption
+	for i := 0; i < 20; i++ {
+		many = append(many, observedInterruption{
+			instanceType:     "m5.large",
+			availabilityZone: "us-east-1a",
+			time:             time.Unix(int64(1700000000+i), 0),
+		})
+	}
+
+	tests := []struct {
+		name          string
+		interruptions []observedInterruption
+		want          string
+		wantPrefix    string
+	}{
+		{
+			name:          "No interruptions",
+			interruptions: nil,
+			want:          "",
+		},
+		{
+			name: "Entries are stored chronologically",
+			interruptions: []observedInterruption{
+				{instanceType: "c5.large", availabilityZone: "us-east-1b", time: time.Unix(1700000100, 0)},
+				{instanceType: "m5.large", availabilityZone: "us-east-1a", time: time.Unix(1700000000, 0)},
+			},
+			want: "m5.large/us-east-1a/1700000000 c5.large/us-east-1b/1700000100",
+		},
+		{
+			name:          "Oldest entries are dropped when exceeding the tag length",
+			interruptions: many,
+			wantPrefix:    "m5.large/us-east-1a/1700000012 ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatObservedInterruptions(tt.interruptions)
+			if len(got) > maxTagValueLength {
+				t.Errorf("formatObservedInterruptions() returned %d characters, more than %d",
+					len(got), maxTagValueLength)
+			}
+			if tt.wantPrefix != "" {
+				if !strings.HasPrefix(got, tt.wantPrefix) {
+					t.Errorf("formatObservedInterruptions() = %v, want prefix %v", got, tt.wantPrefix)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("formatObservedInterruptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_estimatedInterruptionRate(t *testing.T) {
+	recent := strconv.FormatInt(time.Now().Add(-1*time.Hour).Unix(), 10)
+	advisor := &spotAdvisorData{
+		SpotAdvisor: map[string]map[string]map[string]spotAdvisorEntry{
+			"us-east-1": {
+				"Linux": {
+					"m5.large": {Interruptions: 1},
+					"c5.large": {Interruptions: 4},
+				},
+			},
+		},
+	}
+
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{spotAdvisor: advisor},
+		enabledASGs: []autoScalingGroup{
+			{
+				Group: &autoscaling.Group{
+					Tags: []*autoscaling.TagDescription{
+						{
+							Key: aws.String(ObservedInterruptionsTag),
+							Value: aws.String("m5.large/us-east-1a/" + recent +
+								" m5.large/us-east-1a/" + recent + " m5.large/us-east-1b/" + recent),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		region       *region
+		instanceType string
+		zones        []string
+		want         float64
+	}{
+		{name: "No data available", region: &region{name: "us-east-1"}, instanceType: "m5.large", want: 0},
+		{name: "Advisor data and observed interruptions", region: r, instanceType: "m5.large", want: 17.5},
+		{name: "Least interrupted launch zone", region: r, instanceType: "m5.large",
+			zones: []string{"us-east-1a", "us-east-1b"}, want: 12.5},
+		{name: "No interruptions in the launch zone", region: r, instanceType: "m5.large",
+			zones: []string{"us-east-1c"}, want: 7.5},
+		{name: "Advisor data only", region: r, instanceType: "c5.large", want: 25},
+		{name: "Unknown instance type", region: r, instanceType: "r5.large", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{
+					Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				},
+				region: tt.region,
+			}
+			zones := tt.zones
+			if zones == nil {
+				zones = i.launchZones()
+			}
+			if got := i.estimatedInterruptionRate(tt.instanceType, zones, tt.region.observedInterruptions()); got != tt.want {
+				t.Errorf("estimatedInterruptionRate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_launchZones(t *testing.T) {
+	subnets := &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+		{SubnetId: aws.String("subnet-a"), AvailabilityZone: aws.String("us-east-1a")},
+		{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("us-east-1b")},
+		{SubnetId: aws.String("subnet-c"), AvailabilityZone: aws.String("us-east-1b")},
+	}}
+
+	tests := []struct {
+		name       string
+		allSubnets bool
+		scores     bool
+		subnets    *ec2.DescribeSubnetsOutput
+		want       []string
+	}{
+		{name: "subnet of the instance", subnets: subnets, want: []string{"us-east-1a"}},
+		{name: "all subnets", allSubnets: true, subnets: subnets, want: []string{"us-east-1a", "us-east-1b"}},
+		{name: "Spot placement scores", scores: true, subnets: subnets, want: []string{"us-east-1a", "us-east-1b"}},
+		{name: "no subnets", allSubnets: true, subnets: &ec2.DescribeSubnetsOutput{}, want: []string{"us-east-1a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name:     "us-east-1",
+				conf:     &Config{EnableSpotPlacementScores: tt.scores},
+				services: connections{ec2: mockEC2{dsno: tt.subnets}},
+			}
+			i := &instance{
+				Instance: &ec2.Instance{
+					Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				},
+				region: r,
+				asg: &autoScalingGroup{
+					Group: &autoscaling.Group{
+						VPCZoneIdentifier: aws.String("subnet-a,subnet-b,subnet-c"),
+					},
+					region: r,
+					config: AutoScalingConfig{LaunchInAllSubnets: tt.allSubnets},
+				},
+			}
+			if got := i.launchZones(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("launchZones() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_instance_isInterruptionRateCompatible(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{
+			spotAdvisor: &spotAdvisorData{
+				SpotAdvisor: map[string]map[string]map[string]spotAdvisorEntry{
+					"us-east-1": {
+						"Linux": {
+							"m5.large": {Interruptions: 1},
+							"c5.large": {Interruptions: 3},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		maxRate      float64
+		instanceType string
+		want         bool
+	}{
+		{name: "Filter disabled", maxRate: 0, instanceType: "c5.large", want: true},
+		{name: "Bucket within the limit", maxRate: 10, instanceType: "m5.large", want: true},
+		{name: "Bucket exceeding the limit", maxRate: 10, instanceType: "c5.large", want: false},
+		{name: "Bucket exceeding a limit within the bucket", maxRate: 7, instanceType: "m5.large", want: false},
+		{name: "Unknown instance type", maxRate: 5, instanceType: "r5.large", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				region: r,
+				asg: &autoScalingGroup{
+					config: AutoScalingConfig{MaxInterruptionRate: tt.maxRate},
+				},
+			}
+			if got := i.isInterruptionRateCompatible(&instanceTypeInformation{instanceType: tt.instanceType}); got != tt.want {
+				t.Errorf("isInterruptionRateCompatible() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordInterruption(t *testing.T) {
+	tests := []struct {
+		name     string
+		ec2      mockEC2
+		asSvc    mockASG
+		expected error
+	}{
+		{
+			name: "Interruption recorded",
+			ec2: mockEC2{
+				dio: &ec2.DescribeInstancesOutput{
+					Reservations: []*ec2.Reservation{
+						{
+							Instances: []*ec2.Instance{
+								{
+									InstanceId:   aws.String("i-1"),
+									InstanceType: aws.String("m5.large"),
+									Placement:    &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+								},
+							},
+						},
+					},
+				},
+			},
+			asSvc:    mockASG{},
+			expected: nil,
+		},
+		{
+			name: "Instance missing",
+			ec2: mockEC2{
+				dio: &ec2.DescribeInstancesOutput{},
+			},
+			asSvc:    mockASG{},
+			expected: errors.New("interrupted instance i-1 is missing"),
+		},
+		{
+			name: "Describe error",
+			ec2: mockEC2{
+				dierr: errors.New("describe failed"),
+			},
+			asSvc:    mockASG{},
+			expected: errors.New("describe failed"),
+		},
+		{
+			name: "Tagging error",
+			ec2: mockEC2{
+				dio: &ec2.DescribeInstancesOutput{
+					Reservations: []*ec2.Reservation{
+						{
+							Instances: []*ec2.Instance{
+								{
+									InstanceId:   aws.String("i-1"),
+									InstanceType: aws.String("m5.large"),
+									Placement:    &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+								},
+							},
+						},
+					},
+				},
+			},
+			asSvc: mockASG{
+				couterr: errors.New("tagging failed"),
+			},
+			expected: errors.New("tagging failed"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SpotTermination{
+				ec2Svc: tt.ec2,
+				asSvc:  tt.asSvc,
+				asg: autoScalingGroup{
+					name:  "test-asg",
+					Group: &autoscaling.Group{},
+				},
+			}
+			err := s.recordInterruption(aws.String("i-1"))
+			if !errorMatches(err, tt.expected) {
+				t.Errorf("recordInterruption() error = %v, expected %v", err, tt.expected)
+			}
+		})
+	}
+}
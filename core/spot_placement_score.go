@@ -0,0 +1,157 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// spot_placement_score.go contains the logic used for prioritizing the Spot
+// instance types and picking the subnets based on the Spot placement scores,
+// which give an indication of the available Spot capacity in each AZ.
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// DefaultSpotPlacementScoreTTL is the default amount of time for which the
+	// Spot placement scores are cached.
+	DefaultSpotPlacementScoreTTL = time.Hour
+
+	// spotPlacementScoreMaxInstanceTypes is the number of the cheapest
+	// compatible instance types for which we query the placement scores, so we
+	// don't run into the API limits for groups allowing lots of instance types.
+	spotPlacementScoreMaxInstanceTypes = 10
+)
+
+type spotPlacementScoreCacheEntry struct {
+	// scores per availability zone ID
+	scores     map[string]int64
+	expiration time.Time
+}
+
+// spotPlacementScoreCache keeps the scores per region and set of instance
+// types, it's kept across the executions of a warm Lambda function.
+var spotPlacementScoreCache = struct {
+	sync.Mutex
+	entries map[string]spotPlacementScoreCacheEntry
+}{entries: make(map[string]spotPlacementScoreCacheEntry)}
+
+func (r *region) spotPlacementScoresEnabled() bool {
+	return r.conf != nil && r.conf.EnableSpotPlacementScores
+}
+
+func (r *region) spotPlacementScoreTTL() time.Duration {
+	if r.conf == nil || r.conf.SpotPlacementScoreTTL <= 0 {
+		return DefaultSpotPlacementScoreTTL
+	}
+	return r.conf.SpotPlacementScoreTTL
+}
+
+// spotPlacementScores returns the placement scores of a fleet made of the
+// given instance types in each availability zone of the region, keyed by the
+// availability zone ID. The API scores all the instance types together, so a
+// single call is made for all the candidates of a replacement.
+func (r *region) spotPlacementScores(instanceTypes []*string) (map[string]int64, error) {
+	names := aws.StringValueSlice(instanceTypes)
+	sort.Strings(names)
+	key := r.name + "/" + strings.Join(names, ",")
+	now := time.Now()
+
+	spotPlacementScoreCache.Lock()
+	entry, found := spotPlacementScoreCache.entries[key]
+	spotPlacementScoreCache.Unlock()
+
+	if found && now.Before(entry.expiration) {
+		return entry.scores, nil
+	}
+
+	scores := make(map[string]int64)
+
+	err := r.services.ec2.GetSpotPlacementScoresPages(
+		&ec2.GetSpotPlacementScoresInput{
+			InstanceTypes:          instanceTypes,
+			RegionNames:            []*string{aws.String(r.name)},
+			SingleAvailabilityZone: aws.Bool(true),
+			TargetCapacity:         aws.Int64(1),
+		},
+		func(page *ec2.GetSpotPlacementScoresOutput, lastPage bool) bool {
+			for _, s := range page.SpotPlacementScores {
+				if s.AvailabilityZoneId != nil && s.Score != nil {
+					scores[*s.AvailabilityZoneId] = *s.Score
+				}
+			}
+			return true
+		})
+
+	if err != nil {
+		log.Println(r.name, "Failed to get the Spot placement scores of", names, err.Error())
+		return nil, err
+	}
+
+	debug.Println(r.name, "Spot placement scores of", names, scores)
+
+	spotPlacementScoreCache.Lock()
+	spotPlacementScoreCache.entries[key] = spotPlacementScoreCacheEntry{
+		scores:     scores,
+		expiration: now.Add(r.spotPlacementScoreTTL()),
+	}
+	spotPlacementScoreCache.Unlock()
+
+	return scores, nil
+}
+
+// applySpotPlacementScores picks the subnet in which the Spot instance should
+// be launched, based on the placement score of the candidate instance types in
+// each availability zone. The subnet of the current instance is kept unless
+// another subnet of the group has a strictly better score. It returns a nil
+// subnet when the placement scores aren't available.
+func (i *instance) applySpotPlacementScores(instanceTypes []*string) *subnetPlacement {
+	if !i.region.spotPlacementScoresEnabled() || len(instanceTypes) == 0 || i.asg == nil {
+		return nil
+	}
+
+	subnets, err := i.asg.subnetPlacements()
+	if err != nil || len(subnets) == 0 {
+		return nil
+	}
+
+	// reuse the scores fetched while ranking the candidates, if any
+	scores := i.placementScores
+	if scores == nil {
+		queried := instanceTypes
+		if len(queried) > spotPlacementScoreMaxInstanceTypes {
+			queried = queried[:spotPlacementScoreMaxInstanceTypes]
+		}
+
+		if scores, err = i.region.spotPlacementScores(queried); err != nil {
+			return nil
+		}
+	}
+
+	var chosen *subnetPlacement
+	for idx := range subnets {
+		s := &subnets[idx]
+		if i.SubnetId != nil && s.subnetID == *i.SubnetId {
+			chosen = s
+			break
+		}
+	}
+
+	for idx := range subnets {
+		s := &subnets[idx]
+		if chosen == nil || scores[s.availabilityZoneID] > scores[chosen.availabilityZoneID] {
+			chosen = s
+		}
+	}
+
+	debug.Println(i.asg.name, "Picked subnet", chosen.subnetID, "in", chosen.availabilityZone,
+		"with Spot placement score", scores[chosen.availabilityZoneID])
+
+	return chosen
+}
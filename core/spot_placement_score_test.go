@@ -0,0 +1,225 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func placementScores(scores map[string]int64) *ec2.GetSpotPlacementScoresOutput {
+	var out ec2.GetSpotPlacementScoresOutput
+	for az, score := range scores {
+		out.SpotPlacementScores = append(out.SpotPlacementScores, &ec2.SpotPlacementScore{
+			AvailabilityZoneId: aws.String(az),
+			Region:             aws.String("us-east-1"),
+			Score:              aws.Int64(score),
+		})
+	}
+	return &out
+}
+
+func resetSpotPlacementScoreCache() {
+	spotPlacementScoreCache.Lock()
+	spotPlacementScoreCache.entries = make(map[string]spotPlacementScoreCacheEntry)
+	spotPlacementScoreCache.Unlock()
+}
+
+func Test_region_spotPlacementScores(t *testing.T) {
+	tests := []struct {
+		name    string
+		cached  map[string]spotPlacementScoreCacheEntry
+		ec2     mockEC2
+		want    map[string]int64
+		wantErr bool
+	}{
+		{
+			name: "scores fetched from the API",
+			ec2: mockEC2{
+				gspspo: map[string]*ec2.GetSpotPlacementScoresOutput{
+					"m5.large": placementScores(map[string]int64{"use1-az1": 3, "use1-az2": 9}),
+				},
+			},
+			want: map[string]int64{"use1-az1": 3, "use1-az2": 9},
+		},
+		{
+			name: "fresh scores served from the cache",
+			cached: map[string]spotPlacementScoreCacheEntry{
+				"us-east-1/m5.large": {
+					scores:     map[string]int64{"use1-az1": 7},
+					expiration: time.Now().Add(time.Hour),
+				},
+			},
+			ec2: mockEC2{
+				gspsperr: errors.New("shouldn't be called"),
+			},
+			want: map[string]int64{"use1-az1": 7},
+		},
+		{
+			name: "expired scores fetched again",
+			cached: map[string]spotPlacementScoreCacheEntry{
+				"us-east-1/m5.large": {
+					scores:     map[string]int64{"use1-az1": 7},
+					expiration: time.Now().Add(-time.Minute),
+				},
+			},
+			ec2: mockEC2{
+				gspspo: map[string]*ec2.GetSpotPlacementScoresOutput{
+					"m5.large": placementScores(map[string]int64{"use1-az1": 2}),
+				},
+			},
+			want: map[string]int64{"use1-az1": 2},
+		},
+		{
+			name: "API error",
+			ec2: mockEC2{
+				gspsperr: errors.New("throttled"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetSpotPlacementScoreCache()
+			for k, v := range tt.cached {
+				spotPlacementScoreCache.entries[k] = v
+			}
+
+			r := &region{
+				name:     "us-east-1",
+				services: connections{ec2: tt.ec2},
+			}
+
+			got, err := r.spotPlacementScores([]*string{aws.String("m5.large")})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("spotPlacementScores() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("spotPlacementScores() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	resetSpotPlacementScoreCache()
+}
+
+func Test_instance_applySpotPlacementScores(t *testing.T) {
+	subnets := &ec2.DescribeSubnetsOutput{
+		Subnets: []*ec2.Subnet{
+			{
+				SubnetId:           aws.String("subnet-a"),
+				AvailabilityZone:   aws.String("us-east-1a"),
+				AvailabilityZoneId: aws.String("use1-az1"),
+			},
+			{
+				SubnetId:           aws.String("subnet-b"),
+				AvailabilityZone:   aws.String("us-east-1b"),
+				AvailabilityZoneId: aws.String("use1-az2"),
+			},
+		},
+	}
+
+	tests := []struct {
+		name              string
+		enabled           bool
+		vpcZoneIdentifier string
+		ec2               mockEC2
+		wantSubnet        *subnetPlacement
+	}{
+		{
+			name:              "disabled",
+			vpcZoneIdentifier: "subnet-a,subnet-b",
+		},
+		{
+			name:              "better subnet picked with a single call for all the types",
+			enabled:           true,
+			vpcZoneIdentifier: "subnet-a,subnet-b",
+			ec2: mockEC2{
+				dsno: subnets,
+				gspspo: map[string]*ec2.GetSpotPlacementScoresOutput{
+					"m5.large,c5.large": placementScores(map[string]int64{"use1-az1": 4, "use1-az2": 9}),
+				},
+			},
+			wantSubnet: &subnetPlacement{
+				subnetID:           "subnet-b",
+				availabilityZone:   "us-east-1b",
+				availabilityZoneID: "use1-az2",
+			},
+		},
+		{
+			name:              "current subnet kept on equal scores",
+			enabled:           true,
+			vpcZoneIdentifier: "subnet-a,subnet-b",
+			ec2: mockEC2{
+				dsno: subnets,
+				gspspo: map[string]*ec2.GetSpotPlacementScoresOutput{
+					"m5.large,c5.large": placementScores(map[string]int64{"use1-az1": 9, "use1-az2": 9}),
+				},
+			},
+			wantSubnet: &subnetPlacement{
+				subnetID:           "subnet-a",
+				availabilityZone:   "us-east-1a",
+				availabilityZoneID: "use1-az1",
+			},
+		},
+		{
+			name:              "scores unavailable",
+			enabled:           true,
+			vpcZoneIdentifier: "subnet-a,subnet-b",
+			ec2: mockEC2{
+				dsno:     subnets,
+				gspsperr: errors.New("access denied"),
+			},
+		},
+		{
+			name:              "subnets unavailable",
+			enabled:           true,
+			vpcZoneIdentifier: "subnet-a,subnet-b",
+			ec2: mockEC2{
+				dsnerr: errors.New("access denied"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetSpotPlacementScoreCache()
+
+			r := &region{
+				name: "us-east-1",
+				conf: &Config{
+					EnableSpotPlacementScores: tt.enabled,
+					SpotPlacementScoreTTL:     time.Hour,
+				},
+				services: connections{ec2: tt.ec2},
+			}
+
+			i := &instance{
+				Instance: &ec2.Instance{
+					SubnetId: aws.String("subnet-a"),
+				},
+				region: r,
+				asg: &autoScalingGroup{
+					name:   "test-asg",
+					region: r,
+					Group: &autoscaling.Group{
+						VPCZoneIdentifier: aws.String(tt.vpcZoneIdentifier),
+					},
+				},
+			}
+
+			gotSubnet := i.applySpotPlacementScores(
+				[]*string{aws.String("m5.large"), aws.String("c5.large")})
+
+			if !reflect.DeepEqual(gotSubnet, tt.wantSubnet) {
+				t.Errorf("applySpotPlacementScores() subnet = %+v, want %+v", gotSubnet, tt.wantSubnet)
+			}
+		})
+	}
+	resetSpotPlacementScoreCache()
+}
@@ -29,6 +29,10 @@ type SpotTermination struct {
 	ec2Svc          ec2iface.EC2API
 	SleepMultiplier time.Duration
 	asg             autoScalingGroup
+	regionName      string
+
+	// configFile can enable the groups not matched by the filter tags
+	configFile *configFile
 }
 
 func newSpotTermination(region string) SpotTermination {
@@ -43,6 +47,7 @@ func newSpotTermination(region string) SpotTermination {
 		asSvc:           autoscaling.New(session),
 		ec2Svc:          ec2.New(session),
 		SleepMultiplier: 1,
+		regionName:      region,
 	}
 }
 
@@ -242,7 +247,10 @@ func (s *SpotTermination) IsInAutoSpottingASG(instanceID *string, tagFilteringMo
 	s.asg = autoScalingGroup{
 	  Group:  asgGroupsOutput.AutoScalingGroups[0],
 	  name:   asgName,
+	  region: &region{name: s.regionName},
 	}
+	// the configuration file can set the termination notification action too
+	s.asg.fileSettings = s.configFile.settings(&s.asg)
 
 	filters := replaceWhitespace(filterByTags)
 
@@ -255,9 +263,13 @@ func (s *SpotTermination) IsInAutoSpottingASG(instanceID *string, tagFilteringMo
 		}
 	}
 
-	isInASG := optInFilterMode == isASGWithMatchingTags(asgGroupsOutput.AutoScalingGroups[0], tagsToMatch)
+	isInASG, rule := s.configFile.groupEnabled(&s.asg, optInFilterMode,
+		isASGWithMatchingTags(asgGroupsOutput.AutoScalingGroups[0], tagsToMatch))
 
-	if !isInASG {
+	if !isInASG && rule != "" {
+		log.Printf("Skipping group %s because it is disabled by the "+
+			"configuration file %s\n", asgName, rule)
+	} else if !isInASG {
 		log.Printf("Skipping group %s because its tags, the currently "+
 			"configured filtering mode (%s) and tag filters do not align\n",
 			asgName, tagFilteringMode)
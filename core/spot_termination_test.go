@@ -604,3 +604,84 @@ func TestIsInAutoSpottingASG(t *testing.T) {
 		})
 	}
 }
+
+func TestGetTermAction(t *testing.T) {
+	instanceID := "dummyInstanceID"
+
+	cf, err := parseConfigFile([]byte(`
+defaults:
+  autospotting_termination_notification_action: terminate
+rules:
+  - match:
+      names: ["asg1"]
+    enabled: true
+    config:
+      autospotting_termination_notification_action: detach
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		group      string
+		tags       []*autoscaling.TagDescription
+		configFile *configFile
+		expected   string
+	}{
+		{
+			name:     "Without configuration file",
+			group:    "asg2",
+			tags:     []*autoscaling.TagDescription{{Key: aws.String("spot-enabled"), Value: aws.String("true")}},
+			expected: "auto",
+		},
+		{
+			name:       "From the configuration file defaults",
+			group:      "asg2",
+			tags:       []*autoscaling.TagDescription{{Key: aws.String("spot-enabled"), Value: aws.String("true")}},
+			configFile: cf,
+			expected:   "terminate",
+		},
+		{
+			name:       "From a configuration file rule enabling the group",
+			group:      "asg1",
+			configFile: cf,
+			expected:   "detach",
+		},
+		{
+			name:  "Group tag overriding the configuration file",
+			group: "asg1",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(TerminationNotificationActionTag), Value: aws.String("auto")},
+			},
+			configFile: cf,
+			expected:   "auto",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &SpotTermination{
+				asSvc: mockASG{
+					dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+						AutoScalingGroups: []*autoscaling.Group{
+							{AutoScalingGroupName: aws.String(tc.group), Tags: tc.tags},
+						},
+					},
+					dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+						AutoScalingInstances: []*autoscaling.InstanceDetails{
+							{AutoScalingGroupName: aws.String(tc.group)},
+						},
+					},
+				},
+				configFile: tc.configFile,
+			}
+
+			if !s.IsInAutoSpottingASG(&instanceID, "opt-in", "spot-enabled=true") {
+				t.Fatalf("IsInAutoSpottingASG() = false for %s", tc.group)
+			}
+			if actual := s.getTermAction("auto"); actual != tc.expected {
+				t.Errorf("getTermAction() = %s, expected %s", actual, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// subnets.go contains the logic used for deciding in which of the subnets of
+// the group the Spot instances are launched.
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// subnetPlacement contains the availability zone information of a subnet.
+type subnetPlacement struct {
+	subnetID           string
+	availabilityZone   string
+	availabilityZoneID string
+}
+
+// subnetPlacements returns the availability zone information of the subnets
+// configured on the group.
+func (a *autoScalingGroup) subnetPlacements() ([]subnetPlacement, error) {
+	var subnetIDs []*string
+
+	if a.VPCZoneIdentifier != nil {
+		for _, s := range strings.Split(*a.VPCZoneIdentifier, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				subnetIDs = append(subnetIDs, aws.String(s))
+			}
+		}
+	}
+
+	if len(subnetIDs) == 0 {
+		return nil, nil
+	}
+
+	resp, err := a.region.services.ec2.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		SubnetIds: subnetIDs,
+	})
+
+	if err != nil {
+		log.Println(a.region.name, a.name, "Failed to describe subnets", err.Error())
+		return nil, err
+	}
+
+	var result []subnetPlacement
+	for _, s := range resp.Subnets {
+		result = append(result, subnetPlacement{
+			subnetID:           aws.StringValue(s.SubnetId),
+			availabilityZone:   aws.StringValue(s.AvailabilityZone),
+			availabilityZoneID: aws.StringValue(s.AvailabilityZoneId),
+		})
+	}
+	return result, nil
+}
+
+// onDemandInstancesPerAZ counts the running on-demand instances of the group
+// in each availability zone.
+func (a *autoScalingGroup) onDemandInstancesPerAZ() map[string]int {
+	result := make(map[string]int)
+
+	if a.instances == nil {
+		return result
+	}
+
+	for i := range a.instances.instances() {
+		if i.State != nil && *i.State.Name == ec2.InstanceStateNameRunning && !i.isSpot() {
+			result[*i.Placement.AvailabilityZone]++
+		}
+	}
+	return result
+}
+
+// launchSubnets returns the subnets in which the Spot instance may be launched,
+// in the order of preference. Unless the group is configured to launch Spot
+// instances in all its subnets, this is just the preferred subnet, which is the
+// one picked based on the Spot placement scores, or otherwise the subnet of the
+// instance being replaced. When launching in all the subnets, the preferred
+// subnet is followed by the subnets from the availability zones having the most
+// on-demand instances, since the on-demand instance terminated after the Spot
+// instance is attached is picked from the same availability zone, keeping the
+// group balanced.
+func (i *instance) launchSubnets(preferred *subnetPlacement) []*string {
+	preferredID := i.SubnetId
+	if preferred != nil {
+		preferredID = aws.String(preferred.subnetID)
+	}
+
+	if !i.asg.config.LaunchInAllSubnets {
+		return []*string{preferredID}
+	}
+
+	subnets, err := i.asg.subnetPlacements()
+	if err != nil || len(subnets) < 2 {
+		return []*string{preferredID}
+	}
+
+	onDemand := i.asg.onDemandInstancesPerAZ()
+
+	sort.SliceStable(subnets, func(a, b int) bool {
+		return onDemand[subnets[a].availabilityZone] > onDemand[subnets[b].availabilityZone]
+	})
+
+	result := []*string{preferredID}
+	for _, s := range subnets {
+		if preferredID == nil || s.subnetID != *preferredID {
+			result = append(result, aws.String(s.subnetID))
+		}
+	}
+
+	debug.Println(i.asg.name, "Launching Spot instance in the subnets", aws.StringValueSlice(result))
+	return result
+}
+
+// moveLaunchTemplateDataToSubnet updates the launch template data so that the
+// Spot instance is launched in the given subnet instead of the subnet of the
+// instance being replaced.
+func moveLaunchTemplateDataToSubnet(ltData *ec2.RequestLaunchTemplateData, subnet *subnetPlacement) {
+	if subnet == nil {
+		return
+	}
+
+	if ltData.Placement != nil {
+		ltData.Placement.AvailabilityZone = aws.String(subnet.availabilityZone)
+	}
+
+	for _, ni := range ltData.NetworkInterfaces {
+		if ni.SubnetId != nil {
+			ni.SubnetId = aws.String(subnet.subnetID)
+		}
+	}
+}
+
+// unpinLaunchTemplateDataFromSubnet removes the availability zone and subnet
+// of the instance being replaced from the launch template data, leaving them
+// to be set by the CreateFleet overrides.
+func unpinLaunchTemplateDataFromSubnet(ltData *ec2.RequestLaunchTemplateData) {
+	if ltData.Placement != nil {
+		ltData.Placement.AvailabilityZone = nil
+	}
+
+	for _, ni := range ltData.NetworkInterfaces {
+		ni.SubnetId = nil
+	}
+}
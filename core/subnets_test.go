@@ -0,0 +1,197 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_instance_launchSubnets(t *testing.T) {
+	subnets := &ec2.DescribeSubnetsOutput{
+		Subnets: []*ec2.Subnet{
+			{
+				SubnetId:         aws.String("subnet-a"),
+				AvailabilityZone: aws.String("us-east-1a"),
+			},
+			{
+				SubnetId:         aws.String("subnet-b"),
+				AvailabilityZone: aws.String("us-east-1b"),
+			},
+			{
+				SubnetId:         aws.String("subnet-c"),
+				AvailabilityZone: aws.String("us-east-1c"),
+			},
+		},
+	}
+
+	running := &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)}
+
+	groupInstances := makeInstancesWithCatalog(instanceMap{
+		"od-b1": {Instance: &ec2.Instance{
+			InstanceId: aws.String("od-b1"), State: running,
+			Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1b")},
+		}},
+		"od-c1": {Instance: &ec2.Instance{
+			InstanceId: aws.String("od-c1"), State: running,
+			Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1c")},
+		}},
+		"od-c2": {Instance: &ec2.Instance{
+			InstanceId: aws.String("od-c2"), State: running,
+			Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1c")},
+		}},
+		"spot-b1": {Instance: &ec2.Instance{
+			InstanceId: aws.String("spot-b1"), State: running,
+			InstanceLifecycle: aws.String(Spot),
+			Placement:         &ec2.Placement{AvailabilityZone: aws.String("us-east-1b")},
+		}},
+	})
+
+	tests := []struct {
+		name          string
+		allSubnets    bool
+		preferred     *subnetPlacement
+		ec2           mockEC2
+		groupInstance instances
+		want          []string
+	}{
+		{
+			name:       "only the subnet of the replaced instance",
+			allSubnets: false,
+			want:       []string{"subnet-a"},
+		},
+		{
+			name:       "only the subnet picked by the placement scores",
+			allSubnets: false,
+			preferred:  &subnetPlacement{subnetID: "subnet-b"},
+			want:       []string{"subnet-b"},
+		},
+		{
+			name:          "all subnets ordered by the on-demand instances in their AZ",
+			allSubnets:    true,
+			ec2:           mockEC2{dsno: subnets},
+			groupInstance: groupInstances,
+			want:          []string{"subnet-a", "subnet-c", "subnet-b"},
+		},
+		{
+			name:          "all subnets after the subnet picked by the placement scores",
+			allSubnets:    true,
+			preferred:     &subnetPlacement{subnetID: "subnet-b"},
+			ec2:           mockEC2{dsno: subnets},
+			groupInstance: groupInstances,
+			want:          []string{"subnet-b", "subnet-c", "subnet-a"},
+		},
+		{
+			name:       "subnets can't be described",
+			allSubnets: true,
+			ec2:        mockEC2{dsnerr: errors.New("access denied")},
+			want:       []string{"subnet-a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name:     "us-east-1",
+				services: connections{ec2: tt.ec2},
+			}
+
+			i := &instance{
+				Instance: &ec2.Instance{
+					SubnetId: aws.String("subnet-a"),
+				},
+				region: r,
+				asg: &autoScalingGroup{
+					name:   "test-asg",
+					region: r,
+					Group: &autoscaling.Group{
+						VPCZoneIdentifier: aws.String("subnet-a,subnet-b,subnet-c"),
+					},
+					instances: tt.groupInstance,
+					config: AutoScalingConfig{
+						LaunchInAllSubnets: tt.allSubnets,
+					},
+				},
+			}
+
+			if got := aws.StringValueSlice(i.launchSubnets(tt.preferred)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("launchSubnets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_moveLaunchTemplateDataToSubnet(t *testing.T) {
+	ltData := &ec2.RequestLaunchTemplateData{
+		Placement: &ec2.LaunchTemplatePlacementRequest{
+			AvailabilityZone: aws.String("us-east-1a"),
+			Tenancy:          aws.String("default"),
+		},
+		NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+			{
+				DeviceIndex: aws.Int64(0),
+				SubnetId:    aws.String("subnet-a"),
+			},
+		},
+	}
+
+	moveLaunchTemplateDataToSubnet(ltData, &subnetPlacement{
+		subnetID:           "subnet-b",
+		availabilityZone:   "us-east-1b",
+		availabilityZoneID: "use1-az2",
+	})
+
+	want := &ec2.RequestLaunchTemplateData{
+		Placement: &ec2.LaunchTemplatePlacementRequest{
+			AvailabilityZone: aws.String("us-east-1b"),
+			Tenancy:          aws.String("default"),
+		},
+		NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+			{
+				DeviceIndex: aws.Int64(0),
+				SubnetId:    aws.String("subnet-b"),
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(ltData, want) {
+		t.Errorf("moveLaunchTemplateDataToSubnet() = %+v, want %+v", ltData, want)
+	}
+}
+
+func Test_unpinLaunchTemplateDataFromSubnet(t *testing.T) {
+	ltData := &ec2.RequestLaunchTemplateData{
+		Placement: &ec2.LaunchTemplatePlacementRequest{
+			AvailabilityZone: aws.String("us-east-1a"),
+			Tenancy:          aws.String("default"),
+		},
+		NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+			{
+				DeviceIndex: aws.Int64(0),
+				SubnetId:    aws.String("subnet-a"),
+			},
+		},
+	}
+
+	unpinLaunchTemplateDataFromSubnet(ltData)
+
+	want := &ec2.RequestLaunchTemplateData{
+		Placement: &ec2.LaunchTemplatePlacementRequest{
+			Tenancy: aws.String("default"),
+		},
+		NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+			{
+				DeviceIndex: aws.Int64(0),
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(ltData, want) {
+		t.Errorf("unpinLaunchTemplateDataFromSubnet() = %+v, want %+v", ltData, want)
+	}
+}
@@ -0,0 +1,559 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+// validation.go contains the checks of the configuration values set in the
+// command line flags, the configuration file and the group tags, which are
+// otherwise ignored or only failing when used. They are run by the validate
+// command, which can also check a recorded listing of the groups in CI.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// tagPrefix is the prefix of all the tags used by AutoSpotting.
+const tagPrefix = "autospotting_"
+
+// stateTags are set by AutoSpotting on the groups for keeping track of their
+// state, and aren't reported as unknown tags.
+var stateTags = []string{
+	CanaryStateTag,
+	CircuitOpenTag,
+	EvacuationStateTag,
+	FailedPoolsTag,
+	IncompatibleInstanceTypesTag,
+	LaunchBackoffTag,
+	ObservedInterruptionsTag,
+	RecentReplacementsTag,
+	ReplacementFailuresTag,
+	SwappedInstancesTag,
+}
+
+// tagValidators check the values of the configuration tags, which are also
+// the values of the configuration file settings and of the matching flags.
+var tagValidators = map[string]func(string) error{
+	AcceleratorCompatibilityTag: validateEnum(AcceleratorCompatibilityModel,
+		AcceleratorCompatibilityManufacturer, AcceleratorCompatibilityCount),
+	AllowedInstanceTypesTag:    validateGlobs,
+	BareMetalTag:               validateEnum(requirementIncluded, requirementExcluded, requirementRequired),
+	BiddingPolicyTag:           validateEnum(DefaultBiddingPolicy, "aggressive"),
+	BurstablePerformanceTag:    validateEnum(requirementIncluded, requirementExcluded, requirementRequired),
+	CanaryInstancesTag:         validateInt(0),
+	CanarySoakPeriodTag:        validateDuration,
+	CircuitBreakerThresholdTag: validateInt(0),
+	CPUManufacturersTag:        validateCPUManufacturers,
+	CronBlackoutDatesTag: func(value string) error {
+		_, err := parseBlackoutPeriods(value, time.UTC)
+		return err
+	},
+	CronScheduleStateTag:                 validateEnum(CronScheduleStateOn, "off"),
+	DisallowedInstanceTypesTag:           validateGlobs,
+	EnableInstanceLaunchEventHandlingTag: validateBool,
+	EvacuateTag:                          validateBool,
+	EvacuationBatchSizeTag:               validateInt(1),
+	GP2ConversionThresholdTag:            validateInt(0),
+	ImageARM64Tag:                        validateImage,
+	ImageX86Tag:                          validateImage,
+	InstanceGenerationMinTag:             validateInt(0),
+	LaunchInAllSubnetsTag:                validateBool,
+	MaxInstanceTypeShareTag:              validatePercentage,
+	MaxInterruptionRateTag:               validatePercentage,
+	MaxPoolShareTag:                      validatePercentage,
+	MaxReplacementsInFlightTag:           validateInt(0),
+	MaxReplacementsPerHourTag:            validateInt(0),
+	MemoryMaxTag:                         validateFloat(0),
+	MemoryMinTag:                         validateFloat(0),
+	MemoryPerVCPUMaxTag:                  validateFloat(0),
+	MemoryPerVCPUMinTag:                  validateFloat(0),
+	MinOnDemandScheduleTag: func(value string) error {
+		_, err := parseMinOnDemandSchedule(value)
+		return err
+	},
+	NetworkBandwidthMinTag: validateFloat(0),
+	OnDemandNumberLong:     validateInt(0),
+	OnDemandPercentageTag:  validatePercentage,
+	OnDemandPriceMultiplierTag: func(value string) error {
+		multiplier, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		if multiplier <= 0 {
+			return fmt.Errorf("%v is out of range, expected a value larger than 0", multiplier)
+		}
+		return nil
+	},
+	PatchBeanstalkUserdataTag: validateBool,
+	ReadinessChecksTag: func(value string) error {
+		_, err := parseReadinessChecks(value, 0)
+		return err
+	},
+	ReadinessHTTPProbeTag: validateHTTPProbe,
+	ReadinessTimeoutTag:   validateDuration,
+	ScheduleTag:           validateSchedule,
+	ScoringStrategyTag: func(value string) error {
+		var names []string
+		for name := range scoringStrategies {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return validateEnum(names...)(value)
+	},
+	ScoringWeightsTag: func(value string) error {
+		_, err := parseScoringWeights(value)
+		return err
+	},
+	SpotAllocationStrategyTag: validateEnum("capacity-optimized-prioritized",
+		"capacity-optimized", "lowest-price"),
+	SpotPriceBufferPercentageTag: validateFloat(0),
+	SwapWatchPeriodTag:           validateDuration,
+	TerminationNotificationActionTag: validateEnum(AutoTerminationNotificationAction,
+		TerminateTerminationNotificationAction, DetachTerminationNotificationAction),
+	TimezoneTag: func(value string) error {
+		if _, err := time.LoadLocation(value); err != nil {
+			return fmt.Errorf("unknown timezone %q", value)
+		}
+		return nil
+	},
+	VCPUMaxTag: validateInt(0),
+	VCPUMinTag: validateInt(0),
+}
+
+func validateBool(value string) error {
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("invalid boolean %q, expected true or false", value)
+	}
+	return nil
+}
+
+func validateInt(min int64) func(string) error {
+	return func(value string) error {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		if n < min {
+			return fmt.Errorf("%d is out of range, expected at least %d", n, min)
+		}
+		return nil
+	}
+}
+
+func validateFloat(min float64) func(string) error {
+	return func(value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		if f < min {
+			return fmt.Errorf("%v is out of range, expected at least %v", f, min)
+		}
+		return nil
+	}
+}
+
+func validatePercentage(value string) error {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", value)
+	}
+	if f < 0 || f > 100 {
+		return fmt.Errorf("%v is out of range, expected a percentage between 0 and 100", f)
+	}
+	return nil
+}
+
+func validateEnum(values ...string) func(string) error {
+	return func(value string) error {
+		if !itemInSlice(value, values) {
+			return fmt.Errorf("invalid value %q, expected one of %s", value, strings.Join(values, ", "))
+		}
+		return nil
+	}
+}
+
+func validateDuration(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected a value such as 30m or 1h", value)
+	}
+	if d < 0 {
+		return fmt.Errorf("%v is out of range, expected a positive duration", d)
+	}
+	return nil
+}
+
+func validateCPUManufacturers(value string) error {
+	for _, m := range strings.FieldsFunc(value, func(c rune) bool {
+		return c == ',' || c == ' '
+	}) {
+		if err := validateEnum(cpuManufacturerIntel, cpuManufacturerAMD,
+			cpuManufacturerAWS)(strings.ToLower(m)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateGlobs checks the instance type patterns, separated by commas or
+// spaces.
+func validateGlobs(value string) error {
+	for _, pattern := range strings.FieldsFunc(value, func(c rune) bool {
+		return c == ',' || c == ' '
+	}) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// validateSchedule checks the windows of a CronSchedule, without logging the
+// errors like insideSchedule does.
+func validateSchedule(value string) error {
+	windows := 0
+	for _, window := range strings.Split(value, scheduleWindowSeparator) {
+		window = strings.TrimSpace(window)
+		if window == "" {
+			continue
+		}
+		if _, err := insideScheduleWindow(time.Now(), window); err != nil {
+			return fmt.Errorf("invalid schedule window %q: %s", window, err.Error())
+		}
+		windows++
+	}
+	if windows == 0 {
+		return errors.New("empty schedule, no actions would ever be taken inside it")
+	}
+	return nil
+}
+
+func validateImage(value string) error {
+	if parseArchitectureImage(value) == "" {
+		return fmt.Errorf("invalid image %q, expected an AMI ID or an SSM parameter path", value)
+	}
+	return nil
+}
+
+func validateHTTPProbe(value string) error {
+	port := value
+	if idx := strings.Index(value, "/"); idx >= 0 {
+		port = value[:idx]
+	}
+	if port == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q, expected a value such as 8080/health", port)
+	}
+	return nil
+}
+
+// editDistance is the number of single character edits needed for turning a
+// into b, used for suggesting the intended names of misspelled tags.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(min(prev[j]+1, cur[j-1]+1), prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// unknownSettingProblem describes an unknown setting, suggesting the closest
+// known one if it looks like a typo.
+func unknownSettingProblem(key string) string {
+	best, bestDistance := "", 4
+	for _, known := range configurationTags {
+		if d := editDistance(key, known); d < bestDistance {
+			best, bestDistance = known, d
+		}
+	}
+
+	if best != "" {
+		return fmt.Sprintf("unknown setting, did you mean %s?", best)
+	}
+	return "unknown setting"
+}
+
+// validateSettings checks the given configuration tags or settings, returning
+// the problems found as "key: problem" lines sorted by key. The ignored keys
+// are neither validated nor reported as unknown.
+func validateSettings(settings map[string]string, ignored []string) []string {
+	var keys []string
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, key := range keys {
+		if itemInSlice(key, ignored) {
+			continue
+		}
+
+		validator, ok := tagValidators[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: %s", key, unknownSettingProblem(key)))
+			continue
+		}
+
+		if err := validator(settings[key]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", key, err.Error()))
+		}
+	}
+	return problems
+}
+
+// validateGroupTags checks the AutoSpotting tags set on the group itself,
+// ignoring the tags used for filtering the groups, which may also use the
+// AutoSpotting prefix.
+func validateGroupTags(group *autoscaling.Group, filterTags []string) []string {
+	tags := map[string]string{}
+	for _, tag := range group.Tags {
+		if key := aws.StringValue(tag.Key); strings.HasPrefix(key, tagPrefix) {
+			tags[key] = aws.StringValue(tag.Value)
+		}
+	}
+
+	ignored := append(append([]string{}, stateTags...), filterTags...)
+	problems := validateSettings(tags, ignored)
+
+	if value, ok := tags[OnDemandNumberLong]; ok && group.MaxSize != nil {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n > *group.MaxSize {
+			problems = append(problems, fmt.Sprintf("%s: %d is out of range, larger than the maximum size %d of the group",
+				OnDemandNumberLong, n, *group.MaxSize))
+		}
+	}
+	return problems
+}
+
+// validateValues checks the values set by the defaults and the rules of the
+// configuration file.
+func (cf *configFile) validateValues() []string {
+	if cf == nil {
+		return nil
+	}
+
+	var problems []string
+	for _, p := range validateSettings(cf.Defaults, nil) {
+		problems = append(problems, "configuration file defaults: "+p)
+	}
+	for n, rule := range cf.Rules {
+		for _, p := range validateSettings(rule.Config, nil) {
+			problems = append(problems, fmt.Sprintf("configuration file %s: %s", rule.source(n), p))
+		}
+	}
+	return problems
+}
+
+// validateFlags checks the values of the command line flags which can be
+// invalid, most of them using the validators of the matching tags.
+func (c *Config) validateFlags() []string {
+	flags := []struct {
+		name     string
+		value    string
+		validate func(string) error
+	}{
+		{"accelerator_compatibility", c.AcceleratorCompatibility, tagValidators[AcceleratorCompatibilityTag]},
+		{"allowed_instance_types", c.AllowedInstanceTypes, tagValidators[AllowedInstanceTypesTag]},
+		{"bidding_policy", c.BiddingPolicy, tagValidators[BiddingPolicyTag]},
+		{"canary_instances", fmt.Sprint(c.CanaryInstances), tagValidators[CanaryInstancesTag]},
+		{"canary_soak_period", c.CanarySoakPeriod, tagValidators[CanarySoakPeriodTag]},
+		{"circuit_breaker_threshold", fmt.Sprint(c.CircuitBreakerThreshold), tagValidators[CircuitBreakerThresholdTag]},
+		{"cron_blackout_dates", c.CronBlackoutDates, tagValidators[CronBlackoutDatesTag]},
+		{"cron_schedule", c.CronSchedule, tagValidators[ScheduleTag]},
+		{"cron_schedule_state", c.CronScheduleState, tagValidators[CronScheduleStateTag]},
+		{"cron_timezone", c.CronTimezone, tagValidators[TimezoneTag]},
+		{"disallowed_instance_types", c.DisallowedInstanceTypes, tagValidators[DisallowedInstanceTypesTag]},
+		{"ebs_gp2_conversion_threshold", fmt.Sprint(c.GP2ConversionThreshold), tagValidators[GP2ConversionThresholdTag]},
+		{"evacuation_batch_size", fmt.Sprint(c.EvacuationBatchSize), tagValidators[EvacuationBatchSizeTag]},
+		{"instance_termination_method", c.InstanceTerminationMethod,
+			validateEnum(AutoScalingTerminationMethod, DetachTerminationMethod)},
+		{"max_global_replacements_in_flight", fmt.Sprint(c.MaxGlobalReplacementsInFlight), validateInt(0)},
+		{"max_global_replacements_per_hour", fmt.Sprint(c.MaxGlobalReplacementsPerHour), validateInt(0)},
+		{"max_instance_type_share", fmt.Sprint(c.MaxInstanceTypeShare), tagValidators[MaxInstanceTypeShareTag]},
+		{"max_interruption_rate", fmt.Sprint(c.MaxInterruptionRate), tagValidators[MaxInterruptionRateTag]},
+		{"max_pool_share", fmt.Sprint(c.MaxPoolShare), tagValidators[MaxPoolShareTag]},
+		{"max_replacements_in_flight", fmt.Sprint(c.MaxReplacementsInFlight), tagValidators[MaxReplacementsInFlightTag]},
+		{"max_replacements_per_hour", fmt.Sprint(c.MaxReplacementsPerHour), tagValidators[MaxReplacementsPerHourTag]},
+		{"max_replacements_per_run", fmt.Sprint(c.MaxReplacementsPerRun), validateInt(0)},
+		{"min_on_demand_number", fmt.Sprint(c.MinOnDemandNumber), tagValidators[OnDemandNumberLong]},
+		{"min_on_demand_percentage", fmt.Sprint(c.MinOnDemandPercentage), tagValidators[OnDemandPercentageTag]},
+		{"min_on_demand_schedule", c.MinOnDemandSchedule, tagValidators[MinOnDemandScheduleTag]},
+		{"on_demand_price_multiplier", fmt.Sprint(c.OnDemandPriceMultiplier), tagValidators[OnDemandPriceMultiplierTag]},
+		{"readiness_checks", c.ReadinessChecks, tagValidators[ReadinessChecksTag]},
+		{"readiness_http_probe", c.ReadinessHTTPProbe, tagValidators[ReadinessHTTPProbeTag]},
+		{"readiness_timeout", c.ReadinessTimeout, tagValidators[ReadinessTimeoutTag]},
+		{"regions", c.Regions, validateGlobs},
+		{"scoring_strategy", c.ScoringStrategy, tagValidators[ScoringStrategyTag]},
+		{"scoring_weights", c.ScoringWeights, tagValidators[ScoringWeightsTag]},
+		{"spot_allocation_strategy", c.SpotAllocationStrategy, tagValidators[SpotAllocationStrategyTag]},
+		{"spot_price_buffer_percentage", fmt.Sprint(c.SpotPriceBufferPercentage), tagValidators[SpotPriceBufferPercentageTag]},
+		{"swap_watch_period", c.SwapWatchPeriod, tagValidators[SwapWatchPeriodTag]},
+		{"tag_filtering_mode", c.TagFilteringMode, validateEnum("opt-in", "opt-out")},
+		{"termination_notification_action", c.TerminationNotificationAction, tagValidators[TerminationNotificationActionTag]},
+	}
+
+	var problems []string
+	for _, flag := range flags {
+		if err := flag.validate(flag.value); err != nil {
+			problems = append(problems, fmt.Sprintf("flag %s: %s", flag.name, err.Error()))
+		}
+	}
+	return problems
+}
+
+// groupRegion returns the region of a group, taken from its ARN.
+func groupRegion(group *autoscaling.Group, fallback string) string {
+	fields := strings.Split(aws.StringValue(group.AutoScalingGroupARN), ":")
+	if len(fields) > 3 && fields[3] != "" {
+		return fields[3]
+	}
+	return fallback
+}
+
+// loadGroupListing reads the groups from a file recorded with the
+// "aws autoscaling describe-auto-scaling-groups" command.
+func loadGroupListing(path string) ([]*autoscaling.Group, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the group listing %s: %s", path, err.Error())
+	}
+
+	var listing autoscaling.DescribeAutoScalingGroupsOutput
+	if err := json.Unmarshal(body, &listing); err != nil {
+		return nil, fmt.Errorf("couldn't decode the group listing %s: %s", path, err.Error())
+	}
+	return listing.AutoScalingGroups, nil
+}
+
+// describeAllGroups returns all the groups of the region.
+func (r *region) describeAllGroups() ([]*autoscaling.Group, error) {
+	var groups []*autoscaling.Group
+	err := r.services.autoScaling.DescribeAutoScalingGroupsPages(
+		&autoscaling.DescribeAutoScalingGroupsInput{},
+		func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+			groups = append(groups, page.AutoScalingGroups...)
+			return true
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't describe the groups in %s: %s", r.name, err.Error())
+	}
+	return groups, nil
+}
+
+// filterTagKeys returns the keys of the tags used for filtering the groups.
+func (r *region) filterTagKeys() []string {
+	var keys []string
+	for _, tag := range r.tagsToFilterASGsBy {
+		keys = append(keys, tag.Key)
+	}
+	return keys
+}
+
+// validationTargets returns the regions or group listings to validate, falling
+// back to the regions enabled in the configuration like the cron runs do.
+func (a *AutoSpotting) validationTargets(targets []string) ([]string, error) {
+	if len(targets) > 0 || a.config.Regions == "" {
+		return targets, nil
+	}
+
+	regions, err := a.getRegions()
+	if err != nil {
+		return nil, err
+	}
+
+	var enabled []string
+	for _, name := range regions {
+		r := region{name: name, conf: a.config}
+		if r.enabled() {
+			enabled = append(enabled, name)
+		}
+	}
+	return enabled, nil
+}
+
+// validate checks the command line flags, the configuration file and the
+// tags of the groups from the given regions or recorded group listings,
+// printing the problems found and failing if there are any. Without any
+// targets, the groups of the enabled regions are checked.
+func (a *AutoSpotting) validate(targets []string) error {
+	problems := a.config.validateFlags()
+	problems = append(problems, a.config.configFile.validateValues()...)
+
+	targets, err := a.validationTargets(targets)
+	if err != nil {
+		return err
+	}
+
+	filters := region{conf: a.config}
+	filters.setupAsgFilters()
+
+	for _, target := range targets {
+		var groups []*autoscaling.Group
+		var err error
+
+		if _, statErr := os.Stat(target); statErr == nil || strings.HasSuffix(target, ".json") {
+			groups, err = loadGroupListing(target)
+		} else {
+			r := region{name: target, conf: a.config, services: connections{}}
+			r.services.connect(r.name, a.config.MainRegion)
+			groups, err = r.describeAllGroups()
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, group := range groups {
+			for _, p := range validateGroupTags(group, filters.filterTagKeys()) {
+				problems = append(problems, fmt.Sprintf("%s %s: %s",
+					groupRegion(group, target), aws.StringValue(group.AutoScalingGroupName), p))
+			}
+		}
+		log.Printf("Validated the tags of %d groups from %s\n", len(groups), target)
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d configuration problems", len(problems))
+	}
+	log.Println("No configuration problems found")
+	return nil
+}
+
+// logInvalidTags logs the invalid configuration tags of the group, which are
+// ignored or fail when used.
+func (a *autoScalingGroup) logInvalidTags() {
+	for _, p := range validateGroupTags(a.Group, a.region.filterTagKeys()) {
+		log.Println(a.region.name, a.name, "Invalid configuration tag", p)
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright (c) 2016-2022 Cristian Măgherușan-Stanciu
+// Licensed under the Open Software License version 3.0
+
+package autospotting
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_tagValidators(t *testing.T) {
+	for _, tag := range configurationTags {
+		if tagValidators[tag] == nil {
+			t.Errorf("missing validator for the tag %s", tag)
+		}
+	}
+	if len(tagValidators) != len(configurationTags) {
+		t.Errorf("%d validators for %d configuration tags", len(tagValidators), len(configurationTags))
+	}
+}
+
+func Test_validateSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]string
+		want     []string
+	}{
+		{
+			name: "valid",
+			settings: map[string]string{
+				AllowedInstanceTypesTag:   "c5.*, m5.large",
+				BiddingPolicyTag:          "aggressive",
+				CPUManufacturersTag:       "Intel,AMD",
+				CronBlackoutDatesTag:      "2023-11-24/2023-11-27",
+				ImageARM64Tag:             "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-arm64",
+				OnDemandPercentageTag:     "50",
+				ReadinessChecksTag:        "ec2-status,http=10m",
+				ReadinessHTTPProbeTag:     "8080/health",
+				ScheduleTag:               "9-18 1-5; 30-59 8 * * 1-5",
+				SpotAllocationStrategyTag: "capacity-optimized",
+				SwapWatchPeriodTag:        "1h",
+				TimezoneTag:               "Europe/London",
+			},
+		},
+		{
+			name:     "state tags ignored",
+			settings: map[string]string{CircuitOpenTag: "open since yesterday"},
+		},
+		{
+			name:     "typo",
+			settings: map[string]string{"autospotting_min_ondemand_number": "1"},
+			want:     []string{"autospotting_min_ondemand_number: unknown setting, did you mean " + OnDemandNumberLong + "?"},
+		},
+		{
+			name:     "unknown",
+			settings: map[string]string{"autospotting_enabled": "true"},
+			want:     []string{"autospotting_enabled: unknown setting"},
+		},
+		{
+			name: "out of range numbers",
+			settings: map[string]string{
+				EvacuationBatchSizeTag:     "0",
+				MaxPoolShareTag:            "150",
+				OnDemandPriceMultiplierTag: "0",
+				VCPUMinTag:                 "two",
+			},
+			want: []string{
+				EvacuationBatchSizeTag + ": 0 is out of range, expected at least 1",
+				MaxPoolShareTag + ": 150 is out of range, expected a percentage between 0 and 100",
+				OnDemandPriceMultiplierTag + ": 0 is out of range, expected a value larger than 0",
+				VCPUMinTag + `: invalid integer "two"`,
+			},
+		},
+		{
+			name: "invalid enums",
+			settings: map[string]string{
+				CPUManufacturersTag:       "intel,arm",
+				EvacuateTag:               "yes",
+				SpotAllocationStrategyTag: "cheapest",
+			},
+			want: []string{
+				CPUManufacturersTag + `: invalid value "arm", expected one of intel, amd, amazon-web-services`,
+				EvacuateTag + `: invalid boolean "yes", expected true or false`,
+				SpotAllocationStrategyTag + `: invalid value "cheapest", expected one of capacity-optimized-prioritized, capacity-optimized, lowest-price`,
+			},
+		},
+		{
+			name: "invalid schedules",
+			settings: map[string]string{
+				CronBlackoutDatesTag: "24/11/2023",
+				ScheduleTag:          "9-18 1-5; 25 *",
+				TimezoneTag:          "Europe/Londn",
+			},
+			want: []string{
+				CronBlackoutDatesTag + `: invalid blackout date "24", expected YYYY-MM-DD or YYYY-MM-DDTHH:MM`,
+				ScheduleTag + `: invalid schedule window "25 *": end of range (25) above maximum (23): 25`,
+				TimezoneTag + `: unknown timezone "Europe/Londn"`,
+			},
+		},
+		{
+			name: "invalid globs and durations",
+			settings: map[string]string{
+				DisallowedInstanceTypesTag: "t2.*,m5[.large",
+				ReadinessTimeoutTag:        "5 minutes",
+				CanarySoakPeriodTag:        "-1h",
+			},
+			want: []string{
+				CanarySoakPeriodTag + ": -1h0m0s is out of range, expected a positive duration",
+				DisallowedInstanceTypesTag + `: invalid pattern "m5[.large"`,
+				ReadinessTimeoutTag + `: invalid duration "5 minutes", expected a value such as 30m or 1h`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateSettings(tt.settings, stateTags); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateSettings() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateGroupTags(t *testing.T) {
+	group := &autoscaling.Group{
+		MaxSize: aws.Int64(2),
+		Tags: []*autoscaling.TagDescription{
+			{Key: aws.String("spot-enabled"), Value: aws.String("yes")},
+			{Key: aws.String(OnDemandNumberLong), Value: aws.String("3")},
+			{Key: aws.String("autospotting_team"), Value: aws.String("web")},
+		},
+	}
+	want := []string{OnDemandNumberLong + ": 3 is out of range, larger than the maximum size 2 of the group"}
+	if got := validateGroupTags(group, []string{"autospotting_team"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("validateGroupTags() = %q, want %q", got, want)
+	}
+	if got := validateGroupTags(group, nil); len(got) != 2 {
+		t.Errorf("validateGroupTags() = %q, want the unknown filter tag to be reported", got)
+	}
+}
+
+func Test_AutoSpotting_validationTargets(t *testing.T) {
+	regions := mockEC2{dro: &ec2.DescribeRegionsOutput{Regions: []*ec2.Region{
+		{RegionName: aws.String("eu-west-1")},
+		{RegionName: aws.String("eu-central-1")},
+		{RegionName: aws.String("us-east-1")},
+	}}}
+
+	tests := []struct {
+		name    string
+		regions string
+		targets []string
+		want    []string
+	}{
+		{name: "given targets", regions: "us-*", targets: []string{"groups.json"}, want: []string{"groups.json"}},
+		{name: "no regions configured"},
+		{name: "enabled regions", regions: "eu-*", want: []string{"eu-west-1", "eu-central-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &AutoSpotting{config: &Config{Regions: tt.regions}, mainEC2Conn: regions}
+			if got, err := a.validationTargets(tt.targets); err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validationTargets() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// testValidConfig returns the default values of the flags which are validated.
+func testValidConfig() *Config {
+	return &Config{
+		AutoScalingConfig: AutoScalingConfig{
+			AcceleratorCompatibility:      DefaultAcceleratorCompatibility,
+			BiddingPolicy:                 DefaultBiddingPolicy,
+			CanarySoakPeriod:              DefaultCanarySoakPeriod,
+			CronSchedule:                  DefaultCronSchedule,
+			CronScheduleState:             CronScheduleStateOn,
+			CronTimezone:                  "UTC",
+			EvacuationBatchSize:           DefaultEvacuationBatchSize,
+			InstanceTerminationMethod:     DefaultInstanceTerminationMethod,
+			OnDemandPriceMultiplier:       DefaultOnDemandPriceMultiplier,
+			ReadinessHTTPProbe:            DefaultReadinessHTTPProbe,
+			ReadinessTimeout:              DefaultReadinessTimeout,
+			ScoringStrategy:               DefaultScoringStrategy,
+			ScoringWeights:                DefaultScoringWeights,
+			SpotAllocationStrategy:        "capacity-optimized-prioritized",
+			SwapWatchPeriod:               DefaultSwapWatchPeriod,
+			TerminationNotificationAction: DefaultTerminationNotificationAction,
+		},
+		TagFilteringMode: "opt-in",
+	}
+}
+
+func Test_Config_validateFlags(t *testing.T) {
+	conf := testValidConfig()
+	if got := conf.validateFlags(); len(got) != 0 {
+		t.Errorf("validateFlags() with the default values = %q", got)
+	}
+
+	conf.CronTimezone = "Mars/Olympus_Mons"
+	conf.Regions = "eu-*,us-[east"
+	want := []string{
+		`flag cron_timezone: unknown timezone "Mars/Olympus_Mons"`,
+		`flag regions: invalid pattern "us-[east"`,
+	}
+	if got := conf.validateFlags(); !reflect.DeepEqual(got, want) {
+		t.Errorf("validateFlags() = %q, want %q", got, want)
+	}
+}
+
+func Test_configFile_validateValues(t *testing.T) {
+	cf, err := parseConfigFile([]byte(`
+defaults:
+  autospotting_bidding_policy: cheap
+rules:
+  - name: team-a
+    config:
+      autospotting_max_interruption_rate: 200
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`configuration file defaults: ` + BiddingPolicyTag + `: invalid value "cheap", expected one of normal, aggressive`,
+		`configuration file rule 1 (team-a): ` + MaxInterruptionRateTag + `: 200 is out of range, expected a percentage between 0 and 100`,
+	}
+	if got := cf.validateValues(); !reflect.DeepEqual(got, want) {
+		t.Errorf("validateValues() = %q, want %q", got, want)
+	}
+
+	var missing *configFile
+	if got := missing.validateValues(); got != nil {
+		t.Errorf("validateValues() without a configuration file = %q", got)
+	}
+}
+
+func Test_AutoSpotting_validate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autospotting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	listing := `{"AutoScalingGroups": [
+	{
+		"AutoScalingGroupName": "web",
+		"AutoScalingGroupARN": "arn:aws:autoscaling:eu-west-1:123456789012:autoScalingGroup:1:autoScalingGroupName/web",
+		"MaxSize": 4,
+		"CreatedTime": "2023-01-01T12:00:00.000Z",
+		"Tags": [
+			{"Key": "autospotting_cron_timezone", "Value": "Europe/Paris"},
+			{"Key": "autospotting_bidding_policy", "Value": "agressive"}
+		]
+	},
+	{
+		"AutoScalingGroupName": "batch",
+		"MaxSize": 10,
+		"Tags": [{"Key": "autospotting_evacuate", "Value": "true"}]
+	}
+]}`
+	valid := filepath.Join(dir, "valid.json")
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := ioutil.WriteFile(invalid, []byte(listing), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(valid, []byte(`{"AutoScalingGroups": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := &AutoSpotting{config: testValidConfig()}
+
+	if groups, err := loadGroupListing(invalid); err != nil || len(groups) != 2 {
+		t.Fatalf("loadGroupListing() = %v, %v", groups, err)
+	} else if region := groupRegion(groups[0], invalid); region != "eu-west-1" {
+		t.Errorf("groupRegion() = %s, want eu-west-1", region)
+	}
+
+	if err := a.validate([]string{invalid}); err == nil || err.Error() != "found 1 configuration problems" {
+		t.Errorf("validate() error = %v, want the invalid bidding policy to be reported", err)
+	}
+	if err := a.validate([]string{filepath.Join(dir, "missing.json")}); err == nil ||
+		!strings.Contains(err.Error(), "couldn't read") {
+		t.Errorf("validate() error = %v, want a missing listing to be reported", err)
+	}
+	if err := a.RunCommand([]string{"validate", valid}); err != nil {
+		t.Errorf("RunCommand(validate) error = %v", err)
+	}
+}
+
+func Test_region_describeAllGroups(t *testing.T) {
+	r := &region{
+		name: "us-east-1",
+		services: connections{autoScaling: mockASG{dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+			AutoScalingGroups: []*autoscaling.Group{{AutoScalingGroupName: aws.String("web")}},
+		}}},
+	}
+	if groups, err := r.describeAllGroups(); err != nil || len(groups) != 1 {
+		t.Errorf("describeAllGroups() = %v, %v", groups, err)
+	}
+}
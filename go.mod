@@ -4,7 +4,7 @@ go 1.14
 
 require (
 	github.com/aws/aws-lambda-go v1.26.0
-	github.com/aws/aws-sdk-go v1.40.39
+	github.com/aws/aws-sdk-go v1.41.19
 	github.com/davecgh/go-spew v1.1.1
 	github.com/mattn/goveralls v0.0.9
 	github.com/mello7tre/ec2-instances-info v0.0.0-20251113095447-f43690f61ece
@@ -14,5 +14,6 @@ require (
 	golang.org/x/mod v0.5.0 // indirect
 	golang.org/x/sys v0.0.0-20210908233432-aa78b53d3365 // indirect
 	golang.org/x/tools v0.1.5
+	gopkg.in/yaml.v2 v2.2.8
 	gotest.tools/v3 v3.0.0
 )